@@ -12,11 +12,13 @@ import (
 	"github.com/joho/godotenv"
 	"github.com/samuelhorwitz/phosphorescence/api/cache"
 	"github.com/samuelhorwitz/phosphorescence/api/common"
+	"github.com/samuelhorwitz/phosphorescence/api/eos"
 	"github.com/samuelhorwitz/phosphorescence/api/handlers/phosphor"
 	"github.com/samuelhorwitz/phosphorescence/api/handlers/spotify"
 	"github.com/samuelhorwitz/phosphorescence/api/mail"
 	"github.com/samuelhorwitz/phosphorescence/api/middleware"
 	"github.com/samuelhorwitz/phosphorescence/api/models"
+	"github.com/samuelhorwitz/phosphorescence/api/party"
 	"github.com/samuelhorwitz/phosphorescence/api/session"
 	"github.com/samuelhorwitz/phosphorescence/api/spotifyclient"
 )
@@ -49,6 +51,29 @@ func main() {
 		log.Fatalf("Could not parse rate limit: %s", err)
 		return
 	}
+	// Unset means Eos picks its own default.
+	var eosConcurrency int
+	if eosConcurrencyStr := os.Getenv("EOS_CONCURRENCY"); eosConcurrencyStr != "" {
+		eosConcurrency, err = strconv.Atoi(eosConcurrencyStr)
+		if err != nil {
+			log.Fatalf("Could not parse Eos concurrency: %s", err)
+			return
+		}
+	}
+	scriptsBucket := os.Getenv("SCRIPTS_BUCKET")
+	if scriptsBucket == "" {
+		scriptsBucket = "phosphorescence-scripts"
+	}
+	scriptsOrigin := os.Getenv("SCRIPTS_ORIGIN")
+	if scriptsOrigin == "" {
+		scriptsOrigin = "https://scripts.phosphor.me"
+	}
+	// Set but empty means don't send an ACL at all, which some S3 compatible
+	// stores want.
+	scriptsACL, ok := os.LookupEnv("SCRIPTS_ACL")
+	if !ok {
+		scriptsACL = "public-read"
+	}
 	cfg := &config{
 		isProduction:                         isProduction,
 		phosphorOrigin:                       os.Getenv("PHOSPHOR_ORIGIN"),
@@ -63,6 +88,14 @@ func main() {
 		spacesTracksRegion:                   os.Getenv("SPACES_TRACKS_REGION"),
 		spacesScriptsEndpoint:                os.Getenv("SPACES_SCRIPTS_ENDPOINT"),
 		spacesScriptsRegion:                  os.Getenv("SPACES_SCRIPTS_REGION"),
+		scriptStore:                          os.Getenv("SCRIPT_STORE"),
+		scriptsBucket:                        scriptsBucket,
+		scriptsACL:                           scriptsACL,
+		scriptsPathStyle:                     os.Getenv("SCRIPTS_PATH_STYLE") == "true",
+		scriptsLocalDir:                      os.Getenv("SCRIPTS_LOCAL_DIR"),
+		scriptsPublicURL:                     scriptsOrigin,
+		eosConcurrency:                       eosConcurrency,
+		eosDatasetKey:                        os.Getenv("EOS_DATASET_KEY"),
 		postgresConnectionString:             os.Getenv("PG_CONNECTION_STRING"),
 		postgresMaxOpenConnections:           pgMaxOpen,
 		postgresMaxIdleConnections:           pgMaxIdle,
@@ -71,6 +104,7 @@ func main() {
 		writeTimeout:                         60 * time.Second,
 		idleTimeout:                          120 * time.Second,
 		handlerTimeout:                       5 * time.Second,
+		scriptBundleTimeout:                  45 * time.Second,
 		rateLimitPerSecond:                   rateLimit,
 		redisHost:                            os.Getenv("REDIS_HOST"),
 		redisCacheHost:                       os.Getenv("REDIS_CACHE_HOST"),
@@ -129,6 +163,9 @@ func initialize(cfg *config) {
 	phosphor.Initialize(&phosphor.Config{
 		IsProduction:   cfg.isProduction,
 		PhosphorOrigin: cfg.phosphorOrigin,
+		// Streams have to end before the server's write timeout cuts them off,
+		// clients reconnect on their own.
+		StreamLifetime: cfg.writeTimeout - 5*time.Second,
 	})
 	log.Println("Phosphor handlers initialized")
 	mail.Initialize(&mail.Config{
@@ -139,6 +176,10 @@ func initialize(cfg *config) {
 		IsProduction: cfg.isProduction,
 	})
 	log.Println("Session handling initialized")
+	party.Initialize(&party.Config{
+		IsProduction: cfg.isProduction,
+	})
+	log.Println("Listening parties initialized")
 	models.Initialize(&models.Config{
 		IsProduction:             cfg.isProduction,
 		SpacesID:                 cfg.spacesID,
@@ -149,9 +190,27 @@ func initialize(cfg *config) {
 		PostgreMaxLifetime:       cfg.postgresMaxConnectionLifetimeMinutes,
 		SpacesScriptsEndpoint:    cfg.spacesScriptsEndpoint,
 		SpacesScriptsRegion:      cfg.spacesScriptsRegion,
+		ScriptStore:              cfg.scriptStore,
+		ScriptsBucket:            cfg.scriptsBucket,
+		ScriptsACL:               cfg.scriptsACL,
+		ScriptsPathStyle:         cfg.scriptsPathStyle,
+		ScriptsLocalDir:          cfg.scriptsLocalDir,
+		ScriptsPublicURL:         cfg.scriptsPublicURL,
 		GoogleAnalyticsSecret:    cfg.googleAnalyticsSecret,
 	})
 	log.Println("Models initialized")
+	eos.Initialize(&eos.Config{
+		Limits: eos.Limits{
+			Timeout: cfg.writeTimeout - 15*time.Second,
+		},
+		Concurrency:    cfg.eosConcurrency,
+		SpacesID:       cfg.spacesID,
+		SpacesSecret:   cfg.spacesSecret,
+		SpacesEndpoint: cfg.spacesTracksEndpoint,
+		SpacesRegion:   cfg.spacesTracksRegion,
+		DatasetKey:     cfg.eosDatasetKey,
+	})
+	log.Println("Eos initialized")
 }
 
 func run(cfg *config) {
@@ -18,6 +18,14 @@ type config struct {
 	spacesTracksRegion                   string
 	spacesScriptsEndpoint                string
 	spacesScriptsRegion                  string
+	scriptStore                          string
+	scriptsBucket                        string
+	scriptsACL                           string
+	scriptsPathStyle                     bool
+	scriptsLocalDir                      string
+	scriptsPublicURL                     string
+	eosConcurrency                       int
+	eosDatasetKey                        string
 	postgresConnectionString             string
 	postgresMaxOpenConnections           int
 	postgresMaxIdleConnections           int
@@ -26,6 +34,7 @@ type config struct {
 	writeTimeout                         time.Duration
 	idleTimeout                          time.Duration
 	handlerTimeout                       time.Duration
+	scriptBundleTimeout                  time.Duration
 	rateLimitPerSecond                   int
 	redisHost                            string
 	redisCacheHost                       string
@@ -0,0 +1,223 @@
+package coverart
+
+import (
+	"hash/fnv"
+	"image"
+	"image/color"
+	stddraw "image/draw"
+	"math"
+	"sort"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/vector"
+)
+
+// MaxTiles is the most album images a cover will ever use, callers can use it
+// to avoid fetching artwork that won't be drawn.
+const MaxTiles = 9
+
+// These are the same colors the web client uses for its constellation cover.
+var (
+	gradientTop    = color.RGBA{40, 27, 61, 255}
+	gradientBottom = color.RGBA{35, 25, 153, 255}
+	sparklineColor = color.RGBA{255, 255, 255, 255}
+	sparklineFill  = color.NRGBA{255, 255, 255, 64}
+)
+
+// Track is the minimum we need to know about each track in a set. Value is
+// whatever measure the sparkline should plot (energy, aetherealness, etc.) and
+// is expected to be between 0 and 1.
+type Track struct {
+	AlbumID string
+	Value   float64
+}
+
+// TopAlbums returns up to max album IDs ordered by how many tracks in the set
+// come from that album. Ties are broken by which album appears first, so the
+// result only depends on the track list.
+func TopAlbums(tracks []Track, max int) []string {
+	counts := make(map[string]int)
+	firstSeen := make(map[string]int)
+	var albumIDs []string
+	for i, track := range tracks {
+		if track.AlbumID == "" {
+			continue
+		}
+		if _, ok := counts[track.AlbumID]; !ok {
+			firstSeen[track.AlbumID] = i
+			albumIDs = append(albumIDs, track.AlbumID)
+		}
+		counts[track.AlbumID]++
+	}
+	sort.SliceStable(albumIDs, func(i, j int) bool {
+		a, b := albumIDs[i], albumIDs[j]
+		if counts[a] != counts[b] {
+			return counts[a] > counts[b]
+		}
+		return firstSeen[a] < firstSeen[b]
+	})
+	if len(albumIDs) > max {
+		albumIDs = albumIDs[:max]
+	}
+	return albumIDs
+}
+
+// Render draws a size by size cover for the set. albumArt is keyed by album ID
+// and may be missing entries (or be nil entirely), in which case those tiles
+// get a flat color derived from the album ID. mark is drawn in the corner if it
+// is not nil.
+func Render(tracks []Track, albumArt map[string]image.Image, mark image.Image, size int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	drawGradient(dst, 255)
+	drawMosaic(dst, TopAlbums(tracks, MaxTiles), albumArt)
+	drawGradient(dst, 110)
+	drawSparkline(dst, tracks)
+	if mark != nil {
+		drawMark(dst, mark)
+	}
+	return dst
+}
+
+func drawGradient(dst *image.RGBA, alpha uint8) {
+	bounds := dst.Bounds()
+	height := bounds.Dy()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		t := float64(y-bounds.Min.Y) / float64(height)
+		c := color.NRGBA{
+			R: lerp(gradientTop.R, gradientBottom.R, t),
+			G: lerp(gradientTop.G, gradientBottom.G, t),
+			B: lerp(gradientTop.B, gradientBottom.B, t),
+			A: alpha,
+		}
+		row := image.Rect(bounds.Min.X, y, bounds.Max.X, y+1)
+		stddraw.Draw(dst, row, image.NewUniform(c), image.ZP, stddraw.Over)
+	}
+}
+
+func drawMosaic(dst *image.RGBA, albumIDs []string, albumArt map[string]image.Image) {
+	if len(albumIDs) == 0 {
+		return
+	}
+	grid := 1
+	switch {
+	case len(albumIDs) >= 9:
+		grid = 3
+	case len(albumIDs) >= 2:
+		grid = 2
+	}
+	size := dst.Bounds().Dx()
+	for i := 0; i < grid*grid; i++ {
+		albumID := albumIDs[i%len(albumIDs)]
+		x0, y0 := (i%grid)*size/grid, (i/grid)*size/grid
+		x1, y1 := (i%grid+1)*size/grid, (i/grid+1)*size/grid
+		tile := image.Rect(x0, y0, x1, y1)
+		art, ok := albumArt[albumID]
+		if !ok || art == nil {
+			stddraw.Draw(dst, tile, image.NewUniform(albumColor(albumID)), image.ZP, stddraw.Src)
+			continue
+		}
+		draw.CatmullRom.Scale(dst, tile, art, squareCrop(art.Bounds()), draw.Src, nil)
+	}
+}
+
+func drawSparkline(dst *image.RGBA, tracks []Track) {
+	if len(tracks) < 2 {
+		return
+	}
+	size := float32(dst.Bounds().Dx())
+	padding := size * 0.06
+	top := size * 0.68
+	bottom := size - padding
+	left := padding
+	right := size - padding
+	stroke := size / 160
+	points := make([][2]float32, len(tracks))
+	for i, track := range tracks {
+		value := float32(math.Max(0, math.Min(1, track.Value)))
+		points[i] = [2]float32{
+			left + (right-left)*float32(i)/float32(len(tracks)-1),
+			bottom - (bottom-top)*value,
+		}
+	}
+	r := vector.NewRasterizer(dst.Bounds().Dx(), dst.Bounds().Dy())
+	r.MoveTo(left, bottom)
+	for _, point := range points {
+		r.LineTo(point[0], point[1])
+	}
+	r.LineTo(right, bottom)
+	r.ClosePath()
+	r.Draw(dst, dst.Bounds(), image.NewUniform(sparklineFill), image.ZP)
+	r.Reset(dst.Bounds().Dx(), dst.Bounds().Dy())
+	for i := 1; i < len(points); i++ {
+		segment(r, points[i-1], points[i], stroke/2)
+	}
+	r.Draw(dst, dst.Bounds(), image.NewUniform(sparklineColor), image.ZP)
+	// Joints are drawn separately so that their winding can't cancel out the
+	// segments they overlap.
+	r.Reset(dst.Bounds().Dx(), dst.Bounds().Dy())
+	for _, point := range points {
+		circle(r, point, stroke/2)
+	}
+	r.Draw(dst, dst.Bounds(), image.NewUniform(sparklineColor), image.ZP)
+}
+
+func drawMark(dst *image.RGBA, mark image.Image) {
+	size := dst.Bounds().Dx()
+	padding := size * 6 / 100
+	markSize := size * 28 / 100
+	scaled := image.NewRGBA(image.Rect(0, 0, markSize, markSize))
+	draw.CatmullRom.Scale(scaled, scaled.Bounds(), mark, mark.Bounds(), draw.Src, nil)
+	target := image.Rect(padding, padding, padding+markSize, padding+markSize)
+	stddraw.DrawMask(dst, target, scaled, image.ZP, image.NewUniform(color.Alpha{217}), image.ZP, stddraw.Over)
+}
+
+func segment(r *vector.Rasterizer, a, b [2]float32, halfWidth float32) {
+	dx, dy := b[0]-a[0], b[1]-a[1]
+	length := float32(math.Hypot(float64(dx), float64(dy)))
+	if length == 0 {
+		return
+	}
+	nx, ny := -dy/length*halfWidth, dx/length*halfWidth
+	r.MoveTo(a[0]+nx, a[1]+ny)
+	r.LineTo(b[0]+nx, b[1]+ny)
+	r.LineTo(b[0]-nx, b[1]-ny)
+	r.LineTo(a[0]-nx, a[1]-ny)
+	r.ClosePath()
+}
+
+func circle(r *vector.Rasterizer, center [2]float32, radius float32) {
+	const steps = 16
+	r.MoveTo(center[0]+radius, center[1])
+	for i := 1; i < steps; i++ {
+		theta := 2 * math.Pi * float64(i) / steps
+		r.LineTo(center[0]+radius*float32(math.Cos(theta)), center[1]+radius*float32(math.Sin(theta)))
+	}
+	r.ClosePath()
+}
+
+func squareCrop(bounds image.Rectangle) image.Rectangle {
+	side := bounds.Dx()
+	if bounds.Dy() < side {
+		side = bounds.Dy()
+	}
+	offset := image.Pt((bounds.Dx()-side)/2, (bounds.Dy()-side)/2)
+	return image.Rect(0, 0, side, side).Add(bounds.Min).Add(offset)
+}
+
+// albumColor picks a stable color for an album we have no artwork for by
+// hashing its ID, kept dim so it sits under the brand gradient.
+func albumColor(albumID string) color.RGBA {
+	h := fnv.New32a()
+	h.Write([]byte(albumID))
+	sum := h.Sum32()
+	return color.RGBA{
+		R: uint8(40 + sum%80),
+		G: uint8(20 + (sum>>8)%60),
+		B: uint8(90 + (sum>>16)%120),
+		A: 255,
+	}
+}
+
+func lerp(a, b uint8, t float64) uint8 {
+	return uint8(float64(a) + (float64(b)-float64(a))*t)
+}
@@ -0,0 +1,43 @@
+package coverart_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+
+	"github.com/samuelhorwitz/phosphorescence/api/coverart"
+)
+
+func TestTopAlbums(t *testing.T) {
+	tracks := []coverart.Track{
+		{AlbumID: "a"}, {AlbumID: "b"}, {AlbumID: "c"}, {AlbumID: "b"}, {AlbumID: ""}, {AlbumID: "c"}, {AlbumID: "d"},
+	}
+	albums := coverart.TopAlbums(tracks, 3)
+	if expected := []string{"b", "c", "a"}; !reflect.DeepEqual(albums, expected) {
+		t.Fatalf("Expected %v, got %v", expected, albums)
+	}
+}
+
+func TestRenderDeterministic(t *testing.T) {
+	var tracks []coverart.Track
+	for i := 0; i < 20; i++ {
+		tracks = append(tracks, coverart.Track{AlbumID: string(rune('a' + i%5)), Value: float64(i%7) / 6})
+	}
+	art := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	for x := 0; x < 64; x++ {
+		for y := 0; y < 48; y++ {
+			art.Set(x, y, color.RGBA{uint8(x * 4), uint8(y * 5), 200, 255})
+		}
+	}
+	albumArt := map[string]image.Image{"a": art, "c": art}
+	first := coverart.Render(tracks, albumArt, art, 320)
+	second := coverart.Render(tracks, albumArt, art, 320)
+	if first.Bounds() != image.Rect(0, 0, 320, 320) {
+		t.Fatalf("Unexpected bounds %v", first.Bounds())
+	}
+	if !bytes.Equal(first.Pix, second.Pix) {
+		t.Fatalf("Rendering the same tracks twice produced different images")
+	}
+}
@@ -0,0 +1,511 @@
+package eos
+
+// apiScript is eos/api.js and the parts of the Eos worker scripts rely on, for
+// goja rather than a browser. Keep the two in step: anything a script can see
+// in Eos it should see here, and nothing more. It evaluates to a function which
+// installs the API on self and returns the runner the Go side drives, which
+// scripts have no way of reaching.
+//
+// The k-d tree is built and searched the same way as kd-tree-javascript, which
+// Eos uses, so scripts find the same neighbors here as in the browser.
+const apiScript = `(function(self, dataset, prunedTrackIds) {
+    const defineConstants = constants => {
+        for (let key of Object.keys(constants)) {
+            Object.defineProperty(self, key, {
+                value: constants[key],
+                writable: false,
+                configurable: false
+            });
+        }
+    };
+
+    defineConstants({MINOR: 0, MAJOR: 1});
+
+    [['C', 'B_SHARP', 'D_DOUBLE_FLAT'],
+     ['C_SHARP', 'D_FLAT', 'B_DOUBLE_SHARP'],
+     ['D', 'C_DOUBLE_SHARP', 'E_DOUBLE_FLAT'],
+     ['D_SHARP', 'E_FLAT', 'F_DOUBLE_FLAT'],
+     ['E', 'D_DOUBLE_SHARP', 'F_FLAT'],
+     ['F', 'E_SHARP', 'G_DOUBLE_FLAT'],
+     ['F_SHARP', 'G_FLAT', 'E_DOUBLE_SHARP'],
+     ['G', 'F_DOUBLE_SHARP', 'A_DOUBLE_FLAT'],
+     ['G_SHARP', 'A_FLAT'],
+     ['A', 'G_DOUBLE_SHARP', 'B_DOUBLE_FLAT'],
+     ['A_SHARP', 'B_FLAT', 'C_DOUBLE_FLAT'],
+     ['B', 'A_DOUBLE_SHARP', 'C_FLAT']].forEach((pitch, i) => {
+        let constants = {};
+        pitch.forEach(representation => constants[representation] = i);
+        defineConstants(constants);
+    });
+
+    defineConstants({
+        AETHEREALNESS: 'aetherealness',
+        PRIMORDIALNESS: 'primordialness',
+        KEY: 'key',
+        MODE: 'mode',
+        TEMPO: 'tempo',
+        VALENCE: 'valence',
+        ENERGY: 'energy',
+        DANCEABILITY: 'danceability',
+        LOUDNESS: 'loudness',
+        SPEECHINESS: 'speechiness',
+        ACOUSTICNESS: 'acousticness',
+        INSTRUMENTALNESS: 'instrumentalness',
+        LIVENESS: 'liveness',
+        TIME_SIGNATURE: 'timeSignature',
+        DURATION_MS: 'duration',
+        POPULARITY: 'popularity'
+    });
+
+    const defineAPIFunction = (key, fn) => Object.defineProperty(self, key, {
+        value: fn,
+        writable: false,
+        configurable: false
+    });
+
+    // Scripts written for the browser log freely, there is nowhere for that to
+    // go here.
+    const noop = function() {};
+    self.console = {log: noop, debug: noop, info: noop, warn: noop, error: noop};
+
+    let idToTagMap = dataset.idsToTags;
+    let unprunedTracks = dataset.tracks;
+    let tracks = unprunedTracks;
+    if (prunedTrackIds) {
+        tracks = {};
+        for (let prunedTrackId of prunedTrackIds) {
+            if (unprunedTracks[prunedTrackId]) {
+                tracks[prunedTrackId] = unprunedTracks[prunedTrackId];
+            }
+        }
+    }
+    let extraDimensions = [];
+    let tree;
+    let getTree = () => tree;
+
+    const getRandomInt = (min, max) => Math.floor(Math.random() * (max - min + 1)) + min;
+
+    // Splits on each dimension in turn at the median, like kd-tree-javascript.
+    const buildKdNode = (points, depth, dimensions) => {
+        if (points.length == 0) {
+            return null;
+        }
+        let dimension = depth % dimensions.length;
+        points.sort((a, b) => a[dimensions[dimension]] - b[dimensions[dimension]]);
+        let median = Math.floor(points.length / 2);
+        return {
+            point: points[median],
+            dimension,
+            left: buildKdNode(points.slice(0, median), depth + 1, dimensions),
+            right: buildKdNode(points.slice(median + 1), depth + 1, dimensions)
+        };
+    };
+
+    function KdTree(points, dimensions, distanceFn) {
+        let pointsIndex = {};
+        for (let point of points) {
+            pointsIndex[point.id] = point;
+        }
+        // Eos rebuilds the tree on every removal, it is the same tree built
+        // lazily.
+        let root;
+        let getRoot = () => {
+            if (root === undefined) {
+                root = buildKdNode(Object.values(pointsIndex), 0, dimensions);
+            }
+            return root;
+        };
+        this.removeById = function removeById(id) {
+            delete pointsIndex[id];
+            root = undefined;
+        };
+        this.nearest = function nearest(k, point) {
+            // Closest first, so the furthest is always at the end.
+            let best = [];
+            const save = (candidate, distance) => {
+                let i = best.length;
+                while (i > 0 && best[i - 1].distance > distance) {
+                    i--;
+                }
+                best.splice(i, 0, {point: candidate, distance});
+                if (best.length > k) {
+                    best.pop();
+                }
+            };
+            const isCandidate = distance => best.length < k || distance < best[best.length - 1].distance;
+            const search = node => {
+                let dimension = dimensions[node.dimension];
+                let ownDistance = distanceFn(point, node.point);
+                // How far away anything on the other side of the split could
+                // possibly be.
+                let linearPoint = {};
+                for (let i = 0; i < dimensions.length; i++) {
+                    linearPoint[dimensions[i]] = i == node.dimension ? point[dimensions[i]] : node.point[dimensions[i]];
+                }
+                let linearDistance = distanceFn(linearPoint, node.point);
+                if (!node.left && !node.right) {
+                    if (isCandidate(ownDistance)) {
+                        save(node.point, ownDistance);
+                    }
+                    return;
+                }
+                let bestChild;
+                if (!node.right) {
+                    bestChild = node.left;
+                }
+                else if (!node.left) {
+                    bestChild = node.right;
+                }
+                else {
+                    bestChild = point[dimension] < node.point[dimension] ? node.left : node.right;
+                }
+                search(bestChild);
+                if (isCandidate(ownDistance)) {
+                    save(node.point, ownDistance);
+                }
+                if (isCandidate(Math.abs(linearDistance))) {
+                    let otherChild = bestChild === node.left ? node.right : node.left;
+                    if (otherChild) {
+                        search(otherChild);
+                    }
+                }
+            };
+            let rootNode = getRoot();
+            if (rootNode) {
+                search(rootNode);
+            }
+            return best;
+        };
+        this.getRandomNode = function getRandomNode() {
+            let points = Object.values(pointsIndex);
+            return {point: points[getRandomInt(0, points.length - 1)]};
+        };
+        this.getNodesWhere = function getNodesWhere(fn) {
+            let matches = [];
+            for (let point of Object.values(pointsIndex)) {
+                if (fn(point)) {
+                    matches.push({point});
+                }
+            }
+            return matches;
+        };
+        this.forEach = function forEach(fn) {
+            for (let point of Object.values(pointsIndex)) {
+                fn(point);
+            }
+        };
+        this.length = function length() {
+            return Object.keys(pointsIndex).length;
+        };
+        this.getDimensions = function getDimensions() {
+            return dimensions;
+        };
+    }
+
+    self.hooks = {
+        prune({tracks, idToTagMap, unprunedTracks}) {return buildResponse(tracks)},
+        buildTree(kdTreeCtor, {points, tracks, idToTagMap}) {return buildResponse(null)},
+        getFirstTrack({playlist, tags, goalTracks, points, tracks}, tree) {throw new Error('You must define a getFirstTrack hook!')},
+        getNextTrack({playlist, tags, goalTracks, points, previousTrack, tracks}, tree) {throw new Error('You must define a getNextTrack hook!')}
+    };
+
+    defineAPIFunction('buildResponse', function(data) {
+        return {data};
+    });
+
+    defineAPIFunction('buildPoint', function(point) {
+        return {point};
+    });
+
+    defineAPIFunction('getNearestNeighborsByTrack', function(k, track) {
+        return getTree().nearest(Math.max(1, Math.floor(k)), getPointFromTrack(track));
+    });
+
+    defineAPIFunction('getNearestNeighbors', function(k, point) {
+        return getTree().nearest(Math.max(1, Math.floor(k)), point);
+    });
+
+    defineAPIFunction('getRandomTrack', function() {
+        return getTree().getRandomNode();
+    });
+
+    defineAPIFunction('getNodesWhere', function(fn) {
+        return getTree().getNodesWhere(fn);
+    });
+
+    defineAPIFunction('forEachNode', function(fn) {
+        return getTree().forEach(fn);
+    });
+
+    defineAPIFunction('treeSize', function(fn) {
+        return getTree().length();
+    });
+
+    defineAPIFunction('addLoggingDimension', function(dim) {
+        extraDimensions.push(dim);
+    });
+
+    defineAPIFunction('calculateEuclidianDistance', function(...distances) {
+        if (distances.length == 0) {
+            return 0;
+        }
+        else if (distances.length == 1) {
+            return Math.abs(distances[0]);
+        }
+        else if (distances.includes(Infinity)) {
+            return Infinity;
+        }
+        return Math.sqrt(distances.reduce((acc, cur) => acc + Math.pow(cur, 2), 0));
+    });
+
+    const minorsCircle = [A_FLAT, E_FLAT, B_FLAT, F, C, G, D, A, E, B, F_SHARP, D_FLAT];
+    const majorsCircle = [B, F_SHARP, D_FLAT, A_FLAT, E_FLAT, B_FLAT, F, C, G, D, A, E];
+    const minorsPitchToPositionMap = minorsCircle.reduce((acc, cur, i) => {acc[cur] = i; return acc;}, []);
+    const majorsPitchToPositionMap = majorsCircle.reduce((acc, cur, i) => {acc[cur] = i; return acc;}, []);
+    const majorToMinor = [A, A_SHARP, B, C, C_SHARP, D, D_SHARP, E, F, F_SHARP, G, G_SHARP];
+    const minorToMajor = [D_SHARP, E, F, F_SHARP, G, G_SHARP, A, A_SHARP, B, C, C_SHARP, D];
+    const keyUp = [G, G_SHARP, A, A_SHARP, B, C, C_SHARP, D, D_SHARP, E, F, F_SHARP];
+    const keyDown = [F, F_SHARP, G, G_SHARP, A, A_SHARP, B, C, C_SHARP, D, D_SHARP, E];
+
+    defineAPIFunction('nonJarringHarmonicDifference', function(a, b) {
+        if (!a.mode || !a.key || !b.mode || !b.key) {
+            return 1;
+        }
+        let diff;
+        if (a.mode == b.mode) {
+            diff = Math.abs(majorsPitchToPositionMap[a.key] - majorsPitchToPositionMap[b.key]);
+            if (diff > 12 / 2) {
+                diff = 12 % diff;
+            }
+        }
+        else {
+            let diff;
+            if (a.mode == MINOR && b.mode == MAJOR) {
+                diff = Math.abs(minorsPitchToPositionMap[a.key] - majorsPitchToPositionMap[b.key]);
+            }
+            else {
+                diff = Math.abs(majorsPitchToPositionMap[a.key] - minorsPitchToPositionMap[b.key]);
+            }
+            if (diff > 12 / 2) {
+                diff = 12 % diff;
+            }
+            diff += 1;
+        }
+        if (diff == 0) {
+            return 0;
+        }
+        return 1 - (1 / (1 + (0.5 * Math.pow(diff, 3))));
+    });
+
+    defineAPIFunction('sameHarmonics', function(a, b) {
+        return a.mode == b.mode && a.key == b.key;
+    });
+
+    defineAPIFunction('sameModeAndNeighborKeyChange', function(a, b) {
+        return a.mode == b.mode && (keyUp[a.key] == b.key || keyDown[a.key] == b.key);
+    });
+
+    defineAPIFunction('differentModeAndNeighborKeyChange', function(a, b) {
+        return a.mode != b.mode && ((a.mode == MAJOR && majorToMinor[a.key] == b.key) || (a.mode == MINOR && minorToMajor[a.key] == b.key));
+    });
+
+    defineAPIFunction('nonJarringTempoDifference', function(a, b) {
+        if (!a.tempo || !b.tempo) {
+            return 1;
+        }
+        return Math.min(1, Math.max(0, 0.57 * Math.log(Math.abs(b.tempo - a.tempo))));
+    });
+
+    defineAPIFunction('shuffle', function(arr) {
+        for (let i = arr.length - 1; i > 0; i--) {
+            let randomIndex = Math.floor(Math.random() * (i + 1));
+            let temporaryValue = arr[i];
+            arr[i] = arr[randomIndex];
+            arr[randomIndex] = temporaryValue;
+        }
+        return arr;
+    });
+
+    defineAPIFunction('getRandomInt', function(min, max) {
+        return getRandomInt(min, max);
+    });
+
+    defineAPIFunction('pickRandom', function(arr) {
+        return arr[getRandomInt(0, arr.length - 1)];
+    });
+
+    defineAPIFunction('rollDice', function(minTarget, sides) {
+        if (minTarget > sides) {
+            return true;
+        }
+        let rand = getRandomInt(0, sides - 1);
+        return rand < minTarget;
+    });
+
+    defineAPIFunction('calculateRMS', function(...vals) {
+        return Math.sqrt(vals.reduce((acc, cur) => acc + Math.pow(cur, 2), 0) / vals.length);
+    });
+
+    defineAPIFunction('cullTracksWithAlreadySeenTags', function(neighbors, previousTags) {
+        let unculledNeighbors = [];
+        for (let nn of neighbors) {
+            if (previousTags[nn.point.tag]) {
+                continue;
+            }
+            unculledNeighbors.push(nn);
+        }
+        return unculledNeighbors;
+    });
+
+    defineAPIFunction('tracksToPoints', function(tracks) {
+        let evocativenessPoints = [];
+        for (let trackWrapper of Object.values(tracks)) {
+            evocativenessPoints.push(getPointFromTrack(trackWrapper));
+        }
+        return evocativenessPoints;
+    });
+
+    defineAPIFunction('getPointFromTrack', function(trackWrapper) {
+        let {id, track, features, evocativeness} = trackWrapper;
+        let {popularity} = track;
+        let {
+            key,
+            mode,
+            tempo,
+            energy,
+            valence,
+            liveness,
+            loudness,
+            speechiness,
+            acousticness,
+            danceability,
+            instrumentalness,
+            duration_ms: duration,
+            time_signature: timeSignature
+        } = features;
+        let {aetherealness, primordialness} = evocativeness;
+        let tag = idToTagMap[id];
+        return {
+            id,
+            tag,
+            key,
+            mode,
+            tempo,
+            energy,
+            valence,
+            liveness,
+            loudness,
+            duration,
+            popularity,
+            speechiness,
+            acousticness,
+            danceability,
+            timeSignature,
+            aetherealness,
+            primordialness,
+            instrumentalness
+        };
+    });
+
+    // What follows is the Eos runner. Hooks may return promises, which only
+    // settle once control goes back to Go, so each hook is called in one step
+    // and its response is taken up in the next.
+    const copy = data => JSON.parse(JSON.stringify(data));
+    const resolve = Promise.resolve.bind(Promise);
+    const then = Promise.prototype.then;
+    let pending;
+    const settle = response => {
+        let outcome = {settled: false};
+        then.call(resolve(response), value => {
+            outcome.settled = true;
+            outcome.value = value;
+        }, error => {
+            outcome.settled = true;
+            outcome.rejected = true;
+            outcome.error = error;
+        });
+        pending = outcome;
+    };
+    const settled = name => {
+        let outcome = pending;
+        pending = null;
+        if (!outcome || !outcome.settled) {
+            throw new Error(name + ' hook never finished');
+        }
+        if (outcome.rejected) {
+            throw outcome.error;
+        }
+        return outcome.value;
+    };
+    let prunedTracks;
+    let points;
+    let tags = {};
+    let playlist = [];
+    let goalTracks;
+
+    return {
+        prune() {
+            settle(self.hooks.prune(copy({tracks, idToTagMap, unprunedTracks})));
+        },
+        acceptPruned() {
+            let {data: prunedTracksUnsafe} = settled('prune') || {};
+            prunedTracks = {};
+            for (let trackId of Object.keys(prunedTracksUnsafe || {})) {
+                if (!tracks[trackId]) {
+                    throw new Error('Builder pruning returned invalid track ' + trackId);
+                }
+                prunedTracks[trackId] = tracks[trackId];
+            }
+            points = tracksToPoints(prunedTracks);
+        },
+        buildTree() {
+            settle(self.hooks.buildTree(KdTree, copy({tracks: prunedTracks, idToTagMap, points})));
+        },
+        acceptTree() {
+            tree = (settled('buildTree') || {}).data;
+        },
+        setGoalTracks(count) {
+            goalTracks = count;
+        },
+        setFirstTrack(id) {
+            let firstTrack = prunedTracks[id];
+            if (!firstTrack) {
+                throw new Error('First track ' + id + ' is not available');
+            }
+            tree && tree.removeById && tree.removeById(id);
+            this.addToPlaylist(firstTrack);
+        },
+        getFirstTrack() {
+            settle(self.hooks.getFirstTrack(copy({playlist, tags, goalTracks, points, tracks: prunedTracks}), tree));
+        },
+        getNextTrack() {
+            let previousTrack = playlist[playlist.length - 1];
+            settle(self.hooks.getNextTrack(copy({playlist, tags, goalTracks, points, tracks: prunedTracks, previousTrack}), tree));
+        },
+        acceptTrack() {
+            let {data: unsafeTrack} = settled('track') || {};
+            if (!unsafeTrack) {
+                return false;
+            }
+            let {point} = unsafeTrack;
+            let safeTrack = point && prunedTracks[point.id];
+            if (!safeTrack) {
+                throw new Error('Builder returned invalid track ' + (point && point.id));
+            }
+            tree && tree.removeById && tree.removeById(point.id);
+            this.addToPlaylist(safeTrack);
+            return true;
+        },
+        addToPlaylist(track) {
+            tags[idToTagMap[track.id]] = true;
+            playlist.push(track);
+        },
+        result() {
+            let dimensions = [...extraDimensions];
+            if (tree && tree.getDimensions) {
+                dimensions = [...dimensions, ...tree.getDimensions()];
+            }
+            return JSON.stringify({tracks: playlist.map(track => track.id), dimensions});
+        }
+    };
+})`
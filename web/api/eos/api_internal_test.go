@@ -0,0 +1,31 @@
+package eos
+
+import (
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var apiFunction = regexp.MustCompile(`defineAPIFunction\('(\w+)'`)
+
+// apiScript is kept in step with eos/api.js by hand, this at least catches
+// functions being added to one and not the other.
+func Test_apiScriptMatchesEos(t *testing.T) {
+	eosAPI, err := ioutil.ReadFile("../../eos/api.js")
+	if err != nil {
+		t.Fatalf("Could not read Eos API: %s", err)
+	}
+	functions := func(script string) string {
+		var names []string
+		for _, match := range apiFunction.FindAllStringSubmatch(script, -1) {
+			names = append(names, match[1])
+		}
+		sort.Strings(names)
+		return strings.Join(names, ", ")
+	}
+	if expected, actual := functions(string(eosAPI)), functions(apiScript); expected != actual {
+		t.Fatalf("Expected the API functions of Eos:\n%s\ngot:\n%s", expected, actual)
+	}
+}
@@ -0,0 +1,201 @@
+package eos
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	"github.com/samuelhorwitz/phosphorescence/api/common"
+)
+
+const (
+	tracksBucket      = "phosphorescence-tracks"
+	defaultDatasetKey = "processed/tracks.{region}.json"
+	// Track lists are pushed once a day so there is no need to go back for
+	// them any more often than this.
+	datasetLifetime = time.Hour
+	// Datasets are large, only the regions which were used most recently are
+	// kept around.
+	maxCachedDatasets = 4
+	// Fetches are shared by everyone waiting on the region so they don't stop
+	// when whoever started one gives up.
+	datasetFetchTimeout = time.Minute
+)
+
+var ErrNoDataset = errors.New("No track dataset for region")
+
+var (
+	s3Service   *s3.S3
+	datasetKey  string
+	datasetsMux sync.Mutex
+	datasets    = make(map[string]*cachedDataset)
+	// getRegionDataset fetches a region's dataset, tests replace it so they
+	// don't need Spaces.
+	getRegionDataset = fetchDataset
+)
+
+// cachedDataset is a region's dataset, or the fetch of it until ready is
+// closed.
+type cachedDataset struct {
+	ready     chan struct{}
+	dataset   *Dataset
+	err       error
+	fetchedAt time.Time
+	usedAt    time.Time
+}
+
+// Dataset is a region's tracks in the shape the record crate worker hands to
+// Eos, evocativeness and tags included.
+type Dataset struct {
+	raw    []byte
+	tracks map[string]json.RawMessage
+}
+
+// NewDataset reads a dataset of the form
+//
+//	{"tracks": {id: {id, track, features, evocativeness}}, "tags": {...}, "idsToTags": {...}}
+func NewDataset(raw []byte) (*Dataset, error) {
+	var parsed struct {
+		Tracks    map[string]json.RawMessage `json:"tracks"`
+		IDsToTags map[string]string          `json:"idsToTags"`
+	}
+	if err := json.Unmarshal(raw, &parsed); err != nil {
+		return nil, fmt.Errorf("Could not parse track dataset: %s", err)
+	}
+	if parsed.Tracks == nil || parsed.IDsToTags == nil {
+		return nil, errors.New("Track dataset is missing tracks or tags")
+	}
+	return &Dataset{raw: raw, tracks: parsed.Tracks}, nil
+}
+
+func initializeDatasets(cfg *Config) {
+	datasetKey = cfg.DatasetKey
+	if datasetKey == "" {
+		datasetKey = defaultDatasetKey
+	}
+	s3Session, err := common.InitializeS3(&common.AWSConfig{
+		Config: &aws.Config{
+			Endpoint: aws.String(cfg.SpacesEndpoint),
+			Region:   aws.String(cfg.SpacesRegion),
+		},
+		AccessKeyID:     cfg.SpacesID,
+		SecretAccessKey: cfg.SpacesSecret,
+	})
+	if err != nil {
+		log.Fatalf("Could not create Spaces connection: %s", err)
+		return
+	}
+	s3Service = s3.New(s3Session)
+}
+
+// GetDataset gets a region's dataset, from memory if we fetched it recently.
+// Only one fetch of a region happens at a time, anyone else asking for it
+// waits for that one.
+func GetDataset(ctx context.Context, region string) (*Dataset, error) {
+	region = strings.ToLower(region)
+	datasetsMux.Lock()
+	cached, ok := datasets[region]
+	if !ok || (cached.isReady() && time.Since(cached.fetchedAt) >= datasetLifetime) {
+		cached = &cachedDataset{ready: make(chan struct{})}
+		datasets[region] = cached
+		go cached.fetch(region)
+	}
+	cached.usedAt = time.Now()
+	evictDatasets()
+	datasetsMux.Unlock()
+	select {
+	case <-cached.ready:
+		return cached.dataset, cached.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (cached *cachedDataset) fetch(region string) {
+	ctx, cancel := context.WithTimeout(context.Background(), datasetFetchTimeout)
+	defer cancel()
+	dataset, err := getRegionDataset(ctx, region)
+	datasetsMux.Lock()
+	defer datasetsMux.Unlock()
+	cached.dataset, cached.err, cached.fetchedAt = dataset, err, time.Now()
+	// Failures aren't cached, the next request tries again.
+	if err != nil && datasets[region] == cached {
+		delete(datasets, region)
+	}
+	close(cached.ready)
+}
+
+func (cached *cachedDataset) isReady() bool {
+	select {
+	case <-cached.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// evictDatasets drops expired datasets, then the least recently used ones
+// until there are few enough. Fetches in progress are left alone. It must be
+// called with datasetsMux held.
+func evictDatasets() {
+	for region, cached := range datasets {
+		if cached.isReady() && time.Since(cached.fetchedAt) >= datasetLifetime {
+			delete(datasets, region)
+		}
+	}
+	for len(datasets) > maxCachedDatasets {
+		var oldestRegion string
+		var oldest *cachedDataset
+		for region, cached := range datasets {
+			if cached.isReady() && (oldest == nil || cached.usedAt.Before(oldest.usedAt)) {
+				oldestRegion, oldest = region, cached
+			}
+		}
+		if oldest == nil {
+			return
+		}
+		delete(datasets, oldestRegion)
+	}
+}
+
+func fetchDataset(ctx context.Context, region string) (*Dataset, error) {
+	res, err := s3Service.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(tracksBucket),
+		Key:    aws.String(strings.Replace(datasetKey, "{region}", region, 1)),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, ErrNoDataset
+		}
+		return nil, fmt.Errorf("Could not get track dataset: %s", err)
+	}
+	defer res.Body.Close()
+	body := bufio.NewReader(res.Body)
+	var reader io.Reader = body
+	if magic, err := body.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, fmt.Errorf("Could not decompress track dataset: %s", err)
+		}
+		defer zr.Close()
+		reader = zr
+	}
+	raw, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read track dataset: %s", err)
+	}
+	return NewDataset(raw)
+}
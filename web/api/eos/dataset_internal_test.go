@@ -0,0 +1,100 @@
+package eos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func fakeRegionDatasets(t *testing.T, fetch func(region string) (*Dataset, error)) {
+	original := getRegionDataset
+	getRegionDataset = func(ctx context.Context, region string) (*Dataset, error) {
+		return fetch(region)
+	}
+	datasets = make(map[string]*cachedDataset)
+	t.Cleanup(func() {
+		getRegionDataset = original
+		datasets = make(map[string]*cachedDataset)
+	})
+}
+
+func Test_GetDatasetFetchesOnce(t *testing.T) {
+	var fetches int32
+	release := make(chan struct{})
+	fakeRegionDatasets(t, func(region string) (*Dataset, error) {
+		atomic.AddInt32(&fetches, 1)
+		<-release
+		return &Dataset{}, nil
+	})
+	var wg sync.WaitGroup
+	got := make([]*Dataset, 10)
+	for i := range got {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			got[i], _ = GetDataset(context.Background(), "US")
+		}(i)
+	}
+	// Someone who gives up waiting doesn't hold up or cancel the fetch.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := GetDataset(ctx, "us"); err != context.DeadlineExceeded {
+		t.Fatalf("Expected to stop waiting, got %v", err)
+	}
+	close(release)
+	wg.Wait()
+	if fetches != 1 {
+		t.Fatalf("Expected one fetch, got %d", fetches)
+	}
+	for _, dataset := range got {
+		if dataset == nil || dataset != got[0] {
+			t.Fatalf("Expected everyone to get the same dataset, got %v", got)
+		}
+	}
+}
+
+func Test_GetDatasetRetriesFailures(t *testing.T) {
+	failed := errors.New("failed")
+	var fetches int
+	fakeRegionDatasets(t, func(region string) (*Dataset, error) {
+		fetches++
+		if fetches == 1 {
+			return nil, failed
+		}
+		return &Dataset{}, nil
+	})
+	if _, err := GetDataset(context.Background(), "us"); err != failed {
+		t.Fatalf("Expected the failure, got %v", err)
+	}
+	if _, err := GetDataset(context.Background(), "us"); err != nil || fetches != 2 {
+		t.Fatalf("Expected a second fetch to succeed, got %v after %d fetches", err, fetches)
+	}
+}
+
+func Test_GetDatasetEvicts(t *testing.T) {
+	fakeRegionDatasets(t, func(region string) (*Dataset, error) {
+		return &Dataset{}, nil
+	})
+	for i := 0; i <= maxCachedDatasets; i++ {
+		if _, err := GetDataset(context.Background(), fmt.Sprintf("r%d", i)); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+	}
+	if len(datasets) != maxCachedDatasets {
+		t.Fatalf("Expected %d datasets kept, got %d", maxCachedDatasets, len(datasets))
+	}
+	if _, ok := datasets["r0"]; ok {
+		t.Fatal("Expected the least recently used dataset to be evicted")
+	}
+	datasets["r1"].fetchedAt = time.Now().Add(-datasetLifetime)
+	datasetsMux.Lock()
+	evictDatasets()
+	datasetsMux.Unlock()
+	if _, ok := datasets["r1"]; ok {
+		t.Fatal("Expected the expired dataset to be evicted")
+	}
+}
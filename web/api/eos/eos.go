@@ -0,0 +1,304 @@
+// Package eos runs builder scripts on the server the same way the Eos worker
+// runs them in the browser, so sets can be made without one. Every run gets
+// its own JavaScript runtime which only has the Eos API in it: there is no
+// network, filesystem or module loading to reach.
+package eos
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+const (
+	defaultTimeout      = 30 * time.Second
+	defaultMaxHeapBytes = 512 * 1024 * 1024
+	defaultConcurrency  = 2
+	maxCallStackSize    = 1024
+	heapCheckInterval   = 100 * time.Millisecond
+	// MaxTrackCount is the most tracks a single run may ask for.
+	MaxTrackCount = 100
+)
+
+var (
+	ErrTimeout     = errors.New("Script took too long")
+	ErrMemoryLimit = errors.New("Script used too much memory")
+	ErrBusy        = errors.New("Too many scripts are running")
+)
+
+// ScriptError is the script itself failing, as opposed to us failing to run
+// it.
+type ScriptError struct {
+	Message string
+}
+
+func (e ScriptError) Error() string {
+	return e.Message
+}
+
+var (
+	limits    Limits
+	semaphore chan struct{}
+	// The API is the same for every run, only the runtime it is installed in
+	// changes. Programs can be shared between runtimes.
+	apiProgram = goja.MustCompile("api.js", apiScript, false)
+)
+
+// Limits apply to each run. Go can't account for memory per goroutine, so the
+// heap limit is on how far the whole process's heap grows during a run; with
+// several runs at once they share it.
+type Limits struct {
+	Timeout      time.Duration
+	MaxHeapBytes uint64
+}
+
+type Config struct {
+	Limits
+	// Concurrency is how many scripts may run at once. Each run is on a
+	// single goroutine so this also caps how many cores scripts can use.
+	Concurrency    int
+	SpacesID       string
+	SpacesSecret   string
+	SpacesEndpoint string
+	SpacesRegion   string
+	// DatasetKey is the key of a region's processed track dataset in the
+	// tracks bucket, with {region} standing in for the region.
+	DatasetKey string
+}
+
+func Initialize(cfg *Config) {
+	limits = cfg.Limits
+	if limits.Timeout == 0 {
+		limits.Timeout = defaultTimeout
+	}
+	if limits.MaxHeapBytes == 0 {
+		limits.MaxHeapBytes = defaultMaxHeapBytes
+	}
+	concurrency := cfg.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultConcurrency
+	}
+	semaphore = make(chan struct{}, concurrency)
+	initializeDatasets(cfg)
+}
+
+// Job is a single builder script run, like the Eos worker's buildPlaylist
+// message.
+type Job struct {
+	Script string
+	// TrackCount is how many tracks to build, including the first.
+	TrackCount int
+	// PrunedTrackIDs limits the run to these tracks, typically from an
+	// earlier pruner.
+	PrunedTrackIDs []string
+	// FirstTrackID skips the getFirstTrack hook when set.
+	FirstTrackID string
+}
+
+type Result struct {
+	Tracks     []json.RawMessage `json:"tracks"`
+	Dimensions []string          `json:"dimensions"`
+}
+
+// Run builds a set from the script against the region's track dataset.
+func Run(ctx context.Context, region string, job Job) (Result, error) {
+	dataset, err := GetDataset(ctx, region)
+	if err != nil {
+		return Result{}, err
+	}
+	select {
+	case semaphore <- struct{}{}:
+		defer func() { <-semaphore }()
+	case <-ctx.Done():
+		return Result{}, ErrBusy
+	}
+	return Execute(ctx, dataset, job, limits)
+}
+
+// Execute runs the job against the dataset within the limits.
+func Execute(ctx context.Context, dataset *Dataset, job Job, limits Limits) (result Result, err error) {
+	if job.TrackCount <= 0 {
+		job.TrackCount = 20
+	}
+	if job.TrackCount > MaxTrackCount {
+		return result, fmt.Errorf("Cannot build more than %d tracks", MaxTrackCount)
+	}
+	ctx, cancel := context.WithTimeout(ctx, limits.Timeout)
+	defer cancel()
+	vm := goja.New()
+	vm.SetMaxCallStackSize(maxCallStackSize)
+	// Interrupting is the only way to stop a script, whichever limit it hit.
+	var stopReason error
+	stopped := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		stopReason = watch(ctx, done, limits.MaxHeapBytes)
+		if stopReason != nil {
+			vm.Interrupt(stopReason)
+		}
+	}()
+	result, err = executeAndRecover(vm, dataset, job)
+	close(done)
+	<-stopped
+	if _, ok := err.(*goja.InterruptedError); ok && stopReason != nil {
+		err = stopReason
+	}
+	return result, err
+}
+
+func executeAndRecover(vm *goja.Runtime, dataset *Dataset, job Job) (result Result, err error) {
+	defer func() {
+		// Interrupts which land while promise jobs run come out as panics.
+		if r := recover(); r != nil {
+			if interrupted, ok := r.(*goja.InterruptedError); ok {
+				err = interrupted
+				return
+			}
+			err = fmt.Errorf("Script runtime failed: %v", r)
+		}
+	}()
+	return execute(vm, dataset, job)
+}
+
+// watch returns why the script should be stopped, or nil once it is done.
+func watch(ctx context.Context, done <-chan struct{}, maxHeapBytes uint64) error {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	baseline := stats.HeapAlloc
+	ticker := time.NewTicker(heapCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			return ErrTimeout
+		case <-ticker.C:
+			runtime.ReadMemStats(&stats)
+			if stats.HeapAlloc > baseline && stats.HeapAlloc-baseline > maxHeapBytes {
+				return ErrMemoryLimit
+			}
+		}
+	}
+}
+
+func execute(vm *goja.Runtime, dataset *Dataset, job Job) (Result, error) {
+	var result Result
+	self := vm.GlobalObject()
+	if err := vm.Set("self", self); err != nil {
+		return result, fmt.Errorf("Could not set up script runtime: %s", err)
+	}
+	parseJSON, ok := goja.AssertFunction(vm.Get("JSON").ToObject(vm).Get("parse"))
+	if !ok {
+		return result, errors.New("Script runtime has no JSON.parse")
+	}
+	parsedDataset, err := parseJSON(goja.Undefined(), vm.ToValue(string(dataset.raw)))
+	if err != nil {
+		return result, fmt.Errorf("Could not load tracks: %s", err)
+	}
+	install, err := vm.RunProgram(apiProgram)
+	if err != nil {
+		return result, fmt.Errorf("Could not load Eos API: %s", err)
+	}
+	installFn, ok := goja.AssertFunction(install)
+	if !ok {
+		return result, errors.New("Eos API is not a function")
+	}
+	var prunedTrackIDs goja.Value = goja.Null()
+	if job.PrunedTrackIDs != nil {
+		prunedTrackIDs = vm.ToValue(job.PrunedTrackIDs)
+	}
+	runnerValue, err := installFn(goja.Undefined(), self, parsedDataset, prunedTrackIDs)
+	if err != nil {
+		return result, fmt.Errorf("Could not install Eos API: %s", err)
+	}
+	runner := runnerValue.ToObject(vm)
+	// Scripts are wrapped in a closure, the same as Eos does.
+	if _, err = vm.RunScript("script.js", "(function(){"+job.Script+"\n})()"); err != nil {
+		return result, wrapScriptError("Script failed", err)
+	}
+	step := func(name string, args ...goja.Value) (goja.Value, error) {
+		fn, ok := goja.AssertFunction(runner.Get(name))
+		if !ok {
+			return nil, fmt.Errorf("Eos runner has no %s step", name)
+		}
+		return fn(runner, args...)
+	}
+	// hook calls a hook through the runner and then has the runner take up
+	// its response.
+	hook := func(name, accept string) (goja.Value, error) {
+		if _, err := step(name); err != nil {
+			return nil, wrapScriptError(fmt.Sprintf("Could not run %s hook", name), err)
+		}
+		accepted, err := step(accept)
+		if err != nil {
+			return nil, wrapScriptError(fmt.Sprintf("%s hook failed", name), err)
+		}
+		return accepted, nil
+	}
+	if _, err = hook("prune", "acceptPruned"); err != nil {
+		return result, err
+	}
+	if _, err = hook("buildTree", "acceptTree"); err != nil {
+		return result, err
+	}
+	if _, err = step("setGoalTracks", vm.ToValue(job.TrackCount)); err != nil {
+		return result, wrapScriptError("Could not start building", err)
+	}
+	if job.FirstTrackID != "" {
+		if _, err = step("setFirstTrack", vm.ToValue(job.FirstTrackID)); err != nil {
+			return result, wrapScriptError("Could not use first track", err)
+		}
+	} else {
+		added, err := hook("getFirstTrack", "acceptTrack")
+		if err != nil {
+			return result, err
+		}
+		if !added.ToBoolean() {
+			return result, ScriptError{Message: "Builder was unable to get a first track"}
+		}
+	}
+	for i := 1; i < job.TrackCount; i++ {
+		added, err := hook("getNextTrack", "acceptTrack")
+		if err != nil {
+			return result, err
+		}
+		if !added.ToBoolean() {
+			break
+		}
+	}
+	resultJSON, err := step("result")
+	if err != nil {
+		return result, fmt.Errorf("Could not get results: %s", err)
+	}
+	var ids struct {
+		Tracks     []string `json:"tracks"`
+		Dimensions []string `json:"dimensions"`
+	}
+	if err = json.Unmarshal([]byte(resultJSON.String()), &ids); err != nil {
+		return result, fmt.Errorf("Could not parse results: %s", err)
+	}
+	result.Dimensions = ids.Dimensions
+	for _, id := range ids.Tracks {
+		result.Tracks = append(result.Tracks, dataset.tracks[id])
+	}
+	return result, nil
+}
+
+func wrapScriptError(prefix string, err error) error {
+	switch jsErr := err.(type) {
+	case *goja.Exception:
+		return ScriptError{Message: fmt.Sprintf("%s: %s", prefix, jsErr.Value())}
+	case *goja.CompilerSyntaxError:
+		return ScriptError{Message: fmt.Sprintf("%s: %s", prefix, jsErr.Error())}
+	case *goja.StackOverflowError:
+		return ScriptError{Message: fmt.Sprintf("%s: %s", prefix, jsErr.Error())}
+	}
+	return err
+}
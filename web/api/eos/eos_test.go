@@ -0,0 +1,205 @@
+package eos_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/samuelhorwitz/phosphorescence/api/eos"
+)
+
+var testLimits = eos.Limits{Timeout: 10 * time.Second, MaxHeapBytes: 1024 * 1024 * 1024}
+
+func testDataset(t *testing.T, count int) *eos.Dataset {
+	tracks := make(map[string]interface{})
+	tags := make(map[string][]string)
+	idsToTags := make(map[string]string)
+	for i := 0; i < count; i++ {
+		id := fmt.Sprintf("track%d", i)
+		tag := fmt.Sprintf("tag%d", i/2)
+		tracks[id] = map[string]interface{}{
+			"id": id,
+			"track": map[string]interface{}{
+				"id":         id,
+				"name":       id,
+				"popularity": i % 100,
+				"artists":    []map[string]string{{"name": "artist"}},
+			},
+			"features": map[string]interface{}{
+				"key":              i % 12,
+				"mode":             i % 2,
+				"tempo":            100 + i%60,
+				"energy":           float64(i%10) / 10,
+				"valence":          float64(i%7) / 7,
+				"duration_ms":      300000,
+				"time_signature":   4,
+				"danceability":     0.5,
+				"instrumentalness": 0.5,
+			},
+			"evocativeness": map[string]interface{}{
+				"aetherealness":  float64(i%20) / 20,
+				"primordialness": float64(i%13) / 13,
+			},
+		}
+		tags[tag] = append(tags[tag], id)
+		idsToTags[id] = tag
+	}
+	raw, err := json.Marshal(map[string]interface{}{"tracks": tracks, "tags": tags, "idsToTags": idsToTags})
+	if err != nil {
+		t.Fatalf("Could not marshal dataset: %s", err)
+	}
+	dataset, err := eos.NewDataset(raw)
+	if err != nil {
+		t.Fatalf("Could not create dataset: %s", err)
+	}
+	return dataset
+}
+
+func readBuilder(t *testing.T, name string) string {
+	script, err := ioutil.ReadFile("../../builders/" + name)
+	if err != nil {
+		t.Fatalf("Could not read builder: %s", err)
+	}
+	return string(script)
+}
+
+func trackIDs(t *testing.T, result eos.Result) []string {
+	var ids []string
+	for _, raw := range result.Tracks {
+		var track struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(raw, &track); err != nil {
+			t.Fatalf("Could not parse track: %s", err)
+		}
+		ids = append(ids, track.ID)
+	}
+	return ids
+}
+
+func TestExecuteRandomWalk(t *testing.T) {
+	result, err := eos.Execute(context.Background(), testDataset(t, 200), eos.Job{
+		Script:     readBuilder(t, "randomwalk.js"),
+		TrackCount: 10,
+	}, testLimits)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	ids := trackIDs(t, result)
+	if len(ids) != 10 {
+		t.Fatalf("Expected 10 tracks, got %d", len(ids))
+	}
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		if id == "" || seen[id] {
+			t.Fatalf("Expected distinct tracks, got %v", ids)
+		}
+		seen[id] = true
+	}
+	if len(result.Dimensions) == 0 {
+		t.Fatal("Expected the tree's dimensions to be reported")
+	}
+}
+
+func TestExecutePrunedAndFirstTrack(t *testing.T) {
+	result, err := eos.Execute(context.Background(), testDataset(t, 200), eos.Job{
+		Script:         readBuilder(t, "randomwalk.js"),
+		TrackCount:     3,
+		PrunedTrackIDs: []string{"track1", "track3", "track5", "track7", "track9"},
+		FirstTrackID:   "track5",
+	}, testLimits)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	ids := trackIDs(t, result)
+	if len(ids) != 3 || ids[0] != "track5" {
+		t.Fatalf("Expected 3 tracks starting with track5, got %v", ids)
+	}
+	for _, id := range ids {
+		switch id {
+		case "track1", "track3", "track5", "track7", "track9":
+		default:
+			t.Fatalf("Expected only pruned tracks, got %s", id)
+		}
+	}
+}
+
+func TestExecutePromises(t *testing.T) {
+	result, err := eos.Execute(context.Background(), testDataset(t, 20), eos.Job{
+		Script: `self.hooks.getFirstTrack = function({points}) {
+	return Promise.resolve(buildResponse(buildPoint(points[0])));
+};
+self.hooks.getNextTrack = function({points, playlist}) {
+	return new Promise(resolve => resolve(buildResponse(buildPoint(points[playlist.length]))));
+};`,
+		TrackCount: 4,
+	}, testLimits)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if ids := trackIDs(t, result); len(ids) != 4 {
+		t.Fatalf("Expected 4 tracks, got %v", ids)
+	}
+}
+
+func TestExecuteScriptErrors(t *testing.T) {
+	tests := map[string]string{
+		"seeder only":   readBuilder(t, "progressive.js"),
+		"network":       `fetch('http://example.com');`,
+		"modules":       `require('fs');`,
+		"invalid track": `self.hooks.getFirstTrack = () => buildResponse(buildPoint({id: 'nope'}));`,
+		"rejected":      `self.hooks.getFirstTrack = () => Promise.reject(new Error('no'));`,
+		"syntax":        `self.hooks.getFirstTrack = (`,
+	}
+	for name, script := range tests {
+		_, err := eos.Execute(context.Background(), testDataset(t, 20), eos.Job{Script: script, TrackCount: 2}, testLimits)
+		if _, ok := err.(eos.ScriptError); !ok {
+			t.Errorf("%s: expected script error, got %v", name, err)
+		}
+	}
+}
+
+func TestExecuteTimeout(t *testing.T) {
+	_, err := eos.Execute(context.Background(), testDataset(t, 20), eos.Job{
+		Script: `self.hooks.getFirstTrack = function() { while (true) {} };`,
+	}, eos.Limits{Timeout: 200 * time.Millisecond, MaxHeapBytes: testLimits.MaxHeapBytes})
+	if err != eos.ErrTimeout {
+		t.Fatalf("Expected timeout, got %v", err)
+	}
+}
+
+func TestExecuteMemoryLimit(t *testing.T) {
+	_, err := eos.Execute(context.Background(), testDataset(t, 20), eos.Job{
+		Script: `let hoard = []; self.hooks.getFirstTrack = function() { while (true) { hoard.push(new Array(1024).fill(hoard.length)); } };`,
+	}, eos.Limits{Timeout: 10 * time.Second, MaxHeapBytes: 32 * 1024 * 1024})
+	if err != eos.ErrMemoryLimit {
+		t.Fatalf("Expected memory limit, got %v", err)
+	}
+}
+
+func TestExecuteNearestNeighbors(t *testing.T) {
+	// With a metric the tree must find exactly what comparing against every
+	// point does.
+	_, err := eos.Execute(context.Background(), testDataset(t, 500), eos.Job{
+		Script: `const distance = (a, b) => calculateEuclidianDistance(b.aetherealness - a.aetherealness, b.primordialness - a.primordialness, (b.tempo - a.tempo) / 60);
+self.hooks.buildTree = (kdTree, {points}) => buildResponse(new kdTree(points, [AETHEREALNESS, PRIMORDIALNESS, TEMPO], distance));
+self.hooks.getFirstTrack = function({points}) {
+	for (let i = 0; i < points.length; i += 37) {
+		let k = 1 + i % 25;
+		let found = getNearestNeighbors(k, points[i]).map(n => n.distance);
+		let expected = points.map(p => distance(points[i], p)).sort((a, b) => a - b).slice(0, k);
+		if (found.length != k || found.some((d, j) => Math.abs(d - expected[j]) > 1e-12)) {
+			throw new Error('Expected ' + expected + ' got ' + found);
+		}
+	}
+	return buildResponse(buildPoint(points[0]));
+};`,
+		TrackCount: 1,
+	}, testLimits)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+}
@@ -0,0 +1,79 @@
+package phosphor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/samuelhorwitz/phosphorescence/api/common"
+	"github.com/samuelhorwitz/phosphorescence/api/middleware"
+	"github.com/samuelhorwitz/phosphorescence/api/models"
+	"github.com/samuelhorwitz/phosphorescence/api/session"
+)
+
+func AnalyzeTracks(w http.ResponseWriter, r *http.Request) {
+	sess, ok := r.Context().Value(middleware.SessionContextKey).(*session.Session)
+	if !ok {
+		common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not read request body: %s", err), http.StatusBadRequest)
+		return
+	}
+	var requestBody struct {
+		Tracks []string `json:"tracks"`
+	}
+	err = json.Unmarshal(body, &requestBody)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not parse request body: %s", err), http.StatusBadRequest)
+		return
+	}
+	if len(requestBody.Tracks) < 1 {
+		common.Fail(w, errNoTracks, http.StatusBadRequest)
+		return
+	}
+	// Accept URIs too since that is what the editor sends to create playlists.
+	var trackIDs []string
+	for _, track := range requestBody.Tracks {
+		trackIDs = append(trackIDs, strings.TrimPrefix(track, "spotify:track:"))
+	}
+	analysis, err := models.AnalyzeTracks(r.Context(), sess.SpotifyCountry, trackIDs)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if err == models.ErrTooManyTracks {
+			code = http.StatusBadRequest
+		}
+		common.Fail(w, fmt.Errorf("Could not analyze tracks: %s", err), code)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"analysis": analysis})
+}
+
+func GetPlaylistAnalysis(w http.ResponseWriter, r *http.Request) {
+	sess, ok := r.Context().Value(middleware.SessionContextKey).(*session.Session)
+	if !ok {
+		common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
+		return
+	}
+	playlistID := chi.URLParam(r, "playlistID")
+	if playlistID == "" {
+		common.Fail(w, errors.New("Must include playlist ID"), http.StatusBadRequest)
+		return
+	}
+	analysis, err := models.AnalyzePlaylist(r.Context(), sess.SpotifyCountry, playlistID)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if err == models.ErrTooManyTracks {
+			code = http.StatusBadRequest
+		}
+		common.Fail(w, fmt.Errorf("Could not analyze playlist: %s", err), code)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"analysis": analysis})
+}
@@ -0,0 +1,143 @@
+package phosphor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/samuelhorwitz/phosphorescence/api/common"
+	"github.com/samuelhorwitz/phosphorescence/api/middleware"
+	"github.com/samuelhorwitz/phosphorescence/api/models"
+	"github.com/samuelhorwitz/phosphorescence/api/session"
+	"github.com/satori/go.uuid"
+)
+
+func ListScriptCollaborators(w http.ResponseWriter, r *http.Request) {
+	script, ok := r.Context().Value(middleware.ScriptContextKey).(models.Script)
+	if !ok {
+		common.Fail(w, errors.New("No script on request context"), http.StatusInternalServerError)
+		return
+	}
+	collaborators, err := models.GetScriptCollaborators(script.ID)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not get collaborators: %s", err), http.StatusInternalServerError)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"collaborators": collaborators})
+}
+
+// InviteScriptCollaborator invites someone to the script, or changes their
+// role if they already are invited.
+func InviteScriptCollaborator(w http.ResponseWriter, r *http.Request) {
+	script, ok := r.Context().Value(middleware.ScriptContextKey).(models.Script)
+	if !ok {
+		common.Fail(w, errors.New("No script on request context"), http.StatusInternalServerError)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not read request body: %s", err), http.StatusInternalServerError)
+		return
+	}
+	var requestBody struct {
+		Role models.ScriptRole `json:"role"`
+	}
+	err = json.Unmarshal(body, &requestBody)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not parse request body: %s", err), http.StatusInternalServerError)
+		return
+	}
+	if !models.ValidCollaboratorRole(requestBody.Role) {
+		common.Fail(w, fmt.Errorf("Bad request: unknown role %s", requestBody.Role), http.StatusBadRequest)
+		return
+	}
+	collaborator, err := models.InviteScriptCollaborator(script, chi.URLParam(r, "spotifyID"), requestBody.Role)
+	if err != nil {
+		switch err {
+		case models.ErrInviteeDoesNotExist:
+			common.Fail(w, err, http.StatusNotFound)
+		case models.ErrInviteeIsOwner:
+			common.Fail(w, fmt.Errorf("Bad request: %s", err), http.StatusBadRequest)
+		default:
+			common.Fail(w, fmt.Errorf("Could not invite collaborator: %s", err), http.StatusInternalServerError)
+		}
+		return
+	}
+	common.JSON(w, map[string]interface{}{"collaborator": collaborator})
+}
+
+func RevokeScriptCollaborator(w http.ResponseWriter, r *http.Request) {
+	script, ok := r.Context().Value(middleware.ScriptContextKey).(models.Script)
+	if !ok {
+		common.Fail(w, errors.New("No script on request context"), http.StatusInternalServerError)
+		return
+	}
+	revokeScriptCollaborator(w, script.ID, chi.URLParam(r, "spotifyID"))
+}
+
+func ListScriptInvites(w http.ResponseWriter, r *http.Request) {
+	sess, ok := r.Context().Value(middleware.AuthenticatedSessionContextKey).(*session.Session)
+	if !ok {
+		common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
+		return
+	}
+	invites, err := models.GetScriptInvites(sess.SpotifyID)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not get invites: %s", err), http.StatusInternalServerError)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"invites": invites})
+}
+
+func AcceptScriptInvite(w http.ResponseWriter, r *http.Request) {
+	sess, ok := r.Context().Value(middleware.AuthenticatedSessionContextKey).(*session.Session)
+	if !ok {
+		common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
+		return
+	}
+	scriptID, err := uuid.FromString(chi.URLParam(r, "scriptID"))
+	if err != nil {
+		common.Fail(w, errors.New("Invalid script ID"), http.StatusBadRequest)
+		return
+	}
+	if err = models.AcceptScriptInvite(sess.SpotifyID, scriptID); err != nil {
+		if err == models.ErrCollaboratorDoesNotExist {
+			common.Fail(w, err, http.StatusNotFound)
+			return
+		}
+		common.Fail(w, fmt.Errorf("Could not accept invite: %s", err), http.StatusInternalServerError)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"success": true})
+}
+
+// LeaveScript declines an invite, or stops collaborating on a script the user
+// had already accepted an invite to.
+func LeaveScript(w http.ResponseWriter, r *http.Request) {
+	sess, ok := r.Context().Value(middleware.AuthenticatedSessionContextKey).(*session.Session)
+	if !ok {
+		common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
+		return
+	}
+	scriptID, err := uuid.FromString(chi.URLParam(r, "scriptID"))
+	if err != nil {
+		common.Fail(w, errors.New("Invalid script ID"), http.StatusBadRequest)
+		return
+	}
+	revokeScriptCollaborator(w, scriptID, sess.SpotifyID)
+}
+
+func revokeScriptCollaborator(w http.ResponseWriter, scriptID uuid.UUID, spotifyID string) {
+	if err := models.RevokeScriptCollaborator(scriptID, spotifyID); err != nil {
+		if err == models.ErrCollaboratorDoesNotExist {
+			common.Fail(w, err, http.StatusNotFound)
+			return
+		}
+		common.Fail(w, fmt.Errorf("Could not revoke collaborator: %s", err), http.StatusInternalServerError)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"success": true})
+}
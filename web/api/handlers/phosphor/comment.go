@@ -0,0 +1,237 @@
+package phosphor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/samuelhorwitz/phosphorescence/api/common"
+	"github.com/samuelhorwitz/phosphorescence/api/middleware"
+	"github.com/samuelhorwitz/phosphorescence/api/models"
+	"github.com/samuelhorwitz/phosphorescence/api/session"
+	"github.com/satori/go.uuid"
+)
+
+const maxCommentLength = 4096
+
+func ListComments(w http.ResponseWriter, r *http.Request) {
+	listComments(w, r, uuid.NullUUID{})
+}
+
+func ListCommentReplies(w http.ResponseWriter, r *http.Request) {
+	comment, ok := r.Context().Value(middleware.CommentContextKey).(models.Comment)
+	if !ok {
+		common.Fail(w, errors.New("No comment on request context"), http.StatusInternalServerError)
+		return
+	}
+	listComments(w, r, uuid.NullUUID{UUID: comment.ID, Valid: true})
+}
+
+func listComments(w http.ResponseWriter, r *http.Request, parentID uuid.NullUUID) {
+	target, ok := r.Context().Value(middleware.CommentTargetContextKey).(models.CommentTarget)
+	if !ok {
+		common.Fail(w, errors.New("No comment target on request context"), http.StatusInternalServerError)
+		return
+	}
+	count, ok := r.Context().Value(middleware.PageCountContextKey).(uint64)
+	if !ok {
+		common.Fail(w, errors.New("No page count on request context"), http.StatusInternalServerError)
+		return
+	}
+	from, ok := r.Context().Value(middleware.PageCursorContextKey).(time.Time)
+	if !ok {
+		common.Fail(w, errors.New("No page cursor on request context"), http.StatusInternalServerError)
+		return
+	}
+	comments, err := models.GetComments(target, parentID, count, from)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not get comments: %s", err), http.StatusInternalServerError)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"comments": comments})
+}
+
+func GetComment(w http.ResponseWriter, r *http.Request) {
+	comment, ok := r.Context().Value(middleware.CommentContextKey).(models.Comment)
+	if !ok {
+		common.Fail(w, errors.New("No comment on request context"), http.StatusInternalServerError)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"comment": comment})
+}
+
+func GetCommentHistory(w http.ResponseWriter, r *http.Request) {
+	comment, ok := r.Context().Value(middleware.CommentContextKey).(models.Comment)
+	if !ok {
+		common.Fail(w, errors.New("No comment on request context"), http.StatusInternalServerError)
+		return
+	}
+	if comment.Deleted != "" {
+		common.Fail(w, errors.New("Comment was deleted"), http.StatusNotFound)
+		return
+	}
+	revisions, err := models.GetCommentRevisions(comment.ID)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not get comment history: %s", err), http.StatusInternalServerError)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"revisions": revisions})
+}
+
+func CreateComment(w http.ResponseWriter, r *http.Request) {
+	sess, ok := r.Context().Value(middleware.AuthenticatedSessionContextKey).(*session.Session)
+	if !ok {
+		common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
+		return
+	}
+	target, ok := r.Context().Value(middleware.CommentTargetContextKey).(models.CommentTarget)
+	if !ok {
+		common.Fail(w, errors.New("No comment target on request context"), http.StatusInternalServerError)
+		return
+	}
+	// Authors can still read what was said while it was public, but nobody
+	// can add to it.
+	if target.IsPrivate {
+		common.Fail(w, errors.New("Cannot comment on private scripts"), http.StatusForbidden)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not read request body: %s", err), http.StatusInternalServerError)
+		return
+	}
+	var requestBody struct {
+		Body     string     `json:"body"`
+		ParentID *uuid.UUID `json:"parentId"`
+	}
+	err = json.Unmarshal(body, &requestBody)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not parse request body: %s", err), http.StatusInternalServerError)
+		return
+	}
+	if err := validateComment(requestBody.Body); err != nil {
+		common.Fail(w, fmt.Errorf("Bad request: %s", err), http.StatusBadRequest)
+		return
+	}
+	var parentID uuid.NullUUID
+	if requestBody.ParentID != nil {
+		parent, ok, err := models.GetComment(target, *requestBody.ParentID)
+		if err != nil {
+			common.Fail(w, fmt.Errorf("Could not get parent comment: %s", err), http.StatusInternalServerError)
+			return
+		}
+		if !ok || parent.Deleted != "" {
+			common.Fail(w, errors.New("Bad request: Cannot reply to a comment which does not exist"), http.StatusBadRequest)
+			return
+		}
+		parentID = uuid.NullUUID{UUID: parent.ID, Valid: true}
+	}
+	comment, err := models.CreateComment(sess.SpotifyID, target, parentID, requestBody.Body)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not create comment: %s", err), http.StatusInternalServerError)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"comment": comment})
+}
+
+func EditComment(w http.ResponseWriter, r *http.Request) {
+	sess, ok := r.Context().Value(middleware.AuthenticatedSessionContextKey).(*session.Session)
+	if !ok {
+		common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
+		return
+	}
+	target, ok := r.Context().Value(middleware.CommentTargetContextKey).(models.CommentTarget)
+	if !ok {
+		common.Fail(w, errors.New("No comment target on request context"), http.StatusInternalServerError)
+		return
+	}
+	comment, ok := r.Context().Value(middleware.CommentContextKey).(models.Comment)
+	if !ok {
+		common.Fail(w, errors.New("No comment on request context"), http.StatusInternalServerError)
+		return
+	}
+	if comment.Deleted != "" || comment.AuthorSpotifyID.String != sess.SpotifyID {
+		common.Fail(w, errors.New("User is not author of comment"), http.StatusForbidden)
+		return
+	}
+	// Editing adds to the conversation as much as commenting does, and hidden
+	// scripts are private too.
+	if target.IsPrivate {
+		common.Fail(w, errors.New("Cannot comment on private scripts"), http.StatusForbidden)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not read request body: %s", err), http.StatusInternalServerError)
+		return
+	}
+	var requestBody struct {
+		Body string `json:"body"`
+	}
+	err = json.Unmarshal(body, &requestBody)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not parse request body: %s", err), http.StatusInternalServerError)
+		return
+	}
+	if err := validateComment(requestBody.Body); err != nil {
+		common.Fail(w, fmt.Errorf("Bad request: %s", err), http.StatusBadRequest)
+		return
+	}
+	comment, err = models.EditComment(target, comment.ID, requestBody.Body)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not edit comment: %s", err), http.StatusInternalServerError)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"comment": comment})
+}
+
+// DeleteComment lets whoever wrote a comment delete it, and the author of the
+// script or chain it is on remove it.
+func DeleteComment(w http.ResponseWriter, r *http.Request) {
+	sess, ok := r.Context().Value(middleware.AuthenticatedSessionContextKey).(*session.Session)
+	if !ok {
+		common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
+		return
+	}
+	target, ok := r.Context().Value(middleware.CommentTargetContextKey).(models.CommentTarget)
+	if !ok {
+		common.Fail(w, errors.New("No comment target on request context"), http.StatusInternalServerError)
+		return
+	}
+	comment, ok := r.Context().Value(middleware.CommentContextKey).(models.Comment)
+	if !ok {
+		common.Fail(w, errors.New("No comment on request context"), http.StatusInternalServerError)
+		return
+	}
+	if comment.Deleted != "" {
+		common.JSON(w, map[string]interface{}{"success": true})
+		return
+	}
+	isCommentAuthor := comment.AuthorSpotifyID.String == sess.SpotifyID
+	if !isCommentAuthor && target.AuthorSpotifyID != sess.SpotifyID {
+		common.Fail(w, errors.New("User cannot delete comment"), http.StatusForbidden)
+		return
+	}
+	if err := models.DeleteComment(comment.ID, !isCommentAuthor); err != nil {
+		common.Fail(w, fmt.Errorf("Could not delete comment: %s", err), http.StatusInternalServerError)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"success": true})
+}
+
+func validateComment(body string) error {
+	if strings.TrimSpace(body) == "" {
+		return errors.New("Comment is empty")
+	}
+	if len([]byte(body)) > maxCommentLength {
+		return errors.New("Comment is too long")
+	}
+	if containsHTML(body) {
+		return errors.New("Comment contained HTML")
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package phosphor
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/samuelhorwitz/phosphorescence/api/middleware"
+	"github.com/samuelhorwitz/phosphorescence/api/models"
+	"github.com/samuelhorwitz/phosphorescence/api/session"
+	"github.com/satori/go.uuid"
+)
+
+func TestEditCommentOnPrivateScript(t *testing.T) {
+	script := models.Script{ID: uuid.NewV4(), IsPrivate: true}
+	comment := models.Comment{ID: uuid.NewV4()}
+	comment.AuthorSpotifyID.String, comment.AuthorSpotifyID.Valid = "me", true
+	r := httptest.NewRequest(http.MethodPut, "/comment/"+comment.ID.String(), strings.NewReader(`{"body":"Edited"}`))
+	ctx := context.WithValue(r.Context(), middleware.AuthenticatedSessionContextKey, &session.Session{Authenticated: true, SpotifyID: "me"})
+	ctx = context.WithValue(ctx, middleware.CommentTargetContextKey, models.ScriptCommentTarget(script))
+	ctx = context.WithValue(ctx, middleware.CommentContextKey, comment)
+	w := httptest.NewRecorder()
+	EditComment(w, r.WithContext(ctx))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("Expected editing a comment on a private script to be forbidden, got %d", w.Code)
+	}
+}
@@ -14,6 +14,11 @@ import (
 	"github.com/samuelhorwitz/phosphorescence/api/session"
 )
 
+// Spotify usually takes well under a second to hand off, much longer and the
+// device isn't going to pick up. This has to leave room for the transfer request
+// itself within the handler timeout.
+const transferConfirmTimeout = 3 * time.Second
+
 func TransferPlayback(w http.ResponseWriter, r *http.Request) {
 	sess, ok := r.Context().Value(middleware.SessionContextKey).(*session.Session)
 	if !ok {
@@ -32,48 +37,17 @@ func TransferPlayback(w http.ResponseWriter, r *http.Request) {
 	case "pause":
 		playState = models.PlayStatePause
 	}
-	models.TransferPlayback(r.Context(), sess, deviceID, playState)
-	time.Sleep(250 * time.Millisecond)
-	type returnDevicesEnvelope struct {
-		devices models.SpotifyDevices
-		success bool
+	outcome, err := models.TransferAndConfirm(r.Context(), sess, deviceID, playState, transferConfirmTimeout)
+	if err != nil {
+		failPlayback(w, fmt.Errorf("Could not transfer playback: %s", err), err)
+		return
 	}
-	returnDevicesChan := make(chan returnDevicesEnvelope)
-	errChan := make(chan error)
-	done := make(chan struct{})
-	go func() {
-		common.ExponentialBackoff(100*time.Millisecond, 750*time.Millisecond, func() bool {
-			devices, err := models.GetDevices(r.Context(), sess)
-			if err != nil {
-				errChan <- err
-				return true
-			}
-			for _, device := range devices.Devices {
-				if device.ID == deviceID && device.IsActive {
-					returnDevicesChan <- returnDevicesEnvelope{devices, true}
-					return true
-				}
-			}
-			returnDevicesChan <- returnDevicesEnvelope{devices, false}
-			return false
-		})
-		close(done)
-	}()
-	var returnDevices models.SpotifyDevices
-	for {
-		select {
-		case devicesEnvelope := <-returnDevicesChan:
-			if devicesEnvelope.success {
-				common.JSON(w, devicesEnvelope.devices)
-				return
-			}
-			returnDevices = devicesEnvelope.devices
-		case err := <-errChan:
-			common.Fail(w, fmt.Errorf("Could not get devices: %s", err), http.StatusInternalServerError)
-			return
-		case <-done:
-			common.FailWithJSON(w, fmt.Errorf("Device %s does not exist", deviceID), returnDevices, http.StatusNotFound)
-			return
-		}
+	switch outcome.Status {
+	case models.TransferStatusDeviceVanished:
+		common.FailWithJSON(w, fmt.Errorf("Device %s does not exist", deviceID), outcome, http.StatusNotFound)
+	case models.TransferStatusTimedOut:
+		common.FailWithJSON(w, fmt.Errorf("Device %s did not take over playback in time", deviceID), outcome, http.StatusGatewayTimeout)
+	default:
+		common.JSON(w, outcome)
 	}
 }
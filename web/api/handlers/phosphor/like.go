@@ -0,0 +1,110 @@
+package phosphor
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/samuelhorwitz/phosphorescence/api/common"
+	"github.com/samuelhorwitz/phosphorescence/api/middleware"
+	"github.com/samuelhorwitz/phosphorescence/api/models"
+	"github.com/samuelhorwitz/phosphorescence/api/session"
+	"github.com/satori/go.uuid"
+)
+
+func LikeScript(w http.ResponseWriter, r *http.Request) {
+	setScriptLike(w, r, models.LikeScript)
+}
+
+func UnlikeScript(w http.ResponseWriter, r *http.Request) {
+	setScriptLike(w, r, models.UnlikeScript)
+}
+
+func LikeScriptChain(w http.ResponseWriter, r *http.Request) {
+	setScriptChainLike(w, r, models.LikeScriptChain)
+}
+
+func UnlikeScriptChain(w http.ResponseWriter, r *http.Request) {
+	setScriptChainLike(w, r, models.UnlikeScriptChain)
+}
+
+func ListCurrentUserLikes(w http.ResponseWriter, r *http.Request) {
+	sess, ok := r.Context().Value(middleware.AuthenticatedSessionContextKey).(*session.Session)
+	if !ok {
+		common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
+		return
+	}
+	count, ok := r.Context().Value(middleware.PageCountContextKey).(uint64)
+	if !ok {
+		common.Fail(w, errors.New("No page count on request context"), http.StatusInternalServerError)
+		return
+	}
+	from, ok := r.Context().Value(middleware.PageCursorContextKey).(time.Time)
+	if !ok {
+		common.Fail(w, errors.New("No page cursor on request context"), http.StatusInternalServerError)
+		return
+	}
+	likes, err := models.GetLikesBySpotifyUserID(sess.SpotifyID, count, from)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not get likes for user: %s", err), http.StatusInternalServerError)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"likes": likes})
+}
+
+// setScriptLike responds with the script's like count as it is after the change
+// so clients don't have to guess at it.
+func setScriptLike(w http.ResponseWriter, r *http.Request, set func(string, uuid.UUID) error) {
+	sess, ok := r.Context().Value(middleware.AuthenticatedSessionContextKey).(*session.Session)
+	if !ok {
+		common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
+		return
+	}
+	script, ok := r.Context().Value(middleware.ScriptContextKey).(models.Script)
+	if !ok {
+		common.Fail(w, errors.New("No script on request context"), http.StatusInternalServerError)
+		return
+	}
+	if err := set(sess.SpotifyID, script.ID); err != nil {
+		common.Fail(w, fmt.Errorf("Could not update script like: %s", err), http.StatusInternalServerError)
+		return
+	}
+	script, ok, err := models.GetScriptWithAuthorizationCheck(sess.SpotifyID, script.ID)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not get script: %s", err), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		common.Fail(w, errors.New("Script no longer exists"), http.StatusNotFound)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"likes": script.Likes, "likedByMe": script.LikedByMe})
+}
+
+func setScriptChainLike(w http.ResponseWriter, r *http.Request, set func(string, uuid.UUID) error) {
+	sess, ok := r.Context().Value(middleware.AuthenticatedSessionContextKey).(*session.Session)
+	if !ok {
+		common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
+		return
+	}
+	scriptChain, ok := r.Context().Value(middleware.ScriptChainContextKey).(models.ScriptChain)
+	if !ok {
+		common.Fail(w, errors.New("No script chain on request context"), http.StatusInternalServerError)
+		return
+	}
+	if err := set(sess.SpotifyID, scriptChain.ID); err != nil {
+		common.Fail(w, fmt.Errorf("Could not update script chain like: %s", err), http.StatusInternalServerError)
+		return
+	}
+	scriptChain, ok, err := models.GetScriptChainWithAuthorizationCheck(sess.SpotifyID, scriptChain.ID)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not get script chain: %s", err), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		common.Fail(w, errors.New("Script chain no longer exists"), http.StatusNotFound)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"likes": scriptChain.Likes, "likedByMe": scriptChain.LikedByMe})
+}
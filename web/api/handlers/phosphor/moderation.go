@@ -0,0 +1,225 @@
+package phosphor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/samuelhorwitz/phosphorescence/api/common"
+	"github.com/samuelhorwitz/phosphorescence/api/middleware"
+	"github.com/samuelhorwitz/phosphorescence/api/models"
+	"github.com/samuelhorwitz/phosphorescence/api/session"
+	"github.com/satori/go.uuid"
+)
+
+const (
+	maxReportDetailsLength    = 1024
+	maxModerationReasonLength = 1024
+)
+
+func ReportScript(w http.ResponseWriter, r *http.Request) {
+	script, ok := r.Context().Value(middleware.ScriptContextKey).(models.Script)
+	if !ok {
+		common.Fail(w, errors.New("No script on request context"), http.StatusInternalServerError)
+		return
+	}
+	report(w, r, func(spotifyID string, reason models.ReportReason, details string) error {
+		return models.ReportScript(spotifyID, script.ID, reason, details)
+	})
+}
+
+func ReportComment(w http.ResponseWriter, r *http.Request) {
+	comment, ok := r.Context().Value(middleware.CommentContextKey).(models.Comment)
+	if !ok {
+		common.Fail(w, errors.New("No comment on request context"), http.StatusInternalServerError)
+		return
+	}
+	if comment.Deleted != "" {
+		common.Fail(w, errors.New("Comment was deleted"), http.StatusNotFound)
+		return
+	}
+	report(w, r, func(spotifyID string, reason models.ReportReason, details string) error {
+		return models.ReportComment(spotifyID, comment.ID, reason, details)
+	})
+}
+
+func report(w http.ResponseWriter, r *http.Request, create func(string, models.ReportReason, string) error) {
+	sess, ok := r.Context().Value(middleware.AuthenticatedSessionContextKey).(*session.Session)
+	if !ok {
+		common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not read request body: %s", err), http.StatusInternalServerError)
+		return
+	}
+	var requestBody struct {
+		Reason  models.ReportReason `json:"reason"`
+		Details string              `json:"details"`
+	}
+	err = json.Unmarshal(body, &requestBody)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not parse request body: %s", err), http.StatusInternalServerError)
+		return
+	}
+	if !models.ValidReportReason(requestBody.Reason) {
+		common.Fail(w, fmt.Errorf("Bad request: unknown reason %s", requestBody.Reason), http.StatusBadRequest)
+		return
+	}
+	if len([]byte(requestBody.Details)) > maxReportDetailsLength {
+		common.Fail(w, errors.New("Bad request: Details are too long"), http.StatusBadRequest)
+		return
+	}
+	if containsHTML(requestBody.Details) {
+		common.Fail(w, errors.New("Bad request: Details contained HTML"), http.StatusBadRequest)
+		return
+	}
+	if err = create(sess.SpotifyID, requestBody.Reason, requestBody.Details); err != nil {
+		common.Fail(w, fmt.Errorf("Could not report: %s", err), http.StatusInternalServerError)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"success": true})
+}
+
+func ListReports(w http.ResponseWriter, r *http.Request) {
+	count, ok := r.Context().Value(middleware.PageCountContextKey).(uint64)
+	if !ok {
+		common.Fail(w, errors.New("No page count on request context"), http.StatusInternalServerError)
+		return
+	}
+	from, ok := r.Context().Value(middleware.PageCursorContextKey).(time.Time)
+	if !ok {
+		common.Fail(w, errors.New("No page cursor on request context"), http.StatusInternalServerError)
+		return
+	}
+	reports, err := models.GetReports(r.URL.Query().Get("status") == "resolved", count, from)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not get reports: %s", err), http.StatusInternalServerError)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"reports": reports})
+}
+
+func GetModerationLog(w http.ResponseWriter, r *http.Request) {
+	count, ok := r.Context().Value(middleware.PageCountContextKey).(uint64)
+	if !ok {
+		common.Fail(w, errors.New("No page count on request context"), http.StatusInternalServerError)
+		return
+	}
+	from, ok := r.Context().Value(middleware.PageCursorContextKey).(time.Time)
+	if !ok {
+		common.Fail(w, errors.New("No page cursor on request context"), http.StatusInternalServerError)
+		return
+	}
+	entries, err := models.GetModerationLog(count, from)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not get moderation log: %s", err), http.StatusInternalServerError)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"log": entries})
+}
+
+func HideScript(w http.ResponseWriter, r *http.Request) {
+	moderate(w, r, "scriptID", models.HideScript)
+}
+
+func RestoreScript(w http.ResponseWriter, r *http.Request) {
+	moderate(w, r, "scriptID", models.RestoreScript)
+}
+
+func RemoveComment(w http.ResponseWriter, r *http.Request) {
+	moderate(w, r, "commentID", models.RemoveComment)
+}
+
+func DismissReport(w http.ResponseWriter, r *http.Request) {
+	moderate(w, r, "reportID", models.DismissReport)
+}
+
+// moderate runs a moderation action against the ID in the URL. The reason
+// goes in the moderation log and, where someone is notified, to them.
+func moderate(w http.ResponseWriter, r *http.Request, param string, action func(string, uuid.UUID, string) error) {
+	sess, ok := r.Context().Value(middleware.AuthenticatedSessionContextKey).(*session.Session)
+	if !ok {
+		common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
+		return
+	}
+	id, err := uuid.FromString(chi.URLParam(r, param))
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Invalid %s", param), http.StatusBadRequest)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not read request body: %s", err), http.StatusBadRequest)
+		return
+	}
+	var requestBody struct {
+		Reason string `json:"reason"`
+	}
+	if len(body) > 0 {
+		err = json.Unmarshal(body, &requestBody)
+		if err != nil {
+			common.Fail(w, fmt.Errorf("Could not parse request body: %s", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if len([]byte(requestBody.Reason)) > maxModerationReasonLength {
+		common.Fail(w, errors.New("Reason is too long"), http.StatusBadRequest)
+		return
+	}
+	if containsHTML(requestBody.Reason) {
+		common.Fail(w, errors.New("Reason contained HTML"), http.StatusBadRequest)
+		return
+	}
+	if err = action(sess.SpotifyID, id, requestBody.Reason); err != nil {
+		if err == models.ErrNothingToModerate {
+			common.Fail(w, err, http.StatusNotFound)
+			return
+		}
+		common.Fail(w, fmt.Errorf("Could not moderate: %s", err), http.StatusInternalServerError)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"success": true})
+}
+
+func ListNotifications(w http.ResponseWriter, r *http.Request) {
+	sess, ok := r.Context().Value(middleware.AuthenticatedSessionContextKey).(*session.Session)
+	if !ok {
+		common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
+		return
+	}
+	count, ok := r.Context().Value(middleware.PageCountContextKey).(uint64)
+	if !ok {
+		common.Fail(w, errors.New("No page count on request context"), http.StatusInternalServerError)
+		return
+	}
+	from, ok := r.Context().Value(middleware.PageCursorContextKey).(time.Time)
+	if !ok {
+		common.Fail(w, errors.New("No page cursor on request context"), http.StatusInternalServerError)
+		return
+	}
+	notifications, err := models.GetNotifications(sess.SpotifyID, count, from)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not get notifications: %s", err), http.StatusInternalServerError)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"notifications": notifications})
+}
+
+func MarkNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	sess, ok := r.Context().Value(middleware.AuthenticatedSessionContextKey).(*session.Session)
+	if !ok {
+		common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
+		return
+	}
+	if err := models.MarkNotificationsRead(sess.SpotifyID); err != nil {
+		common.Fail(w, fmt.Errorf("Could not mark notifications read: %s", err), http.StatusInternalServerError)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"success": true})
+}
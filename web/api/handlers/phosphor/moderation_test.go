@@ -0,0 +1,98 @@
+package phosphor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/samuelhorwitz/phosphorescence/api/middleware"
+	"github.com/samuelhorwitz/phosphorescence/api/models"
+	"github.com/samuelhorwitz/phosphorescence/api/session"
+	"github.com/satori/go.uuid"
+)
+
+func moderationRequest(param, id string, body interface{}) *http.Request {
+	encoded, _ := json.Marshal(body)
+	r := httptest.NewRequest("POST", "/", strings.NewReader(string(encoded)))
+	routeContext := chi.NewRouteContext()
+	routeContext.URLParams.Add(param, id)
+	ctx := context.WithValue(r.Context(), chi.RouteCtxKey, routeContext)
+	ctx = context.WithValue(ctx, middleware.AuthenticatedSessionContextKey, &session.Session{Authenticated: true, SpotifyID: "admin"})
+	return r.WithContext(ctx)
+}
+
+func TestModerate(t *testing.T) {
+	noHTML = bluemonday.StrictPolicy()
+	commentID := uuid.NewV4()
+	tests := []struct {
+		name     string
+		id       string
+		reason   string
+		err      error
+		expected int
+	}{
+		{"valid", commentID.String(), "Spam", nil, http.StatusOK},
+		{"bad ID", "nope", "Spam", nil, http.StatusBadRequest},
+		{"reason too long", commentID.String(), strings.Repeat("a", maxModerationReasonLength+1), nil, http.StatusBadRequest},
+		{"reason with HTML", commentID.String(), "<b>Spam</b>", nil, http.StatusBadRequest},
+		{"nothing to moderate", commentID.String(), "Spam", models.ErrNothingToModerate, http.StatusNotFound},
+		{"failure", commentID.String(), "Spam", errors.New("DB is down"), http.StatusInternalServerError},
+	}
+	for _, test := range tests {
+		var called bool
+		w := httptest.NewRecorder()
+		moderate(w, moderationRequest("commentID", test.id, map[string]string{"reason": test.reason}), "commentID", func(spotifyID string, id uuid.UUID, reason string) error {
+			called = true
+			if spotifyID != "admin" || !uuid.Equal(id, commentID) || reason != test.reason {
+				t.Errorf("%s: Expected admin, %s and %q, got %s, %s and %q", test.name, commentID, test.reason, spotifyID, id, reason)
+			}
+			return test.err
+		})
+		if w.Code != test.expected {
+			t.Errorf("%s: Expected %d, got %d: %s", test.name, test.expected, w.Code, w.Body.String())
+		}
+		if shouldCall := test.expected != http.StatusBadRequest; called != shouldCall {
+			t.Errorf("%s: Expected action called to be %t", test.name, shouldCall)
+		}
+	}
+}
+
+func TestReport(t *testing.T) {
+	noHTML = bluemonday.StrictPolicy()
+	tests := []struct {
+		name     string
+		reason   models.ReportReason
+		details  string
+		expected int
+	}{
+		{"valid", models.ReportReasonSpam, "Selling followers", http.StatusOK},
+		{"no details", models.ReportReasonOther, "", http.StatusOK},
+		{"unknown reason", "boring", "", http.StatusBadRequest},
+		{"details too long", models.ReportReasonSpam, strings.Repeat("a", maxReportDetailsLength+1), http.StatusBadRequest},
+		{"details with HTML", models.ReportReasonSpam, "<a href=\"x\">x</a>", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		var called bool
+		w := httptest.NewRecorder()
+		r := moderationRequest("scriptID", uuid.NewV4().String(), map[string]string{"reason": string(test.reason), "details": test.details})
+		report(w, r, func(spotifyID string, reason models.ReportReason, details string) error {
+			called = true
+			if reason != test.reason || details != test.details {
+				t.Errorf("%s: Expected %s and %q, got %s and %q", test.name, test.reason, test.details, reason, details)
+			}
+			return nil
+		})
+		if w.Code != test.expected {
+			t.Errorf("%s: Expected %d, got %d: %s", test.name, test.expected, w.Code, w.Body.String())
+		}
+		if shouldCall := test.expected == http.StatusOK; called != shouldCall {
+			t.Errorf("%s: Expected report created to be %t", test.name, shouldCall)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package phosphor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/samuelhorwitz/phosphorescence/api/common"
+	"github.com/samuelhorwitz/phosphorescence/api/middleware"
+	"github.com/samuelhorwitz/phosphorescence/api/models"
+	"github.com/samuelhorwitz/phosphorescence/api/session"
+)
+
+const (
+	maxOfficialPlaylistTitleLength = 200
+	maxOfficialPlaylistTags        = 20
+	maxOfficialPlaylistTagLength   = 50
+)
+
+func ListOfficialPlaylists(w http.ResponseWriter, r *http.Request) {
+	count, ok := r.Context().Value(middleware.PageCountContextKey).(uint64)
+	if !ok {
+		common.Fail(w, errors.New("No page count on request context"), http.StatusInternalServerError)
+		return
+	}
+	from, ok := r.Context().Value(middleware.PageCursorContextKey).(time.Time)
+	if !ok {
+		common.Fail(w, errors.New("No page cursor on request context"), http.StatusInternalServerError)
+		return
+	}
+	playlists, err := models.GetOfficialPlaylists(count, from)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not get official playlists: %s", err), http.StatusInternalServerError)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"playlists": playlists})
+}
+
+func PromoteOfficialPlaylist(w http.ResponseWriter, r *http.Request) {
+	sess, ok := r.Context().Value(middleware.AuthenticatedSessionContextKey).(*session.Session)
+	if !ok {
+		common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
+		return
+	}
+	playlistID := chi.URLParam(r, "playlistID")
+	if playlistID == "" {
+		common.Fail(w, errors.New("Must include playlist ID"), http.StatusBadRequest)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not read request body: %s", err), http.StatusBadRequest)
+		return
+	}
+	var requestBody struct {
+		Title string   `json:"title"`
+		Tags  []string `json:"tags"`
+	}
+	if len(body) > 0 {
+		err = json.Unmarshal(body, &requestBody)
+		if err != nil {
+			common.Fail(w, fmt.Errorf("Could not parse request body: %s", err), http.StatusBadRequest)
+			return
+		}
+	}
+	// Titles and tags are plain text, they are stored as given and escaped
+	// wherever they are shown.
+	title := strings.TrimSpace(requestBody.Title)
+	if containsHTML(title) {
+		common.Fail(w, errors.New("Title contained HTML"), http.StatusBadRequest)
+		return
+	}
+	if len([]byte(title)) > maxOfficialPlaylistTitleLength {
+		common.Fail(w, fmt.Errorf("Title must be at most %d characters", maxOfficialPlaylistTitleLength), http.StatusBadRequest)
+		return
+	}
+	tags, err := cleanOfficialPlaylistTags(requestBody.Tags)
+	if err != nil {
+		common.Fail(w, err, http.StatusBadRequest)
+		return
+	}
+	playlist, err := models.PromoteOfficialPlaylist(r.Context(), sess.SpotifyID, playlistID, title, tags)
+	if err != nil {
+		if err == models.ErrPlaylistAlreadyOfficial {
+			common.Fail(w, err, http.StatusConflict)
+			return
+		}
+		common.Fail(w, fmt.Errorf("Could not promote official playlist: %s", err), http.StatusInternalServerError)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"playlist": playlist})
+}
+
+func RetireOfficialPlaylist(w http.ResponseWriter, r *http.Request) {
+	sess, ok := r.Context().Value(middleware.AuthenticatedSessionContextKey).(*session.Session)
+	if !ok {
+		common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
+		return
+	}
+	playlistID := chi.URLParam(r, "playlistID")
+	if playlistID == "" {
+		common.Fail(w, errors.New("Must include playlist ID"), http.StatusBadRequest)
+		return
+	}
+	err := models.RetireOfficialPlaylist(r.Context(), sess.SpotifyID, playlistID)
+	if err != nil {
+		if err == models.ErrPlaylistNotOfficial {
+			common.Fail(w, err, http.StatusNotFound)
+			return
+		}
+		common.Fail(w, fmt.Errorf("Could not retire official playlist: %s", err), http.StatusInternalServerError)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"playlist": playlistID})
+}
+
+// Tags are stored the way the search hashtags are matched, lowercase without
+// the leading hashmark.
+func cleanOfficialPlaylistTags(tags []string) ([]string, error) {
+	if len(tags) > maxOfficialPlaylistTags {
+		return nil, fmt.Errorf("Must include at most %d tags", maxOfficialPlaylistTags)
+	}
+	seen := make(map[string]bool)
+	cleanedTags := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if containsHTML(tag) {
+			return nil, errors.New("Tags cannot contain HTML")
+		}
+		if len([]byte(tag)) > maxOfficialPlaylistTagLength {
+			return nil, fmt.Errorf("Tags must be at most %d characters", maxOfficialPlaylistTagLength)
+		}
+		seen[tag] = true
+		cleanedTags = append(cleanedTags, tag)
+	}
+	return cleanedTags, nil
+}
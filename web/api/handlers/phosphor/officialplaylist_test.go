@@ -0,0 +1,21 @@
+package phosphor
+
+import (
+	"testing"
+
+	"github.com/microcosm-cc/bluemonday"
+)
+
+func TestCleanOfficialPlaylistTags(t *testing.T) {
+	noHTML = bluemonday.StrictPolicy()
+	tags, err := cleanOfficialPlaylistTags([]string{"#Rock & Roll", "rock & roll", " drum'n'bass "})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(tags) != 2 || tags[0] != "rock & roll" || tags[1] != "drum'n'bass" {
+		t.Fatalf("Expected tags to be stored as given, got %q", tags)
+	}
+	if _, err = cleanOfficialPlaylistTags([]string{"<b>rock</b>"}); err == nil {
+		t.Fatal("Expected HTML in tags to be rejected")
+	}
+}
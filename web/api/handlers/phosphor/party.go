@@ -0,0 +1,195 @@
+package phosphor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/samuelhorwitz/phosphorescence/api/common"
+	"github.com/samuelhorwitz/phosphorescence/api/middleware"
+	"github.com/samuelhorwitz/phosphorescence/api/party"
+	"github.com/samuelhorwitz/phosphorescence/api/session"
+)
+
+func CreateParty(w http.ResponseWriter, r *http.Request) {
+	sess, ok := r.Context().Value(middleware.SessionContextKey).(*session.Session)
+	if !ok {
+		common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
+		return
+	}
+	p, err := party.Create(sess)
+	if err != nil {
+		failParty(w, fmt.Errorf("Could not create party: %s", err), err)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"party": p})
+}
+
+// GetParty is only for the host and members, the party ID alone isn't enough to
+// see who is in it.
+func GetParty(w http.ResponseWriter, r *http.Request) {
+	sess, ok := r.Context().Value(middleware.SessionContextKey).(*session.Session)
+	if !ok {
+		common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
+		return
+	}
+	partyID := chi.URLParam(r, "partyID")
+	inParty, err := party.IsInParty(partyID, sess.SpotifyID)
+	if err != nil {
+		failParty(w, fmt.Errorf("Could not check party membership: %s", err), err)
+		return
+	}
+	if !inParty {
+		common.Fail(w, party.ErrNotMember, http.StatusForbidden)
+		return
+	}
+	p, err := party.Get(partyID)
+	if err != nil {
+		failParty(w, fmt.Errorf("Could not get party: %s", err), err)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"party": p})
+}
+
+func JoinParty(w http.ResponseWriter, r *http.Request) {
+	sess, ok := r.Context().Value(middleware.SessionContextKey).(*session.Session)
+	if !ok {
+		common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
+		return
+	}
+	p, err := party.Join(chi.URLParam(r, "partyID"), sess)
+	if err != nil {
+		failParty(w, fmt.Errorf("Could not join party: %s", err), err)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"party": p})
+}
+
+func LeaveParty(w http.ResponseWriter, r *http.Request) {
+	sess, ok := r.Context().Value(middleware.SessionContextKey).(*session.Session)
+	if !ok {
+		common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
+		return
+	}
+	err := party.Leave(chi.URLParam(r, "partyID"), sess)
+	if err != nil {
+		failParty(w, fmt.Errorf("Could not leave party: %s", err), err)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"success": true})
+}
+
+func KickPartyMember(w http.ResponseWriter, r *http.Request) {
+	sess, ok := r.Context().Value(middleware.SessionContextKey).(*session.Session)
+	if !ok {
+		common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
+		return
+	}
+	err := party.Kick(chi.URLParam(r, "partyID"), sess, chi.URLParam(r, "spotifyID"))
+	if err != nil {
+		failParty(w, fmt.Errorf("Could not kick party member: %s", err), err)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"success": true})
+}
+
+func EndParty(w http.ResponseWriter, r *http.Request) {
+	sess, ok := r.Context().Value(middleware.SessionContextKey).(*session.Session)
+	if !ok {
+		common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
+		return
+	}
+	err := party.End(chi.URLParam(r, "partyID"), sess)
+	if err != nil {
+		failParty(w, fmt.Errorf("Could not end party: %s", err), err)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"success": true})
+}
+
+// StreamParty sends the party's events to the host and members. Connecting
+// also makes sure someone is syncing the party, which picks the party back up
+// if the instance that was syncing it went away.
+func StreamParty(w http.ResponseWriter, r *http.Request) {
+	sess, ok := r.Context().Value(middleware.SessionContextKey).(*session.Session)
+	if !ok {
+		common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
+		return
+	}
+	partyID := chi.URLParam(r, "partyID")
+	inParty, err := party.IsInParty(partyID, sess.SpotifyID)
+	if err != nil {
+		failParty(w, fmt.Errorf("Could not check party membership: %s", err), err)
+		return
+	}
+	if !inParty {
+		common.Fail(w, party.ErrNotMember, http.StatusForbidden)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		common.Fail(w, errors.New("Streaming is not supported"), http.StatusInternalServerError)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), streamLifetime)
+	defer cancel()
+	events, err := party.Subscribe(ctx, partyID)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not subscribe to party: %s", err), http.StatusInternalServerError)
+		return
+	}
+	party.EnsureSyncing(partyID)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", streamRetryMilliseconds)
+	flusher.Flush()
+	heartbeat := time.NewTicker(streamHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				if !isProduction {
+					log.Printf("Could not marshal party event: %s", err)
+				}
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+			// There is nothing more for this client to hear once it is out of
+			// the party.
+			if event.Type == party.EventTypeEnded || (event.Type == party.EventTypeKicked && event.Member != nil && event.Member.SpotifyID == sess.SpotifyID) {
+				flusher.Flush()
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+func failParty(w http.ResponseWriter, err, cause error) {
+	code := http.StatusInternalServerError
+	switch cause {
+	case party.ErrPartyNotFound:
+		code = http.StatusNotFound
+	case party.ErrAlreadyInParty, party.ErrPartyFull:
+		code = http.StatusConflict
+	case party.ErrKicked, party.ErrNotHost:
+		code = http.StatusForbidden
+	case party.ErrNotMember:
+		code = http.StatusBadRequest
+	}
+	common.Fail(w, err, code)
+}
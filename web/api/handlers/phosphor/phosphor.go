@@ -1,6 +1,7 @@
 package phosphor
 
 import (
+	"html"
 	"net/http"
 	"time"
 
@@ -12,18 +13,28 @@ var (
 	isProduction   bool
 	noHTML         *bluemonday.Policy
 	safeHTTPClient *http.Client
+	streamLifetime time.Duration
 )
 
 type Config struct {
 	PhosphorOrigin string
 	IsProduction   bool
+	StreamLifetime time.Duration
 }
 
 func Initialize(cfg *Config) {
 	phosphorOrigin = cfg.PhosphorOrigin
 	isProduction = cfg.IsProduction
+	streamLifetime = cfg.StreamLifetime
 	safeHTTPClient = &http.Client{
 		Timeout: 10 * time.Second,
 	}
 	noHTML = bluemonday.StrictPolicy()
 }
+
+// containsHTML is whether sanitizing would strip anything from the text. The
+// sanitizer also escapes characters like quotes and ampersands, which are fine
+// in plain text, so both sides are unescaped before comparing.
+func containsHTML(text string) bool {
+	return html.UnescapeString(noHTML.Sanitize(text)) != html.UnescapeString(text)
+}
@@ -0,0 +1,28 @@
+package phosphor
+
+import (
+	"testing"
+
+	"github.com/microcosm-cc/bluemonday"
+)
+
+func TestContainsHTML(t *testing.T) {
+	noHTML = bluemonday.StrictPolicy()
+	for text, expected := range map[string]bool{
+		"don't":                     false,
+		`she said "hi"`:             false,
+		"Rock & Roll":               false,
+		"&amp; is an entity":        false,
+		"1 < 2 > 0":                 false,
+		"<b>bold</b>":               true,
+		"<script>alert(1)</script>": true,
+		`<a href="x">link</a>`:      true,
+	} {
+		if actual := containsHTML(text); actual != expected {
+			t.Errorf("Expected containsHTML(%q) to be %t", text, expected)
+		}
+	}
+	if err := validateComment("don't & won't"); err != nil {
+		t.Errorf("Expected punctuation to be allowed in comments, got %s", err)
+	}
+}
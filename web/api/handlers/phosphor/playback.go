@@ -0,0 +1,254 @@
+package phosphor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/samuelhorwitz/phosphorescence/api/common"
+	"github.com/samuelhorwitz/phosphorescence/api/middleware"
+	"github.com/samuelhorwitz/phosphorescence/api/models"
+	"github.com/samuelhorwitz/phosphorescence/api/session"
+)
+
+// Spotify allows more, but a set is never longer than this.
+const maxPlayURIs = 500
+
+// Each queued track is its own Spotify request plus the spacing between them,
+// at a few hundred milliseconds each this keeps bulk queueing inside the 5
+// second handler timeout.
+const maxQueueURIs = 10
+
+func Play(w http.ResponseWriter, r *http.Request) {
+	sess, ok := r.Context().Value(middleware.SessionContextKey).(*session.Session)
+	if !ok {
+		common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not read request body: %s", err), http.StatusBadRequest)
+		return
+	}
+	var requestBody struct {
+		URIs       []string `json:"uris"`
+		ContextURI string   `json:"contextUri"`
+		Offset     struct {
+			Position *int   `json:"position"`
+			URI      string `json:"uri"`
+		} `json:"offset"`
+		PositionMilliseconds int `json:"positionMs"`
+	}
+	err = json.Unmarshal(body, &requestBody)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not parse request body: %s", err), http.StatusBadRequest)
+		return
+	}
+	if len(requestBody.URIs) > 0 && requestBody.ContextURI != "" {
+		common.Fail(w, errors.New("Must include either URIs or a context URI, not both"), http.StatusBadRequest)
+		return
+	}
+	if len(requestBody.URIs) == 0 && requestBody.ContextURI == "" {
+		common.Fail(w, errors.New("Must include URIs or a context URI"), http.StatusBadRequest)
+		return
+	}
+	if len(requestBody.URIs) > maxPlayURIs {
+		common.Fail(w, fmt.Errorf("Must include at most %d URIs", maxPlayURIs), http.StatusBadRequest)
+		return
+	}
+	for _, uri := range requestBody.URIs {
+		if !strings.HasPrefix(uri, "spotify:track:") {
+			common.Fail(w, fmt.Errorf("Invalid track URI %s", uri), http.StatusBadRequest)
+			return
+		}
+	}
+	if requestBody.ContextURI != "" && !strings.HasPrefix(requestBody.ContextURI, "spotify:") {
+		common.Fail(w, fmt.Errorf("Invalid context URI %s", requestBody.ContextURI), http.StatusBadRequest)
+		return
+	}
+	if requestBody.Offset.Position != nil && requestBody.Offset.URI != "" {
+		common.Fail(w, errors.New("Offset must be a position or a URI, not both"), http.StatusBadRequest)
+		return
+	}
+	if requestBody.Offset.Position != nil && *requestBody.Offset.Position < 0 {
+		common.Fail(w, errors.New("Offset position must not be negative"), http.StatusBadRequest)
+		return
+	}
+	if requestBody.PositionMilliseconds < 0 {
+		common.Fail(w, errors.New("Position must not be negative"), http.StatusBadRequest)
+		return
+	}
+	err = models.Play(r.Context(), sess, r.URL.Query().Get("deviceId"), models.PlayOptions{
+		URIs:                 requestBody.URIs,
+		ContextURI:           requestBody.ContextURI,
+		OffsetPosition:       requestBody.Offset.Position,
+		OffsetURI:            requestBody.Offset.URI,
+		PositionMilliseconds: requestBody.PositionMilliseconds,
+	})
+	if err != nil {
+		failPlayback(w, fmt.Errorf("Could not start playback: %s", err), err)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"success": true})
+}
+
+func Resume(w http.ResponseWriter, r *http.Request) {
+	playbackCommand(w, r, "resume playback", models.Resume)
+}
+
+func PausePlayback(w http.ResponseWriter, r *http.Request) {
+	playbackCommand(w, r, "pause playback", models.PausePlayback)
+}
+
+func SkipToNext(w http.ResponseWriter, r *http.Request) {
+	playbackCommand(w, r, "skip to next track", models.SkipToNext)
+}
+
+func SkipToPrevious(w http.ResponseWriter, r *http.Request) {
+	playbackCommand(w, r, "skip to previous track", models.SkipToPrevious)
+}
+
+func Seek(w http.ResponseWriter, r *http.Request) {
+	position, err := strconv.Atoi(r.URL.Query().Get("positionMs"))
+	if err != nil || position < 0 {
+		common.Fail(w, errors.New("Must include a valid position"), http.StatusBadRequest)
+		return
+	}
+	playbackCommand(w, r, "seek", func(ctx context.Context, sess *session.Session, deviceID string) error {
+		return models.Seek(ctx, sess, deviceID, position)
+	})
+}
+
+func SetVolume(w http.ResponseWriter, r *http.Request) {
+	volume, err := strconv.Atoi(r.URL.Query().Get("percent"))
+	if err != nil || volume < 0 || volume > 100 {
+		common.Fail(w, errors.New("Must include a volume between 0 and 100"), http.StatusBadRequest)
+		return
+	}
+	playbackCommand(w, r, "set volume", func(ctx context.Context, sess *session.Session, deviceID string) error {
+		return models.SetVolume(ctx, sess, deviceID, volume)
+	})
+}
+
+func SetShuffle(w http.ResponseWriter, r *http.Request) {
+	shuffle, err := strconv.ParseBool(r.URL.Query().Get("state"))
+	if err != nil {
+		common.Fail(w, errors.New("Must include a valid shuffle state"), http.StatusBadRequest)
+		return
+	}
+	playbackCommand(w, r, "set shuffle", func(ctx context.Context, sess *session.Session, deviceID string) error {
+		return models.SetShuffle(ctx, sess, deviceID, shuffle)
+	})
+}
+
+func SetRepeat(w http.ResponseWriter, r *http.Request) {
+	repeatState := models.RepeatState(strings.ToLower(r.URL.Query().Get("state")))
+	switch repeatState {
+	case models.RepeatStateTrack, models.RepeatStateContext, models.RepeatStateOff:
+	default:
+		common.Fail(w, errors.New("Repeat state must be track, context or off"), http.StatusBadRequest)
+		return
+	}
+	playbackCommand(w, r, "set repeat", func(ctx context.Context, sess *session.Session, deviceID string) error {
+		return models.SetRepeat(ctx, sess, deviceID, repeatState)
+	})
+}
+
+func playbackCommand(w http.ResponseWriter, r *http.Request, description string, command func(context.Context, *session.Session, string) error) {
+	sess, ok := r.Context().Value(middleware.SessionContextKey).(*session.Session)
+	if !ok {
+		common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
+		return
+	}
+	err := command(r.Context(), sess, r.URL.Query().Get("deviceId"))
+	if err != nil {
+		failPlayback(w, fmt.Errorf("Could not %s: %s", description, err), err)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"success": true})
+}
+
+// failPlayback passes Spotify's reason along to the client so it can tell the
+// user to open Spotify somewhere or that they need premium, rather than just
+// showing a generic error.
+func failPlayback(w http.ResponseWriter, err error, cause error) {
+	playerErr, ok := cause.(models.SpotifyPlayerError)
+	if !ok {
+		common.Fail(w, err, http.StatusInternalServerError)
+		return
+	}
+	code := http.StatusBadGateway
+	switch playerErr.Reason {
+	case models.PlayerErrorNoActiveDevice:
+		code = http.StatusNotFound
+	case models.PlayerErrorPremiumRequired:
+		code = http.StatusForbidden
+	case models.PlayerErrorRateLimited:
+		code = http.StatusTooManyRequests
+	default:
+		if playerErr.Status >= 400 && playerErr.Status < 500 && playerErr.Status != http.StatusUnauthorized {
+			code = playerErr.Status
+		}
+	}
+	common.FailWithJSON(w, err, map[string]interface{}{"reason": playerErr.Reason, "message": playerErr.Message}, code)
+}
+
+func GetQueue(w http.ResponseWriter, r *http.Request) {
+	sess, ok := r.Context().Value(middleware.SessionContextKey).(*session.Session)
+	if !ok {
+		common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
+		return
+	}
+	queue, err := models.GetQueue(r.Context(), sess)
+	if err != nil {
+		failPlayback(w, fmt.Errorf("Could not get queue: %s", err), err)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"queue": queue})
+}
+
+func AddToQueue(w http.ResponseWriter, r *http.Request) {
+	sess, ok := r.Context().Value(middleware.SessionContextKey).(*session.Session)
+	if !ok {
+		common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not read request body: %s", err), http.StatusBadRequest)
+		return
+	}
+	var requestBody struct {
+		URIs []string `json:"uris"`
+	}
+	err = json.Unmarshal(body, &requestBody)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not parse request body: %s", err), http.StatusBadRequest)
+		return
+	}
+	if len(requestBody.URIs) < 1 {
+		common.Fail(w, errNoTracks, http.StatusBadRequest)
+		return
+	}
+	if len(requestBody.URIs) > maxQueueURIs {
+		common.Fail(w, fmt.Errorf("Must include at most %d URIs", maxQueueURIs), http.StatusBadRequest)
+		return
+	}
+	for _, uri := range requestBody.URIs {
+		if !strings.HasPrefix(uri, "spotify:track:") {
+			common.Fail(w, fmt.Errorf("Invalid track URI %s", uri), http.StatusBadRequest)
+			return
+		}
+	}
+	queued, err := models.AddManyToQueue(r.Context(), sess, r.URL.Query().Get("deviceId"), requestBody.URIs)
+	if err != nil {
+		failPlayback(w, fmt.Errorf("Could not add to queue after %d tracks: %s", queued, err), err)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"queued": queued})
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/samuelhorwitz/phosphorescence/api/common"
@@ -13,10 +14,13 @@ import (
 	"github.com/samuelhorwitz/phosphorescence/api/middleware"
 	"github.com/samuelhorwitz/phosphorescence/api/models"
 	"github.com/samuelhorwitz/phosphorescence/api/session"
+	"github.com/satori/go.uuid"
 )
 
 var errNoTracks = errors.New("Must include at least one track")
 
+const maxBuilderNameLength = 64
+
 func GetPlaylist(w http.ResponseWriter, r *http.Request) {
 	sess, ok := r.Context().Value(middleware.SessionContextKey).(*session.Session)
 	if !ok {
@@ -60,20 +64,6 @@ func CreatePrivatePlaylist(w http.ResponseWriter, r *http.Request) {
 	common.JSON(w, map[string]interface{}{"playlist": playlistID})
 }
 
-func MakePlaylistOfficial(w http.ResponseWriter, r *http.Request) {
-	playlistID := chi.URLParam(r, "playlistID")
-	if playlistID == "" {
-		common.Fail(w, errors.New("Must include playlist ID"), http.StatusBadRequest)
-		return
-	}
-	err := models.MakePlaylistPublic(r.Context(), playlistID)
-	if err != nil {
-		common.Fail(w, fmt.Errorf("Could not make playlist public: %s", err), http.StatusInternalServerError)
-		return
-	}
-	common.JSON(w, map[string]interface{}{"playlist": playlistID})
-}
-
 func createPlaylist(r *http.Request) (string, error) {
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -82,9 +72,15 @@ func createPlaylist(r *http.Request) (string, error) {
 	var requestBody struct {
 		Image            string `json:"image"`
 		UTCOffsetMinutes int    `json:"utcOffsetMinutes"`
-		Tracks           []struct {
-			Name string `json:"name"`
-			URI  string `json:"uri"`
+		Generator        struct {
+			Builder       string    `json:"builder"`
+			ScriptID      uuid.UUID `json:"scriptId"`
+			ScriptVersion time.Time `json:"scriptVersion"`
+		} `json:"generator"`
+		Tracks []struct {
+			Name          string   `json:"name"`
+			URI           string   `json:"uri"`
+			Aetherealness *float64 `json:"aetherealness"`
 		} `json:"tracks"`
 	}
 	err = json.Unmarshal(body, &requestBody)
@@ -95,10 +91,34 @@ func createPlaylist(r *http.Request) (string, error) {
 		return "", handlers.NewHTTPError(errNoTracks, http.StatusBadRequest)
 	}
 	var trackURIs []string
+	var aetherealness []float64
 	for _, track := range requestBody.Tracks {
 		trackURIs = append(trackURIs, track.URI)
+		if track.Aetherealness != nil {
+			aetherealness = append(aetherealness, *track.Aetherealness)
+		}
+	}
+	// Aetherealness is all or nothing, the cover art falls back to energy
+	// otherwise.
+	if len(aetherealness) != len(trackURIs) {
+		aetherealness = nil
+	}
+	// Playlists can be created without a session, in which case we just don't
+	// know who made them.
+	sess, _ := r.Context().Value(middleware.SessionContextKey).(*session.Session)
+	generator := models.PlaylistGenerator{
+		ScriptID:      requestBody.Generator.ScriptID,
+		ScriptVersion: requestBody.Generator.ScriptVersion,
+	}
+	if uuid.Equal(generator.ScriptID, uuid.Nil) {
+		if len(requestBody.Generator.Builder) > maxBuilderNameLength {
+			return "", handlers.NewHTTPError(errors.New("Builder name is too long"), http.StatusBadRequest)
+		}
+		generator.Builder = requestBody.Generator.Builder
+	} else if generator.ScriptVersion.IsZero() {
+		return "", handlers.NewHTTPError(errors.New("Script generators must include a version"), http.StatusBadRequest)
 	}
-	playlistID, err := models.CreatePlaylist(r.Context(), requestBody.Tracks[0].Name, requestBody.Image, requestBody.UTCOffsetMinutes, trackURIs)
+	playlistID, err := models.CreatePlaylist(r.Context(), sess, generator, requestBody.Tracks[0].Name, requestBody.Image, requestBody.UTCOffsetMinutes, trackURIs, aetherealness)
 	if err != nil {
 		return "", fmt.Errorf("Failed to create playlist: %s", err)
 	}
@@ -0,0 +1,114 @@
+package phosphor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/samuelhorwitz/phosphorescence/api/common"
+	"github.com/samuelhorwitz/phosphorescence/api/middleware"
+	"github.com/samuelhorwitz/phosphorescence/api/models"
+)
+
+const maxChangelogLength = 4096
+
+func ListScriptReleases(w http.ResponseWriter, r *http.Request) {
+	script, ok := r.Context().Value(middleware.ScriptContextKey).(models.Script)
+	if !ok {
+		common.Fail(w, errors.New("No script on request context"), http.StatusInternalServerError)
+		return
+	}
+	count, ok := r.Context().Value(middleware.PageCountContextKey).(uint64)
+	if !ok {
+		common.Fail(w, errors.New("No page count on request context"), http.StatusInternalServerError)
+		return
+	}
+	from, ok := r.Context().Value(middleware.PageCursorContextKey).(time.Time)
+	if !ok {
+		common.Fail(w, errors.New("No page cursor on request context"), http.StatusInternalServerError)
+		return
+	}
+	releases, err := models.GetScriptReleases(script.ID, count, from)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not get releases: %s", err), http.StatusInternalServerError)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"releases": releases})
+}
+
+func ReleaseScriptVersion(w http.ResponseWriter, r *http.Request) {
+	script, ok := r.Context().Value(middleware.ScriptContextKey).(models.Script)
+	if !ok {
+		common.Fail(w, errors.New("No script on request context"), http.StatusInternalServerError)
+		return
+	}
+	scriptVersion, ok := r.Context().Value(middleware.ScriptVersionContextKey).(models.ScriptVersion)
+	if !ok {
+		common.Fail(w, errors.New("No script version on request context"), http.StatusInternalServerError)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not read request body: %s", err), http.StatusInternalServerError)
+		return
+	}
+	var requestBody struct {
+		Name      string `json:"name"`
+		Changelog string `json:"changelog"`
+	}
+	err = json.Unmarshal(body, &requestBody)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not parse request body: %s", err), http.StatusInternalServerError)
+		return
+	}
+	if !models.ValidReleaseName(requestBody.Name) {
+		common.Fail(w, fmt.Errorf("Bad request: %s", models.ErrInvalidReleaseName), http.StatusBadRequest)
+		return
+	}
+	if len([]byte(requestBody.Changelog)) > maxChangelogLength {
+		common.Fail(w, errors.New("Bad request: Changelog is too long"), http.StatusBadRequest)
+		return
+	}
+	if containsHTML(requestBody.Changelog) {
+		common.Fail(w, errors.New("Bad request: Changelog contained HTML"), http.StatusBadRequest)
+		return
+	}
+	release, err := models.CreateScriptRelease(script.ID, scriptVersion, requestBody.Name, requestBody.Changelog)
+	if err != nil {
+		switch err {
+		case models.ErrReleaseExists:
+			common.Fail(w, err, http.StatusConflict)
+		case models.ErrReleaseNotPublished, models.ErrInvalidReleaseName:
+			common.Fail(w, fmt.Errorf("Bad request: %s", err), http.StatusBadRequest)
+		default:
+			common.Fail(w, fmt.Errorf("Could not create release: %s", err), http.StatusInternalServerError)
+		}
+		return
+	}
+	common.JSON(w, map[string]interface{}{"release": release})
+}
+
+func DeleteScriptVersionRelease(w http.ResponseWriter, r *http.Request) {
+	script, ok := r.Context().Value(middleware.ScriptContextKey).(models.Script)
+	if !ok {
+		common.Fail(w, errors.New("No script on request context"), http.StatusInternalServerError)
+		return
+	}
+	scriptVersion, ok := r.Context().Value(middleware.ScriptVersionContextKey).(models.ScriptVersion)
+	if !ok {
+		common.Fail(w, errors.New("No script version on request context"), http.StatusInternalServerError)
+		return
+	}
+	if err := models.DeleteScriptRelease(script.ID, scriptVersion.CreatedAt); err != nil {
+		if err == models.ErrReleaseDoesNotExist {
+			common.Fail(w, err, http.StatusNotFound)
+			return
+		}
+		common.Fail(w, fmt.Errorf("Could not delete release: %s", err), http.StatusInternalServerError)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"success": true})
+}
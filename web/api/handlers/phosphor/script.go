@@ -1,6 +1,7 @@
 package phosphor
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,14 +10,22 @@ import (
 	"time"
 
 	"github.com/samuelhorwitz/phosphorescence/api/common"
+	"github.com/samuelhorwitz/phosphorescence/api/eos"
 	"github.com/samuelhorwitz/phosphorescence/api/middleware"
 	"github.com/samuelhorwitz/phosphorescence/api/models"
+	"github.com/samuelhorwitz/phosphorescence/api/scriptcheck"
+	"github.com/samuelhorwitz/phosphorescence/api/scriptdiff"
 	"github.com/samuelhorwitz/phosphorescence/api/session"
 
+	"github.com/go-chi/chi"
 	"github.com/rivo/uniseg"
+	"github.com/satori/go.uuid"
 )
 
-const maxScriptVersionPageSize float64 = 10
+const (
+	maxScriptVersionPageSize float64 = 10
+	maxScriptBundleSize              = 32 * 1024 * 1024
+)
 
 func GetScript(w http.ResponseWriter, r *http.Request) {
 	script, ok := r.Context().Value(middleware.ScriptContextKey).(models.Script)
@@ -30,6 +39,7 @@ func GetScript(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if ok {
+		mostRecent.FileURL = models.ScriptFileURL(mostRecent.FileID)
 		script.MostRecent = &mostRecent
 	}
 	common.JSON(w, map[string]interface{}{"script": script})
@@ -41,6 +51,7 @@ func GetScriptVersion(w http.ResponseWriter, r *http.Request) {
 		common.Fail(w, errors.New("No script version on request context"), http.StatusInternalServerError)
 		return
 	}
+	scriptVersion.FileURL = models.ScriptFileURL(scriptVersion.FileID)
 	common.JSON(w, map[string]interface{}{"scriptVersion": scriptVersion})
 }
 
@@ -73,10 +84,18 @@ func getScriptVersions(w http.ResponseWriter, r *http.Request, limitToPublished
 		common.Fail(w, fmt.Errorf("Could not get script versions: %s", err), http.StatusInternalServerError)
 		return
 	}
+	for i := range versions {
+		versions[i].FileURL = models.ScriptFileURL(versions[i].FileID)
+	}
 	common.JSON(w, map[string]interface{}{"scriptVersions": versions})
 }
 
 func ListPublicScripts(w http.ResponseWriter, r *http.Request) {
+	sess, ok := r.Context().Value(middleware.AuthenticatedSessionContextKey).(*session.Session)
+	if !ok {
+		common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
+		return
+	}
 	count, ok := r.Context().Value(middleware.PageCountContextKey).(uint64)
 	if !ok {
 		common.Fail(w, errors.New("No page count on request context"), http.StatusInternalServerError)
@@ -87,7 +106,7 @@ func ListPublicScripts(w http.ResponseWriter, r *http.Request) {
 		common.Fail(w, errors.New("No page cursor on request context"), http.StatusInternalServerError)
 		return
 	}
-	scripts, err := models.GetNewScripts(count, from)
+	scripts, err := models.GetNewScripts(sess.SpotifyID, count, from)
 	if err != nil {
 		common.Fail(w, fmt.Errorf("Could not get new scripts: %s", err), http.StatusInternalServerError)
 		return
@@ -110,14 +129,15 @@ func CreateScript(w http.ResponseWriter, r *http.Request) {
 		Script      string `json:"script"`
 		Name        string `json:"name"`
 		Description string `json:"description"`
+		Type        string `json:"type"`
 	}
 	err = json.Unmarshal(body, &requestBody)
 	if err != nil {
 		common.Fail(w, fmt.Errorf("Could not parse request body: %s", err), http.StatusInternalServerError)
 		return
 	}
-	if err := validateScript(requestBody.Name, requestBody.Description, requestBody.Script); err != nil {
-		common.Fail(w, fmt.Errorf("Bad request: %s", err), http.StatusBadRequest)
+	if err := validateScript(requestBody.Name, requestBody.Description, requestBody.Script, requestBody.Type); err != nil {
+		failScriptValidation(w, err)
 		return
 	}
 	createDetails, err := models.CreateScript(sess.SpotifyID, requestBody.Name, requestBody.Description, requestBody.Script)
@@ -128,45 +148,23 @@ func CreateScript(w http.ResponseWriter, r *http.Request) {
 	common.JSON(w, map[string]interface{}{"create": createDetails})
 }
 
+// updateScript saves scripts, tests replace it so they don't need a database.
+var updateScript = models.UpdateScript
+
 func UpdateScript(w http.ResponseWriter, r *http.Request) {
-	existingScript, ok := r.Context().Value(middleware.ScriptContextKey).(models.Script)
-	if !ok {
-		common.Fail(w, errors.New("No script on request context"), http.StatusInternalServerError)
-		return
-	}
-	body, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		common.Fail(w, fmt.Errorf("Could not read request body: %s", err), http.StatusInternalServerError)
-		return
-	}
-	var requestBody struct {
-		Script      string `json:"script"`
-		Name        string `json:"name"`
-		Description string `json:"description"`
-		Permissions string `json:"permissions"`
-	}
-	err = json.Unmarshal(body, &requestBody)
-	if err != nil {
-		common.Fail(w, fmt.Errorf("Could not parse request body: %s", err), http.StatusInternalServerError)
-		return
-	}
-	if requestBody.Permissions == "" {
-		common.Fail(w, errors.New("Permissions must be populated"), http.StatusBadRequest)
-		return
-	}
-	if err := validateScript(requestBody.Name, requestBody.Description, requestBody.Script); err != nil {
-		common.Fail(w, fmt.Errorf("Bad request: %s", err), http.StatusBadRequest)
-		return
-	}
-	updateDetails, err := models.UpdateScript(existingScript.ID, requestBody.Name, requestBody.Description, requestBody.Script, requestBody.Permissions, models.ScriptSaveTypeDraft)
-	if err != nil {
-		common.Fail(w, fmt.Errorf("Could not update script: %s", err), http.StatusInternalServerError)
-		return
-	}
-	common.JSON(w, map[string]interface{}{"update": updateDetails})
+	saveScript(w, r, models.ScriptSaveTypeDraft)
 }
 
 func PublishScript(w http.ResponseWriter, r *http.Request) {
+	saveScript(w, r, models.ScriptSaveTypePublished)
+}
+
+func saveScript(w http.ResponseWriter, r *http.Request, saveType models.ScriptSaveType) {
+	sess, ok := r.Context().Value(middleware.AuthenticatedSessionContextKey).(*session.Session)
+	if !ok {
+		common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
+		return
+	}
 	existingScript, ok := r.Context().Value(middleware.ScriptContextKey).(models.Script)
 	if !ok {
 		common.Fail(w, errors.New("No script on request context"), http.StatusInternalServerError)
@@ -182,6 +180,7 @@ func PublishScript(w http.ResponseWriter, r *http.Request) {
 		Name        string `json:"name"`
 		Description string `json:"description"`
 		Permissions string `json:"permissions"`
+		Type        string `json:"type"`
 	}
 	err = json.Unmarshal(body, &requestBody)
 	if err != nil {
@@ -192,11 +191,15 @@ func PublishScript(w http.ResponseWriter, r *http.Request) {
 		common.Fail(w, errors.New("Permissions must be populated"), http.StatusBadRequest)
 		return
 	}
-	if err := validateScript(requestBody.Name, requestBody.Description, requestBody.Script); err != nil {
-		common.Fail(w, fmt.Errorf("Bad request: %s", err), http.StatusBadRequest)
+	if !canChangePermissions(existingScript, requestBody.Permissions) {
+		common.Fail(w, errors.New("Only the owner can change script permissions"), http.StatusForbidden)
 		return
 	}
-	updateDetails, err := models.UpdateScript(existingScript.ID, requestBody.Name, requestBody.Script, requestBody.Description, requestBody.Permissions, models.ScriptSaveTypePublished)
+	if err := validateScript(requestBody.Name, requestBody.Description, requestBody.Script, requestBody.Type); err != nil {
+		failScriptValidation(w, err)
+		return
+	}
+	updateDetails, err := updateScript(sess.SpotifyID, existingScript.ID, requestBody.Name, requestBody.Description, requestBody.Script, requestBody.Permissions, saveType)
 	if err != nil {
 		common.Fail(w, fmt.Errorf("Could not update script: %s", err), http.StatusInternalServerError)
 		return
@@ -252,7 +255,10 @@ func ForkScript(w http.ResponseWriter, r *http.Request) {
 		common.Fail(w, errors.New("No script on request context"), http.StatusInternalServerError)
 		return
 	}
-	scriptVersionID := common.ParseScriptVersion(r.URL.Query().Get("version"))
+	scriptVersionID, ok := parseScriptVersionQuery(w, r, sess.SpotifyID, existingScript.ID)
+	if !ok {
+		return
+	}
 	forkDetails, err := models.ForkScript(sess.SpotifyID, existingScript.ID, existingScript.Name.String, scriptVersionID, true)
 	if err != nil {
 		common.Fail(w, fmt.Errorf("Could not fork script: %s", err), http.StatusInternalServerError)
@@ -261,6 +267,28 @@ func ForkScript(w http.ResponseWriter, r *http.Request) {
 	common.JSON(w, map[string]interface{}{"fork": forkDetails})
 }
 
+var getScriptVersion = models.GetScriptVersionWithAuthorizationCheck
+
+// parseScriptVersionQuery resolves the version query parameter, which may be a
+// timestamp, a release name or latest. Without one the zero time is returned
+// and the model picks the most recent version.
+func parseScriptVersionQuery(w http.ResponseWriter, r *http.Request, spotifyID string, scriptID uuid.UUID) (time.Time, bool) {
+	ref := r.URL.Query().Get("version")
+	if ref == "" {
+		return time.Time{}, true
+	}
+	scriptVersion, ok, err := getScriptVersion(spotifyID, scriptID, models.ParseScriptVersionRef(ref))
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not get script version: %s", err), http.StatusInternalServerError)
+		return time.Time{}, false
+	}
+	if !ok {
+		common.Fail(w, fmt.Errorf("Script version %s does not exist", ref), http.StatusNotFound)
+		return time.Time{}, false
+	}
+	return scriptVersion.CreatedAt, true
+}
+
 func ForkScriptVersion(w http.ResponseWriter, r *http.Request) {
 	sess, ok := r.Context().Value(middleware.AuthenticatedSessionContextKey).(*session.Session)
 	if !ok {
@@ -320,7 +348,10 @@ func DuplicateScript(w http.ResponseWriter, r *http.Request) {
 	} else {
 		name = existingScript.Name.String
 	}
-	scriptVersionID := common.ParseScriptVersion(r.URL.Query().Get("version"))
+	scriptVersionID, ok := parseScriptVersionQuery(w, r, sess.SpotifyID, existingScript.ID)
+	if !ok {
+		return
+	}
 	forkDetails, err := models.ForkScript(sess.SpotifyID, existingScript.ID, name, scriptVersionID, false)
 	if err != nil {
 		common.Fail(w, fmt.Errorf("Could not duplicate script: %s", err), http.StatusInternalServerError)
@@ -377,11 +408,20 @@ func DuplicateScriptVersion(w http.ResponseWriter, r *http.Request) {
 	common.JSON(w, map[string]interface{}{"fork": forkDetails})
 }
 
-func validateScript(name, description, script string) error {
-	if script == "" {
-		return errors.New("Script cannot be empty")
-	}
-	if description != noHTML.Sanitize(description) {
+// canChangePermissions is whether the user can save the script with the given
+// permissions. Editors can save and publish, but whether the script is public
+// is up to its owner.
+func canChangePermissions(script models.Script, permissions string) bool {
+	if script.MyRole.Can(models.ScriptRoleOwner) {
+		return true
+	}
+	return (permissions == "private") == script.IsPrivate
+}
+
+// validateScript checks the script's details and that the script itself
+// parses and defines the hooks for its type, if one was given.
+func validateScript(name, description, script, scriptType string) error {
+	if containsHTML(description) {
 		return errors.New("Description contained HTML")
 	}
 	if err := validateScriptName(name); err != nil {
@@ -390,6 +430,66 @@ func validateScript(name, description, script string) error {
 	if len([]byte(description)) > 1024 {
 		return errors.New("Description is too long")
 	}
+	var roles []scriptcheck.Role
+	if scriptType != "" {
+		role := scriptcheck.Role(scriptType)
+		if !scriptcheck.ValidRole(role) {
+			return fmt.Errorf("Unknown script type %s", scriptType)
+		}
+		roles = append(roles, role)
+	}
+	_, err := scriptcheck.Check(script, roles...)
+	return err
+}
+
+// failScriptValidation responds with where in the script things went wrong so
+// the editor can point at it.
+func failScriptValidation(w http.ResponseWriter, err error) {
+	wrapped := fmt.Errorf("Bad request: %s", err)
+	switch checkErr := err.(type) {
+	case *scriptcheck.SyntaxError:
+		common.FailWithJSON(w, wrapped, map[string]interface{}{"reason": "syntax", "line": checkErr.Line, "column": checkErr.Column, "message": checkErr.Message}, http.StatusBadRequest)
+	case *scriptcheck.ContractError:
+		common.FailWithJSON(w, wrapped, map[string]interface{}{"reason": "contract", "message": checkErr.Message}, http.StatusBadRequest)
+	default:
+		if err == scriptcheck.ErrTooLarge {
+			common.Fail(w, wrapped, http.StatusRequestEntityTooLarge)
+			return
+		}
+		common.Fail(w, wrapped, http.StatusBadRequest)
+	}
+}
+
+// validateScriptBundle holds published versions and the latest version to the
+// same checks as saving them would. Older drafts may have been saved before
+// those checks existed, or be half-finished, so they only need to fit.
+func validateScriptBundle(bundle models.ScriptBundle) error {
+	latest := 0
+	for i, version := range bundle.Versions {
+		if version.CreatedAt.After(bundle.Versions[latest].CreatedAt) {
+			latest = i
+		}
+	}
+	strict := make(map[uuid.UUID]bool)
+	for i, version := range bundle.Versions {
+		if i == latest || version.Type == models.ScriptSaveTypePublished {
+			strict[version.FileID] = true
+		}
+	}
+	checked := make(map[uuid.UUID]bool)
+	for _, version := range bundle.Versions {
+		if checked[version.FileID] {
+			continue
+		}
+		if strict[version.FileID] {
+			if err := validateScript(bundle.Name, bundle.Description, version.Script, ""); err != nil {
+				return err
+			}
+		} else if len(version.Script) > scriptcheck.MaxScriptSize {
+			return scriptcheck.ErrTooLarge
+		}
+		checked[version.FileID] = true
+	}
 	return nil
 }
 
@@ -399,3 +499,261 @@ func validateScriptName(name string) error {
 	}
 	return nil
 }
+
+func GetScriptListeningStats(w http.ResponseWriter, r *http.Request) {
+	script, ok := r.Context().Value(middleware.ScriptContextKey).(models.Script)
+	if !ok {
+		common.Fail(w, errors.New("No script on request context"), http.StatusInternalServerError)
+		return
+	}
+	stats, err := models.GetScriptListeningStats(script.ID)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not get listening stats: %s", err), http.StatusInternalServerError)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"stats": stats})
+}
+
+func DiffScriptVersions(w http.ResponseWriter, r *http.Request) {
+	sess, ok := r.Context().Value(middleware.AuthenticatedSessionContextKey).(*session.Session)
+	if !ok {
+		common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
+		return
+	}
+	script, ok := r.Context().Value(middleware.ScriptContextKey).(models.Script)
+	if !ok {
+		common.Fail(w, errors.New("No script on request context"), http.StatusInternalServerError)
+		return
+	}
+	scriptVersion, ok := r.Context().Value(middleware.ScriptVersionContextKey).(models.ScriptVersion)
+	if !ok {
+		common.Fail(w, errors.New("No script version on request context"), http.StatusInternalServerError)
+		return
+	}
+	otherScriptVersionRef := models.ParseScriptVersionRef(chi.URLParam(r, "otherScriptVersionID"))
+	otherScriptVersion, ok, err := models.GetScriptVersionWithAuthorizationCheck(sess.SpotifyID, script.ID, otherScriptVersionRef)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Cannot check script version authorization: %s", err), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		common.Fail(w, errors.New("User does not have access to script version or script version does not exist"), http.StatusForbidden)
+		return
+	}
+	diffScriptVersions(w, script.ID, scriptVersion, script.ID, otherScriptVersion)
+}
+
+// DiffScriptVersionWithUpstream diffs a version of a fork against the version
+// of the script it was forked from.
+func DiffScriptVersionWithUpstream(w http.ResponseWriter, r *http.Request) {
+	sess, ok := r.Context().Value(middleware.AuthenticatedSessionContextKey).(*session.Session)
+	if !ok {
+		common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
+		return
+	}
+	script, ok := r.Context().Value(middleware.ScriptContextKey).(models.Script)
+	if !ok {
+		common.Fail(w, errors.New("No script on request context"), http.StatusInternalServerError)
+		return
+	}
+	scriptVersion, ok := r.Context().Value(middleware.ScriptVersionContextKey).(models.ScriptVersion)
+	if !ok {
+		common.Fail(w, errors.New("No script version on request context"), http.StatusInternalServerError)
+		return
+	}
+	if !script.ForkedFromScriptID.Valid || !script.ForkedFromScriptVersion.Valid {
+		common.Fail(w, errors.New("Script is not a fork"), http.StatusBadRequest)
+		return
+	}
+	upstreamScriptID := script.ForkedFromScriptID.UUID
+	upstreamVersion, ok, err := models.GetScriptVersionWithAuthorizationCheck(sess.SpotifyID, upstreamScriptID, models.ScriptVersionRef{CreatedAt: script.ForkedFromScriptVersion.Time})
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Cannot check upstream script version authorization: %s", err), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		common.Fail(w, errors.New("User does not have access to upstream script version or upstream script version does not exist"), http.StatusForbidden)
+		return
+	}
+	diffScriptVersions(w, upstreamScriptID, upstreamVersion, script.ID, scriptVersion)
+}
+
+func diffScriptVersions(w http.ResponseWriter, fromScriptID uuid.UUID, from models.ScriptVersion, toScriptID uuid.UUID, to models.ScriptVersion) {
+	fromScript, err := models.GetScriptFile(from.FileID)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not get script version: %s", err), http.StatusInternalServerError)
+		return
+	}
+	toScript, err := models.GetScriptFile(to.FileID)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not get script version: %s", err), http.StatusInternalServerError)
+		return
+	}
+	diff := scriptdiff.Compare(scriptVersionDiffName("a", fromScriptID, from), fromScript, scriptVersionDiffName("b", toScriptID, to), toScript)
+	common.JSON(w, map[string]interface{}{"diff": diff})
+}
+
+func scriptVersionDiffName(side string, scriptID uuid.UUID, scriptVersion models.ScriptVersion) string {
+	return fmt.Sprintf("%s/%s@%s", side, scriptID, scriptVersion.CreatedAt.Format(time.RFC3339Nano))
+}
+
+func RunScriptVersion(w http.ResponseWriter, r *http.Request) {
+	sess, ok := r.Context().Value(middleware.AuthenticatedSessionContextKey).(*session.Session)
+	if !ok {
+		common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
+		return
+	}
+	scriptVersion, ok := r.Context().Value(middleware.ScriptVersionContextKey).(models.ScriptVersion)
+	if !ok {
+		common.Fail(w, errors.New("No script version on request context"), http.StatusInternalServerError)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not read request body: %s", err), http.StatusInternalServerError)
+		return
+	}
+	var requestBody struct {
+		TrackCount     int      `json:"trackCount"`
+		FirstTrackID   string   `json:"firstTrackId"`
+		PrunedTrackIDs []string `json:"prunedTrackIds"`
+	}
+	if len(body) > 0 {
+		err = json.Unmarshal(body, &requestBody)
+		if err != nil {
+			common.Fail(w, fmt.Errorf("Could not parse request body: %s", err), http.StatusInternalServerError)
+			return
+		}
+	}
+	if requestBody.TrackCount < 0 || requestBody.TrackCount > eos.MaxTrackCount {
+		common.Fail(w, fmt.Errorf("Track count must be at most %d", eos.MaxTrackCount), http.StatusBadRequest)
+		return
+	}
+	script, err := models.GetScriptFile(scriptVersion.FileID)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not get script version: %s", err), http.StatusInternalServerError)
+		return
+	}
+	result, err := eos.Run(r.Context(), sess.SpotifyCountry, eos.Job{
+		Script:         script,
+		TrackCount:     requestBody.TrackCount,
+		FirstTrackID:   requestBody.FirstTrackID,
+		PrunedTrackIDs: requestBody.PrunedTrackIDs,
+	})
+	if err != nil {
+		wrapped := fmt.Errorf("Could not run script: %s", err)
+		if _, ok := err.(eos.ScriptError); ok || err == eos.ErrTimeout || err == eos.ErrMemoryLimit {
+			common.FailWithJSON(w, wrapped, map[string]interface{}{"message": err.Error()}, http.StatusUnprocessableEntity)
+			return
+		}
+		switch err {
+		case eos.ErrNoDataset:
+			common.Fail(w, wrapped, http.StatusNotFound)
+		case eos.ErrBusy:
+			common.Fail(w, wrapped, http.StatusServiceUnavailable)
+		default:
+			common.Fail(w, wrapped, http.StatusInternalServerError)
+		}
+		return
+	}
+	common.JSON(w, map[string]interface{}{"tracks": result.Tracks, "dimensions": result.Dimensions})
+}
+
+func ExportScript(w http.ResponseWriter, r *http.Request) {
+	script, ok := r.Context().Value(middleware.ScriptContextKey).(models.Script)
+	if !ok {
+		common.Fail(w, errors.New("No script on request context"), http.StatusInternalServerError)
+		return
+	}
+	// Build the whole bundle first so a failure part way through is still an
+	// error response rather than a broken download.
+	var bundle bytes.Buffer
+	if err := models.ExportScript(&bundle, script); err != nil {
+		common.Fail(w, fmt.Errorf("Could not export script: %s", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, script.ID))
+	w.Write(bundle.Bytes())
+}
+
+func ImportScript(w http.ResponseWriter, r *http.Request) {
+	sess, ok := r.Context().Value(middleware.AuthenticatedSessionContextKey).(*session.Session)
+	if !ok {
+		common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
+		return
+	}
+	opts := models.ScriptImportOptions{
+		NameConflict: models.ScriptNameConflict(r.URL.Query().Get("nameConflict")),
+		Permissions:  r.URL.Query().Get("permissions"),
+	}
+	switch opts.NameConflict {
+	case "":
+		opts.NameConflict = models.ScriptNameConflictRename
+	case models.ScriptNameConflictRename, models.ScriptNameConflictFail, models.ScriptNameConflictAllow:
+	default:
+		common.Fail(w, fmt.Errorf("Bad request: unknown name conflict handling %s", opts.NameConflict), http.StatusBadRequest)
+		return
+	}
+	if opts.Permissions != "" && opts.Permissions != "public" && opts.Permissions != "private" {
+		common.Fail(w, fmt.Errorf("Bad request: unknown permissions %s", opts.Permissions), http.StatusBadRequest)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxScriptBundleSize))
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not read request body: %s", err), http.StatusRequestEntityTooLarge)
+		return
+	}
+	bundle, err := models.ReadScriptBundle(body)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Bad request: %s", err), http.StatusBadRequest)
+		return
+	}
+	if err = validateScriptBundle(bundle); err != nil {
+		failScriptValidation(w, err)
+		return
+	}
+	importDetails, err := models.ImportScript(sess.SpotifyID, bundle, opts)
+	if err != nil {
+		wrapped := fmt.Errorf("Could not import script: %s", err)
+		switch err.(type) {
+		case models.ScriptNameConflictError:
+			common.Fail(w, wrapped, http.StatusConflict)
+		case models.ScriptBundleError:
+			common.Fail(w, wrapped, http.StatusBadRequest)
+		default:
+			common.Fail(w, wrapped, http.StatusInternalServerError)
+		}
+		return
+	}
+	common.JSON(w, map[string]interface{}{"import": importDetails})
+}
+
+func GetScriptLineage(w http.ResponseWriter, r *http.Request) {
+	sess, ok := r.Context().Value(middleware.AuthenticatedSessionContextKey).(*session.Session)
+	if !ok {
+		common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
+		return
+	}
+	script, ok := r.Context().Value(middleware.ScriptContextKey).(models.Script)
+	if !ok {
+		common.Fail(w, errors.New("No script on request context"), http.StatusInternalServerError)
+		return
+	}
+	count, ok := r.Context().Value(middleware.PageCountContextKey).(uint64)
+	if !ok {
+		common.Fail(w, errors.New("No page count on request context"), http.StatusInternalServerError)
+		return
+	}
+	from, ok := r.Context().Value(middleware.PageCursorContextKey).(time.Time)
+	if !ok {
+		common.Fail(w, errors.New("No page cursor on request context"), http.StatusInternalServerError)
+		return
+	}
+	lineage, err := models.GetScriptLineage(sess.SpotifyID, script.ID, count, from)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not get script lineage: %s", err), http.StatusInternalServerError)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"lineage": lineage})
+}
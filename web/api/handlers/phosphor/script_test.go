@@ -0,0 +1,228 @@
+package phosphor
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/samuelhorwitz/phosphorescence/api/middleware"
+	"github.com/samuelhorwitz/phosphorescence/api/models"
+	"github.com/samuelhorwitz/phosphorescence/api/scriptcheck"
+	"github.com/samuelhorwitz/phosphorescence/api/session"
+	"github.com/satori/go.uuid"
+)
+
+type savedScript struct {
+	spotifyUserID string
+	scriptID      uuid.UUID
+	name          string
+	description   string
+	script        string
+	permissions   string
+	saveType      models.ScriptSaveType
+}
+
+// fakeUpdateScript records what would have been saved instead of saving it.
+func fakeUpdateScript(t *testing.T) *[]savedScript {
+	noHTML = bluemonday.StrictPolicy()
+	var saved []savedScript
+	original := updateScript
+	updateScript = func(spotifyUserID string, scriptID uuid.UUID, name, description, script, permissions string, saveType models.ScriptSaveType) (models.CreateOrUpdateScriptResponse, error) {
+		saved = append(saved, savedScript{spotifyUserID, scriptID, name, description, script, permissions, saveType})
+		return models.CreateOrUpdateScriptResponse{ID: scriptID}, nil
+	}
+	t.Cleanup(func() { updateScript = original })
+	return &saved
+}
+
+func scriptRequest(method string, body interface{}, spotifyID string, script models.Script) *http.Request {
+	encoded, _ := json.Marshal(body)
+	r := httptest.NewRequest(method, "/script/"+script.ID.String(), strings.NewReader(string(encoded)))
+	ctx := context.WithValue(r.Context(), middleware.AuthenticatedSessionContextKey, &session.Session{Authenticated: true, SpotifyID: spotifyID})
+	ctx = context.WithValue(ctx, middleware.ScriptContextKey, script)
+	return r.WithContext(ctx)
+}
+
+func TestSaveScriptPassesScriptAndDescription(t *testing.T) {
+	saved := fakeUpdateScript(t)
+	script := models.Script{ID: uuid.NewV4(), IsPrivate: true, MyRole: models.ScriptRoleOwner}
+	body := map[string]string{
+		"name":        "Walk",
+		"description": "Goes for a walk, doesn't run",
+		"script":      "self.hooks.getFirstTrack = () => null;",
+		"permissions": "private",
+	}
+	for _, test := range []struct {
+		handler  http.HandlerFunc
+		saveType models.ScriptSaveType
+	}{
+		{UpdateScript, models.ScriptSaveTypeDraft},
+		{PublishScript, models.ScriptSaveTypePublished},
+	} {
+		*saved = nil
+		w := httptest.NewRecorder()
+		test.handler(w, scriptRequest(http.MethodPut, body, "me", script))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected %s to succeed, got %d: %s", test.saveType, w.Code, w.Body.String())
+		}
+		if len(*saved) != 1 {
+			t.Fatalf("Expected one save, got %+v", *saved)
+		}
+		got := (*saved)[0]
+		if got.script != body["script"] || got.description != body["description"] || got.name != body["name"] {
+			t.Errorf("Expected %s to save the script and description as sent, got %+v", got.saveType, got)
+		}
+		if got.saveType != test.saveType || got.spotifyUserID != "me" || !uuid.Equal(got.scriptID, script.ID) {
+			t.Errorf("Expected a %s by me of %s, got %+v", test.saveType, script.ID, got)
+		}
+	}
+}
+
+func TestPublishScriptValidatesScript(t *testing.T) {
+	saved := fakeUpdateScript(t)
+	script := models.Script{ID: uuid.NewV4(), IsPrivate: true, MyRole: models.ScriptRoleOwner}
+	w := httptest.NewRecorder()
+	PublishScript(w, scriptRequest(http.MethodPut, map[string]string{
+		"description": "Goes for a walk",
+		"script":      "this is not javascript (",
+		"permissions": "private",
+	}, "me", script))
+	if w.Code != http.StatusBadRequest || len(*saved) != 0 {
+		t.Fatalf("Expected an invalid script not to be published, got %d and %+v", w.Code, *saved)
+	}
+	if !strings.Contains(w.Body.String(), `"syntax"`) {
+		t.Fatalf("Expected a syntax error, got %s", w.Body.String())
+	}
+}
+
+// putScript saves the script the way the router does, behind the editor check.
+func putScript(script models.Script, permissions string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	middleware.AuthorizeScriptEditor(http.HandlerFunc(UpdateScript)).ServeHTTP(w, scriptRequest(http.MethodPut, map[string]string{
+		"name":        "Walk",
+		"description": "Goes for a walk",
+		"script":      "self.hooks.getFirstTrack = () => null;",
+		"permissions": permissions,
+	}, "collaborator", script))
+	return w
+}
+
+func TestSaveScriptRoles(t *testing.T) {
+	saved := fakeUpdateScript(t)
+	for _, test := range []struct {
+		name        string
+		role        models.ScriptRole
+		isPrivate   bool
+		permissions string
+		expected    int
+	}{
+		{"unaccepted invite", models.ScriptRoleNone, false, "public", http.StatusForbidden},
+		{"viewer", models.ScriptRoleViewer, true, "private", http.StatusForbidden},
+		{"editor making public", models.ScriptRoleEditor, true, "public", http.StatusForbidden},
+		{"editor making private", models.ScriptRoleEditor, false, "private", http.StatusForbidden},
+		{"editor", models.ScriptRoleEditor, true, "private", http.StatusOK},
+		{"owner making public", models.ScriptRoleOwner, true, "public", http.StatusOK},
+	} {
+		*saved = nil
+		script := models.Script{ID: uuid.NewV4(), IsPrivate: test.isPrivate, MyRole: test.role}
+		w := putScript(script, test.permissions)
+		if w.Code != test.expected {
+			t.Errorf("%s: expected %d, got %d: %s", test.name, test.expected, w.Code, w.Body.String())
+		}
+		if saves := len(*saved); (test.expected == http.StatusOK) != (saves == 1) {
+			t.Errorf("%s: unexpected saves %+v", test.name, *saved)
+		}
+	}
+}
+
+func TestUnacceptedInviteCannotView(t *testing.T) {
+	script := models.Script{ID: uuid.NewV4(), IsPrivate: true, MyRole: models.ScriptRoleNone}
+	viewed := false
+	w := httptest.NewRecorder()
+	middleware.AuthorizeScriptViewer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		viewed = true
+	})).ServeHTTP(w, scriptRequest(http.MethodGet, nil, "collaborator", script))
+	if w.Code != http.StatusForbidden || viewed {
+		t.Fatalf("Expected an unaccepted invite to be forbidden, got %d", w.Code)
+	}
+}
+
+func TestDiffScriptVersions(t *testing.T) {
+	store := models.NewMemoryScriptStore("https://scripts.example/")
+	models.SetScriptStore(store)
+	t.Cleanup(func() { models.SetScriptStore(nil) })
+	fromFileID, _ := store.Put("const a = 1;\n")
+	toFileID, _ := store.Put("const a = 2;\n")
+	w := httptest.NewRecorder()
+	diffScriptVersions(w, uuid.NewV4(), models.ScriptVersion{FileID: fromFileID}, uuid.NewV4(), models.ScriptVersion{FileID: toFileID})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if body := w.Body.String(); !strings.Contains(body, "-const a = 1;") || !strings.Contains(body, "+const a = 2;") {
+		t.Errorf("Expected diff of both versions, got %s", body)
+	}
+}
+
+func TestParseScriptVersionQuery(t *testing.T) {
+	version := time.Date(2019, time.August, 31, 12, 0, 0, 0, time.UTC)
+	var refs []models.ScriptVersionRef
+	original := getScriptVersion
+	getScriptVersion = func(spotifyUserID string, scriptID uuid.UUID, ref models.ScriptVersionRef) (models.ScriptVersion, bool, error) {
+		refs = append(refs, ref)
+		if ref.Release == "v1.0.0" {
+			return models.ScriptVersion{CreatedAt: version}, true, nil
+		}
+		return models.ScriptVersion{}, false, nil
+	}
+	t.Cleanup(func() { getScriptVersion = original })
+	scriptID := uuid.NewV4()
+	w := httptest.NewRecorder()
+	if actual, ok := parseScriptVersionQuery(w, httptest.NewRequest("POST", "/?version=v1.0.0", nil), "someone", scriptID); !ok || !actual.Equal(version) {
+		t.Errorf("Expected release to resolve to %s, got %s (%t)", version, actual, ok)
+	}
+	w = httptest.NewRecorder()
+	if _, ok := parseScriptVersionQuery(w, httptest.NewRequest("POST", "/?version=v9.9.9", nil), "someone", scriptID); ok || w.Code != http.StatusNotFound {
+		t.Errorf("Expected unknown release to be a 404, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	if actual, ok := parseScriptVersionQuery(w, httptest.NewRequest("POST", "/", nil), "someone", scriptID); !ok || !actual.IsZero() {
+		t.Errorf("Expected no version to leave it to the model, got %s (%t)", actual, ok)
+	}
+	if len(refs) != 2 {
+		t.Errorf("Expected 2 lookups, got %d", len(refs))
+	}
+}
+
+func TestValidateScriptBundleOnlyChecksPublishedAndLatest(t *testing.T) {
+	noHTML = bluemonday.StrictPolicy()
+	valid := "self.hooks.getFirstTrack = () => null;"
+	unfinished := "self.hooks.getFirstTrack = ("
+	version := func(minutes int, saveType models.ScriptSaveType, script string) models.ScriptBundleVersion {
+		return models.ScriptBundleVersion{
+			CreatedAt: time.Date(2019, 9, 1, 0, minutes, 0, 0, time.UTC),
+			Type:      saveType,
+			FileID:    models.ScriptFileID(script),
+			Script:    script,
+		}
+	}
+	for _, test := range []struct {
+		name     string
+		versions []models.ScriptBundleVersion
+		valid    bool
+	}{
+		{"old draft", []models.ScriptBundleVersion{version(1, models.ScriptSaveTypeDraft, unfinished), version(2, models.ScriptSaveTypePublished, valid)}, true},
+		{"published", []models.ScriptBundleVersion{version(1, models.ScriptSaveTypePublished, unfinished), version(2, models.ScriptSaveTypeDraft, valid)}, false},
+		{"latest draft", []models.ScriptBundleVersion{version(2, models.ScriptSaveTypeDraft, unfinished), version(1, models.ScriptSaveTypePublished, valid)}, false},
+		{"too large", []models.ScriptBundleVersion{version(1, models.ScriptSaveTypeDraft, strings.Repeat("//", scriptcheck.MaxScriptSize)), version(2, models.ScriptSaveTypeDraft, valid)}, false},
+	} {
+		err := validateScriptBundle(models.ScriptBundle{Name: "Walk", Versions: test.versions})
+		if (err == nil) != test.valid {
+			t.Errorf("%s: expected valid to be %t, got %v", test.name, test.valid, err)
+		}
+	}
+}
@@ -0,0 +1,449 @@
+package phosphor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/samuelhorwitz/phosphorescence/api/common"
+	"github.com/samuelhorwitz/phosphorescence/api/middleware"
+	"github.com/samuelhorwitz/phosphorescence/api/models"
+	"github.com/samuelhorwitz/phosphorescence/api/session"
+	"github.com/satori/go.uuid"
+)
+
+const maxScriptChainPruners = 10
+
+type scriptChainScriptRequest struct {
+	ScriptID uuid.UUID `json:"scriptId"`
+	Version  string    `json:"version"`
+}
+
+// scriptChainRequest is the body for creating and saving chains. A script
+// without a version is pinned to its most recent published version.
+type scriptChainRequest struct {
+	Name        string                     `json:"name"`
+	Description string                     `json:"description"`
+	Permissions string                     `json:"permissions"`
+	Seeder      *scriptChainScriptRequest  `json:"seeder"`
+	Builder     scriptChainScriptRequest   `json:"builder"`
+	Pruners     []scriptChainScriptRequest `json:"pruners"`
+}
+
+func GetScriptChain(w http.ResponseWriter, r *http.Request) {
+	scriptChain, ok := r.Context().Value(middleware.ScriptChainContextKey).(models.ScriptChain)
+	if !ok {
+		common.Fail(w, errors.New("No script chain on request context"), http.StatusInternalServerError)
+		return
+	}
+	mostRecent, ok, err := models.GetMostRecentPublishedScriptChainVersion(scriptChain.ID)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not get most recent script chain version: %s", err), http.StatusInternalServerError)
+		return
+	}
+	if ok {
+		scriptChain.MostRecent = &mostRecent
+	}
+	common.JSON(w, map[string]interface{}{"scriptChain": scriptChain})
+}
+
+func GetScriptChainVersion(w http.ResponseWriter, r *http.Request) {
+	scriptChainVersion, ok := r.Context().Value(middleware.ScriptChainVersionContextKey).(models.ScriptChainVersion)
+	if !ok {
+		common.Fail(w, errors.New("No script chain version on request context"), http.StatusInternalServerError)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"scriptChainVersion": scriptChainVersion})
+}
+
+func GetScriptChainVersions(w http.ResponseWriter, r *http.Request) {
+	getScriptChainVersions(w, r, true)
+}
+
+func GetPrivateScriptChainVersions(w http.ResponseWriter, r *http.Request) {
+	getScriptChainVersions(w, r, false)
+}
+
+func getScriptChainVersions(w http.ResponseWriter, r *http.Request, limitToPublished bool) {
+	scriptChain, ok := r.Context().Value(middleware.ScriptChainContextKey).(models.ScriptChain)
+	if !ok {
+		common.Fail(w, errors.New("No script chain on request context"), http.StatusInternalServerError)
+		return
+	}
+	count, ok := r.Context().Value(middleware.PageCountContextKey).(uint64)
+	if !ok {
+		common.Fail(w, errors.New("No page count on request context"), http.StatusInternalServerError)
+		return
+	}
+	from, ok := r.Context().Value(middleware.PageCursorContextKey).(time.Time)
+	if !ok {
+		common.Fail(w, errors.New("No page cursor on request context"), http.StatusInternalServerError)
+		return
+	}
+	versions, err := models.GetScriptChainVersions(scriptChain.ID, count, from, limitToPublished)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not get script chain versions: %s", err), http.StatusInternalServerError)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"scriptChainVersions": versions})
+}
+
+func ListPublicScriptChains(w http.ResponseWriter, r *http.Request) {
+	sess, ok := r.Context().Value(middleware.AuthenticatedSessionContextKey).(*session.Session)
+	if !ok {
+		common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
+		return
+	}
+	count, ok := r.Context().Value(middleware.PageCountContextKey).(uint64)
+	if !ok {
+		common.Fail(w, errors.New("No page count on request context"), http.StatusInternalServerError)
+		return
+	}
+	from, ok := r.Context().Value(middleware.PageCursorContextKey).(time.Time)
+	if !ok {
+		common.Fail(w, errors.New("No page cursor on request context"), http.StatusInternalServerError)
+		return
+	}
+	scriptChains, err := models.GetNewScriptChains(sess.SpotifyID, count, from)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not get new script chains: %s", err), http.StatusInternalServerError)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"scriptChains": scriptChains})
+}
+
+func ListCurrentUserScriptChains(w http.ResponseWriter, r *http.Request) {
+	sess, ok := r.Context().Value(middleware.AuthenticatedSessionContextKey).(*session.Session)
+	if !ok {
+		common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
+		return
+	}
+	count, ok := r.Context().Value(middleware.PageCountContextKey).(uint64)
+	if !ok {
+		common.Fail(w, errors.New("No page count on request context"), http.StatusInternalServerError)
+		return
+	}
+	from, ok := r.Context().Value(middleware.PageCursorContextKey).(time.Time)
+	if !ok {
+		common.Fail(w, errors.New("No page cursor on request context"), http.StatusInternalServerError)
+		return
+	}
+	scriptChains, err := models.GetScriptChainsBySpotifyUserID(sess.SpotifyID, count, from, true)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not get script chains for user: %s", err), http.StatusInternalServerError)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"scriptChains": scriptChains})
+}
+
+func CreateScriptChain(w http.ResponseWriter, r *http.Request) {
+	sess, ok := r.Context().Value(middleware.AuthenticatedSessionContextKey).(*session.Session)
+	if !ok {
+		common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
+		return
+	}
+	requestBody, err := parseScriptChainRequest(r)
+	if err != nil {
+		common.Fail(w, err, http.StatusInternalServerError)
+		return
+	}
+	if err := validateScriptChain(requestBody, true); err != nil {
+		common.Fail(w, fmt.Errorf("Bad request: %s", err), http.StatusBadRequest)
+		return
+	}
+	seeder, builder, pruners := requestBody.scripts()
+	createDetails, err := models.CreateScriptChain(sess.SpotifyID, requestBody.Name, requestBody.Description, seeder, builder, pruners)
+	if err != nil {
+		failScriptChain(w, fmt.Errorf("Could not create script chain: %s", err), err)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"create": createDetails})
+}
+
+func UpdateScriptChain(w http.ResponseWriter, r *http.Request) {
+	saveScriptChain(w, r, models.ScriptSaveTypeDraft)
+}
+
+func PublishScriptChain(w http.ResponseWriter, r *http.Request) {
+	saveScriptChain(w, r, models.ScriptSaveTypePublished)
+}
+
+func saveScriptChain(w http.ResponseWriter, r *http.Request, scriptSaveType models.ScriptSaveType) {
+	sess, ok := r.Context().Value(middleware.AuthenticatedSessionContextKey).(*session.Session)
+	if !ok {
+		common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
+		return
+	}
+	existingScriptChain, ok := r.Context().Value(middleware.ScriptChainContextKey).(models.ScriptChain)
+	if !ok {
+		common.Fail(w, errors.New("No script chain on request context"), http.StatusInternalServerError)
+		return
+	}
+	requestBody, err := parseScriptChainRequest(r)
+	if err != nil {
+		common.Fail(w, err, http.StatusInternalServerError)
+		return
+	}
+	if requestBody.Permissions == "" {
+		common.Fail(w, errors.New("Permissions must be populated"), http.StatusBadRequest)
+		return
+	}
+	if err := validateScriptChain(requestBody, false); err != nil {
+		common.Fail(w, fmt.Errorf("Bad request: %s", err), http.StatusBadRequest)
+		return
+	}
+	seeder, builder, pruners := requestBody.scripts()
+	updateDetails, err := models.UpdateScriptChain(sess.SpotifyID, existingScriptChain.ID, requestBody.Name, requestBody.Description, seeder, builder, pruners, requestBody.Permissions, scriptSaveType)
+	if err != nil {
+		failScriptChain(w, fmt.Errorf("Could not update script chain: %s", err), err)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"update": updateDetails})
+}
+
+func DeleteScriptChain(w http.ResponseWriter, r *http.Request) {
+	existingScriptChain, ok := r.Context().Value(middleware.ScriptChainContextKey).(models.ScriptChain)
+	if !ok {
+		common.Fail(w, errors.New("No script chain on request context"), http.StatusInternalServerError)
+		return
+	}
+	err := models.DeleteScriptChain(existingScriptChain.ID)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not delete script chain: %s", err), http.StatusInternalServerError)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"delete": true})
+}
+
+func DeleteScriptChainVersion(w http.ResponseWriter, r *http.Request) {
+	existingScriptChain, ok := r.Context().Value(middleware.ScriptChainContextKey).(models.ScriptChain)
+	if !ok {
+		common.Fail(w, errors.New("No script chain on request context"), http.StatusInternalServerError)
+		return
+	}
+	scriptChainVersion, ok := r.Context().Value(middleware.ScriptChainVersionContextKey).(models.ScriptChainVersion)
+	if !ok {
+		common.Fail(w, errors.New("No script chain version on request context"), http.StatusInternalServerError)
+		return
+	}
+	if scriptChainVersion.CreatedAt.IsZero() {
+		common.Fail(w, errors.New("Invalid script chain version"), http.StatusBadRequest)
+		return
+	}
+	err := models.DeleteScriptChainVersion(existingScriptChain.ID, scriptChainVersion.CreatedAt)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not delete script chain version: %s", err), http.StatusInternalServerError)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"delete": true})
+}
+
+func ForkScriptChain(w http.ResponseWriter, r *http.Request) {
+	scriptChainVersionID, ok := parseScriptChainVersionQuery(w, r)
+	if !ok {
+		return
+	}
+	forkScriptChain(w, r, scriptChainVersionID, "", true)
+}
+
+func ForkScriptChainVersion(w http.ResponseWriter, r *http.Request) {
+	scriptChainVersion, ok := r.Context().Value(middleware.ScriptChainVersionContextKey).(models.ScriptChainVersion)
+	if !ok {
+		common.Fail(w, errors.New("No script chain version on request context"), http.StatusInternalServerError)
+		return
+	}
+	forkScriptChain(w, r, scriptChainVersion.CreatedAt, "", true)
+}
+
+func DuplicateScriptChain(w http.ResponseWriter, r *http.Request) {
+	name, ok := parseDuplicateScriptChainName(w, r)
+	if !ok {
+		return
+	}
+	scriptChainVersionID, ok := parseScriptChainVersionQuery(w, r)
+	if !ok {
+		return
+	}
+	forkScriptChain(w, r, scriptChainVersionID, name, false)
+}
+
+func DuplicateScriptChainVersion(w http.ResponseWriter, r *http.Request) {
+	scriptChainVersion, ok := r.Context().Value(middleware.ScriptChainVersionContextKey).(models.ScriptChainVersion)
+	if !ok {
+		common.Fail(w, errors.New("No script chain version on request context"), http.StatusInternalServerError)
+		return
+	}
+	name, ok := parseDuplicateScriptChainName(w, r)
+	if !ok {
+		return
+	}
+	forkScriptChain(w, r, scriptChainVersion.CreatedAt, name, false)
+}
+
+// forkScriptChain copies the chain for the current user, a fork only copies
+// published versions while the author duplicating their own chain may copy any
+// version. An empty name keeps the existing chain's name.
+func forkScriptChain(w http.ResponseWriter, r *http.Request, scriptChainVersionID time.Time, name string, onlyPublished bool) {
+	sess, ok := r.Context().Value(middleware.AuthenticatedSessionContextKey).(*session.Session)
+	if !ok {
+		common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
+		return
+	}
+	existingScriptChain, ok := r.Context().Value(middleware.ScriptChainContextKey).(models.ScriptChain)
+	if !ok {
+		common.Fail(w, errors.New("No script chain on request context"), http.StatusInternalServerError)
+		return
+	}
+	if name == "" {
+		name = existingScriptChain.Name.String
+	}
+	forkDetails, err := models.ForkScriptChain(sess.SpotifyID, existingScriptChain.ID, name, scriptChainVersionID, onlyPublished)
+	if err != nil {
+		failScriptChain(w, fmt.Errorf("Could not fork script chain: %s", err), err)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"fork": forkDetails})
+}
+
+var (
+	getScriptChainVersion                    = models.GetScriptChainVersionWithAuthorizationCheck
+	getMostRecentPublishedScriptChainVersion = models.GetMostRecentPublishedScriptChainVersion
+)
+
+// parseScriptChainVersionQuery resolves the version query parameter like
+// parseScriptVersionQuery does, except chains have no releases so only a
+// timestamp or latest can resolve.
+func parseScriptChainVersionQuery(w http.ResponseWriter, r *http.Request) (time.Time, bool) {
+	sess, ok := r.Context().Value(middleware.AuthenticatedSessionContextKey).(*session.Session)
+	if !ok {
+		common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
+		return time.Time{}, false
+	}
+	scriptChain, ok := r.Context().Value(middleware.ScriptChainContextKey).(models.ScriptChain)
+	if !ok {
+		common.Fail(w, errors.New("No script chain on request context"), http.StatusInternalServerError)
+		return time.Time{}, false
+	}
+	query := r.URL.Query().Get("version")
+	if query == "" {
+		return time.Time{}, true
+	}
+	var scriptChainVersion models.ScriptChainVersion
+	var err error
+	switch ref := models.ParseScriptVersionRef(query); {
+	case ref.Latest:
+		scriptChainVersion, ok, err = getMostRecentPublishedScriptChainVersion(scriptChain.ID)
+	case ref.Release != "":
+		ok = false
+	default:
+		scriptChainVersion, ok, err = getScriptChainVersion(sess.SpotifyID, scriptChain.ID, ref.CreatedAt)
+	}
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not get script chain version: %s", err), http.StatusInternalServerError)
+		return time.Time{}, false
+	}
+	if !ok {
+		common.Fail(w, fmt.Errorf("Script chain version %s does not exist", query), http.StatusNotFound)
+		return time.Time{}, false
+	}
+	return scriptChainVersion.CreatedAt, true
+}
+
+func parseDuplicateScriptChainName(w http.ResponseWriter, r *http.Request) (string, bool) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not read request body: %s", err), http.StatusInternalServerError)
+		return "", false
+	}
+	var requestBody struct {
+		Name string `json:"name"`
+	}
+	err = json.Unmarshal(body, &requestBody)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not parse request body: %s", err), http.StatusInternalServerError)
+		return "", false
+	}
+	err = validateScriptName(requestBody.Name)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Bad request: %s", err), http.StatusBadRequest)
+		return "", false
+	}
+	return requestBody.Name, true
+}
+
+func parseScriptChainRequest(r *http.Request) (scriptChainRequest, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return scriptChainRequest{}, fmt.Errorf("Could not read request body: %s", err)
+	}
+	var requestBody scriptChainRequest
+	err = json.Unmarshal(body, &requestBody)
+	if err != nil {
+		return scriptChainRequest{}, fmt.Errorf("Could not parse request body: %s", err)
+	}
+	return requestBody, nil
+}
+
+func (req scriptChainRequest) scripts() (seeder *models.ScriptChainScript, builder models.ScriptChainScript, pruners []models.ScriptChainScript) {
+	if req.Seeder != nil {
+		pinned := req.Seeder.pin()
+		seeder = &pinned
+	}
+	for _, pruner := range req.Pruners {
+		pruners = append(pruners, pruner.pin())
+	}
+	return seeder, req.Builder.pin(), pruners
+}
+
+// pin turns the requested version, which may be a timestamp, a release name or
+// latest, into what the model pins. No version at all means latest.
+func (req scriptChainScriptRequest) pin() models.ScriptChainScript {
+	if req.Version == "" {
+		return models.ScriptChainScript{ScriptID: req.ScriptID}
+	}
+	ref := models.ParseScriptVersionRef(req.Version)
+	return models.ScriptChainScript{ScriptID: req.ScriptID, Version: ref.CreatedAt, Release: ref.Release}
+}
+
+// validateScriptChain checks the chain in a request. New chains need a
+// builder, saves without one only change the chain's details and keep its
+// scripts as they are.
+func validateScriptChain(req scriptChainRequest, creating bool) error {
+	if uuid.Equal(req.Builder.ScriptID, uuid.Nil) && (creating || req.Seeder != nil || len(req.Pruners) > 0) {
+		return errors.New("Script chain must have a builder")
+	}
+	if req.Seeder != nil && uuid.Equal(req.Seeder.ScriptID, uuid.Nil) {
+		return errors.New("Seeder must have a script ID")
+	}
+	if len(req.Pruners) > maxScriptChainPruners {
+		return fmt.Errorf("Script chain cannot have more than %d pruners", maxScriptChainPruners)
+	}
+	for _, pruner := range req.Pruners {
+		if uuid.Equal(pruner.ScriptID, uuid.Nil) {
+			return errors.New("Pruners must have script IDs")
+		}
+	}
+	if containsHTML(req.Description) {
+		return errors.New("Description contained HTML")
+	}
+	if err := validateScriptName(req.Name); err != nil {
+		return err
+	}
+	if len([]byte(req.Description)) > 1024 {
+		return errors.New("Description is too long")
+	}
+	return nil
+}
+
+// failScriptChain responds with a bad request if the chain refers to scripts
+// which can't be used in it, otherwise an internal error.
+func failScriptChain(w http.ResponseWriter, wrapped, err error) {
+	if _, ok := err.(models.ScriptChainScriptError); ok {
+		common.Fail(w, wrapped, http.StatusBadRequest)
+		return
+	}
+	common.Fail(w, wrapped, http.StatusInternalServerError)
+}
@@ -0,0 +1,78 @@
+package phosphor
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/samuelhorwitz/phosphorescence/api/middleware"
+	"github.com/samuelhorwitz/phosphorescence/api/models"
+	"github.com/samuelhorwitz/phosphorescence/api/session"
+	"github.com/satori/go.uuid"
+)
+
+func TestValidateScriptChainBuilder(t *testing.T) {
+	noHTML = bluemonday.StrictPolicy()
+	detailsOnly := scriptChainRequest{Name: "Walk", Description: "Goes for a walk"}
+	if err := validateScriptChain(detailsOnly, true); err == nil {
+		t.Error("Expected new chains to need a builder")
+	}
+	if err := validateScriptChain(detailsOnly, false); err != nil {
+		t.Errorf("Expected saving only details to be fine, got %s", err)
+	}
+	withoutBuilder := detailsOnly
+	withoutBuilder.Pruners = []scriptChainScriptRequest{{ScriptID: uuid.NewV4()}}
+	if err := validateScriptChain(withoutBuilder, false); err == nil {
+		t.Error("Expected pruners without a builder to be rejected")
+	}
+	withBuilder := withoutBuilder
+	withBuilder.Builder = scriptChainScriptRequest{ScriptID: uuid.NewV4()}
+	if err := validateScriptChain(withBuilder, true); err != nil {
+		t.Errorf("Expected a chain with a builder to be fine, got %s", err)
+	}
+}
+
+func TestParseScriptChainVersionQuery(t *testing.T) {
+	version := time.Date(2019, time.August, 31, 12, 0, 0, 0, time.UTC)
+	latest := version.Add(time.Hour)
+	originalVersion, originalLatest := getScriptChainVersion, getMostRecentPublishedScriptChainVersion
+	getScriptChainVersion = func(spotifyUserID string, scriptChainID uuid.UUID, scriptChainVersionID time.Time) (models.ScriptChainVersion, bool, error) {
+		return models.ScriptChainVersion{CreatedAt: version}, scriptChainVersionID.Equal(version), nil
+	}
+	getMostRecentPublishedScriptChainVersion = func(scriptChainID uuid.UUID) (models.ScriptChainVersion, bool, error) {
+		return models.ScriptChainVersion{CreatedAt: latest}, true, nil
+	}
+	t.Cleanup(func() {
+		getScriptChainVersion, getMostRecentPublishedScriptChainVersion = originalVersion, originalLatest
+	})
+	request := func(query string) *http.Request {
+		r := httptest.NewRequest("POST", "/"+query, nil)
+		ctx := context.WithValue(r.Context(), middleware.AuthenticatedSessionContextKey, &session.Session{Authenticated: true, SpotifyID: "someone"})
+		ctx = context.WithValue(ctx, middleware.ScriptChainContextKey, models.ScriptChain{ID: uuid.NewV4()})
+		return r.WithContext(ctx)
+	}
+	tests := []struct {
+		query    string
+		expected time.Time
+		code     int
+	}{
+		{"", time.Time{}, http.StatusOK},
+		{"?version=" + version.Format(time.RFC3339), version, http.StatusOK},
+		{"?version=latest", latest, http.StatusOK},
+		{"?version=" + latest.Format(time.RFC3339), time.Time{}, http.StatusNotFound},
+		{"?version=v1.0.0", time.Time{}, http.StatusNotFound},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		actual, ok := parseScriptChainVersionQuery(w, request(test.query))
+		if ok != (test.code == http.StatusOK) || w.Code != test.code {
+			t.Errorf("%s: Expected %d, got %d (%t)", test.query, test.code, w.Code, ok)
+		}
+		if !actual.Equal(test.expected) {
+			t.Errorf("%s: Expected %s, got %s", test.query, test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package phosphor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/samuelhorwitz/phosphorescence/api/common"
+	"github.com/samuelhorwitz/phosphorescence/api/middleware"
+	"github.com/samuelhorwitz/phosphorescence/api/nowplaying"
+	"github.com/samuelhorwitz/phosphorescence/api/session"
+)
+
+// Proxies tend to close connections which are quiet for too long.
+const streamHeartbeatInterval = 15 * time.Second
+
+// How long the browser's EventSource should wait before reconnecting, in
+// milliseconds.
+const streamRetryMilliseconds = 1000
+
+func StreamCurrentlyPlaying(w http.ResponseWriter, r *http.Request) {
+	sess, ok := r.Context().Value(middleware.SessionContextKey).(*session.Session)
+	if !ok {
+		common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		common.Fail(w, errors.New("Streaming is not supported"), http.StatusInternalServerError)
+		return
+	}
+	events, unsubscribe := nowplaying.Subscribe(sess)
+	defer unsubscribe()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", streamRetryMilliseconds)
+	flusher.Flush()
+	heartbeat := time.NewTicker(streamHeartbeatInterval)
+	defer heartbeat.Stop()
+	lifetime := time.NewTimer(streamLifetime)
+	defer lifetime.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-lifetime.C:
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		case event := <-events:
+			data, err := json.Marshal(event)
+			if err != nil {
+				if !isProduction {
+					log.Printf("Could not marshal now playing event: %s", err)
+				}
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+		}
+		flusher.Flush()
+	}
+}
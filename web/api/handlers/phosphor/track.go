@@ -21,7 +21,50 @@ func GetTracks(w http.ResponseWriter, r *http.Request) {
 		common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
 		return
 	}
-	getTracks(w, r, sess.SpotifyCountry, chi.URLParam(r, "trackIDs"))
+	recentFilter, recentWindow, err := handlers.ParseRecentFilter(r)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if httpErr, ok := err.(handlers.HTTPError); ok {
+			code = httpErr.Code
+		}
+		common.Fail(w, err, code)
+		return
+	}
+	if recentFilter == models.RecentFilterNone {
+		getTracks(w, r, sess.SpotifyCountry, chi.URLParam(r, "trackIDs"))
+		return
+	}
+	tracks, err := getTracksFromModel(r.Context(), sess.SpotifyCountry, chi.URLParam(r, "trackIDs"))
+	if err != nil {
+		code := http.StatusInternalServerError
+		if httpErr, ok := err.(handlers.HTTPError); ok {
+			code = httpErr.Code
+		}
+		common.Fail(w, err, code)
+		return
+	}
+	weights, err := models.GetRecentPlayWeights(r.Context(), sess, recentWindow)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not get recently played tracks: %s", err), http.StatusInternalServerError)
+		return
+	}
+	if recentFilter == models.RecentFilterExclude {
+		unheardTracks := []*models.SpotifyTrackEnvelope{}
+		for _, track := range tracks {
+			if _, ok := weights[track.OriginalID()]; !ok {
+				unheardTracks = append(unheardTracks, track)
+			}
+		}
+		common.JSON(w, map[string]interface{}{"tracks": unheardTracks})
+		return
+	}
+	trackWeights := make(map[string]float64)
+	for _, track := range tracks {
+		if weight, ok := weights[track.OriginalID()]; ok {
+			trackWeights[track.OriginalID()] = weight
+		}
+	}
+	common.JSON(w, map[string]interface{}{"tracks": tracks, "weights": trackWeights})
 }
 
 func GetTracksUnauthenticated(w http.ResponseWriter, r *http.Request) {
@@ -1,8 +1,11 @@
 package phosphor
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"log"
 	"net/http"
 	"time"
 
@@ -36,15 +39,82 @@ func GetCurrentlyPlaying(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	currentlyPlaying, err := models.GetCurrentPlayback(r.Context(), sess)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not get current playback: %s", err), http.StatusBadGateway)
+		return
+	}
+	if err = models.ObservePlayback(sess, currentlyPlaying); err != nil {
+		if !isProduction {
+			log.Printf("Could not record listening log: %s", err)
+		}
+	}
+	common.JSON(w, currentlyPlaying)
+}
+
+func GetRecentlyPlayed(w http.ResponseWriter, r *http.Request) {
+	sess, ok := r.Context().Value(middleware.SessionContextKey).(*session.Session)
+	if !ok {
+		common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
+		return
+	}
+	window, err := handlers.ParseRecentWindow(r, "days")
 	if err != nil {
 		code := http.StatusInternalServerError
-		if err == models.ErrCurrentlyPlayingNotTrack {
-			code = http.StatusNotFound
+		if httpErr, ok := err.(handlers.HTTPError); ok {
+			code = httpErr.Code
 		}
-		common.Fail(w, fmt.Errorf("Could not get current playback: %s", err), code)
+		common.Fail(w, err, code)
 		return
 	}
-	common.JSON(w, currentlyPlaying)
+	recentlyPlayed, err := models.GetRecentlyPlayed(r.Context(), sess, time.Now().Add(-window))
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not get recently played tracks: %s", err), http.StatusInternalServerError)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"recentlyPlayed": recentlyPlayed})
+}
+
+func GetListeningLog(w http.ResponseWriter, r *http.Request) {
+	sess, ok := r.Context().Value(middleware.SessionContextKey).(*session.Session)
+	if !ok {
+		common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
+		return
+	}
+	enabled, err := models.GetListeningLog(sess.SpotifyID)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not get listening log setting: %s", err), http.StatusInternalServerError)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"enabled": enabled})
+}
+
+func SetListeningLog(w http.ResponseWriter, r *http.Request) {
+	sess, ok := r.Context().Value(middleware.SessionContextKey).(*session.Session)
+	if !ok {
+		common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not read request body: %s", err), http.StatusBadRequest)
+		return
+	}
+	var requestBody struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err = json.Unmarshal(body, &requestBody); err != nil {
+		common.Fail(w, fmt.Errorf("Could not parse request body: %s", err), http.StatusBadRequest)
+		return
+	}
+	if requestBody.Enabled == nil {
+		common.Fail(w, errors.New("Must specify whether the listening log is enabled"), http.StatusBadRequest)
+		return
+	}
+	if err = models.SetListeningLog(sess.SpotifyID, *requestBody.Enabled); err != nil {
+		common.Fail(w, fmt.Errorf("Could not set listening log setting: %s", err), http.StatusInternalServerError)
+		return
+	}
+	common.JSON(w, map[string]interface{}{"enabled": *requestBody.Enabled})
 }
 
 func ListCurrentUserScripts(w http.ResponseWriter, r *http.Request) {
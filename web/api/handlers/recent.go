@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/samuelhorwitz/phosphorescence/api/models"
+)
+
+const (
+	defaultRecentDays = 7
+	maxRecentDays     = 30
+)
+
+// ParseRecentFilter reads the recent and recentDays query params shared by
+// endpoints which can leave out or down-weight tracks the user heard lately.
+func ParseRecentFilter(r *http.Request) (models.RecentFilterMode, time.Duration, error) {
+	mode := models.RecentFilterMode(r.URL.Query().Get("recent"))
+	switch mode {
+	case models.RecentFilterNone, models.RecentFilterExclude, models.RecentFilterDownweight:
+	default:
+		return "", 0, NewHTTPError(fmt.Errorf("Unknown recent filter %q", mode), http.StatusBadRequest)
+	}
+	window, err := ParseRecentWindow(r, "recentDays")
+	if err != nil {
+		return "", 0, err
+	}
+	return mode, window, nil
+}
+
+// ParseRecentWindow reads a number of days from the given query param, falling
+// back to a week if it isn't set.
+func ParseRecentWindow(r *http.Request, param string) (time.Duration, error) {
+	days := defaultRecentDays
+	if daysStr := r.URL.Query().Get(param); daysStr != "" {
+		var err error
+		days, err = strconv.Atoi(daysStr)
+		if err != nil || days < 1 || days > maxRecentDays {
+			return 0, NewHTTPError(fmt.Errorf("%s must be between 1 and %d", param, maxRecentDays), http.StatusBadRequest)
+		}
+	}
+	return time.Duration(days) * 24 * time.Hour, nil
+}
@@ -11,12 +11,14 @@ import (
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/go-chi/chi"
 	"github.com/samuelhorwitz/phosphorescence/api/common"
+	"github.com/samuelhorwitz/phosphorescence/api/handlers"
 	"github.com/samuelhorwitz/phosphorescence/api/middleware"
+	"github.com/samuelhorwitz/phosphorescence/api/models"
 	"github.com/samuelhorwitz/phosphorescence/api/session"
 )
 
 func TracksUnauthenticated(w http.ResponseWriter, r *http.Request) {
-	tracks(w, r, chi.URLParam(r, "region"))
+	tracks(w, r, chi.URLParam(r, "region"), nil)
 }
 
 func Tracks(w http.ResponseWriter, r *http.Request) {
@@ -25,10 +27,44 @@ func Tracks(w http.ResponseWriter, r *http.Request) {
 		common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
 		return
 	}
-	tracks(w, r, sess.SpotifyCountry)
+	recentFilter, recentWindow, err := handlers.ParseRecentFilter(r)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if httpErr, ok := err.(handlers.HTTPError); ok {
+			code = httpErr.Code
+		}
+		common.Fail(w, err, code)
+		return
+	}
+	if recentFilter == models.RecentFilterNone {
+		tracks(w, r, sess.SpotifyCountry, nil)
+		return
+	}
+	weights, err := models.GetRecentPlayWeights(r.Context(), sess, recentWindow)
+	if err != nil {
+		common.Fail(w, fmt.Errorf("Could not get recently played tracks: %s", err), http.StatusInternalServerError)
+		return
+	}
+	// The catalog itself comes straight from Spaces so the client does the
+	// filtering, we just tell it what to filter.
+	recent := &recentTracks{}
+	if recentFilter == models.RecentFilterExclude {
+		recent.ExcludeTrackIDs = []string{}
+		for trackID := range weights {
+			recent.ExcludeTrackIDs = append(recent.ExcludeTrackIDs, trackID)
+		}
+	} else {
+		recent.TrackWeights = weights
+	}
+	tracks(w, r, sess.SpotifyCountry, recent)
+}
+
+type recentTracks struct {
+	ExcludeTrackIDs []string           `json:"excludeTrackIds,omitempty"`
+	TrackWeights    map[string]float64 `json:"trackWeights,omitempty"`
 }
 
-func tracks(w http.ResponseWriter, r *http.Request, region string) {
+func tracks(w http.ResponseWriter, r *http.Request, region string, recent *recentTracks) {
 	region = strings.ToLower(region)
 	s3Req, _ := s3Service.GetObjectRequest(&s3.GetObjectInput{
 		Bucket: aws.String("phosphorescence-tracks"),
@@ -42,7 +78,9 @@ func tracks(w http.ResponseWriter, r *http.Request, region string) {
 	}
 	common.JSON(w, struct {
 		TracksURL string `json:"tracksUrl"`
+		*recentTracks
 	}{
-		TracksURL: tracksURL,
+		TracksURL:    tracksURL,
+		recentTracks: recent,
 	})
 }
@@ -14,6 +14,8 @@ import (
 
 const ScriptContextKey = contextKey("script")
 const ScriptVersionContextKey = contextKey("scriptVersion")
+const ScriptChainContextKey = contextKey("scriptChain")
+const ScriptChainVersionContextKey = contextKey("scriptChainVersion")
 
 func AuthorizeReadScript(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -41,26 +43,57 @@ func AuthorizeReadScript(next http.Handler) http.Handler {
 	})
 }
 
-func AuthorizePrivateScriptActions(next http.Handler) http.Handler {
+func AuthorizeReadScriptChain(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		sess, ok := r.Context().Value(AuthenticatedSessionContextKey).(*session.Session)
 		if !ok {
 			common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
 			return
 		}
-		script, ok := r.Context().Value(ScriptContextKey).(models.Script)
-		if !ok {
-			common.Fail(w, errors.New("No script on request context"), http.StatusInternalServerError)
+		scriptChainID, err := uuid.FromString(chi.URLParam(r, "scriptChainID"))
+		if err != nil {
+			common.Fail(w, errors.New("Invalid script chain ID"), http.StatusBadRequest)
 			return
 		}
-		if script.AuthorSpotifyID.String != sess.SpotifyID {
-			common.Fail(w, errors.New("User is not author of script"), http.StatusForbidden)
+		scriptChain, ok, err := models.GetScriptChainWithAuthorizationCheck(sess.SpotifyID, scriptChainID)
+		if err != nil {
+			common.Fail(w, fmt.Errorf("Cannot check script chain authorization: %s", err), http.StatusInternalServerError)
 			return
 		}
-		next.ServeHTTP(w, r)
+		if !ok {
+			common.Fail(w, errors.New("User does not have access to script chain or script chain does not exist"), http.StatusForbidden)
+			return
+		}
+		ctx := context.WithValue(r.Context(), ScriptChainContextKey, scriptChain)
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// These only let through users with at least the role on the script: its
+// owner, or a collaborator who has accepted their invite.
+var (
+	AuthorizeScriptViewer = authorizeScriptRole(models.ScriptRoleViewer)
+	AuthorizeScriptEditor = authorizeScriptRole(models.ScriptRoleEditor)
+	AuthorizeScriptOwner  = authorizeScriptRole(models.ScriptRoleOwner)
+)
+
+func authorizeScriptRole(role models.ScriptRole) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			script, ok := r.Context().Value(ScriptContextKey).(models.Script)
+			if !ok {
+				common.Fail(w, errors.New("No script on request context"), http.StatusInternalServerError)
+				return
+			}
+			if !script.MyRole.Can(role) {
+				common.Fail(w, fmt.Errorf("User does not have the %s role on script", role), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 func AuthorizeReadScriptVersion(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		sess, ok := r.Context().Value(AuthenticatedSessionContextKey).(*session.Session)
@@ -73,8 +106,8 @@ func AuthorizeReadScriptVersion(next http.Handler) http.Handler {
 			common.Fail(w, errors.New("No script on request context"), http.StatusInternalServerError)
 			return
 		}
-		scriptVersionID := common.ParseScriptVersion(chi.URLParam(r, "scriptVersionID"))
-		scriptVersion, ok, err := models.GetScriptVersionWithAuthorizationCheck(sess.SpotifyID, script.ID, scriptVersionID)
+		scriptVersionRef := models.ParseScriptVersionRef(chi.URLParam(r, "scriptVersionID"))
+		scriptVersion, ok, err := models.GetScriptVersionWithAuthorizationCheck(sess.SpotifyID, script.ID, scriptVersionRef)
 		if err != nil {
 			common.Fail(w, fmt.Errorf("Cannot check script version authorization: %s", err), http.StatusInternalServerError)
 			return
@@ -88,6 +121,53 @@ func AuthorizeReadScriptVersion(next http.Handler) http.Handler {
 	})
 }
 
+func AuthorizePrivateScriptChainActions(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sess, ok := r.Context().Value(AuthenticatedSessionContextKey).(*session.Session)
+		if !ok {
+			common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
+			return
+		}
+		scriptChain, ok := r.Context().Value(ScriptChainContextKey).(models.ScriptChain)
+		if !ok {
+			common.Fail(w, errors.New("No script chain on request context"), http.StatusInternalServerError)
+			return
+		}
+		if scriptChain.AuthorSpotifyID.String != sess.SpotifyID {
+			common.Fail(w, errors.New("User is not author of script chain"), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func AuthorizeReadScriptChainVersion(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sess, ok := r.Context().Value(AuthenticatedSessionContextKey).(*session.Session)
+		if !ok {
+			common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
+			return
+		}
+		scriptChain, ok := r.Context().Value(ScriptChainContextKey).(models.ScriptChain)
+		if !ok {
+			common.Fail(w, errors.New("No script chain on request context"), http.StatusInternalServerError)
+			return
+		}
+		scriptChainVersionID := common.ParseScriptVersion(chi.URLParam(r, "scriptChainVersionID"))
+		scriptChainVersion, ok, err := models.GetScriptChainVersionWithAuthorizationCheck(sess.SpotifyID, scriptChain.ID, scriptChainVersionID)
+		if err != nil {
+			common.Fail(w, fmt.Errorf("Cannot check script chain version authorization: %s", err), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			common.Fail(w, errors.New("User does not have access to script chain version or script chain version does not exist"), http.StatusForbidden)
+			return
+		}
+		ctx := context.WithValue(r.Context(), ScriptChainVersionContextKey, scriptChainVersion)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
 func AuthorizePremiumSpotifyUser(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		sess, ok := r.Context().Value(SessionContextKey).(*session.Session)
@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/didip/tollbooth"
+	"github.com/go-chi/chi"
+	"github.com/samuelhorwitz/phosphorescence/api/common"
+	"github.com/samuelhorwitz/phosphorescence/api/models"
+	"github.com/samuelhorwitz/phosphorescence/api/session"
+	"github.com/satori/go.uuid"
+)
+
+const CommentTargetContextKey = contextKey("commentTarget")
+const CommentContextKey = contextKey("comment")
+
+func ScriptCommentTarget(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		script, ok := r.Context().Value(ScriptContextKey).(models.Script)
+		if !ok {
+			common.Fail(w, errors.New("No script on request context"), http.StatusInternalServerError)
+			return
+		}
+		ctx := context.WithValue(r.Context(), CommentTargetContextKey, models.ScriptCommentTarget(script))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func ScriptChainCommentTarget(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scriptChain, ok := r.Context().Value(ScriptChainContextKey).(models.ScriptChain)
+		if !ok {
+			common.Fail(w, errors.New("No script chain on request context"), http.StatusInternalServerError)
+			return
+		}
+		ctx := context.WithValue(r.Context(), CommentTargetContextKey, models.ScriptChainCommentTarget(scriptChain))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func AuthorizeReadComment(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target, ok := r.Context().Value(CommentTargetContextKey).(models.CommentTarget)
+		if !ok {
+			common.Fail(w, errors.New("No comment target on request context"), http.StatusInternalServerError)
+			return
+		}
+		commentID, err := uuid.FromString(chi.URLParam(r, "commentID"))
+		if err != nil {
+			common.Fail(w, errors.New("Invalid comment ID"), http.StatusBadRequest)
+			return
+		}
+		comment, ok, err := models.GetComment(target, commentID)
+		if err != nil {
+			common.Fail(w, fmt.Errorf("Cannot get comment: %s", err), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			common.Fail(w, errors.New("Comment does not exist"), http.StatusNotFound)
+			return
+		}
+		ctx := context.WithValue(r.Context(), CommentContextKey, comment)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// CommentLimiter stops anyone posting or editing comments faster than a person
+// reasonably would.
+func CommentLimiter(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sess, ok := r.Context().Value(AuthenticatedSessionContextKey).(*session.Session)
+		if !ok {
+			common.Fail(w, errors.New("No session on request context"), http.StatusUnauthorized)
+			return
+		}
+		lmtErr := tollbooth.LimitByKeys(commentLimiter, []string{sess.SpotifyID})
+		if lmtErr != nil {
+			commentLimiter.ExecOnLimitReached(w, r)
+			common.Fail(w, fmt.Errorf("Comment rate limiting hit: %s", lmtErr.Message), lmtErr.StatusCode)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -17,6 +17,7 @@ var (
 	phosphorLimiter  *limiter.Limiter
 	spotifyLimiter   *limiter.Limiter
 	ipLimiter        *limiter.Limiter
+	commentLimiter   *limiter.Limiter
 	googleHTTPClient *http.Client
 	recaptchaSecret  string
 	phosphorHost     string
@@ -36,6 +37,8 @@ func Initialize(cfg *Config) {
 	phosphorLimiter = tollbooth.NewLimiter(float64(cfg.RateLimitPerSecond), nil)
 	spotifyLimiter = tollbooth.NewLimiter(10, nil)
 	ipLimiter = tollbooth.NewLimiter(float64(cfg.RateLimitPerSecond), nil)
+	// A handful of comments at once, then one every ten seconds.
+	commentLimiter = tollbooth.NewLimiter(0.1, &limiter.ExpirableOptions{DefaultExpirationTTL: time.Hour}).SetBurst(5)
 	googleHTTPClient = &http.Client{
 		Timeout: 10 * time.Second,
 	}
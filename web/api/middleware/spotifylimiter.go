@@ -25,3 +25,10 @@ func SpotifyLimiter(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// SpotifyLimitReached is whether the user has used up their Spotify requests
+// for now. Anything calling Spotify for a user outside of a request, like the
+// party syncer, shares the limit with their requests.
+func SpotifyLimitReached(spotifyID string) bool {
+	return tollbooth.LimitByKeys(spotifyLimiter, []string{spotifyID}) != nil
+}
@@ -0,0 +1,28 @@
+-- Rows are never deleted, retiring a playlist just marks it so we keep a record
+-- of who promoted and retired what. A playlist can be promoted again after it
+-- is retired, which is why the playlist ID alone is not the primary key.
+create table official_playlists (
+	id uuid primary key,
+	playlist_id text not null,
+	title text not null,
+	tags text[] not null default '{}',
+	promoted_by uuid not null references users(id) on update restrict on delete restrict,
+	promoted_at timestamp with time zone not null default now(),
+	retired_by uuid references users(id) on update restrict on delete restrict,
+	retired_at timestamp with time zone
+);
+
+alter table official_playlists add check
+	((retired_by is null and retired_at is null) or
+	(retired_by is not null and retired_at is not null));
+
+create unique index on official_playlists (playlist_id) where retired_at is null;
+create index on official_playlists (promoted_at) where retired_at is null;
+
+grant select on official_playlists to phosphor_api;
+grant insert on official_playlists to phosphor_api;
+grant update (retired_by, retired_at) on official_playlists to phosphor_api;
+
+create view official_playlists_view as select id, playlist_id, title, tags, promoted_by, promoted_at from official_playlists where retired_at is null;
+
+grant select on official_playlists_view to phosphor_api;
@@ -0,0 +1,22 @@
+-- Every playlist we create is owned by the Phosphorescence Spotify account, so
+-- we need a record of them to be able to clean up the ones nobody kept.
+create table created_playlists (
+	playlist_id text primary key, -- spotify IDs are opaque strings
+	creator_id uuid references users(id) on update restrict on delete restrict, -- null when created without a session
+	region text,
+	track_count integer not null,
+	created_at timestamp with time zone not null default now(),
+	followed_at timestamp with time zone,
+	deleted_at timestamp with time zone
+);
+
+create index on created_playlists (creator_id);
+create index on created_playlists (created_at) where deleted_at is null and followed_at is null;
+
+grant select on created_playlists to phosphor_api;
+grant insert on created_playlists to phosphor_api;
+grant update (followed_at, deleted_at) on created_playlists to phosphor_api;
+
+create view created_playlists_view as select playlist_id, creator_id, region, track_count, created_at, followed_at from created_playlists where deleted_at is null;
+
+grant select on created_playlists_view to phosphor_api;
@@ -0,0 +1,48 @@
+-- The listening log is opt in, nothing is recorded for users who haven't
+-- turned it on.
+alter table users add column listening_log boolean not null default false;
+grant update (listening_log) on users to phosphor_api;
+
+create or replace view users_view as select id, spotify_id, name, listening_log from users where deleted_at is null;
+
+-- What generated a playlist, either one of the built in builders or a script
+-- version. Both are null for playlists created before we tracked this.
+alter table created_playlists add column builder text;
+alter table created_playlists add column script_id uuid;
+alter table created_playlists add column script_version_created_at timestamp with time zone;
+
+alter table created_playlists add check
+	((script_id is null and script_version_created_at is null) or
+	(script_id is not null and script_version_created_at is not null));
+
+alter table created_playlists add foreign key (script_id, script_version_created_at)
+	references script_versions(script_id, created_at) on update restrict on delete restrict;
+
+create index on created_playlists (script_id);
+
+create or replace view created_playlists_view as
+select playlist_id, creator_id, region, track_count, created_at, followed_at, builder, script_id, script_version_created_at
+from created_playlists
+where deleted_at is null;
+
+create table listening_sessions (
+	id uuid primary key,
+	listener_id uuid not null references users(id) on update restrict on delete restrict,
+	playlist_id text not null references created_playlists(playlist_id) on update restrict on delete restrict,
+	started_at timestamp with time zone not null default now(),
+	last_seen_at timestamp with time zone not null default now(),
+	track_ids text[] not null default '{}', -- in the order they were reached, may repeat
+	skips jsonb not null default '[]', -- [{"trackId": "...", "position": ms}]
+	completion real not null default 0 check (completion >= 0 and completion <= 1)
+);
+
+create index on listening_sessions (listener_id);
+create index on listening_sessions (playlist_id);
+
+grant select on listening_sessions to phosphor_api;
+grant insert on listening_sessions to phosphor_api;
+grant update (last_seen_at, track_ids, skips, completion) on listening_sessions to phosphor_api;
+
+create view listening_sessions_view as select id, listener_id, playlist_id, started_at, last_seen_at, track_ids, skips, completion from listening_sessions;
+
+grant select on listening_sessions_view to phosphor_api;
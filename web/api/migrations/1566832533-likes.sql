@@ -0,0 +1,16 @@
+alter table script_likes add column created_at timestamp with time zone not null default now();
+alter table script_chain_likes add column created_at timestamp with time zone not null default now();
+
+create index on script_likes (liker_id, created_at);
+create index on script_chain_likes (liker_id, created_at);
+
+-- Counts are read live rather than from searchables so a like shows up straight
+-- away instead of after the next refresh.
+grant select on script_likes to phosphor_api;
+grant select on script_chain_likes to phosphor_api;
+
+create view script_likes_view as select script_id, liker_id, created_at from script_likes;
+create view script_chain_likes_view as select script_chain_id, liker_id, created_at from script_chain_likes;
+
+grant select on script_likes_view to phosphor_api;
+grant select on script_chain_likes_view to phosphor_api;
@@ -0,0 +1,66 @@
+-- Chain versions are drafts or publishes like script versions, and every script
+-- in a chain is pinned to a published version of that script so a chain keeps
+-- doing the same thing when the scripts in it change.
+alter table script_chain_versions add column type version_type not null default 'draft';
+alter table script_chain_versions alter column type drop default;
+
+-- Existing chain versions are pinned to whatever was most recently published
+-- when they were saved, or failing that the most recent version of any kind,
+-- or failing that the first version.
+create function pg_temp.pinned_script_version(script_id uuid, saved_at timestamp with time zone) returns timestamp with time zone as $$
+	select coalesce(
+		(select max(created_at) from script_versions where script_versions.script_id = $1 and created_at <= $2 and type = 'published'),
+		(select max(created_at) from script_versions where script_versions.script_id = $1 and created_at <= $2),
+		(select min(created_at) from script_versions where script_versions.script_id = $1))
+$$ language sql stable;
+
+alter table script_chain_versions add column seeder_version_created_at timestamp with time zone;
+alter table script_chain_versions add column builder_version_created_at timestamp with time zone;
+update script_chain_versions set
+	seeder_version_created_at = case when seeder_id is not null then pg_temp.pinned_script_version(seeder_id, created_at) end,
+	builder_version_created_at = pg_temp.pinned_script_version(builder_id, created_at);
+alter table script_chain_versions alter column builder_version_created_at set not null;
+
+alter table script_chain_versions add check
+	((seeder_id is null and seeder_version_created_at is null) or
+	(seeder_id is not null and seeder_version_created_at is not null));
+
+alter table script_chain_versions add foreign key (seeder_id, seeder_version_created_at)
+	references script_versions(script_id, created_at) on update restrict on delete restrict;
+alter table script_chain_versions add foreign key (builder_id, builder_version_created_at)
+	references script_versions(script_id, created_at) on update restrict on delete restrict;
+
+alter table script_chain_pruners add column pruner_version_created_at timestamp with time zone;
+update script_chain_pruners set pruner_version_created_at = pg_temp.pinned_script_version(pruner_id, created_at);
+alter table script_chain_pruners alter column pruner_version_created_at set not null;
+
+alter table script_chain_pruners add foreign key (pruner_id, pruner_version_created_at)
+	references script_versions(script_id, created_at) on update restrict on delete restrict;
+
+-- The same pruner may run more than once in a chain.
+alter table script_chain_pruners drop constraint script_chain_pruners_pkey;
+alter table script_chain_pruners add primary key (script_chain_id, created_at, execution_order);
+
+create or replace view script_chain_versions_view as
+select
+	script_chain_versions.script_chain_id,
+	script_chain_versions.created_at,
+	script_chain_versions.seeder_id,
+	script_chain_versions.builder_id,
+	script_chain_pruners.pruner_ids,
+	script_chain_versions.type,
+	script_chain_versions.seeder_version_created_at,
+	script_chain_versions.builder_version_created_at,
+	coalesce(script_chain_pruners.pruners, '[]') as pruners
+from script_chain_versions
+left join (
+	select
+		script_chain_id,
+		created_at,
+		array_agg(pruner_id order by execution_order asc) as pruner_ids,
+		jsonb_agg(jsonb_build_object('scriptId', pruner_id, 'version', pruner_version_created_at) order by execution_order asc) as pruners
+	from script_chain_pruners
+	group by script_chain_id, created_at
+) script_chain_pruners on script_chain_versions.script_chain_id = script_chain_pruners.script_chain_id
+	and script_chain_versions.created_at = script_chain_pruners.created_at
+where script_chain_versions.deleted_at is null;
@@ -0,0 +1,19 @@
+-- scripts_view leaves out deleted scripts, which would cut a lineage in two
+-- wherever one was deleted. This keeps them so they can be shown redacted.
+create view script_lineage_view as
+select
+	scripts.id,
+	scripts.author_id,
+	scripts.is_private,
+	scripts.forked_from_script_id,
+	scripts.forked_from_script_version_created_at,
+	scripts.deleted_at is not null as is_deleted,
+	script_versions.created_at
+from scripts
+join (
+	select script_id, min(created_at) as created_at
+	from script_versions
+	group by script_id
+) script_versions on scripts.id = script_versions.script_id;
+
+grant select on script_lineage_view to phosphor_api;
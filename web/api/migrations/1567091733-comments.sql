@@ -0,0 +1,66 @@
+-- A comment is on exactly one script or script chain. Replies point at the
+-- comment they reply to, which is on the same script or chain.
+create table comments (
+	id uuid primary key,
+	author_id uuid not null references users(id) on update restrict on delete restrict,
+	script_id uuid references scripts(id) on update restrict on delete restrict,
+	script_chain_id uuid references script_chains(id) on update restrict on delete restrict,
+	parent_id uuid references comments(id) on update restrict on delete restrict,
+	created_at timestamp with time zone not null default now(),
+	deleted_at timestamp with time zone,
+	-- Set when the script or chain's author removed the comment rather than
+	-- whoever wrote it.
+	deleted_by_moderator boolean not null default false,
+	check ((script_id is null) <> (script_chain_id is null))
+);
+
+create index on comments (script_id, created_at);
+create index on comments (script_chain_id, created_at);
+create index on comments (parent_id, created_at);
+create index on comments (author_id, created_at);
+
+-- Every edit is kept, the most recent is the comment's body.
+create table comment_revisions (
+	comment_id uuid not null references comments(id) on update restrict on delete restrict,
+	created_at timestamp with time zone not null default now(),
+	body text not null,
+	primary key (comment_id, created_at)
+);
+
+grant select on comments to phosphor_api;
+grant insert on comments to phosphor_api;
+grant update (deleted_at, deleted_by_moderator) on comments to phosphor_api;
+grant select on comment_revisions to phosphor_api;
+grant insert on comment_revisions to phosphor_api;
+
+-- Deleted comments stay in the view without their body so their replies still
+-- have somewhere to hang.
+create view comments_view as
+select
+	comments.id,
+	comments.author_id,
+	comments.script_id,
+	comments.script_chain_id,
+	comments.parent_id,
+	comments.created_at,
+	comments.deleted_at is not null as is_deleted,
+	comments.deleted_by_moderator,
+	case when comments.deleted_at is null then revisions.body end as body,
+	nullif(revisions.created_at, comments.created_at) as edited_at
+from comments
+join lateral (
+	select body, created_at
+	from comment_revisions
+	where comment_revisions.comment_id = comments.id
+	order by created_at desc
+	limit 1
+) revisions on true;
+
+create view comment_revisions_view as
+select comment_revisions.comment_id, comment_revisions.created_at, comment_revisions.body
+from comment_revisions
+join comments on comments.id = comment_revisions.comment_id
+where comments.deleted_at is null;
+
+grant select on comments_view to phosphor_api;
+grant select on comment_revisions_view to phosphor_api;
@@ -0,0 +1,125 @@
+-- A hidden script is private to everyone but its author, whatever the author
+-- sets it to, until an admin restores it.
+alter table scripts add column hidden_at timestamp with time zone;
+
+grant update (hidden_at) on scripts to phosphor_api;
+
+create or replace view scripts_view as
+select
+	scripts.id,
+	scripts.author_id,
+	scripts.forked_from_script_id,
+	scripts.is_private or scripts.hidden_at is not null as is_private,
+	scripts.name,
+	scripts.forked_from_script_version_created_at,
+	script_versions.created_at,
+	scripts.description,
+	scripts.hidden_at is not null as is_hidden
+from scripts
+join (
+	select script_id, min(created_at) as created_at
+	from script_versions_view
+	group by script_id
+) script_versions on scripts.id = script_versions.script_id
+where scripts.deleted_at is null;
+
+create or replace view script_lineage_view as
+select
+	scripts.id,
+	scripts.author_id,
+	scripts.is_private or scripts.hidden_at is not null as is_private,
+	scripts.forked_from_script_id,
+	scripts.forked_from_script_version_created_at,
+	scripts.deleted_at is not null as is_deleted,
+	script_versions.created_at
+from scripts
+join (
+	select script_id, min(created_at) as created_at
+	from script_versions
+	group by script_id
+) script_versions on scripts.id = script_versions.script_id;
+
+create type report_reason as enum (
+	'spam',
+	'offensive',
+	'malicious',
+	'copyright',
+	'other'
+);
+
+-- A report is on a script or a comment.
+create table reports (
+	id uuid primary key,
+	reporter_id uuid not null references users(id) on update restrict on delete restrict,
+	script_id uuid references scripts(id) on update restrict on delete restrict,
+	comment_id uuid references comments(id) on update restrict on delete restrict,
+	reason report_reason not null,
+	details text,
+	created_at timestamp with time zone not null default now(),
+	resolved_at timestamp with time zone,
+	check ((script_id is null) <> (comment_id is null))
+);
+
+create index on reports (created_at) where resolved_at is null;
+-- Reporting the same thing again before anyone has looked at it does nothing.
+create unique index on reports (reporter_id, script_id) where resolved_at is null and script_id is not null;
+create unique index on reports (reporter_id, comment_id) where resolved_at is null and comment_id is not null;
+
+grant select on reports to phosphor_api;
+grant insert on reports to phosphor_api;
+grant update (resolved_at) on reports to phosphor_api;
+
+create type moderation_action as enum (
+	'hide_script',
+	'restore_script',
+	'remove_comment',
+	'dismiss_report'
+);
+
+-- Every moderation action, never updated or deleted.
+create table moderation_log (
+	id uuid primary key,
+	moderator_id uuid not null references users(id) on update restrict on delete restrict,
+	action moderation_action not null,
+	script_id uuid references scripts(id) on update restrict on delete restrict,
+	comment_id uuid references comments(id) on update restrict on delete restrict,
+	report_id uuid references reports(id) on update restrict on delete restrict,
+	reason text,
+	created_at timestamp with time zone not null default now()
+);
+
+create index on moderation_log (created_at);
+
+grant select on moderation_log to phosphor_api;
+grant insert on moderation_log to phosphor_api;
+
+create type notification_type as enum (
+	'script_hidden',
+	'script_restored',
+	'comment_removed'
+);
+
+create table notifications (
+	id uuid primary key,
+	user_id uuid not null references users(id) on update restrict on delete restrict,
+	type notification_type not null,
+	script_id uuid references scripts(id) on update restrict on delete restrict,
+	comment_id uuid references comments(id) on update restrict on delete restrict,
+	message text,
+	created_at timestamp with time zone not null default now(),
+	read_at timestamp with time zone
+);
+
+create index on notifications (user_id, created_at);
+
+grant select on notifications to phosphor_api;
+grant insert on notifications to phosphor_api;
+grant update (read_at) on notifications to phosphor_api;
+
+create view reports_view as select id, reporter_id, script_id, comment_id, reason, details, created_at, resolved_at from reports;
+create view moderation_log_view as select id, moderator_id, action, script_id, comment_id, report_id, reason, created_at from moderation_log;
+create view notifications_view as select id, user_id, type, script_id, comment_id, message, created_at, read_at from notifications;
+
+grant select on reports_view to phosphor_api;
+grant select on moderation_log_view to phosphor_api;
+grant select on notifications_view to phosphor_api;
@@ -0,0 +1,28 @@
+-- A release names a published script version so it can be linked to and
+-- pinned by something easier to read than a timestamp.
+create table script_releases (
+	script_id uuid not null,
+	version_created_at timestamp with time zone not null,
+	name text not null,
+	changelog text,
+	created_at timestamp with time zone not null default now(),
+	primary key (script_id, name),
+	unique (script_id, version_created_at),
+	foreign key (script_id, version_created_at)
+		references script_versions(script_id, created_at) on update restrict on delete restrict
+);
+
+grant select on script_releases to phosphor_api;
+grant insert on script_releases to phosphor_api;
+grant delete on script_releases to phosphor_api;
+
+-- Deleting a version deletes its release in the same transaction, so every
+-- name in script_releases belongs to a version that still exists. Reads still
+-- go through the view to be sure.
+create view script_releases_view as
+select script_releases.script_id, script_releases.version_created_at, script_releases.name, script_releases.changelog, script_releases.created_at
+from script_releases
+join script_versions_view script_versions on script_versions.script_id = script_releases.script_id
+	and script_versions.created_at = script_releases.version_created_at;
+
+grant select on script_releases_view to phosphor_api;
@@ -0,0 +1,37 @@
+-- The author of a script owns it. Collaborators are invited by the owner and
+-- can do more than the public once they accept: viewers can read drafts,
+-- editors can also save and publish.
+create type collaborator_role as enum (
+	'editor',
+	'viewer'
+);
+
+create table script_collaborators (
+	id uuid primary key,
+	script_id uuid not null references scripts(id) on update restrict on delete restrict,
+	user_id uuid not null references users(id) on update restrict on delete restrict,
+	role collaborator_role not null,
+	invited_by uuid not null references users(id) on update restrict on delete restrict,
+	created_at timestamp with time zone not null default now(),
+	accepted_at timestamp with time zone,
+	revoked_at timestamp with time zone
+);
+
+-- Revoked invites are kept, but there is only ever one live one per user.
+create unique index on script_collaborators (script_id, user_id) where revoked_at is null;
+create index on script_collaborators (user_id, created_at);
+
+grant select on script_collaborators to phosphor_api;
+grant insert on script_collaborators to phosphor_api;
+grant update (role, accepted_at, revoked_at) on script_collaborators to phosphor_api;
+
+create view script_collaborators_view as select id, script_id, user_id, role, invited_by, created_at, accepted_at from script_collaborators where revoked_at is null;
+
+grant select on script_collaborators_view to phosphor_api;
+
+-- Now that more than the author can save, each version records who did.
+alter table script_versions add column saved_by uuid references users(id) on update restrict on delete restrict;
+update script_versions set saved_by = scripts.author_id from scripts where scripts.id = script_versions.script_id;
+alter table script_versions alter column saved_by set not null;
+
+create or replace view script_versions_view as select script_id, created_at, type, file_id, saved_by from script_versions where deleted_at is null;
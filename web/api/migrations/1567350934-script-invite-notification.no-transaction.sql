@@ -0,0 +1 @@
+alter type notification_type add value 'script_invite';
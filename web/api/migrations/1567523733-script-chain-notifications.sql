@@ -0,0 +1,9 @@
+-- Comments on script chains have no script, notifications about them point at
+-- the chain instead.
+alter table notifications add column script_chain_id uuid references script_chains(id) on update restrict on delete restrict;
+
+update notifications set script_chain_id = comments.script_chain_id
+from comments
+where comments.id = notifications.comment_id and notifications.script_id is null;
+
+create or replace view notifications_view as select id, user_id, type, script_id, comment_id, message, created_at, read_at, script_chain_id from notifications;
@@ -0,0 +1,94 @@
+package models
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/samuelhorwitz/phosphorescence/api/setanalysis"
+	"github.com/samuelhorwitz/phosphorescence/api/spotifyclient"
+)
+
+// AnalyzeTracks analyzes the flow of an ordered list of tracks. Tracks we
+// can't find or get audio features for are left out of the analysis, positions
+// in it are still into trackIDs.
+func AnalyzeTracks(ctx context.Context, region string, trackIDs []string) (setanalysis.Analysis, error) {
+	if len(trackIDs) > maxTracksPerRequest {
+		return setanalysis.Analysis{}, ErrTooManyTracks
+	}
+	phosphorescenceToken, err := spotifyclient.GetAppToken()
+	if err != nil {
+		return setanalysis.Analysis{}, fmt.Errorf("Could not get Spotify application token: %s", err)
+	}
+	tracks, err := getTracks(ctx, phosphorescenceToken, region, trackIDs)
+	if err != nil {
+		return setanalysis.Analysis{}, fmt.Errorf("Could not get tracks: %s", err)
+	}
+	return analyzeTracks(trackIDs, tracks), nil
+}
+
+// AnalyzePlaylist is AnalyzeTracks for a playlist, positions are into the
+// playlist as Spotify has it.
+func AnalyzePlaylist(ctx context.Context, region, playlistID string) (setanalysis.Analysis, error) {
+	phosphorescenceToken, err := spotifyclient.GetAppToken()
+	if err != nil {
+		return setanalysis.Analysis{}, fmt.Errorf("Could not get Spotify application token: %s", err)
+	}
+	playlist, err := getPlaylist(ctx, phosphorescenceToken, region, playlistID)
+	if err != nil {
+		if err == ErrTooManyTracks {
+			return setanalysis.Analysis{}, err
+		}
+		return setanalysis.Analysis{}, fmt.Errorf("Could not get playlist: %s", err)
+	}
+	return analyzeTracks(playlist.trackOrder, playlist.Tracks), nil
+}
+
+// analyzeTracks analyzes tracks, which are some of the tracks in order in the
+// same order, then points the analysis back at where they are in order.
+func analyzeTracks(order []string, tracks []*SpotifyTrackEnvelope) setanalysis.Analysis {
+	analysisTracks, positions := toAnalysisTracks(order, tracks)
+	analysis := setanalysis.Analyze(analysisTracks)
+	for _, transitions := range [][]setanalysis.Transition{analysis.Transitions, analysis.WorstTransitions} {
+		for i := range transitions {
+			transitions[i].From = positions[transitions[i].From]
+			transitions[i].To = positions[transitions[i].To]
+		}
+	}
+	for i := range analysis.ArtistRepeats {
+		analysis.ArtistRepeats[i].First = positions[analysis.ArtistRepeats[i].First]
+		analysis.ArtistRepeats[i].Second = positions[analysis.ArtistRepeats[i].Second]
+	}
+	return analysis
+}
+
+// toAnalysisTracks converts tracks, positions is where each of them is in
+// order. The same track can be in order more than once, each is matched to the
+// first occurrence after the previous track's.
+func toAnalysisTracks(order []string, tracks []*SpotifyTrackEnvelope) (analysisTracks []setanalysis.Track, positions []int) {
+	next := 0
+	for _, track := range tracks {
+		position := next
+		for position < len(order) && order[position] != track.OriginalID() {
+			position++
+		}
+		if position == len(order) || track.Track == nil || track.Features == nil {
+			continue
+		}
+		var artists []setanalysis.Artist
+		for _, artist := range track.Track.Artists {
+			artists = append(artists, setanalysis.Artist{ID: artist.ID, Name: artist.Name})
+		}
+		analysisTracks = append(analysisTracks, setanalysis.Track{
+			ID:      track.OriginalID(),
+			Artists: artists,
+			Tempo:   track.Features.Tempo,
+			Key:     track.Features.Key,
+			Mode:    track.Features.Mode,
+			Energy:  track.Features.Energy,
+			Valence: track.Features.Valence,
+		})
+		positions = append(positions, position)
+		next = position + 1
+	}
+	return analysisTracks, positions
+}
@@ -0,0 +1,70 @@
+package models
+
+import "testing"
+
+func testAnalysisTrack(id, artistID string, features bool) *SpotifyTrackEnvelope {
+	track := &SpotifyTrackEnvelope{
+		ID:    id,
+		Track: &SpotifyTrack{ID: id, IsPlayable: true, Artists: []SpotifyArtist{{ID: artistID, Name: artistID}}},
+	}
+	if features {
+		track.Features = &SpotifyFeatures{ID: id, Tempo: 120, Key: 0, Mode: 1}
+	}
+	return track
+}
+
+func Test_analyzeTracks(t *testing.T) {
+	// b can't be found and c has no features, a is in the set twice.
+	trackIDs := []string{"a", "b", "c", "d", "a"}
+	found := map[string]*SpotifyTrackEnvelope{
+		"a": testAnalysisTrack("a", "artist1", true),
+		"c": testAnalysisTrack("c", "artist2", false),
+		"d": testAnalysisTrack("d", "artist2", true),
+	}
+	analysis := analyzeTracks(trackIDs, withFeatures(orderTracks(trackIDs, found)))
+	expectedTransitions := [][2]int{{0, 3}, {3, 4}}
+	if len(analysis.Transitions) != len(expectedTransitions) {
+		t.Fatalf("Expected %d transitions, got %d", len(expectedTransitions), len(analysis.Transitions))
+	}
+	for i, expected := range expectedTransitions {
+		if transition := analysis.Transitions[i]; transition.From != expected[0] || transition.To != expected[1] {
+			t.Errorf("Expected transition %d to be %d -> %d, got %d -> %d", i, expected[0], expected[1], transition.From, transition.To)
+		}
+	}
+	for _, transition := range analysis.WorstTransitions {
+		if transition.From != 0 && transition.From != 3 {
+			t.Errorf("Expected worst transition to start at a requested position, got %d", transition.From)
+		}
+	}
+	if len(analysis.ArtistRepeats) != 1 {
+		t.Fatalf("Expected 1 artist repeat, got %d", len(analysis.ArtistRepeats))
+	}
+	if repeat := analysis.ArtistRepeats[0]; repeat.First != 0 || repeat.Second != 4 {
+		t.Errorf("Expected artist repeat at 0 and 4, got %d and %d", repeat.First, repeat.Second)
+	}
+}
+
+func Test_analyzeTracksPlaylist(t *testing.T) {
+	unplayable := testAnalysisTrack("b", "artist2", true).Track
+	unplayable.IsPlayable = false
+	items := []SpotifyPlaylistTrack{
+		{Track: *testAnalysisTrack("a", "artist1", false).Track},
+		{IsLocal: true},
+		{Track: *unplayable},
+		{Track: *testAnalysisTrack("c", "artist1", false).Track},
+	}
+	tracks, order := playlistPageTracks(items, "US")
+	if len(order) != len(items) {
+		t.Fatalf("Expected every item in the order, got %d", len(order))
+	}
+	for _, track := range tracks {
+		track.Features = &SpotifyFeatures{ID: track.ID, Tempo: 120, Mode: 1}
+	}
+	analysis := analyzeTracks(order, withFeatures(tracks))
+	if len(analysis.Transitions) != 1 {
+		t.Fatalf("Expected 1 transition, got %d", len(analysis.Transitions))
+	}
+	if transition := analysis.Transitions[0]; transition.From != 0 || transition.To != 3 {
+		t.Errorf("Expected transition to be 0 -> 3, got %d -> %d", transition.From, transition.To)
+	}
+}
@@ -0,0 +1,245 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/samuelhorwitz/phosphorescence/api/common"
+	"github.com/satori/go.uuid"
+)
+
+// ScriptRole is what a user may do with a script beyond what the public can.
+// Each role can do everything the ones below it can.
+type ScriptRole string
+
+const (
+	ScriptRoleNone   ScriptRole = ""
+	ScriptRoleViewer ScriptRole = "viewer"
+	ScriptRoleEditor ScriptRole = "editor"
+	ScriptRoleOwner  ScriptRole = "owner"
+)
+
+var scriptRoleRanks = map[ScriptRole]int{
+	ScriptRoleNone:   0,
+	ScriptRoleViewer: 1,
+	ScriptRoleEditor: 2,
+	ScriptRoleOwner:  3,
+}
+
+// The role column is the role the user was invited as, whether or not they
+// have accepted; scriptRoleOf only counts it once the condition also holds.
+// Only collaborators who have accepted their invite can see private scripts.
+const (
+	scriptCollaboratorRoleColumn = "coalesce((select collaborators.role::text from script_collaborators_view collaborators join users_view collaborator_users on collaborator_users.id = collaborators.user_id where collaborators.script_id = scripts.id and collaborator_users.spotify_id = ?), '')"
+	scriptCollaboratorCondition  = "exists(select 1 from script_collaborators_view collaborators join users_view collaborator_users on collaborator_users.id = collaborators.user_id where collaborators.script_id = scripts.id and collaborators.accepted_at is not null and collaborator_users.spotify_id = ?)"
+)
+
+var (
+	ErrCollaboratorDoesNotExist = errors.New("Collaborator or invite does not exist")
+	ErrInviteeDoesNotExist      = errors.New("User does not exist")
+	ErrInviteeIsOwner           = errors.New("Cannot invite the owner of the script")
+)
+
+type ScriptCollaborator struct {
+	ScriptID           uuid.UUID  `json:"scriptId"`
+	ScriptName         nullString `json:"scriptName"`
+	SpotifyID          string     `json:"spotifyId"`
+	Name               nullString `json:"name"`
+	Role               ScriptRole `json:"role"`
+	InvitedBySpotifyID string     `json:"invitedBySpotifyId"`
+	CreatedAt          time.Time  `json:"createdAt"`
+	AcceptedAt         nullTime   `json:"acceptedAt"`
+}
+
+// Can is whether the role is allowed to do what the required role can.
+func (role ScriptRole) Can(required ScriptRole) bool {
+	return scriptRoleRanks[role] >= scriptRoleRanks[required]
+}
+
+// scriptRoleOf is the role the user has on the script. Authors own their
+// scripts, and invites only count once they are accepted.
+func scriptRoleOf(script Script, spotifyUserID string, invitedRole ScriptRole, accepted bool) ScriptRole {
+	if script.AuthorSpotifyID.Valid && script.AuthorSpotifyID.String == spotifyUserID {
+		return ScriptRoleOwner
+	}
+	if !accepted || !ValidCollaboratorRole(invitedRole) {
+		return ScriptRoleNone
+	}
+	return invitedRole
+}
+
+// ValidCollaboratorRole is whether someone can be invited as the role. Scripts
+// only ever have the one owner.
+func ValidCollaboratorRole(role ScriptRole) bool {
+	return role == ScriptRoleEditor || role == ScriptRoleViewer
+}
+
+// InviteScriptCollaborator invites the user to collaborate on the script, or
+// changes the role of someone already invited.
+func InviteScriptCollaborator(script Script, spotifyUserID string, role ScriptRole) (ScriptCollaborator, error) {
+	if !ValidCollaboratorRole(role) {
+		return ScriptCollaborator{}, fmt.Errorf("Invalid collaborator role %s", role)
+	}
+	tx, err := postgresDB.Begin()
+	if err != nil {
+		return ScriptCollaborator{}, fmt.Errorf("Could not start transaction: %s", err)
+	}
+	var userID uuid.UUID
+	err = psql.Select("id").
+		From("users_view").
+		Where(sq.Eq{"spotify_id": spotifyUserID}).
+		RunWith(tx).QueryRow().Scan(&userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ScriptCollaborator{}, common.TryToRollback(tx, ErrInviteeDoesNotExist)
+		}
+		return ScriptCollaborator{}, common.TryToRollback(tx, fmt.Errorf("Could not get invitee: %s", err))
+	}
+	if uuid.Equal(userID, script.AuthorID) {
+		return ScriptCollaborator{}, common.TryToRollback(tx, ErrInviteeIsOwner)
+	}
+	res, err := psql.Update("script_collaborators").
+		Set("role", role).
+		Where(sq.Eq{
+			"script_id":  script.ID,
+			"user_id":    userID,
+			"revoked_at": nil,
+		}).
+		RunWith(tx).Exec()
+	if err != nil {
+		return ScriptCollaborator{}, common.TryToRollback(tx, fmt.Errorf("Could not update collaborator role: %s", err))
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return ScriptCollaborator{}, common.TryToRollback(tx, fmt.Errorf("Could not get updated collaborator count: %s", err))
+	}
+	if updated == 0 {
+		_, err = psql.Insert("script_collaborators").
+			Columns("id", "script_id", "user_id", "role", "invited_by").
+			Values(uuid.NewV4(), script.ID, userID, role, script.AuthorID).
+			RunWith(tx).Exec()
+		if err != nil {
+			return ScriptCollaborator{}, common.TryToRollback(tx, fmt.Errorf("Could not insert invite: %s", err))
+		}
+		err = createNotification(tx, userID, notificationScriptInvite, uuid.NullUUID{UUID: script.ID, Valid: true}, uuid.NullUUID{}, uuid.NullUUID{}, string(role))
+		if err != nil {
+			return ScriptCollaborator{}, common.TryToRollback(tx, err)
+		}
+	}
+	collaborator, err := scanScriptCollaborator(scriptCollaboratorsQuery().
+		Where(sq.Eq{"collaborators.script_id": script.ID, "collaborators.user_id": userID}).
+		RunWith(tx).QueryRow())
+	if err != nil {
+		return ScriptCollaborator{}, common.TryToRollback(tx, fmt.Errorf("Could not get collaborator: %s", err))
+	}
+	if err = tx.Commit(); err != nil {
+		return ScriptCollaborator{}, common.TryToRollback(tx, fmt.Errorf("Could not commit: %s", err))
+	}
+	return collaborator, nil
+}
+
+// AcceptScriptInvite gives the user the role they were invited as.
+func AcceptScriptInvite(spotifyUserID string, scriptID uuid.UUID) error {
+	res, err := postgresDB.Exec(`
+		update script_collaborators set accepted_at = now()
+		from users_view users
+		where users.id = script_collaborators.user_id and users.spotify_id = $1 and script_collaborators.script_id = $2
+			and script_collaborators.revoked_at is null and script_collaborators.accepted_at is null`, spotifyUserID, scriptID)
+	if err != nil {
+		return fmt.Errorf("Could not accept invite: %s", err)
+	}
+	return expectOneCollaborator(res)
+}
+
+// RevokeScriptCollaborator takes away the user's role, or their invite if they
+// have not accepted it yet. Owners revoke, collaborators decline or leave.
+func RevokeScriptCollaborator(scriptID uuid.UUID, spotifyUserID string) error {
+	res, err := postgresDB.Exec(`
+		update script_collaborators set revoked_at = now()
+		from users_view users
+		where users.id = script_collaborators.user_id and users.spotify_id = $1 and script_collaborators.script_id = $2
+			and script_collaborators.revoked_at is null`, spotifyUserID, scriptID)
+	if err != nil {
+		return fmt.Errorf("Could not revoke collaborator: %s", err)
+	}
+	return expectOneCollaborator(res)
+}
+
+// GetScriptCollaborators gets everyone invited to the script, whether or not
+// they have accepted.
+func GetScriptCollaborators(scriptID uuid.UUID) ([]ScriptCollaborator, error) {
+	return getScriptCollaborators(sq.Eq{"collaborators.script_id": scriptID})
+}
+
+// GetScriptInvites gets the scripts the user has been invited to, including
+// those they have already accepted.
+func GetScriptInvites(spotifyUserID string) ([]ScriptCollaborator, error) {
+	return getScriptCollaborators(sq.Eq{"users.spotify_id": spotifyUserID})
+}
+
+func getScriptCollaborators(where sq.Sqlizer) (collaborators []ScriptCollaborator, err error) {
+	rows, err := scriptCollaboratorsQuery().
+		Where(where).
+		OrderBy("collaborators.created_at desc").
+		RunWith(postgresDB).Query()
+	if err != nil {
+		return nil, fmt.Errorf("Could not get collaborators from DB: %s", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		collaborator, err := scanScriptCollaborator(rows)
+		if err != nil {
+			return nil, fmt.Errorf("Could not scan row: %s", err)
+		}
+		collaborators = append(collaborators, collaborator)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("Error after scanning rows: %s", err)
+	}
+	return collaborators, nil
+}
+
+func scriptCollaboratorsQuery() sq.SelectBuilder {
+	return psql.Select(
+		"collaborators.script_id",
+		"scripts.name",
+		"users.spotify_id",
+		"users.name",
+		"collaborators.role",
+		"inviters.spotify_id",
+		"collaborators.created_at",
+		"collaborators.accepted_at").
+		From("script_collaborators_view as collaborators").
+		Join("scripts_view scripts on scripts.id = collaborators.script_id").
+		Join("users_view users on users.id = collaborators.user_id").
+		Join("users_view inviters on inviters.id = collaborators.invited_by")
+}
+
+func scanScriptCollaborator(row sq.RowScanner) (ScriptCollaborator, error) {
+	var collaborator ScriptCollaborator
+	err := row.Scan(
+		&collaborator.ScriptID,
+		&collaborator.ScriptName,
+		&collaborator.SpotifyID,
+		&collaborator.Name,
+		&collaborator.Role,
+		&collaborator.InvitedBySpotifyID,
+		&collaborator.CreatedAt,
+		&collaborator.AcceptedAt)
+	return collaborator, err
+}
+
+func expectOneCollaborator(res sql.Result) error {
+	changed, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("Could not get changed collaborator count: %s", err)
+	}
+	if changed == 0 {
+		return ErrCollaboratorDoesNotExist
+	}
+	return nil
+}
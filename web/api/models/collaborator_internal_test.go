@@ -0,0 +1,56 @@
+package models
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func Test_ScriptRole_Can(t *testing.T) {
+	roles := []ScriptRole{ScriptRoleNone, ScriptRoleViewer, ScriptRoleEditor, ScriptRoleOwner}
+	for i, role := range roles {
+		for j, required := range roles {
+			if expected, actual := i >= j, role.Can(required); expected != actual {
+				t.Errorf("Expected %q can %q to be %t", role, required, expected)
+			}
+		}
+	}
+	if ScriptRole("admin").Can(ScriptRoleViewer) {
+		t.Error("Expected unknown roles to be unable to do anything")
+	}
+}
+
+func Test_ValidCollaboratorRole(t *testing.T) {
+	for role, expected := range map[ScriptRole]bool{
+		ScriptRoleNone:   false,
+		ScriptRoleViewer: true,
+		ScriptRoleEditor: true,
+		ScriptRoleOwner:  false,
+	} {
+		if actual := ValidCollaboratorRole(role); actual != expected {
+			t.Errorf("Expected %q to be valid %t", role, expected)
+		}
+	}
+}
+
+func Test_scriptRoleOf(t *testing.T) {
+	script := Script{AuthorSpotifyID: nullString{sql.NullString{String: "author", Valid: true}}}
+	tests := []struct {
+		spotifyUserID string
+		invitedRole   ScriptRole
+		accepted      bool
+		expected      ScriptRole
+	}{
+		{"author", ScriptRoleNone, false, ScriptRoleOwner},
+		{"invitee", ScriptRoleEditor, false, ScriptRoleNone},
+		{"invitee", ScriptRoleViewer, false, ScriptRoleNone},
+		{"invitee", ScriptRoleEditor, true, ScriptRoleEditor},
+		{"invitee", ScriptRoleViewer, true, ScriptRoleViewer},
+		{"invitee", ScriptRoleOwner, true, ScriptRoleNone},
+		{"stranger", ScriptRoleNone, false, ScriptRoleNone},
+	}
+	for _, test := range tests {
+		if actual := scriptRoleOf(script, test.spotifyUserID, test.invitedRole, test.accepted); actual != test.expected {
+			t.Errorf("Expected %s invited as %q (accepted %t) to be %q, got %q", test.spotifyUserID, test.invitedRole, test.accepted, test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/samuelhorwitz/phosphorescence/api/common"
+	"github.com/satori/go.uuid"
+)
+
+const (
+	commentDeletedByAuthor    = "author"
+	commentDeletedByModerator = "moderator"
+)
+
+// CommentTarget is the script or script chain a comment is on. Its author
+// moderates the comments on it.
+type CommentTarget struct {
+	Type            resultType
+	ID              uuid.UUID
+	AuthorSpotifyID string
+	IsPrivate       bool
+}
+
+func ScriptCommentTarget(script Script) CommentTarget {
+	return CommentTarget{
+		Type:            scriptResultType,
+		ID:              script.ID,
+		AuthorSpotifyID: script.AuthorSpotifyID.String,
+		IsPrivate:       script.IsPrivate,
+	}
+}
+
+func ScriptChainCommentTarget(scriptChain ScriptChain) CommentTarget {
+	return CommentTarget{
+		Type:            scriptChainResultType,
+		ID:              scriptChain.ID,
+		AuthorSpotifyID: scriptChain.AuthorSpotifyID.String,
+		IsPrivate:       scriptChain.IsPrivate,
+	}
+}
+
+func (t CommentTarget) column() string {
+	if t.Type == scriptChainResultType {
+		return "comments.script_chain_id"
+	}
+	return "comments.script_id"
+}
+
+// Comment is a comment or reply. Deleted comments keep their place in a thread
+// but lose their body and author.
+type Comment struct {
+	ID              uuid.UUID  `json:"id"`
+	ParentID        nullUUID   `json:"parentId"`
+	AuthorSpotifyID nullString `json:"authorSpotifyId"`
+	AuthorName      nullString `json:"authorName"`
+	Body            nullString `json:"body"`
+	CreatedAt       time.Time  `json:"createdAt"`
+	EditedAt        nullTime   `json:"editedAt"`
+	Deleted         string     `json:"deleted,omitempty"`
+	ReplyCount      uint64     `json:"replyCount"`
+}
+
+type CommentRevision struct {
+	CreatedAt time.Time `json:"createdAt"`
+	Body      string    `json:"body"`
+}
+
+func selectComments() sq.SelectBuilder {
+	return psql.Select(
+		"comments.id",
+		"comments.parent_id",
+		"users.spotify_id",
+		"users.name",
+		"comments.body",
+		"comments.created_at",
+		"comments.edited_at",
+		"comments.is_deleted",
+		"comments.deleted_by_moderator",
+		"(select count(*) from comments_view replies where replies.parent_id = comments.id)").
+		From("comments_view as comments").
+		LeftJoin("users_view users on users.id = comments.author_id")
+}
+
+type commentScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanComment(row commentScanner) (Comment, error) {
+	var comment Comment
+	var isDeleted, deletedByModerator bool
+	err := row.Scan(
+		&comment.ID,
+		&comment.ParentID,
+		&comment.AuthorSpotifyID,
+		&comment.AuthorName,
+		&comment.Body,
+		&comment.CreatedAt,
+		&comment.EditedAt,
+		&isDeleted,
+		&deletedByModerator,
+		&comment.ReplyCount)
+	if err != nil {
+		return comment, err
+	}
+	if isDeleted {
+		comment.AuthorSpotifyID = nullString{}
+		comment.AuthorName = nullString{}
+		comment.Deleted = commentDeletedByAuthor
+		if deletedByModerator {
+			comment.Deleted = commentDeletedByModerator
+		}
+	}
+	return comment, nil
+}
+
+// GetComments gets a page of comments on the target, most recent first. With
+// a parent it gets replies to that comment, otherwise the top of each thread.
+func GetComments(target CommentTarget, parentID uuid.NullUUID, count uint64, from time.Time) (comments []Comment, err error) {
+	where := sq.And{sq.Eq{target.column(): target.ID}}
+	if parentID.Valid {
+		where = append(where, sq.Eq{"comments.parent_id": parentID.UUID})
+	} else {
+		where = append(where, sq.Eq{"comments.parent_id": nil})
+	}
+	if !from.IsZero() {
+		where = append(where, sq.Lt{"comments.created_at": from})
+	}
+	rows, err := selectComments().
+		Where(where).
+		OrderBy("comments.created_at desc").
+		Limit(count).
+		RunWith(postgresDB).Query()
+	if err != nil {
+		return nil, fmt.Errorf("Could not get comments from DB: %s", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		comment, err := scanComment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("Could not scan row: %s", err)
+		}
+		comments = append(comments, comment)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("Error after scanning rows: %s", err)
+	}
+	return comments, nil
+}
+
+// GetComment gets a comment if it is on the target.
+func GetComment(target CommentTarget, commentID uuid.UUID) (Comment, bool, error) {
+	comment, err := scanComment(selectComments().
+		Where(sq.Eq{
+			"comments.id":   commentID,
+			target.column(): target.ID,
+		}).
+		RunWith(postgresDB).QueryRow())
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return Comment{}, false, nil
+		}
+		return Comment{}, false, fmt.Errorf("Could not query for comment: %s", err)
+	}
+	return comment, true, nil
+}
+
+func CreateComment(spotifyUserID string, target CommentTarget, parentID uuid.NullUUID, body string) (Comment, error) {
+	tx, err := postgresDB.Begin()
+	if err != nil {
+		return Comment{}, fmt.Errorf("Could not start transaction: %s", err)
+	}
+	userID, err := mapSpotifyIDToOurID(tx, spotifyUserID)
+	if err != nil {
+		return Comment{}, common.TryToRollback(tx, fmt.Errorf("Could not get user ID from Spotify ID: %s", err))
+	}
+	var scriptID, scriptChainID uuid.NullUUID
+	if target.Type == scriptChainResultType {
+		scriptChainID = uuid.NullUUID{UUID: target.ID, Valid: true}
+	} else {
+		scriptID = uuid.NullUUID{UUID: target.ID, Valid: true}
+	}
+	commentID := uuid.NewV4()
+	_, err = psql.Insert("comments").
+		Columns("id", "author_id", "script_id", "script_chain_id", "parent_id").
+		Values(commentID, userID, scriptID, scriptChainID, parentID).
+		RunWith(tx).Exec()
+	if err != nil {
+		return Comment{}, common.TryToRollback(tx, fmt.Errorf("Could not insert comment: %s", err))
+	}
+	_, err = psql.Insert("comment_revisions").
+		Columns("comment_id", "body").
+		Values(commentID, body).
+		RunWith(tx).Exec()
+	if err != nil {
+		return Comment{}, common.TryToRollback(tx, fmt.Errorf("Could not insert comment body: %s", err))
+	}
+	err = tx.Commit()
+	if err != nil {
+		return Comment{}, common.TryToRollback(tx, fmt.Errorf("Could not commit: %s", err))
+	}
+	comment, _, err := GetComment(target, commentID)
+	if err != nil {
+		return Comment{}, fmt.Errorf("Could not get new comment: %s", err)
+	}
+	return comment, nil
+}
+
+// EditComment replaces the comment's body, keeping the old one in its
+// history.
+func EditComment(target CommentTarget, commentID uuid.UUID, body string) (Comment, error) {
+	_, err := psql.Insert("comment_revisions").
+		Columns("comment_id", "body").
+		Values(commentID, body).
+		RunWith(postgresDB).Exec()
+	if err != nil {
+		return Comment{}, fmt.Errorf("Could not insert comment body: %s", err)
+	}
+	comment, _, err := GetComment(target, commentID)
+	if err != nil {
+		return Comment{}, fmt.Errorf("Could not get edited comment: %s", err)
+	}
+	return comment, nil
+}
+
+// DeleteComment hides the comment's body but leaves any replies to it be.
+func DeleteComment(commentID uuid.UUID, byModerator bool) error {
+	_, err := psql.Update("comments").
+		Set("deleted_at", sq.Expr("now()")).
+		Set("deleted_by_moderator", byModerator).
+		Where(sq.Eq{"id": commentID, "deleted_at": nil}).
+		RunWith(postgresDB).Exec()
+	if err != nil {
+		return fmt.Errorf("Could not delete comment: %s", err)
+	}
+	return nil
+}
+
+// GetCommentRevisions gets every version of the comment, most recent first.
+// Deleted comments have no history.
+func GetCommentRevisions(commentID uuid.UUID) (revisions []CommentRevision, err error) {
+	rows, err := psql.Select("created_at", "body").
+		From("comment_revisions_view").
+		Where(sq.Eq{"comment_id": commentID}).
+		OrderBy("created_at desc").
+		RunWith(postgresDB).Query()
+	if err != nil {
+		return nil, fmt.Errorf("Could not get comment revisions from DB: %s", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var revision CommentRevision
+		if err := rows.Scan(&revision.CreatedAt, &revision.Body); err != nil {
+			return nil, fmt.Errorf("Could not scan row: %s", err)
+		}
+		revisions = append(revisions, revision)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("Error after scanning rows: %s", err)
+	}
+	return revisions, nil
+}
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+type testCommentRow struct {
+	isDeleted          bool
+	deletedByModerator bool
+}
+
+func (r testCommentRow) Scan(dest ...interface{}) error {
+	*dest[0].(*uuid.UUID) = uuid.NewV4()
+	*dest[2].(*nullString) = nullString{sql.NullString{String: "someone", Valid: true}}
+	*dest[3].(*nullString) = nullString{sql.NullString{String: "Someone", Valid: true}}
+	*dest[5].(*time.Time) = time.Now()
+	*dest[7].(*bool) = r.isDeleted
+	*dest[8].(*bool) = r.deletedByModerator
+	*dest[9].(*uint64) = 2
+	return nil
+}
+
+func Test_scanComment(t *testing.T) {
+	comment, err := scanComment(testCommentRow{})
+	if err != nil {
+		t.Fatalf("Could not scan comment: %s", err)
+	}
+	if comment.Deleted != "" || comment.AuthorSpotifyID.String != "someone" || comment.ReplyCount != 2 {
+		t.Fatalf("Expected a visible comment, got %+v", comment)
+	}
+	comment, err = scanComment(testCommentRow{isDeleted: true})
+	if err != nil {
+		t.Fatalf("Could not scan comment: %s", err)
+	}
+	if comment.Deleted != commentDeletedByAuthor || comment.AuthorSpotifyID.Valid || comment.AuthorName.Valid {
+		t.Fatalf("Expected the author to be hidden on a deleted comment, got %+v", comment)
+	}
+	comment, err = scanComment(testCommentRow{isDeleted: true, deletedByModerator: true})
+	if err != nil {
+		t.Fatalf("Could not scan comment: %s", err)
+	}
+	if comment.Deleted != commentDeletedByModerator || comment.ReplyCount != 2 {
+		t.Fatalf("Expected a moderated comment to keep its replies, got %+v", comment)
+	}
+}
@@ -0,0 +1,114 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/samuelhorwitz/phosphorescence/api/coverart"
+	"github.com/samuelhorwitz/phosphorescence/api/playlistimage"
+	"github.com/samuelhorwitz/phosphorescence/api/spotifyclient"
+)
+
+// Album art comes from Spotify's CDN, not the API, so it doesn't go through
+// the Spotify client.
+var albumArtClient = &http.Client{Timeout: 5 * time.Second}
+
+// Album art from Spotify is at most 640x640 JPEG, anything bigger than this is
+// not something we asked for.
+const maxAlbumArtBytes = 2 * 1024 * 1024
+
+const generatedCoverDimension = 640
+
+// generatePlaylistImage builds a cover from the album art of the set's most
+// common albums with a sparkline of aetherealness (or energy if the client
+// didn't send aetherealness for every track) across the set.
+func generatePlaylistImage(ctx context.Context, region string, trackURIs []string, aetherealness []float64) (string, error) {
+	var trackIDs []string
+	for _, trackURI := range trackURIs {
+		trackIDs = append(trackIDs, strings.TrimPrefix(trackURI, "spotify:track:"))
+	}
+	phosphorescenceToken, err := spotifyclient.GetAppToken()
+	if err != nil {
+		return "", fmt.Errorf("Could not get Spotify application token: %s", err)
+	}
+	tracks, err := getTracks(ctx, phosphorescenceToken, region, trackIDs)
+	if err != nil {
+		return "", fmt.Errorf("Could not get tracks: %s", err)
+	}
+	useAetherealness := len(aetherealness) == len(trackIDs) && len(tracks) == len(trackIDs)
+	albumImages := make(map[string]string)
+	var coverTracks []coverart.Track
+	for i, track := range tracks {
+		if track.Track == nil || track.Features == nil {
+			continue
+		}
+		value := track.Features.Energy
+		if useAetherealness {
+			value = aetherealness[i]
+		}
+		albumID := track.Track.Album.ID
+		if images := findBestImage(track.Track.Album.Images); len(images) > 0 {
+			albumImages[albumID] = images[0].URL
+		}
+		coverTracks = append(coverTracks, coverart.Track{AlbumID: albumID, Value: value})
+	}
+	if len(coverTracks) == 0 {
+		return "", fmt.Errorf("No tracks to generate image from")
+	}
+	albumArt := getAlbumArt(ctx, coverart.TopAlbums(coverTracks, coverart.MaxTiles), albumImages)
+	return playlistimage.Encode(coverart.Render(coverTracks, albumArt, logoMark, generatedCoverDimension))
+}
+
+// getAlbumArt fetches album art concurrently. Albums whose art can't be fetched
+// are left out of the map and get a placeholder tile instead.
+func getAlbumArt(ctx context.Context, albumIDs []string, albumImages map[string]string) map[string]image.Image {
+	var mux sync.Mutex
+	var wg sync.WaitGroup
+	albumArt := make(map[string]image.Image)
+	for _, albumID := range albumIDs {
+		url, ok := albumImages[albumID]
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func(albumID, url string) {
+			defer wg.Done()
+			img, err := getImage(ctx, url)
+			if err != nil {
+				return
+			}
+			mux.Lock()
+			defer mux.Unlock()
+			albumArt[albumID] = img
+		}(albumID, url)
+	}
+	wg.Wait()
+	return albumArt
+}
+
+func getImage(ctx context.Context, url string) (image.Image, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Could not build image request: %s", err)
+	}
+	res, err := albumArtClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Could not make image request: %s", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Image request responded with %d", res.StatusCode)
+	}
+	raw, err := ioutil.ReadAll(io.LimitReader(res.Body, maxAlbumArtBytes))
+	if err != nil {
+		return nil, fmt.Errorf("Could not read image response: %s", err)
+	}
+	return playlistimage.Decode(raw)
+}
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/satori/go.uuid"
+
+	"github.com/samuelhorwitz/phosphorescence/api/common"
+	"github.com/samuelhorwitz/phosphorescence/api/session"
+)
+
+func recordCreatedPlaylist(sess *session.Session, generator PlaylistGenerator, playlistID string, trackCount int) error {
+	var scriptID, scriptVersion interface{}
+	if !uuid.Equal(generator.ScriptID, uuid.Nil) {
+		// The client tells us which script it ran, if that script version
+		// doesn't exist we would rather lose the provenance than the record.
+		exists, err := scriptVersionExists(generator.ScriptID, generator.ScriptVersion)
+		if err != nil {
+			return err
+		}
+		if exists {
+			scriptID, scriptVersion = generator.ScriptID, generator.ScriptVersion
+		}
+	}
+	if sess == nil {
+		_, err := psql.Insert("created_playlists").
+			Columns("playlist_id", "track_count", "builder", "script_id", "script_version_created_at").
+			Values(playlistID, trackCount, stringOrNull(generator.Builder), scriptID, scriptVersion).
+			RunWith(postgresDB).Exec()
+		if err != nil {
+			return fmt.Errorf("Could not insert created playlist: %s", err)
+		}
+		return nil
+	}
+	tx, err := postgresDB.Begin()
+	if err != nil {
+		return fmt.Errorf("Could not begin transaction: %s", err)
+	}
+	userID, err := mapSpotifyIDToOurID(tx, sess.SpotifyID)
+	if err != nil {
+		return common.TryToRollback(tx, fmt.Errorf("Could not get user ID from Spotify ID: %s", err))
+	}
+	_, err = psql.Insert("created_playlists").
+		Columns("playlist_id", "creator_id", "region", "track_count", "builder", "script_id", "script_version_created_at").
+		Values(playlistID, userID, stringOrNull(sess.SpotifyCountry), trackCount, stringOrNull(generator.Builder), scriptID, scriptVersion).
+		RunWith(tx).Exec()
+	if err != nil {
+		return common.TryToRollback(tx, fmt.Errorf("Could not insert created playlist: %s", err))
+	}
+	if err = tx.Commit(); err != nil {
+		return common.TryToRollback(tx, fmt.Errorf("Could not commit: %s", err))
+	}
+	return nil
+}
+
+func recordCreatedPlaylistFollowed(playlistID string) error {
+	_, err := psql.Update("created_playlists").
+		Set("followed_at", sq.Expr("now()")).
+		Where(sq.Eq{
+			"playlist_id": playlistID,
+			"followed_at": nil,
+		}).
+		RunWith(postgresDB).Exec()
+	if err != nil {
+		return fmt.Errorf("Could not mark created playlist as followed: %s", err)
+	}
+	return nil
+}
+
+func scriptVersionExists(scriptID uuid.UUID, version time.Time) (bool, error) {
+	var count int
+	err := psql.Select("count(*)").From("script_versions_view").
+		Where(sq.Eq{
+			"script_id":  scriptID,
+			"created_at": version,
+		}).
+		RunWith(postgresDB).QueryRow().Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("Could not check script version: %s", err)
+	}
+	return count > 0, nil
+}
@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -28,18 +27,88 @@ type SpotifyDevice struct {
 	VolumePercent    int    `json:"volume_percent"`
 }
 
+// PlaybackItemType is what kind of thing is playing. Track is only set for
+// tracks and local files, Episode only for episodes, and neither is set for ads
+// or when nothing is playing.
+type PlaybackItemType string
+
+const (
+	PlaybackItemTrack   PlaybackItemType = "track"
+	PlaybackItemEpisode PlaybackItemType = "episode"
+	PlaybackItemAd      PlaybackItemType = "ad"
+	PlaybackItemLocal   PlaybackItemType = "local"
+	PlaybackItemNothing PlaybackItemType = "nothing"
+)
+
 type Playback struct {
-	IsPlaying        bool                 `json:"isPlaying"`
-	Progress         int                  `json:"progress"`
-	FetchedAtSpotify int                  `json:"fetchedAtSpotify"`
-	FetchedAt        int64                `json:"fetchedAt"`
-	Track            SpotifyPlaybackTrack `json:"track"`
+	Type             PlaybackItemType        `json:"type"`
+	IsPlaying        bool                    `json:"isPlaying"`
+	Progress         int                     `json:"progress"`
+	FetchedAtSpotify int                     `json:"fetchedAtSpotify"`
+	FetchedAt        int64                   `json:"fetchedAt"`
+	Track            *SpotifyPlaybackTrack   `json:"track,omitempty"`
+	Episode          *SpotifyPlaybackEpisode `json:"episode,omitempty"`
+	Device           *SpotifyDevice          `json:"device,omitempty"`
+	ContextURI       string                  `json:"contextUri,omitempty"`
+	ShuffleState     bool                    `json:"shuffleState"`
+	RepeatState      RepeatState             `json:"repeatState,omitempty"`
+}
+
+// TrackID is the ID of the Spotify track playing, or empty if something else
+// (including a local file) is playing.
+func (p Playback) TrackID() string {
+	if p.Type != PlaybackItemTrack || p.Track == nil {
+		return ""
+	}
+	return p.Track.ID
+}
+
+// ItemURI identifies whatever is playing, or is empty for ads and when nothing
+// is playing.
+func (p Playback) ItemURI() string {
+	switch {
+	case p.Track != nil:
+		return p.Track.URI
+	case p.Episode != nil:
+		return p.Episode.URI
+	}
+	return ""
+}
+
+// Duration is how long whatever is playing lasts in milliseconds, or 0 if we
+// don't know.
+func (p Playback) Duration() int {
+	switch {
+	case p.Track != nil:
+		return p.Track.Duration
+	case p.Episode != nil:
+		return p.Episode.Duration
+	}
+	return 0
 }
 
 type SpotifyPlaybackTrack struct {
-	ID      string          `json:"id,omitempty"`
-	Name    string          `json:"name"`
-	Artists []SpotifyArtist `json:"artists"`
+	ID       string          `json:"id,omitempty"`
+	URI      string          `json:"uri,omitempty"`
+	Name     string          `json:"name"`
+	Artists  []SpotifyArtist `json:"artists"`
+	Duration int             `json:"duration,omitempty"`
+	IsLocal  bool            `json:"isLocal,omitempty"`
+}
+
+type SpotifyPlaybackEpisode struct {
+	ID       string         `json:"id"`
+	URI      string         `json:"uri"`
+	Name     string         `json:"name"`
+	Show     SpotifyShow    `json:"show"`
+	Images   []SpotifyImage `json:"images"`
+	Duration int            `json:"duration,omitempty"`
+}
+
+type SpotifyShow struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Publisher string `json:"publisher"`
 }
 
 type PlayState int
@@ -50,9 +119,6 @@ const (
 	PlayStatePlay
 )
 
-var ErrCurrentlyPlayingNotTrack = errors.New("Spotify currently playing is not a track")
-var ErrLocalTrack = errors.New("Spotify currently playing is a local track")
-
 func GetDevices(ctx context.Context, sess *session.Session) (SpotifyDevices, error) {
 	req, err := http.NewRequestWithContext(ctx, "GET", "https://api.spotify.com/v1/me/player/devices", nil)
 	if err != nil {
@@ -101,7 +167,7 @@ func TransferPlayback(ctx context.Context, sess *session.Session, deviceID strin
 	}
 	defer res.Body.Close()
 	if res.StatusCode != http.StatusNoContent {
-		return fmt.Errorf("Spotify device transfer request responded with %d", res.StatusCode)
+		return parsePlayerError(res)
 	}
 	if playState == PlayStatePause {
 		err = Pause(ctx, sess, deviceID)
@@ -112,6 +178,89 @@ func TransferPlayback(ctx context.Context, sess *session.Session, deviceID strin
 	return nil
 }
 
+type TransferStatus string
+
+const (
+	TransferStatusConfirmed      TransferStatus = "confirmed"
+	TransferStatusTimedOut       TransferStatus = "timed_out"
+	TransferStatusDeviceVanished TransferStatus = "device_vanished"
+)
+
+// TransferOutcome is how a transfer ended along with the last device list we
+// saw, so the client can redraw its device picker either way.
+type TransferOutcome struct {
+	Status  TransferStatus  `json:"status"`
+	Devices []SpotifyDevice `json:"devices"`
+}
+
+const (
+	transferPollBase = 100 * time.Millisecond
+	transferPollMax  = 1 * time.Second
+)
+
+// TransferAndConfirm transfers playback and then waits, for at most timeout,
+// until Spotify reports the device as active and in the requested play state.
+// An error is only returned if the transfer itself fails or ctx is cancelled,
+// a transfer which never takes effect is reported through the outcome.
+func TransferAndConfirm(ctx context.Context, sess *session.Session, deviceID string, playState PlayState, timeout time.Duration) (TransferOutcome, error) {
+	if err := TransferPlayback(ctx, sess, deviceID, playState); err != nil {
+		return TransferOutcome{}, err
+	}
+	confirmCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	outcome := TransferOutcome{Status: TransferStatusTimedOut, Devices: []SpotifyDevice{}}
+	wait := transferPollBase
+	for {
+		select {
+		case <-confirmCtx.Done():
+			if err := ctx.Err(); err != nil {
+				return TransferOutcome{}, err
+			}
+			return outcome, nil
+		case <-time.After(wait):
+		}
+		if wait *= 2; wait > transferPollMax {
+			wait = transferPollMax
+		}
+		devices, err := GetDevices(confirmCtx, sess)
+		if err != nil {
+			// Most likely the poll was cut short by the timeout, if not the
+			// next poll will tell.
+			continue
+		}
+		outcome.Devices = devices.Devices
+		device, ok := findDevice(devices.Devices, deviceID)
+		if !ok {
+			outcome.Status = TransferStatusDeviceVanished
+			return outcome, nil
+		}
+		if !device.IsActive {
+			continue
+		}
+		if playState == PlayStateUndefined {
+			outcome.Status = TransferStatusConfirmed
+			return outcome, nil
+		}
+		playback, err := GetCurrentPlayback(confirmCtx, sess)
+		if err != nil || playback.Device == nil || playback.Device.ID != deviceID {
+			continue
+		}
+		if playback.IsPlaying == (playState == PlayStatePlay) {
+			outcome.Status = TransferStatusConfirmed
+			return outcome, nil
+		}
+	}
+}
+
+func findDevice(devices []SpotifyDevice, deviceID string) (SpotifyDevice, bool) {
+	for _, device := range devices {
+		if device.ID == deviceID {
+			return device, true
+		}
+	}
+	return SpotifyDevice{}, false
+}
+
 func Pause(ctx context.Context, sess *session.Session, deviceID string) error {
 	body, err := json.Marshal(struct {
 		DeviceID string `json:"device_id"`
@@ -137,8 +286,11 @@ func Pause(ctx context.Context, sess *session.Session, deviceID string) error {
 	return nil
 }
 
+// GetCurrentPlayback returns what the user is playing, whatever it is. When
+// nothing is playing (or there is no active device) the playback's type is
+// PlaybackItemNothing rather than an error.
 func GetCurrentPlayback(ctx context.Context, sess *session.Session) (Playback, error) {
-	req, err := http.NewRequestWithContext(ctx, "GET", "https://api.spotify.com/v1/me/player/currently-playing", nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://api.spotify.com/v1/me/player?additional_types=episode", nil)
 	if err != nil {
 		return Playback{}, fmt.Errorf("Could not build Spotify currently playing request: %s", err)
 	}
@@ -149,6 +301,12 @@ func GetCurrentPlayback(ctx context.Context, sess *session.Session) (Playback, e
 	}
 	fetchedAt := time.Now()
 	defer res.Body.Close()
+	if res.StatusCode == http.StatusNoContent {
+		return Playback{
+			Type:      PlaybackItemNothing,
+			FetchedAt: fetchedAt.UnixNano() / int64(time.Millisecond),
+		}, nil
+	}
 	if res.StatusCode != http.StatusOK {
 		return Playback{}, fmt.Errorf("Spotify currently playing request responded with %d", res.StatusCode)
 	}
@@ -157,31 +315,70 @@ func GetCurrentPlayback(ctx context.Context, sess *session.Session) (Playback, e
 		return Playback{}, fmt.Errorf("Could not read Spotify currently playing response: %s", err)
 	}
 	var parsedBody struct {
-		IsPlaying            bool         `json:"is_playing"`
-		Track                SpotifyTrack `json:"item"`
-		CurrentlyPlayingType string       `json:"currently_playing_type"`
-		Timestamp            int          `json:"timestamp"`
-		ProgressMilliseconds int          `json:"progress_ms"`
+		Device  *SpotifyDevice `json:"device"`
+		Context *struct {
+			URI string `json:"uri"`
+		} `json:"context"`
+		ShuffleState         bool            `json:"shuffle_state"`
+		RepeatState          RepeatState     `json:"repeat_state"`
+		IsPlaying            bool            `json:"is_playing"`
+		Item                 json.RawMessage `json:"item"`
+		CurrentlyPlayingType string          `json:"currently_playing_type"`
+		Timestamp            int             `json:"timestamp"`
+		ProgressMilliseconds int             `json:"progress_ms"`
 	}
 	err = json.Unmarshal(body, &parsedBody)
 	if err != nil {
 		return Playback{}, fmt.Errorf("Could not parse Spotify currently playing response: %s", err)
 	}
-	if parsedBody.CurrentlyPlayingType != "track" {
-		return Playback{}, ErrCurrentlyPlayingNotTrack
-	}
-	if parsedBody.Track.IsLocal {
-		return Playback{}, ErrLocalTrack
-	}
-	return Playback{
+	playback := Playback{
+		Type:             PlaybackItemNothing,
 		IsPlaying:        parsedBody.IsPlaying,
 		Progress:         parsedBody.ProgressMilliseconds,
 		FetchedAtSpotify: parsedBody.Timestamp,
 		FetchedAt:        fetchedAt.UnixNano() / int64(time.Millisecond),
-		Track: SpotifyPlaybackTrack{
-			ID:      parsedBody.Track.ID,
-			Name:    parsedBody.Track.Name,
-			Artists: parsedBody.Track.Artists,
-		},
-	}, nil
+		Device:           parsedBody.Device,
+		ShuffleState:     parsedBody.ShuffleState,
+		RepeatState:      parsedBody.RepeatState,
+	}
+	if parsedBody.Context != nil {
+		playback.ContextURI = parsedBody.Context.URI
+	}
+	hasItem := len(parsedBody.Item) > 0 && string(parsedBody.Item) != "null"
+	switch {
+	case parsedBody.CurrentlyPlayingType == "ad":
+		playback.Type = PlaybackItemAd
+	case parsedBody.CurrentlyPlayingType == "track" && hasItem:
+		var track struct {
+			SpotifyTrack
+			URI string `json:"uri"`
+		}
+		if err = json.Unmarshal(parsedBody.Item, &track); err != nil {
+			return Playback{}, fmt.Errorf("Could not parse Spotify currently playing track: %s", err)
+		}
+		playback.Type = PlaybackItemTrack
+		if track.IsLocal {
+			playback.Type = PlaybackItemLocal
+		}
+		playback.Track = &SpotifyPlaybackTrack{
+			ID:       track.ID,
+			URI:      track.URI,
+			Name:     track.Name,
+			Artists:  track.Artists,
+			Duration: track.DurationMillseconds,
+			IsLocal:  track.IsLocal,
+		}
+	case parsedBody.CurrentlyPlayingType == "episode" && hasItem:
+		var episode struct {
+			SpotifyPlaybackEpisode
+			DurationMillseconds int `json:"duration_ms"`
+		}
+		if err = json.Unmarshal(parsedBody.Item, &episode); err != nil {
+			return Playback{}, fmt.Errorf("Could not parse Spotify currently playing episode: %s", err)
+		}
+		playback.Type = PlaybackItemEpisode
+		playback.Episode = &episode.SpotifyPlaybackEpisode
+		playback.Episode.Duration = episode.DurationMillseconds
+	}
+	return playback, nil
 }
@@ -0,0 +1,172 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/lib/pq"
+	"github.com/satori/go.uuid"
+
+	"github.com/samuelhorwitz/phosphorescence/api/common"
+)
+
+// Like counts are counted on the fly rather than read from searchables, which
+// is only refreshed every few minutes, so a like shows up as soon as it is made.
+const (
+	scriptLikesColumn        = "(select count(*) from script_likes_view script_likes where script_likes.script_id = scripts.id)"
+	scriptLikedByColumn      = "exists(select 1 from script_likes_view script_likes join users_view likers on likers.id = script_likes.liker_id where script_likes.script_id = scripts.id and likers.spotify_id = ?)"
+	scriptChainLikesColumn   = "(select count(*) from script_chain_likes_view script_chain_likes where script_chain_likes.script_chain_id = script_chains.id)"
+	scriptChainLikedByColumn = "exists(select 1 from script_chain_likes_view script_chain_likes join users_view likers on likers.id = script_chain_likes.liker_id where script_chain_likes.script_chain_id = script_chains.id and likers.spotify_id = ?)"
+)
+
+type Like struct {
+	Type       resultType `json:"type"`
+	ID         uuid.UUID  `json:"id"`
+	Name       nullString `json:"name"`
+	AuthorName nullString `json:"authorName"`
+	LikedAt    time.Time  `json:"likedAt"`
+}
+
+func LikeScript(spotifyUserID string, scriptID uuid.UUID) error {
+	return like("script_likes", "script_id", spotifyUserID, scriptID)
+}
+
+func UnlikeScript(spotifyUserID string, scriptID uuid.UUID) error {
+	return unlike("script_likes", "script_id", spotifyUserID, scriptID)
+}
+
+func LikeScriptChain(spotifyUserID string, scriptChainID uuid.UUID) error {
+	return like("script_chain_likes", "script_chain_id", spotifyUserID, scriptChainID)
+}
+
+func UnlikeScriptChain(spotifyUserID string, scriptChainID uuid.UUID) error {
+	return unlike("script_chain_likes", "script_chain_id", spotifyUserID, scriptChainID)
+}
+
+// GetLikesBySpotifyUserID lists what the user has liked, most recent first.
+// Anything which has since been made private by someone else is left out.
+func GetLikesBySpotifyUserID(spotifyUserID string, count uint64, from time.Time) (likes []Like, err error) {
+	if from.IsZero() {
+		from = time.Now()
+	}
+	rows, err := postgresDB.Query(`
+		select * from (
+			select $2::searchable_type, scripts.id, scripts.name, authors.name, script_likes.created_at
+			from script_likes_view script_likes
+			join users_view likers on likers.id = script_likes.liker_id
+			join scripts_view scripts on scripts.id = script_likes.script_id
+			left join users_view authors on authors.id = scripts.author_id
+			where likers.spotify_id = $1 and (not scripts.is_private or scripts.author_id = likers.id)
+			union all
+			select $3::searchable_type, script_chains.id, script_chains.name, authors.name, script_chain_likes.created_at
+			from script_chain_likes_view script_chain_likes
+			join users_view likers on likers.id = script_chain_likes.liker_id
+			join script_chains_view script_chains on script_chains.id = script_chain_likes.script_chain_id
+			left join users_view authors on authors.id = script_chains.author_id
+			where likers.spotify_id = $1 and (not script_chains.is_private or script_chains.author_id = likers.id)
+		) likes
+		where likes.created_at < $4
+		order by likes.created_at desc
+		limit $5`, spotifyUserID, scriptResultType, scriptChainResultType, from, count)
+	if err != nil {
+		return nil, fmt.Errorf("Could not get likes from DB: %s", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var like Like
+		err := rows.Scan(&like.Type, &like.ID, &like.Name, &like.AuthorName, &like.LikedAt)
+		if err != nil {
+			return nil, fmt.Errorf("Could not scan row: %s", err)
+		}
+		likes = append(likes, like)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("Error after scanning rows: %s", err)
+	}
+	return likes, nil
+}
+
+// getLikeCounts counts likes for each of the IDs in the given likes table,
+// anything without likes is missing from the map.
+func getLikeCounts(table, column string, ids []uuid.UUID) (map[uuid.UUID]uint64, error) {
+	counts := make(map[uuid.UUID]uint64)
+	if len(ids) == 0 {
+		return counts, nil
+	}
+	idStrings := make([]string, len(ids))
+	for i, id := range ids {
+		idStrings[i] = id.String()
+	}
+	rows, err := psql.Select(column, "count(*)").
+		From(table+"_view").
+		Where(fmt.Sprintf("%s = any(?::uuid[])", column), pq.Array(idStrings)).
+		GroupBy(column).
+		RunWith(postgresDB).Query()
+	if err != nil {
+		return nil, fmt.Errorf("Could not get like counts: %s", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id uuid.UUID
+		var count uint64
+		if err := rows.Scan(&id, &count); err != nil {
+			return nil, fmt.Errorf("Could not scan row: %s", err)
+		}
+		counts[id] = count
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("Error after scanning rows: %s", err)
+	}
+	return counts, nil
+}
+
+func like(table, column, spotifyUserID string, id uuid.UUID) error {
+	tx, err := postgresDB.Begin()
+	if err != nil {
+		return fmt.Errorf("Could not begin transaction: %s", err)
+	}
+	userID, err := mapSpotifyIDToOurID(tx, spotifyUserID)
+	if err != nil {
+		return common.TryToRollback(tx, fmt.Errorf("Could not get user ID from Spotify ID: %s", err))
+	}
+	// Liking twice is not an error, there is just still the one like.
+	_, err = psql.Insert(table).
+		Columns(column, "liker_id").
+		Values(id, userID).
+		Suffix("on conflict do nothing").
+		RunWith(tx).Exec()
+	if err != nil {
+		return common.TryToRollback(tx, fmt.Errorf("Could not insert like: %s", err))
+	}
+	if err = tx.Commit(); err != nil {
+		return common.TryToRollback(tx, fmt.Errorf("Could not commit: %s", err))
+	}
+	return nil
+}
+
+func unlike(table, column, spotifyUserID string, id uuid.UUID) error {
+	tx, err := postgresDB.Begin()
+	if err != nil {
+		return fmt.Errorf("Could not begin transaction: %s", err)
+	}
+	userID, err := mapSpotifyIDToOurID(tx, spotifyUserID)
+	if err != nil {
+		return common.TryToRollback(tx, fmt.Errorf("Could not get user ID from Spotify ID: %s", err))
+	}
+	_, err = psql.Delete(table).
+		Where(sq.Eq{
+			column:     id,
+			"liker_id": userID,
+		}).
+		RunWith(tx).Exec()
+	if err != nil {
+		return common.TryToRollback(tx, fmt.Errorf("Could not delete like: %s", err))
+	}
+	if err = tx.Commit(); err != nil {
+		return common.TryToRollback(tx, fmt.Errorf("Could not commit: %s", err))
+	}
+	return nil
+}
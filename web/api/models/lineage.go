@@ -0,0 +1,236 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/satori/go.uuid"
+)
+
+const (
+	// Forks can't be made into loops, this only stops a broken lineage
+	// running forever.
+	maxLineageAncestors = 100
+	// Only this many levels of forks come back at once, deeper forks are
+	// found by asking for the lineage of the script at the bottom.
+	maxLineageDepth = 3
+	// Forks of forks are cut to this many, the most recent, with ForkCount
+	// saying how many there really are.
+	maxLineageForks = 10
+)
+
+const (
+	lineageRedactedPrivate = "private"
+	lineageRedactedDeleted = "deleted"
+)
+
+// ScriptLineage is where a script was forked from, nearest first, and a page
+// of the scripts forked from it.
+type ScriptLineage struct {
+	Ancestors []LineageNode `json:"ancestors"`
+	Forks     []LineageNode `json:"forks"`
+	ForkCount uint64        `json:"forkCount"`
+}
+
+// LineageNode is a script in a lineage. Scripts the caller can't see keep
+// their place, so the lineage still joins up, but say nothing about
+// themselves beyond why they are redacted.
+type LineageNode struct {
+	ID                      nullUUID      `json:"id"`
+	Name                    nullString    `json:"name"`
+	AuthorSpotifyID         nullString    `json:"authorSpotifyId"`
+	AuthorName              nullString    `json:"authorName"`
+	ForkedFromScriptVersion nullTime      `json:"forkedFromScriptVersion"`
+	IsPrivate               bool          `json:"isPrivate,omitempty"`
+	Redacted                string        `json:"redacted,omitempty"`
+	CreatedAt               time.Time     `json:"createdAt"`
+	ForkCount               uint64        `json:"forkCount"`
+	Forks                   []LineageNode `json:"forks,omitempty"`
+}
+
+// lineageRow is a script in a lineage as it comes out of the database, before
+// redaction.
+type lineageRow struct {
+	id                      uuid.UUID
+	forkedFromScriptID      nullUUID
+	forkedFromScriptVersion nullTime
+	isPrivate               bool
+	isDeleted               bool
+	createdAt               time.Time
+	name                    nullString
+	authorSpotifyID         nullString
+	authorName              nullString
+	forkCount               uint64
+	isCollaborator          bool
+}
+
+const lineageColumns = `
+	lineage.id,
+	lineage.forked_from_script_id,
+	lineage.forked_from_script_version_created_at,
+	lineage.is_private,
+	lineage.is_deleted or scripts.id is null,
+	lineage.created_at,
+	scripts.name,
+	users.spotify_id,
+	users.name,
+	(select count(*) from script_lineage_view forks where forks.forked_from_script_id = lineage.id),
+	` + scriptCollaboratorCondition
+
+const lineageJoins = `
+	left join scripts_view scripts on scripts.id = lineage.id
+	left join users_view users on users.id = lineage.author_id`
+
+// GetScriptLineage gets the script's ancestors up to the original script and
+// a page of its forks, most recent first, each with a few levels of their own
+// forks.
+func GetScriptLineage(spotifyUserID string, scriptID uuid.UUID, count uint64, from time.Time) (ScriptLineage, error) {
+	var lineage ScriptLineage
+	ancestors, err := queryLineage(`
+		with recursive ancestors as (
+			select parent.*, 1 as depth
+			from script_lineage_view child
+			join script_lineage_view parent on parent.id = child.forked_from_script_id
+			where child.id = ?
+			union all
+			select parent.*, ancestors.depth + 1
+			from ancestors
+			join script_lineage_view parent on parent.id = ancestors.forked_from_script_id
+			where ancestors.depth < ?
+		)
+		select`+lineageColumns+`
+		from ancestors lineage`+lineageJoins+`
+		order by lineage.depth asc`, scriptID, maxLineageAncestors, spotifyUserID)
+	if err != nil {
+		return lineage, fmt.Errorf("Could not get ancestors: %s", err)
+	}
+	lineage.Ancestors = make([]LineageNode, len(ancestors))
+	for i, row := range ancestors {
+		lineage.Ancestors[i] = redactLineageRow(spotifyUserID, row)
+	}
+	if from.IsZero() {
+		from = time.Now()
+	}
+	descendants, err := queryLineage(`
+		with recursive descendants as (
+			select * from (
+				select child.*, 1 as depth, row_number() over (order by child.created_at desc) as fork_rank
+				from script_lineage_view child
+				where child.forked_from_script_id = ? and child.created_at < ?
+				order by child.created_at desc
+				limit ?
+			) page
+			union all
+			-- Each level only keeps the most recent few forks of every parent.
+			select * from (
+				select child.*, descendants.depth + 1,
+					row_number() over (partition by child.forked_from_script_id order by child.created_at desc) as fork_rank
+				from descendants
+				join script_lineage_view child on child.forked_from_script_id = descendants.id
+				where descendants.depth < ?
+			) forks
+			where forks.fork_rank <= ?
+		)
+		select`+lineageColumns+`
+		from descendants lineage`+lineageJoins, scriptID, from, count, maxLineageDepth, maxLineageForks, spotifyUserID)
+	if err != nil {
+		return lineage, fmt.Errorf("Could not get forks: %s", err)
+	}
+	lineage.Forks = buildLineageTree(spotifyUserID, scriptID, descendants)
+	err = psql.Select("count(*)").
+		From("script_lineage_view").
+		Where("forked_from_script_id = ?", scriptID).
+		RunWith(postgresDB).QueryRow().Scan(&lineage.ForkCount)
+	if err != nil {
+		return lineage, fmt.Errorf("Could not count forks: %s", err)
+	}
+	return lineage, nil
+}
+
+// queryLineage runs a lineage query written with ? placeholders. The last
+// argument is the Spotify ID of whoever is looking, for lineageColumns.
+func queryLineage(query string, args ...interface{}) ([]lineageRow, error) {
+	query, err := sq.Dollar.ReplacePlaceholders(query)
+	if err != nil {
+		return nil, fmt.Errorf("Could not build lineage query: %s", err)
+	}
+	rows, err := postgresDB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("Could not query lineage: %s", err)
+	}
+	defer rows.Close()
+	var lineage []lineageRow
+	for rows.Next() {
+		var row lineageRow
+		err := rows.Scan(
+			&row.id,
+			&row.forkedFromScriptID,
+			&row.forkedFromScriptVersion,
+			&row.isPrivate,
+			&row.isDeleted,
+			&row.createdAt,
+			&row.name,
+			&row.authorSpotifyID,
+			&row.authorName,
+			&row.forkCount,
+			&row.isCollaborator)
+		if err != nil {
+			return nil, fmt.Errorf("Could not scan row: %s", err)
+		}
+		lineage = append(lineage, row)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("Error after scanning rows: %s", err)
+	}
+	return lineage, nil
+}
+
+// buildLineageTree nests the rows under whichever of them they were forked
+// from, starting with the root's forks.
+func buildLineageTree(spotifyUserID string, rootID uuid.UUID, rows []lineageRow) []LineageNode {
+	children := make(map[uuid.UUID][]lineageRow)
+	for _, row := range rows {
+		if row.forkedFromScriptID.Valid {
+			children[row.forkedFromScriptID.UUID] = append(children[row.forkedFromScriptID.UUID], row)
+		}
+	}
+	var build func(parentID uuid.UUID, limit int) []LineageNode
+	build = func(parentID uuid.UUID, limit int) []LineageNode {
+		forks := children[parentID]
+		sort.Slice(forks, func(i, j int) bool {
+			return forks[i].createdAt.After(forks[j].createdAt)
+		})
+		if limit > 0 && len(forks) > limit {
+			forks = forks[:limit]
+		}
+		var nodes []LineageNode
+		for _, row := range forks {
+			node := redactLineageRow(spotifyUserID, row)
+			node.Forks = build(row.id, maxLineageForks)
+			nodes = append(nodes, node)
+		}
+		return nodes
+	}
+	// The root's forks are already a page so aren't cut any further.
+	return build(rootID, 0)
+}
+
+func redactLineageRow(spotifyUserID string, row lineageRow) LineageNode {
+	node := LineageNode{CreatedAt: row.createdAt, ForkCount: row.forkCount}
+	switch {
+	case row.isDeleted:
+		node.Redacted = lineageRedactedDeleted
+	case row.isPrivate && row.authorSpotifyID.String != spotifyUserID && !row.isCollaborator:
+		node.Redacted = lineageRedactedPrivate
+	default:
+		node.ID = nullUUID{uuid.NullUUID{UUID: row.id, Valid: true}}
+		node.Name = row.name
+		node.AuthorSpotifyID = row.authorSpotifyID
+		node.AuthorName = row.authorName
+		node.ForkedFromScriptVersion = row.forkedFromScriptVersion
+		node.IsPrivate = row.isPrivate
+	}
+	return node
+}
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+func testLineageRow(parent uuid.UUID, author string, createdAt time.Time) lineageRow {
+	return lineageRow{
+		id:                 uuid.NewV4(),
+		forkedFromScriptID: nullUUID{uuid.NullUUID{UUID: parent, Valid: true}},
+		createdAt:          createdAt,
+		name:               nullString{sql.NullString{String: "fork", Valid: true}},
+		authorSpotifyID:    nullString{sql.NullString{String: author, Valid: true}},
+	}
+}
+
+func Test_buildLineageTree(t *testing.T) {
+	root := uuid.NewV4()
+	now := time.Now()
+	older := testLineageRow(root, "someone", now.Add(-time.Hour))
+	newer := testLineageRow(root, "someone", now)
+	private := testLineageRow(older.id, "someone", now)
+	private.isPrivate = true
+	mine := testLineageRow(older.id, "me", now.Add(-time.Minute))
+	mine.isPrivate = true
+	deleted := testLineageRow(private.id, "me", now)
+	deleted.isDeleted = true
+	grandchild := testLineageRow(deleted.id, "someone", now)
+	tree := buildLineageTree("me", root, []lineageRow{grandchild, older, deleted, mine, newer, private})
+	if len(tree) != 2 || tree[0].ID.UUID != newer.id || tree[1].ID.UUID != older.id {
+		t.Fatalf("Expected root's forks most recent first, got %+v", tree)
+	}
+	forks := tree[1].Forks
+	if len(forks) != 2 {
+		t.Fatalf("Expected 2 forks of the older fork, got %+v", forks)
+	}
+	if forks[0].Redacted != lineageRedactedPrivate || forks[0].ID.Valid || forks[0].Name.Valid {
+		t.Fatalf("Expected someone else's private fork to be redacted, got %+v", forks[0])
+	}
+	if forks[1].Redacted != "" || !forks[1].IsPrivate || forks[1].ID.UUID != mine.id {
+		t.Fatalf("Expected my private fork to be visible, got %+v", forks[1])
+	}
+	below := forks[0].Forks
+	if len(below) != 1 || below[0].Redacted != lineageRedactedDeleted {
+		t.Fatalf("Expected a deleted fork under the private one, got %+v", below)
+	}
+	if len(below[0].Forks) != 1 || below[0].Forks[0].ID.UUID != grandchild.id {
+		t.Fatalf("Expected forks of a deleted script to still be shown, got %+v", below[0].Forks)
+	}
+}
+
+func Test_buildLineageTreeCutsNestedForks(t *testing.T) {
+	root := uuid.NewV4()
+	child := testLineageRow(root, "someone", time.Now())
+	rows := []lineageRow{child}
+	for i := 0; i < maxLineageForks+5; i++ {
+		rows = append(rows, testLineageRow(child.id, "someone", time.Now()))
+	}
+	tree := buildLineageTree("me", root, rows)
+	if len(tree) != 1 || len(tree[0].Forks) != maxLineageForks {
+		t.Fatalf("Expected nested forks to be cut to %d, got %+v", maxLineageForks, tree)
+	}
+}
+
+func Test_redactLineageRowForCollaborators(t *testing.T) {
+	row := testLineageRow(uuid.NewV4(), "someone", time.Now())
+	row.isPrivate = true
+	if node := redactLineageRow("me", row); node.Redacted != lineageRedactedPrivate {
+		t.Fatalf("Expected someone else's private script to be redacted, got %+v", node)
+	}
+	row.isCollaborator = true
+	if node := redactLineageRow("me", row); node.Redacted != "" || node.ID.UUID != row.id {
+		t.Fatalf("Expected a private script I collaborate on to be visible, got %+v", node)
+	}
+}
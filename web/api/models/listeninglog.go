@@ -0,0 +1,291 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/gomodule/redigo/redis"
+	"github.com/lib/pq"
+	"github.com/satori/go.uuid"
+
+	"github.com/samuelhorwitz/phosphorescence/api/common"
+	"github.com/samuelhorwitz/phosphorescence/api/session"
+)
+
+const (
+	listeningPrefix = "listening"
+	// If we don't see a user playing the playlist for this long, coming back to
+	// it starts a new listening session.
+	listeningSessionGap = 30 * time.Minute
+	// Anything left with less than this to go counts as played through since we
+	// only see playback every few seconds.
+	skipThresholdMilliseconds = 10000
+	// A session which got this far counts as completed in stats.
+	completedThreshold = 0.9
+)
+
+// PlaylistGenerator is what generated a playlist. At most one of Builder (one
+// of the built in builders) or ScriptID and ScriptVersion is set.
+type PlaylistGenerator struct {
+	Builder       string
+	ScriptID      uuid.UUID
+	ScriptVersion time.Time
+}
+
+type ListeningSkip struct {
+	TrackID  string `json:"trackId"`
+	Position int    `json:"position"`
+}
+
+type ListeningStats struct {
+	Sessions          int     `json:"sessions"`
+	Listeners         int     `json:"listeners"`
+	AverageCompletion float64 `json:"averageCompletion"`
+	CompletedSessions int     `json:"completedSessions"`
+	AverageSkips      float64 `json:"averageSkips"`
+}
+
+// listeningState is what we remember between polls about the listening
+// session a user is in, it lives in Redis. Playlists which aren't logged,
+// because they aren't ours or the user hasn't opted in, are remembered as
+// ignored so polling them doesn't hit the database.
+type listeningState struct {
+	Ignored    bool            `json:"ignored,omitempty"`
+	SessionID  uuid.UUID       `json:"sessionId"`
+	PlaylistID string          `json:"playlistId"`
+	TrackCount int             `json:"trackCount"`
+	TrackIDs   []string        `json:"trackIds"`
+	Skips      []ListeningSkip `json:"skips"`
+	Progress   int             `json:"progress"`
+	Duration   int             `json:"duration"`
+}
+
+func GetListeningLog(spotifyID string) (bool, error) {
+	var enabled bool
+	err := psql.Select("listening_log").From("users_view").
+		Where(sq.Eq{"spotify_id": spotifyID}).
+		RunWith(postgresDB).QueryRow().Scan(&enabled)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("Could not get listening log setting: %s", err)
+	}
+	return enabled, nil
+}
+
+func SetListeningLog(spotifyID string, enabled bool) error {
+	tx, err := postgresDB.Begin()
+	if err != nil {
+		return fmt.Errorf("Could not begin transaction: %s", err)
+	}
+	userID, err := mapSpotifyIDToOurID(tx, spotifyID)
+	if err != nil {
+		return common.TryToRollback(tx, fmt.Errorf("Could not get user ID from Spotify ID: %s", err))
+	}
+	_, err = psql.Update("users").
+		Set("listening_log", enabled).
+		Where(sq.Eq{"id": userID}).
+		RunWith(tx).Exec()
+	if err != nil {
+		return common.TryToRollback(tx, fmt.Errorf("Could not update listening log setting: %s", err))
+	}
+	if err = tx.Commit(); err != nil {
+		return common.TryToRollback(tx, fmt.Errorf("Could not commit: %s", err))
+	}
+	// Either way whatever we remember about what they are listening to no longer
+	// holds, including that it is ignored.
+	redisConn := common.RedisPool.Get()
+	defer redisConn.Close()
+	if _, err = redisConn.Do("DEL", getListeningKey(spotifyID)); err != nil {
+		return fmt.Errorf("Could not clear listening state: %s", err)
+	}
+	return nil
+}
+
+// ObservePlayback records the playback in the user's listening log if they are
+// playing a playlist we created and have opted in. It is meant to be called
+// whenever we happen to see what a user is playing, so the database is only
+// written to when the track changes.
+func ObservePlayback(sess *session.Session, playback Playback) error {
+	if !strings.HasPrefix(playback.ContextURI, "spotify:playlist:") || playback.TrackID() == "" {
+		return nil
+	}
+	playlistID := strings.TrimPrefix(playback.ContextURI, "spotify:playlist:")
+	redisConn := common.RedisPool.Get()
+	defer redisConn.Close()
+	state, err := getListeningState(redisConn, sess.SpotifyID)
+	if err != nil {
+		return err
+	}
+	if state == nil || state.PlaylistID != playlistID {
+		state, err = startListeningSession(sess.SpotifyID, playlistID)
+		if err != nil {
+			return err
+		}
+	}
+	if state.Ignored {
+		return setListeningState(redisConn, sess.SpotifyID, state)
+	}
+	if state.advance(playback) {
+		skips, err := json.Marshal(state.Skips)
+		if err != nil {
+			return fmt.Errorf("Could not marshal skips: %s", err)
+		}
+		_, err = psql.Update("listening_sessions").
+			Set("last_seen_at", sq.Expr("now()")).
+			Set("track_ids", pq.Array(state.TrackIDs)).
+			Set("skips", string(skips)).
+			Set("completion", state.completion()).
+			Where(sq.Eq{"id": state.SessionID}).
+			RunWith(postgresDB).Exec()
+		if err != nil {
+			return fmt.Errorf("Could not update listening session: %s", err)
+		}
+	}
+	return setListeningState(redisConn, sess.SpotifyID, state)
+}
+
+// GetScriptListeningStats aggregates every listening session of every playlist
+// generated by any version of the script.
+func GetScriptListeningStats(scriptID uuid.UUID) (ListeningStats, error) {
+	var stats ListeningStats
+	var averageCompletion, averageSkips sql.NullFloat64
+	err := psql.Select(
+		"count(*)",
+		"count(distinct listening_sessions.listener_id)",
+		"avg(listening_sessions.completion)",
+		fmt.Sprintf("count(*) filter (where listening_sessions.completion >= %f)", completedThreshold),
+		"avg(jsonb_array_length(listening_sessions.skips))",
+	).
+		From("listening_sessions_view listening_sessions").
+		Join("created_playlists_view created_playlists on created_playlists.playlist_id = listening_sessions.playlist_id").
+		Where(sq.Eq{"created_playlists.script_id": scriptID}).
+		RunWith(postgresDB).QueryRow().
+		Scan(&stats.Sessions, &stats.Listeners, &averageCompletion, &stats.CompletedSessions, &averageSkips)
+	if err != nil {
+		return ListeningStats{}, fmt.Errorf("Could not get listening stats: %s", err)
+	}
+	stats.AverageCompletion = averageCompletion.Float64
+	stats.AverageSkips = averageSkips.Float64
+	return stats, nil
+}
+
+// startListeningSession returns an ignored state if the playlist isn't ours or
+// the user hasn't opted in.
+func startListeningSession(spotifyID, playlistID string) (*listeningState, error) {
+	var userID uuid.UUID
+	var trackCount int
+	err := psql.Select("users.id", "created_playlists.track_count").
+		From("created_playlists_view created_playlists").
+		Join("users_view users on users.spotify_id = ?", spotifyID).
+		Where(sq.Eq{
+			"created_playlists.playlist_id": playlistID,
+			"users.listening_log":           true,
+		}).
+		RunWith(postgresDB).QueryRow().Scan(&userID, &trackCount)
+	if err == sql.ErrNoRows {
+		return &listeningState{Ignored: true, PlaylistID: playlistID}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not check for listening session: %s", err)
+	}
+	state := &listeningState{
+		SessionID:  uuid.NewV4(),
+		PlaylistID: playlistID,
+		TrackCount: trackCount,
+	}
+	_, err = psql.Insert("listening_sessions").
+		Columns("id", "listener_id", "playlist_id").
+		Values(state.SessionID, userID, playlistID).
+		RunWith(postgresDB).Exec()
+	if err != nil {
+		return nil, fmt.Errorf("Could not insert listening session: %s", err)
+	}
+	return state, nil
+}
+
+// advance moves the session along to what is playing now, noting a skip if
+// the previous track was left well before its end. It is whether the listener
+// got anywhere worth writing down: a new track, or the end of the current one.
+func (s *listeningState) advance(playback Playback) bool {
+	trackID := playback.TrackID()
+	var lastTrackID string
+	if len(s.TrackIDs) > 0 {
+		lastTrackID = s.TrackIDs[len(s.TrackIDs)-1]
+	}
+	wasEnding := s.ending()
+	changed := trackID != lastTrackID
+	if changed {
+		if lastTrackID != "" && s.Duration > 0 && !wasEnding {
+			s.Skips = append(s.Skips, ListeningSkip{TrackID: lastTrackID, Position: s.Progress})
+		}
+		s.TrackIDs = append(s.TrackIDs, trackID)
+		wasEnding = false
+	}
+	s.Progress = playback.Progress
+	s.Duration = playback.Duration()
+	return changed || (!wasEnding && s.ending())
+}
+
+// ending is whether the current track is close enough to its end to count as
+// played through.
+func (s listeningState) ending() bool {
+	return s.Duration > 0 && s.Duration-s.Progress <= skipThresholdMilliseconds
+}
+
+// completion is how far through the playlist the listener got, counting each
+// distinct track reached and how far into the current one they are.
+func (s listeningState) completion() float64 {
+	if s.TrackCount == 0 || len(s.TrackIDs) == 0 {
+		return 0
+	}
+	distinct := len(dedupeTrackIDs(s.TrackIDs))
+	var fraction float64
+	if s.Duration > 0 {
+		fraction = float64(s.Progress) / float64(s.Duration)
+	}
+	completion := (float64(distinct-1) + fraction) / float64(s.TrackCount)
+	if completion > 1 {
+		return 1
+	}
+	if completion < 0 {
+		return 0
+	}
+	return completion
+}
+
+func getListeningState(redisConn redis.Conn, spotifyID string) (*listeningState, error) {
+	stateJSON, err := redis.Bytes(redisConn.Do("GET", getListeningKey(spotifyID)))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not get listening state: %s", err)
+	}
+	var state listeningState
+	if err = json.Unmarshal(stateJSON, &state); err != nil {
+		return nil, fmt.Errorf("Could not parse listening state: %s", err)
+	}
+	return &state, nil
+}
+
+func setListeningState(redisConn redis.Conn, spotifyID string, state *listeningState) error {
+	stateJSON, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("Could not marshal listening state: %s", err)
+	}
+	_, err = redisConn.Do("SET", getListeningKey(spotifyID), stateJSON, "EX", int(listeningSessionGap.Seconds()))
+	if err != nil {
+		return fmt.Errorf("Could not save listening state: %s", err)
+	}
+	return nil
+}
+
+func getListeningKey(spotifyID string) string {
+	return fmt.Sprintf("%s:%s", listeningPrefix, spotifyID)
+}
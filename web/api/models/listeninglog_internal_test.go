@@ -0,0 +1,49 @@
+package models
+
+import (
+	"testing"
+)
+
+func Test_listeningState(t *testing.T) {
+	state := listeningState{TrackCount: 4}
+	track := func(id string, progress, duration int) Playback {
+		return Playback{
+			Type:     PlaybackItemTrack,
+			Progress: progress,
+			Track:    &SpotifyPlaybackTrack{ID: id, Duration: duration},
+		}
+	}
+	for i, test := range []struct {
+		playback Playback
+		write    bool
+	}{
+		{track("a", 1000, 200000), true},
+		{track("a", 5000, 200000), false},
+		{track("a", 195000, 200000), true},
+		{track("a", 198000, 200000), false},
+		{track("b", 2000, 200000), true},
+	} {
+		if write := state.advance(test.playback); write != test.write {
+			t.Fatalf("Expected poll %d to write %t", i, test.write)
+		}
+	}
+	if len(state.Skips) != 0 {
+		t.Fatalf("Expected no skips after playing through, got %+v", state.Skips)
+	}
+	state.advance(track("b", 50000, 200000))
+	state.advance(track("c", 1000, 100000))
+	if len(state.Skips) != 1 || state.Skips[0].TrackID != "b" || state.Skips[0].Position != 50000 {
+		t.Fatalf("Expected skip of b at 50000, got %+v", state.Skips)
+	}
+	state.advance(track("c", 50000, 100000))
+	if len(state.TrackIDs) != 3 {
+		t.Fatalf("Expected 3 tracks reached, got %v", state.TrackIDs)
+	}
+	if completion := state.completion(); completion != 2.5/4 {
+		t.Fatalf("Expected completion of %f, got %f", 2.5/4, completion)
+	}
+	state.advance(track("a", 0, 200000))
+	if completion := state.completion(); completion != 2.0/4 {
+		t.Fatalf("Expected revisited track not to count again, got %f", completion)
+	}
+}
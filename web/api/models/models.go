@@ -4,6 +4,9 @@ import (
 	"database/sql"
 	"encoding/base64"
 	"encoding/hex"
+	"fmt"
+	"image"
+	_ "image/png"
 	"io/ioutil"
 	"log"
 	"os"
@@ -21,20 +24,28 @@ import (
 var psql = sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
 
 var (
-	s3Service             *s3.S3
-	s3Uploader            *s3manager.Uploader
+	scriptStore           ScriptStore
 	postgresDB            *sql.DB
 	playlistImageBase64   string
+	logoMark              image.Image
 	isProduction          bool
 	googleAnalyticsSecret []byte
 )
 
 type Config struct {
-	IsProduction             bool
-	SpacesID                 string
-	SpacesSecret             string
-	SpacesScriptsEndpoint    string
-	SpacesScriptsRegion      string
+	IsProduction          bool
+	SpacesID              string
+	SpacesSecret          string
+	SpacesScriptsEndpoint string
+	SpacesScriptsRegion   string
+	// ScriptStore is "spaces" for an S3 compatible bucket or "local" for a
+	// directory on disk.
+	ScriptStore              string
+	ScriptsBucket            string
+	ScriptsACL               string
+	ScriptsPathStyle         bool
+	ScriptsLocalDir          string
+	ScriptsPublicURL         string
 	PostgresConnectionString string
 	PostgresMaxOpen          int
 	PostgresMaxIdle          int
@@ -55,25 +66,25 @@ func Initialize(cfg *Config) {
 		return
 	}
 	playlistImageBase64 = base64.StdEncoding.EncodeToString(playlistImage)
+	logoFile, err := os.Open(filepath.Join(exPath, "assets", "logo-p.png"))
+	if err != nil {
+		log.Fatalf("Could not open logo image: %s", err)
+		return
+	}
+	defer logoFile.Close()
+	if logoMark, _, err = image.Decode(logoFile); err != nil {
+		log.Fatalf("Could not decode logo image: %s", err)
+		return
+	}
 	isProduction = cfg.IsProduction
 	if googleAnalyticsSecret, err = hex.DecodeString(cfg.GoogleAnalyticsSecret); err != nil {
 		log.Fatalf("Could not get Google Analytics secret: %s", err)
 		return
 	}
-	s3Session, err := common.InitializeS3(&common.AWSConfig{
-		Config: &aws.Config{
-			Endpoint: aws.String(cfg.SpacesScriptsEndpoint),
-			Region:   aws.String(cfg.SpacesScriptsRegion),
-		},
-		AccessKeyID:     cfg.SpacesID,
-		SecretAccessKey: cfg.SpacesSecret,
-	})
-	if err != nil {
-		log.Fatalf("Could not initialize Spaces connection: %s", err)
+	if scriptStore, err = initializeScriptStore(cfg); err != nil {
+		log.Fatalf("Could not initialize script storage: %s", err)
 		return
 	}
-	s3Service = s3.New(s3Session)
-	s3Uploader = s3manager.NewUploader(s3Session)
 	postgresDB, err = sql.Open("postgres", cfg.PostgresConnectionString)
 	if err != nil {
 		log.Fatalf("Could not initialize Postgres: %s", err)
@@ -85,7 +96,37 @@ func Initialize(cfg *Config) {
 	initializeRefreshers(cfg.IsProduction)
 }
 
+func initializeScriptStore(cfg *Config) (ScriptStore, error) {
+	switch cfg.ScriptStore {
+	case "local":
+		return NewLocalScriptStore(cfg.ScriptsLocalDir, cfg.ScriptsPublicURL)
+	case "", "spaces":
+		s3Session, err := common.InitializeS3(&common.AWSConfig{
+			Config: &aws.Config{
+				Endpoint:         aws.String(cfg.SpacesScriptsEndpoint),
+				Region:           aws.String(cfg.SpacesScriptsRegion),
+				S3ForcePathStyle: aws.Bool(cfg.ScriptsPathStyle),
+			},
+			AccessKeyID:     cfg.SpacesID,
+			SecretAccessKey: cfg.SpacesSecret,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Could not initialize Spaces connection: %s", err)
+		}
+		return NewS3ScriptStore(S3ScriptStoreConfig{
+			Service:       s3.New(s3Session),
+			Uploader:      s3manager.NewUploader(s3Session),
+			Bucket:        cfg.ScriptsBucket,
+			ACL:           cfg.ScriptsACL,
+			PublicURLBase: cfg.ScriptsPublicURL,
+		}), nil
+	default:
+		return nil, fmt.Errorf("Unknown script store %s", cfg.ScriptStore)
+	}
+}
+
 func initializeRefreshers(isProduction bool) {
+	go refreshSearchablesOnRequest()
 	go func() {
 		logInDev := func(l string) {
 			if !isProduction {
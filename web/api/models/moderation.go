@@ -0,0 +1,384 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/samuelhorwitz/phosphorescence/api/common"
+	"github.com/satori/go.uuid"
+)
+
+type ReportReason string
+
+const (
+	ReportReasonSpam      ReportReason = "spam"
+	ReportReasonOffensive ReportReason = "offensive"
+	ReportReasonMalicious ReportReason = "malicious"
+	ReportReasonCopyright ReportReason = "copyright"
+	ReportReasonOther     ReportReason = "other"
+)
+
+type moderationAction string
+
+const (
+	moderationHideScript    moderationAction = "hide_script"
+	moderationRestoreScript moderationAction = "restore_script"
+	moderationRemoveComment moderationAction = "remove_comment"
+	moderationDismissReport moderationAction = "dismiss_report"
+)
+
+// ErrNothingToModerate is a moderation action on something which doesn't
+// exist or has already had that done to it.
+var ErrNothingToModerate = errors.New("Nothing to moderate")
+
+func ValidReportReason(reason ReportReason) bool {
+	switch reason {
+	case ReportReasonSpam, ReportReasonOffensive, ReportReasonMalicious, ReportReasonCopyright, ReportReasonOther:
+		return true
+	}
+	return false
+}
+
+// Report is a report along with enough of what was reported to act on it.
+type Report struct {
+	ID                uuid.UUID    `json:"id"`
+	ScriptID          nullUUID     `json:"scriptId"`
+	ScriptName        nullString   `json:"scriptName"`
+	CommentID         nullUUID     `json:"commentId"`
+	CommentBody       nullString   `json:"commentBody"`
+	AuthorSpotifyID   nullString   `json:"authorSpotifyId"`
+	AuthorName        nullString   `json:"authorName"`
+	ReporterSpotifyID nullString   `json:"reporterSpotifyId"`
+	Reason            ReportReason `json:"reason"`
+	Details           nullString   `json:"details"`
+	CreatedAt         time.Time    `json:"createdAt"`
+	ResolvedAt        nullTime     `json:"resolvedAt"`
+}
+
+type ModerationLogEntry struct {
+	ID                 uuid.UUID        `json:"id"`
+	ModeratorSpotifyID nullString       `json:"moderatorSpotifyId"`
+	Action             moderationAction `json:"action"`
+	ScriptID           nullUUID         `json:"scriptId"`
+	CommentID          nullUUID         `json:"commentId"`
+	ReportID           nullUUID         `json:"reportId"`
+	Reason             nullString       `json:"reason"`
+	CreatedAt          time.Time        `json:"createdAt"`
+}
+
+func ReportScript(spotifyUserID string, scriptID uuid.UUID, reason ReportReason, details string) error {
+	return report(spotifyUserID, "script_id", scriptID, reason, details)
+}
+
+func ReportComment(spotifyUserID string, commentID uuid.UUID, reason ReportReason, details string) error {
+	return report(spotifyUserID, "comment_id", commentID, reason, details)
+}
+
+func report(spotifyUserID, column string, id uuid.UUID, reason ReportReason, details string) error {
+	tx, err := postgresDB.Begin()
+	if err != nil {
+		return fmt.Errorf("Could not start transaction: %s", err)
+	}
+	userID, err := mapSpotifyIDToOurID(tx, spotifyUserID)
+	if err != nil {
+		return common.TryToRollback(tx, fmt.Errorf("Could not get user ID from Spotify ID: %s", err))
+	}
+	_, err = psql.Insert("reports").
+		Columns("id", "reporter_id", column, "reason", "details").
+		Values(uuid.NewV4(), userID, id, reason, stringOrNull(details)).
+		Suffix("on conflict do nothing").
+		RunWith(tx).Exec()
+	if err != nil {
+		return common.TryToRollback(tx, fmt.Errorf("Could not insert report: %s", err))
+	}
+	err = tx.Commit()
+	if err != nil {
+		return common.TryToRollback(tx, fmt.Errorf("Could not commit: %s", err))
+	}
+	return nil
+}
+
+// GetReports gets a page of open or resolved reports, most recent first.
+func GetReports(resolved bool, count uint64, from time.Time) (reports []Report, err error) {
+	where := sq.And{sq.Eq{"reports.resolved_at": nil}}
+	if resolved {
+		where = sq.And{sq.NotEq{"reports.resolved_at": nil}}
+	}
+	if !from.IsZero() {
+		where = append(where, sq.Lt{"reports.created_at": from})
+	}
+	rows, err := psql.Select(
+		"reports.id",
+		"reports.script_id",
+		"scripts.name",
+		"reports.comment_id",
+		"comments.body",
+		"authors.spotify_id",
+		"authors.name",
+		"reporters.spotify_id",
+		"reports.reason",
+		"reports.details",
+		"reports.created_at",
+		"reports.resolved_at").
+		From("reports_view as reports").
+		LeftJoin("scripts_view scripts on scripts.id = reports.script_id").
+		LeftJoin("comments_view comments on comments.id = reports.comment_id").
+		LeftJoin("users_view authors on authors.id = coalesce(scripts.author_id, comments.author_id)").
+		LeftJoin("users_view reporters on reporters.id = reports.reporter_id").
+		Where(where).
+		OrderBy("reports.created_at desc").
+		Limit(count).
+		RunWith(postgresDB).Query()
+	if err != nil {
+		return nil, fmt.Errorf("Could not get reports from DB: %s", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var report Report
+		err := rows.Scan(
+			&report.ID,
+			&report.ScriptID,
+			&report.ScriptName,
+			&report.CommentID,
+			&report.CommentBody,
+			&report.AuthorSpotifyID,
+			&report.AuthorName,
+			&report.ReporterSpotifyID,
+			&report.Reason,
+			&report.Details,
+			&report.CreatedAt,
+			&report.ResolvedAt)
+		if err != nil {
+			return nil, fmt.Errorf("Could not scan row: %s", err)
+		}
+		reports = append(reports, report)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("Error after scanning rows: %s", err)
+	}
+	return reports, nil
+}
+
+// HideScript takes a script out of search and the public listings, closing
+// any reports on it. Its author can still see it.
+func HideScript(adminSpotifyID string, scriptID uuid.UUID, reason string) error {
+	return setScriptHidden(adminSpotifyID, scriptID, reason, true)
+}
+
+func RestoreScript(adminSpotifyID string, scriptID uuid.UUID, reason string) error {
+	return setScriptHidden(adminSpotifyID, scriptID, reason, false)
+}
+
+func setScriptHidden(adminSpotifyID string, scriptID uuid.UUID, reason string, hide bool) error {
+	tx, err := postgresDB.Begin()
+	if err != nil {
+		return fmt.Errorf("Could not start transaction: %s", err)
+	}
+	adminID, err := mapSpotifyIDToOurID(tx, adminSpotifyID)
+	if err != nil {
+		return common.TryToRollback(tx, fmt.Errorf("Could not get user ID from Spotify ID: %s", err))
+	}
+	update := psql.Update("scripts").Where(sq.Eq{"id": scriptID, "deleted_at": nil})
+	action, notification := moderationRestoreScript, notificationScriptRestored
+	if hide {
+		update = update.Set("hidden_at", sq.Expr("now()")).Where(sq.Eq{"hidden_at": nil})
+		action, notification = moderationHideScript, notificationScriptHidden
+	} else {
+		update = update.Set("hidden_at", nil).Where(sq.NotEq{"hidden_at": nil})
+	}
+	var authorID uuid.UUID
+	err = update.Suffix("returning author_id").RunWith(tx).QueryRow().Scan(&authorID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return common.TryToRollback(tx, ErrNothingToModerate)
+		}
+		return common.TryToRollback(tx, fmt.Errorf("Could not update script: %s", err))
+	}
+	if hide {
+		if err = resolveReports(tx, "script_id", scriptID); err != nil {
+			return common.TryToRollback(tx, err)
+		}
+	}
+	scriptNullID := uuid.NullUUID{UUID: scriptID, Valid: true}
+	if err = logModeration(tx, adminID, action, scriptNullID, uuid.NullUUID{}, uuid.NullUUID{}, reason); err != nil {
+		return common.TryToRollback(tx, err)
+	}
+	if err = createNotification(tx, authorID, notification, scriptNullID, uuid.NullUUID{}, uuid.NullUUID{}, reason); err != nil {
+		return common.TryToRollback(tx, err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return common.TryToRollback(tx, fmt.Errorf("Could not commit: %s", err))
+	}
+	// Search would otherwise keep showing a hidden script until the next
+	// scheduled refresh.
+	requestSearchablesRefresh()
+	return nil
+}
+
+// RemoveComment deletes a comment as a moderator, closing any reports on it.
+func RemoveComment(adminSpotifyID string, commentID uuid.UUID, reason string) error {
+	tx, err := postgresDB.Begin()
+	if err != nil {
+		return fmt.Errorf("Could not start transaction: %s", err)
+	}
+	adminID, err := mapSpotifyIDToOurID(tx, adminSpotifyID)
+	if err != nil {
+		return common.TryToRollback(tx, fmt.Errorf("Could not get user ID from Spotify ID: %s", err))
+	}
+	var authorID uuid.UUID
+	var scriptID, scriptChainID uuid.NullUUID
+	err = psql.Update("comments").
+		Set("deleted_at", sq.Expr("now()")).
+		Set("deleted_by_moderator", true).
+		Where(sq.Eq{"id": commentID, "deleted_at": nil}).
+		Suffix("returning author_id, script_id, script_chain_id").
+		RunWith(tx).QueryRow().Scan(&authorID, &scriptID, &scriptChainID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return common.TryToRollback(tx, ErrNothingToModerate)
+		}
+		return common.TryToRollback(tx, fmt.Errorf("Could not update comment: %s", err))
+	}
+	if err = resolveReports(tx, "comment_id", commentID); err != nil {
+		return common.TryToRollback(tx, err)
+	}
+	commentNullID := uuid.NullUUID{UUID: commentID, Valid: true}
+	if err = logModeration(tx, adminID, moderationRemoveComment, uuid.NullUUID{}, commentNullID, uuid.NullUUID{}, reason); err != nil {
+		return common.TryToRollback(tx, err)
+	}
+	if err = createNotification(tx, authorID, notificationCommentRemoved, scriptID, scriptChainID, commentNullID, reason); err != nil {
+		return common.TryToRollback(tx, err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return common.TryToRollback(tx, fmt.Errorf("Could not commit: %s", err))
+	}
+	return nil
+}
+
+// DismissReport closes a report without doing anything about it.
+func DismissReport(adminSpotifyID string, reportID uuid.UUID, reason string) error {
+	tx, err := postgresDB.Begin()
+	if err != nil {
+		return fmt.Errorf("Could not start transaction: %s", err)
+	}
+	adminID, err := mapSpotifyIDToOurID(tx, adminSpotifyID)
+	if err != nil {
+		return common.TryToRollback(tx, fmt.Errorf("Could not get user ID from Spotify ID: %s", err))
+	}
+	res, err := psql.Update("reports").
+		Set("resolved_at", sq.Expr("now()")).
+		Where(sq.Eq{"id": reportID, "resolved_at": nil}).
+		RunWith(tx).Exec()
+	if err != nil {
+		return common.TryToRollback(tx, fmt.Errorf("Could not update report: %s", err))
+	}
+	if updated, err := res.RowsAffected(); err != nil || updated == 0 {
+		return common.TryToRollback(tx, ErrNothingToModerate)
+	}
+	if err = logModeration(tx, adminID, moderationDismissReport, uuid.NullUUID{}, uuid.NullUUID{}, uuid.NullUUID{UUID: reportID, Valid: true}, reason); err != nil {
+		return common.TryToRollback(tx, err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return common.TryToRollback(tx, fmt.Errorf("Could not commit: %s", err))
+	}
+	return nil
+}
+
+// GetModerationLog gets a page of moderation actions, most recent first.
+func GetModerationLog(count uint64, from time.Time) (entries []ModerationLogEntry, err error) {
+	where := sq.And{}
+	if !from.IsZero() {
+		where = append(where, sq.Lt{"moderation_log.created_at": from})
+	}
+	rows, err := psql.Select(
+		"moderation_log.id",
+		"moderators.spotify_id",
+		"moderation_log.action",
+		"moderation_log.script_id",
+		"moderation_log.comment_id",
+		"moderation_log.report_id",
+		"moderation_log.reason",
+		"moderation_log.created_at").
+		From("moderation_log_view as moderation_log").
+		LeftJoin("users_view moderators on moderators.id = moderation_log.moderator_id").
+		Where(where).
+		OrderBy("moderation_log.created_at desc").
+		Limit(count).
+		RunWith(postgresDB).Query()
+	if err != nil {
+		return nil, fmt.Errorf("Could not get moderation log from DB: %s", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var entry ModerationLogEntry
+		err := rows.Scan(
+			&entry.ID,
+			&entry.ModeratorSpotifyID,
+			&entry.Action,
+			&entry.ScriptID,
+			&entry.CommentID,
+			&entry.ReportID,
+			&entry.Reason,
+			&entry.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("Could not scan row: %s", err)
+		}
+		entries = append(entries, entry)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("Error after scanning rows: %s", err)
+	}
+	return entries, nil
+}
+
+func resolveReports(tx *sql.Tx, column string, id uuid.UUID) error {
+	_, err := psql.Update("reports").
+		Set("resolved_at", sq.Expr("now()")).
+		Where(sq.Eq{column: id, "resolved_at": nil}).
+		RunWith(tx).Exec()
+	if err != nil {
+		return fmt.Errorf("Could not resolve reports: %s", err)
+	}
+	return nil
+}
+
+func logModeration(tx *sql.Tx, moderatorID uuid.UUID, action moderationAction, scriptID, commentID, reportID uuid.NullUUID, reason string) error {
+	_, err := psql.Insert("moderation_log").
+		Columns("id", "moderator_id", "action", "script_id", "comment_id", "report_id", "reason").
+		Values(uuid.NewV4(), moderatorID, action, scriptID, commentID, reportID, stringOrNull(reason)).
+		RunWith(tx).Exec()
+	if err != nil {
+		return fmt.Errorf("Could not log moderation: %s", err)
+	}
+	return nil
+}
+
+// searchablesRefresh holds at most one pending refresh, so a burst of
+// moderation only refreshes once more after whatever refresh is running.
+var searchablesRefresh = make(chan struct{}, 1)
+
+func requestSearchablesRefresh() {
+	select {
+	case searchablesRefresh <- struct{}{}:
+	default:
+	}
+}
+
+// refreshSearchablesOnRequest refreshes concurrently so search keeps working
+// from the old contents while the view is rebuilt.
+func refreshSearchablesOnRequest() {
+	for range searchablesRefresh {
+		if _, err := postgresDB.Exec("refresh materialized view concurrently searchables"); err != nil {
+			log.Printf("Could not refresh searchables: %s", err)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package models
+
+import "testing"
+
+func Test_requestSearchablesRefresh(t *testing.T) {
+	for i := 0; i < 3; i++ {
+		requestSearchablesRefresh()
+	}
+	if pending := len(searchablesRefresh); pending != 1 {
+		t.Errorf("Expected a burst of requests to leave 1 pending refresh, got %d", pending)
+	}
+	<-searchablesRefresh
+	requestSearchablesRefresh()
+	if pending := len(searchablesRefresh); pending != 1 {
+		t.Errorf("Expected a request after a refresh started to be pending, got %d", pending)
+	}
+	<-searchablesRefresh
+}
+
+func Test_ValidReportReason(t *testing.T) {
+	for _, reason := range []ReportReason{ReportReasonSpam, ReportReasonOffensive, ReportReasonMalicious, ReportReasonCopyright, ReportReasonOther} {
+		if !ValidReportReason(reason) {
+			t.Errorf("Expected %s to be a valid report reason", reason)
+		}
+	}
+	for _, reason := range []ReportReason{"", "boring", "SPAM"} {
+		if ValidReportReason(reason) {
+			t.Errorf("Expected %q to be an invalid report reason", reason)
+		}
+	}
+}
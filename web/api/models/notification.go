@@ -0,0 +1,103 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/satori/go.uuid"
+)
+
+type notificationType string
+
+const (
+	notificationScriptHidden   notificationType = "script_hidden"
+	notificationScriptRestored notificationType = "script_restored"
+	notificationCommentRemoved notificationType = "comment_removed"
+	notificationScriptInvite   notificationType = "script_invite"
+)
+
+type Notification struct {
+	ID            uuid.UUID        `json:"id"`
+	Type          notificationType `json:"type"`
+	ScriptID      nullUUID         `json:"scriptId"`
+	ScriptChainID nullUUID         `json:"scriptChainId"`
+	CommentID     nullUUID         `json:"commentId"`
+	Message       nullString       `json:"message"`
+	CreatedAt     time.Time        `json:"createdAt"`
+	ReadAt        nullTime         `json:"readAt"`
+}
+
+// GetNotifications gets a page of the user's notifications, most recent
+// first.
+func GetNotifications(spotifyUserID string, count uint64, from time.Time) (notifications []Notification, err error) {
+	where := sq.And{sq.Eq{"users.spotify_id": spotifyUserID}}
+	if !from.IsZero() {
+		where = append(where, sq.Lt{"notifications.created_at": from})
+	}
+	rows, err := psql.Select(
+		"notifications.id",
+		"notifications.type",
+		"notifications.script_id",
+		"notifications.script_chain_id",
+		"notifications.comment_id",
+		"notifications.message",
+		"notifications.created_at",
+		"notifications.read_at").
+		From("notifications_view as notifications").
+		Join("users_view users on users.id = notifications.user_id").
+		Where(where).
+		OrderBy("notifications.created_at desc").
+		Limit(count).
+		RunWith(postgresDB).Query()
+	if err != nil {
+		return nil, fmt.Errorf("Could not get notifications from DB: %s", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var notification Notification
+		err := rows.Scan(
+			&notification.ID,
+			&notification.Type,
+			&notification.ScriptID,
+			&notification.ScriptChainID,
+			&notification.CommentID,
+			&notification.Message,
+			&notification.CreatedAt,
+			&notification.ReadAt)
+		if err != nil {
+			return nil, fmt.Errorf("Could not scan row: %s", err)
+		}
+		notifications = append(notifications, notification)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("Error after scanning rows: %s", err)
+	}
+	return notifications, nil
+}
+
+// MarkNotificationsRead marks everything the user has been notified of so far
+// as read.
+func MarkNotificationsRead(spotifyUserID string) error {
+	_, err := postgresDB.Exec(`
+		update notifications set read_at = now()
+		from users_view users
+		where users.id = notifications.user_id and users.spotify_id = $1 and notifications.read_at is null`, spotifyUserID)
+	if err != nil {
+		return fmt.Errorf("Could not mark notifications read: %s", err)
+	}
+	return nil
+}
+
+func createNotification(tx *sql.Tx, userID uuid.UUID, typ notificationType, scriptID, scriptChainID, commentID uuid.NullUUID, message string) error {
+	_, err := psql.Insert("notifications").
+		Columns("id", "user_id", "type", "script_id", "script_chain_id", "comment_id", "message").
+		Values(uuid.NewV4(), userID, typ, scriptID, scriptChainID, commentID, stringOrNull(message)).
+		RunWith(tx).Exec()
+	if err != nil {
+		return fmt.Errorf("Could not insert notification: %s", err)
+	}
+	return nil
+}
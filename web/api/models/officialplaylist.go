@@ -0,0 +1,213 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/lib/pq"
+	"github.com/satori/go.uuid"
+	"golang.org/x/oauth2"
+
+	"github.com/samuelhorwitz/phosphorescence/api/common"
+	"github.com/samuelhorwitz/phosphorescence/api/spotifyclient"
+)
+
+type OfficialPlaylist struct {
+	ID         uuid.UUID `json:"id"`
+	PlaylistID string    `json:"playlistId"`
+	Title      string    `json:"title"`
+	Tags       []string  `json:"tags"`
+	PromotedAt time.Time `json:"promotedAt"`
+}
+
+var (
+	ErrPlaylistAlreadyOfficial = errors.New("Playlist is already official")
+	ErrPlaylistNotOfficial     = errors.New("Playlist is not official")
+)
+
+func GetOfficialPlaylists(count uint64, from time.Time) (playlists []OfficialPlaylist, err error) {
+	where := sq.And{}
+	if !from.IsZero() {
+		where = append(where, sq.Lt{"promoted_at": from})
+	}
+	rows, err := psql.Select("id", "playlist_id", "title", "tags", "promoted_at").
+		From("official_playlists_view").
+		Where(where).
+		OrderBy("promoted_at desc").
+		Limit(count).
+		RunWith(postgresDB).Query()
+	if err != nil {
+		return nil, fmt.Errorf("Could not get official playlists from DB: %s", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var playlist OfficialPlaylist
+		err := rows.Scan(&playlist.ID, &playlist.PlaylistID, &playlist.Title, pq.Array(&playlist.Tags), &playlist.PromotedAt)
+		if err != nil {
+			return nil, fmt.Errorf("Could not scan row: %s", err)
+		}
+		playlists = append(playlists, playlist)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("Error after scanning rows: %s", err)
+	}
+	return playlists, nil
+}
+
+// PromoteOfficialPlaylist follows the playlist from the Phosphorescence account,
+// makes it public and records it as official. If title is empty the playlist's
+// name on Spotify is used. The record is inserted before touching Spotify, so a
+// concurrent promotion of the same playlist waits on it and then gives up, but
+// it is only committed once Spotify has been updated. Anything done on Spotify
+// is undone if the record can't be kept.
+func PromoteOfficialPlaylist(ctx context.Context, spotifyUserID, playlistID, title string, tags []string) (OfficialPlaylist, error) {
+	isOfficial, err := isPlaylistOfficial(playlistID)
+	if err != nil {
+		return OfficialPlaylist{}, err
+	}
+	if isOfficial {
+		return OfficialPlaylist{}, ErrPlaylistAlreadyOfficial
+	}
+	phosphorescenceToken, err := spotifyclient.GetAppUserToken()
+	if err != nil {
+		return OfficialPlaylist{}, fmt.Errorf("Could not get Spotify application user token: %s", err)
+	}
+	if title == "" {
+		spotifyPlaylist, err := getSpotifyPlaylist(ctx, phosphorescenceToken, "", playlistID)
+		if err != nil {
+			return OfficialPlaylist{}, fmt.Errorf("Could not get Spotify playlist: %s", err)
+		}
+		title = spotifyPlaylist.Name
+	}
+	if tags == nil {
+		tags = []string{}
+	}
+	playlist := OfficialPlaylist{
+		ID:         uuid.NewV4(),
+		PlaylistID: playlistID,
+		Title:      title,
+		Tags:       tags,
+	}
+	tx, err := postgresDB.Begin()
+	if err != nil {
+		return OfficialPlaylist{}, fmt.Errorf("Could not begin transaction: %s", err)
+	}
+	userID, err := mapSpotifyIDToOurID(tx, spotifyUserID)
+	if err != nil {
+		return OfficialPlaylist{}, common.TryToRollback(tx, fmt.Errorf("Could not get user ID from Spotify ID: %s", err))
+	}
+	err = psql.Insert("official_playlists").
+		Columns("id", "playlist_id", "title", "tags", "promoted_by").
+		Values(playlist.ID, playlist.PlaylistID, playlist.Title, pq.Array(playlist.Tags), userID).
+		Suffix("on conflict (playlist_id) where retired_at is null do nothing returning promoted_at").
+		RunWith(tx).QueryRow().Scan(&playlist.PromotedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return OfficialPlaylist{}, common.TryToRollback(tx, ErrPlaylistAlreadyOfficial)
+		}
+		return OfficialPlaylist{}, common.TryToRollback(tx, fmt.Errorf("Could not insert official playlist: %s", err))
+	}
+	err = followPlaylist(ctx, phosphorescenceToken, playlistID)
+	if err != nil {
+		return OfficialPlaylist{}, common.TryToRollback(tx, fmt.Errorf("Failed to follow playlist: %s", err))
+	}
+	err = setPlaylistPublic(ctx, phosphorescenceToken, playlistID, true)
+	if err != nil {
+		return OfficialPlaylist{}, common.TryToRollback(tx, undoPromotion(ctx, phosphorescenceToken, playlistID, fmt.Errorf("Failed to make playlist public: %s", err)))
+	}
+	if err = tx.Commit(); err != nil {
+		return OfficialPlaylist{}, common.TryToRollback(tx, undoPromotion(ctx, phosphorescenceToken, playlistID, fmt.Errorf("Could not commit: %s", err)))
+	}
+	return playlist, nil
+}
+
+// undoPromotion takes back what promoting did on Spotify after it failed,
+// returning why it failed along with anything that went wrong undoing it.
+func undoPromotion(ctx context.Context, phosphorescenceToken *oauth2.Token, playlistID string, cause error) error {
+	if err := unpromotePlaylist(ctx, phosphorescenceToken, playlistID); err != nil {
+		return fmt.Errorf("%s, and could not undo promotion: %s", cause, err)
+	}
+	return cause
+}
+
+// unpromotePlaylist makes the playlist private and unfollows it. A playlist
+// must be made private before it is unfollowed, afterwards we are no longer
+// allowed to change it.
+func unpromotePlaylist(ctx context.Context, phosphorescenceToken *oauth2.Token, playlistID string) error {
+	err := setPlaylistPublic(ctx, phosphorescenceToken, playlistID, false)
+	if err != nil {
+		return fmt.Errorf("Failed to make playlist private: %s", err)
+	}
+	err = unfollowPlaylist(ctx, phosphorescenceToken, playlistID)
+	if err != nil {
+		return fmt.Errorf("Failed to unfollow playlist: %s", err)
+	}
+	return nil
+}
+
+// RetireOfficialPlaylist undoes everything PromoteOfficialPlaylist did on
+// Spotify and marks the playlist as retired. The row is kept for auditing.
+func RetireOfficialPlaylist(ctx context.Context, spotifyUserID, playlistID string) error {
+	isOfficial, err := isPlaylistOfficial(playlistID)
+	if err != nil {
+		return err
+	}
+	if !isOfficial {
+		return ErrPlaylistNotOfficial
+	}
+	phosphorescenceToken, err := spotifyclient.GetAppUserToken()
+	if err != nil {
+		return fmt.Errorf("Could not get Spotify application user token: %s", err)
+	}
+	tx, err := postgresDB.Begin()
+	if err != nil {
+		return fmt.Errorf("Could not begin transaction: %s", err)
+	}
+	userID, err := mapSpotifyIDToOurID(tx, spotifyUserID)
+	if err != nil {
+		return common.TryToRollback(tx, fmt.Errorf("Could not get user ID from Spotify ID: %s", err))
+	}
+	res, err := psql.Update("official_playlists").
+		Set("retired_by", userID).
+		Set("retired_at", sq.Expr("now()")).
+		Where(sq.Eq{
+			"playlist_id": playlistID,
+			"retired_at":  nil,
+		}).
+		RunWith(tx).Exec()
+	if err != nil {
+		return common.TryToRollback(tx, fmt.Errorf("Could not mark official playlist as retired: %s", err))
+	}
+	if retired, err := res.RowsAffected(); err != nil || retired == 0 {
+		return common.TryToRollback(tx, ErrPlaylistNotOfficial)
+	}
+	// Once unfollowed we can't touch the playlist again, so the row is only
+	// retired if Spotify was updated and stays official for a retry otherwise.
+	if err = unpromotePlaylist(ctx, phosphorescenceToken, playlistID); err != nil {
+		return common.TryToRollback(tx, err)
+	}
+	if err = tx.Commit(); err != nil {
+		return common.TryToRollback(tx, fmt.Errorf("Could not commit: %s", err))
+	}
+	return nil
+}
+
+func isPlaylistOfficial(playlistID string) (bool, error) {
+	var id uuid.UUID
+	err := psql.Select("id").
+		From("official_playlists_view").
+		Where(sq.Eq{"playlist_id": playlistID}).
+		RunWith(postgresDB).QueryRow().Scan(&id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, fmt.Errorf("Could not check if playlist is official: %s", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,185 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/samuelhorwitz/phosphorescence/api/common"
+	"github.com/samuelhorwitz/phosphorescence/api/session"
+)
+
+// Spotify's player error reasons, see
+// https://developer.spotify.com/documentation/web-api/reference/object-model/#player-error-reasons
+const (
+	PlayerErrorNoPreviousTrack       = "NO_PREV_TRACK"
+	PlayerErrorNoNextTrack           = "NO_NEXT_TRACK"
+	PlayerErrorNoSpecificTrack       = "NO_SPECIFIC_TRACK"
+	PlayerErrorAlreadyPaused         = "ALREADY_PAUSED"
+	PlayerErrorNotPaused             = "NOT_PAUSED"
+	PlayerErrorNotPlayingLocally     = "NOT_PLAYING_LOCALLY"
+	PlayerErrorNotPlayingTrack       = "NOT_PLAYING_TRACK"
+	PlayerErrorNotPlayingContext     = "NOT_PLAYING_CONTEXT"
+	PlayerErrorEndlessContext        = "ENDLESS_CONTEXT"
+	PlayerErrorContextDisallow       = "CONTEXT_DISALLOW"
+	PlayerErrorAlreadyPlaying        = "ALREADY_PLAYING"
+	PlayerErrorRateLimited           = "RATE_LIMITED"
+	PlayerErrorRemoteControlDisallow = "REMOTE_CONTROL_DISALLOW"
+	PlayerErrorDeviceNotControllable = "DEVICE_NOT_CONTROLLABLE"
+	PlayerErrorVolumeControlDisallow = "VOLUME_CONTROL_DISALLOW"
+	PlayerErrorNoActiveDevice        = "NO_ACTIVE_DEVICE"
+	PlayerErrorPremiumRequired       = "PREMIUM_REQUIRED"
+	PlayerErrorUnknown               = "UNKNOWN"
+)
+
+// SpotifyPlayerError is returned when Spotify refuses a player command. Reason
+// is one of the PlayerError constants, or empty if Spotify didn't give one.
+type SpotifyPlayerError struct {
+	Status  int    `json:"status"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+func (e SpotifyPlayerError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("Spotify player request responded with %d: %s", e.Status, e.Message)
+	}
+	return fmt.Sprintf("Spotify player request responded with %d (%s): %s", e.Status, e.Reason, e.Message)
+}
+
+type RepeatState string
+
+const (
+	RepeatStateTrack   RepeatState = "track"
+	RepeatStateContext RepeatState = "context"
+	RepeatStateOff     RepeatState = "off"
+)
+
+// PlayOptions describes what to start playing. Either URIs (a list of tracks)
+// or ContextURI (a playlist or album) may be set, not both. With neither set
+// whatever was playing is resumed. The offset is only meaningful for a context
+// or a list of URIs, and is either a position or a track URI.
+type PlayOptions struct {
+	URIs                 []string
+	ContextURI           string
+	OffsetPosition       *int
+	OffsetURI            string
+	PositionMilliseconds int
+}
+
+func Play(ctx context.Context, sess *session.Session, deviceID string, opts PlayOptions) error {
+	var body struct {
+		ContextURI string   `json:"context_uri,omitempty"`
+		URIs       []string `json:"uris,omitempty"`
+		Offset     *struct {
+			Position *int   `json:"position,omitempty"`
+			URI      string `json:"uri,omitempty"`
+		} `json:"offset,omitempty"`
+		PositionMilliseconds int `json:"position_ms,omitempty"`
+	}
+	body.ContextURI = opts.ContextURI
+	body.URIs = opts.URIs
+	body.PositionMilliseconds = opts.PositionMilliseconds
+	if opts.OffsetPosition != nil || opts.OffsetURI != "" {
+		body.Offset = &struct {
+			Position *int   `json:"position,omitempty"`
+			URI      string `json:"uri,omitempty"`
+		}{opts.OffsetPosition, opts.OffsetURI}
+	}
+	return playerRequest(ctx, sess, "PUT", "play", deviceID, nil, body)
+}
+
+// Resume continues whatever was playing, without a body Spotify's play
+// endpoint doesn't change the queue.
+func Resume(ctx context.Context, sess *session.Session, deviceID string) error {
+	return playerRequest(ctx, sess, "PUT", "play", deviceID, nil, nil)
+}
+
+func PausePlayback(ctx context.Context, sess *session.Session, deviceID string) error {
+	return playerRequest(ctx, sess, "PUT", "pause", deviceID, nil, nil)
+}
+
+func SkipToNext(ctx context.Context, sess *session.Session, deviceID string) error {
+	return playerRequest(ctx, sess, "POST", "next", deviceID, nil, nil)
+}
+
+func SkipToPrevious(ctx context.Context, sess *session.Session, deviceID string) error {
+	return playerRequest(ctx, sess, "POST", "previous", deviceID, nil, nil)
+}
+
+func Seek(ctx context.Context, sess *session.Session, deviceID string, positionMilliseconds int) error {
+	return playerRequest(ctx, sess, "PUT", "seek", deviceID, url.Values{"position_ms": {strconv.Itoa(positionMilliseconds)}}, nil)
+}
+
+func SetVolume(ctx context.Context, sess *session.Session, deviceID string, volumePercent int) error {
+	return playerRequest(ctx, sess, "PUT", "volume", deviceID, url.Values{"volume_percent": {strconv.Itoa(volumePercent)}}, nil)
+}
+
+func SetShuffle(ctx context.Context, sess *session.Session, deviceID string, shuffle bool) error {
+	return playerRequest(ctx, sess, "PUT", "shuffle", deviceID, url.Values{"state": {strconv.FormatBool(shuffle)}}, nil)
+}
+
+func SetRepeat(ctx context.Context, sess *session.Session, deviceID string, repeatState RepeatState) error {
+	return playerRequest(ctx, sess, "PUT", "repeat", deviceID, url.Values{"state": {string(repeatState)}}, nil)
+}
+
+// playerRequest makes a request against https://api.spotify.com/v1/me/player/
+// and turns any error Spotify responds with into a SpotifyPlayerError.
+func playerRequest(ctx context.Context, sess *session.Session, method, endpoint, deviceID string, query url.Values, body interface{}) error {
+	if query == nil {
+		query = url.Values{}
+	}
+	if deviceID != "" {
+		query.Set("device_id", deviceID)
+	}
+	endpointURL := fmt.Sprintf("https://api.spotify.com/v1/me/player/%s", endpoint)
+	if len(query) > 0 {
+		endpointURL = fmt.Sprintf("%s?%s", endpointURL, query.Encode())
+	}
+	var reqBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+			return fmt.Errorf("Could not build Spotify player %s request body: %s", endpoint, err)
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpointURL, &reqBody)
+	if err != nil {
+		return fmt.Errorf("Could not build Spotify player %s request: %s", endpoint, err)
+	}
+	sess.SpotifyToken.SetAuthHeader(req)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	res, err := common.SpotifyClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Could not make Spotify player %s request: %s", endpoint, err)
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusAccepted, http.StatusNoContent:
+		return nil
+	}
+	return parsePlayerError(res)
+}
+
+func parsePlayerError(res *http.Response) error {
+	playerErr := SpotifyPlayerError{Status: res.StatusCode}
+	resBody, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return playerErr
+	}
+	var parsedBody struct {
+		Error SpotifyPlayerError `json:"error"`
+	}
+	if err = json.Unmarshal(resBody, &parsedBody); err != nil {
+		return playerErr
+	}
+	playerErr.Reason = parsedBody.Error.Reason
+	playerErr.Message = parsedBody.Error.Message
+	return playerErr
+}
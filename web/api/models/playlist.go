@@ -6,10 +6,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"net/http"
 	"time"
 
 	"github.com/samuelhorwitz/phosphorescence/api/common"
+	"github.com/samuelhorwitz/phosphorescence/api/playlistimage"
 	"github.com/samuelhorwitz/phosphorescence/api/session"
 	"github.com/samuelhorwitz/phosphorescence/api/spotifyclient"
 	"golang.org/x/oauth2"
@@ -42,6 +44,9 @@ type Playlist struct {
 	Owner       SpotifyUser             `json:"owner"`
 	Images      []SpotifyImage          `json:"images"`
 	Tracks      []*SpotifyTrackEnvelope `json:"tracks"`
+	// trackOrder is the ID of every item in the playlist, including the ones
+	// left out of Tracks, so positions can be reported as Spotify shows them.
+	trackOrder []string
 }
 
 const maxTracksPerRequest = 500
@@ -72,11 +77,20 @@ func GetSimplePlaylist(ctx context.Context, playlistID string) (*Playlist, error
 	return playlist, nil
 }
 
-func CreatePlaylist(ctx context.Context, firstTrackName string, base64Image string, utcOffsetMinutes int, trackURIs []string) (string, error) {
+// CreatePlaylist makes a new playlist owned by the Phosphorescence account. If
+// no usable image is supplied a cover is generated from the tracks themselves,
+// aetherealness is optional and should either be empty or have a value for
+// every track. sess may be nil when the playlist is created without a session.
+func CreatePlaylist(ctx context.Context, sess *session.Session, generator PlaylistGenerator, firstTrackName string, base64Image string, utcOffsetMinutes int, trackURIs []string, aetherealness []float64) (string, error) {
 	phosphorescenceToken, err := spotifyclient.GetAppUserToken()
 	if err != nil {
 		return "", fmt.Errorf("Could not get Spotify application user token: %s", err)
 	}
+	var region string
+	if sess != nil {
+		region = sess.SpotifyCountry
+	}
+	base64Image = preparePlaylistImage(ctx, region, base64Image, trackURIs, aetherealness)
 	createdPlaylistID, err := createPlaylist(ctx, phosphorescenceToken, firstTrackName, utcOffsetMinutes)
 	if err != nil {
 		return "", fmt.Errorf("Could not create playlist: %s", err)
@@ -93,29 +107,26 @@ func CreatePlaylist(ctx context.Context, firstTrackName string, base64Image stri
 	if err != nil {
 		return "", fmt.Errorf("Could not unfollow playlist: %s", err)
 	}
+	// The playlist exists on Spotify at this point so failing the request would
+	// not help anyone, but an untracked playlist will never be cleaned up so we
+	// always want to hear about it.
+	if err = recordCreatedPlaylist(sess, generator, createdPlaylistID, len(trackURIs)); err != nil {
+		log.Printf("Could not record created playlist %s: %s", createdPlaylistID, err)
+	}
 	return createdPlaylistID, nil
 }
 
-func MakePlaylistPublic(ctx context.Context, playlistID string) error {
-	phosphorescenceToken, err := spotifyclient.GetAppUserToken()
-	if err != nil {
-		return fmt.Errorf("Could not get Spotify application user token: %s", err)
-	}
-	err = followPlaylist(ctx, phosphorescenceToken, playlistID)
+func FollowPlaylist(ctx context.Context, sess *session.Session, playlistID string) error {
+	err := followPlaylist(ctx, sess.SpotifyToken, playlistID)
 	if err != nil {
-		return fmt.Errorf("Failed to follow playlist: %s", err)
+		return err
 	}
-	err = makePlaylistPublic(ctx, phosphorescenceToken, playlistID)
-	if err != nil {
-		return fmt.Errorf("Failed to make playlist public: %s", err)
+	if err = recordCreatedPlaylistFollowed(playlistID); err != nil {
+		log.Printf("Could not record created playlist %s as followed: %s", playlistID, err)
 	}
 	return nil
 }
 
-func FollowPlaylist(ctx context.Context, sess *session.Session, playlistID string) error {
-	return followPlaylist(ctx, sess.SpotifyToken, playlistID)
-}
-
 func getPlaylist(ctx context.Context, token *oauth2.Token, region, playlistID string) (*Playlist, error) {
 	spotifyPlaylist, err := getSpotifyPlaylist(ctx, token, region, playlistID)
 	if err != nil {
@@ -131,7 +142,7 @@ func getPlaylist(ctx context.Context, token *oauth2.Token, region, playlistID st
 	if spotifyPlaylist.Tracks.Total > maxTracksPerRequest {
 		return nil, ErrTooManyTracks
 	}
-	playlist.Tracks, err = getSpotifyPlaylistTracks(ctx, token, region, spotifyPlaylist)
+	playlist.Tracks, playlist.trackOrder, err = getSpotifyPlaylistTracks(ctx, token, region, spotifyPlaylist)
 	if err != nil {
 		return nil, fmt.Errorf("Could not get track data for playlist tracks: %s", err)
 	}
@@ -175,32 +186,45 @@ func getSpotifyPlaylist(ctx context.Context, token *oauth2.Token, region, playli
 	return &playlistData, nil
 }
 
-func getSpotifyPlaylistTracks(ctx context.Context, token *oauth2.Token, region string, spotifyPlaylist *SpotifyPlaylist) (trackData []*SpotifyTrackEnvelope, err error) {
+func getSpotifyPlaylistTracks(ctx context.Context, token *oauth2.Token, region string, spotifyPlaylist *SpotifyPlaylist) (trackData []*SpotifyTrackEnvelope, order []string, err error) {
 	trackPage := spotifyPlaylist.Tracks
 	for true {
-		for _, playlistTrack := range trackPage.Items {
-			if playlistTrack.IsLocal {
-				continue
-			}
-			track := playlistTrack.Track
-			if region != "" && !track.IsPlayable {
-				continue
-			}
-			trackData = append(trackData, &SpotifyTrackEnvelope{
-				ID:    track.ID,
-				Track: &track,
-			})
-		}
+		pageTracks, pageOrder := playlistPageTracks(trackPage.Items, region)
+		trackData = append(trackData, pageTracks...)
+		order = append(order, pageOrder...)
 		if trackPage.Next != "" {
 			trackPage, err = getSpotifyPlaylistTrackPage(ctx, token, trackPage.Next)
 			if err != nil {
-				return nil, fmt.Errorf("Could not get next playlist track page: %s", err)
+				return nil, nil, fmt.Errorf("Could not get next playlist track page: %s", err)
 			}
 		} else {
 			break
 		}
 	}
-	return trackData, nil
+	return trackData, order, nil
+}
+
+// playlistPageTracks leaves out local tracks and, when there is a region,
+// tracks which can't be played there. order has every item's ID regardless,
+// local tracks have none.
+func playlistPageTracks(items []SpotifyPlaylistTrack, region string) (trackData []*SpotifyTrackEnvelope, order []string) {
+	for _, playlistTrack := range items {
+		if playlistTrack.IsLocal {
+			order = append(order, "")
+			continue
+		}
+		track := playlistTrack.Track
+		envelope := &SpotifyTrackEnvelope{
+			ID:    track.ID,
+			Track: &track,
+		}
+		order = append(order, envelope.OriginalID())
+		if region != "" && !track.IsPlayable {
+			continue
+		}
+		trackData = append(trackData, envelope)
+	}
+	return trackData, order
 }
 
 func getSpotifyPlaylistTrackPage(ctx context.Context, token *oauth2.Token, pageURL string) (SpotifyPlaylistTrackPaging, error) {
@@ -296,13 +320,32 @@ func addTracksToPlaylist(ctx context.Context, token *oauth2.Token, playlistID st
 	return nil
 }
 
-func setPlaylistImage(ctx context.Context, token *oauth2.Token, playlistID string, base64Image string) error {
-	var buf *bytes.Buffer
+// preparePlaylistImage turns whatever the client uploaded into a JPEG Spotify
+// will accept. If there is nothing usable we generate a cover from the tracks,
+// and if even that fails we use the default image rather than failing the
+// whole playlist.
+func preparePlaylistImage(ctx context.Context, region, base64Image string, trackURIs []string, aetherealness []float64) string {
 	if base64Image != "" {
-		buf = bytes.NewBuffer([]byte(base64Image))
-	} else {
-		buf = bytes.NewBuffer([]byte(playlistImageBase64))
+		processedImage, err := playlistimage.Process(base64Image)
+		if err == nil {
+			return processedImage
+		}
+		if !isProduction {
+			log.Printf("Could not process playlist image, generating one: %s", err)
+		}
+	}
+	generatedImage, err := generatePlaylistImage(ctx, region, trackURIs, aetherealness)
+	if err != nil {
+		if !isProduction {
+			log.Printf("Could not generate playlist image, using default: %s", err)
+		}
+		return playlistImageBase64
 	}
+	return generatedImage
+}
+
+func setPlaylistImage(ctx context.Context, token *oauth2.Token, playlistID string, base64Image string) error {
+	buf := bytes.NewBuffer([]byte(base64Image))
 	req, err := http.NewRequestWithContext(ctx, "PUT", fmt.Sprintf("https://api.spotify.com/v1/playlists/%s/images", playlistID), buf)
 	if err != nil {
 		return fmt.Errorf("Could not build Spotify change image request: %s", err)
@@ -355,11 +398,11 @@ func unfollowPlaylist(ctx context.Context, token *oauth2.Token, playlistID strin
 	return nil
 }
 
-func makePlaylistPublic(ctx context.Context, token *oauth2.Token, playlistID string) error {
+func setPlaylistPublic(ctx context.Context, token *oauth2.Token, playlistID string, public bool) error {
 	var updatePlaylistBody struct {
 		Public bool `json:"public"`
 	}
-	updatePlaylistBody.Public = true
+	updatePlaylistBody.Public = public
 	updatePlaylistBodyJSON, err := json.Marshal(updatePlaylistBody)
 	if err != nil {
 		return fmt.Errorf("Could not marshal update playlist request body: %s", err)
@@ -0,0 +1,90 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/samuelhorwitz/phosphorescence/api/common"
+	"github.com/samuelhorwitz/phosphorescence/api/session"
+)
+
+// Spotify only lets us queue one track per request, so bulk queueing is a
+// burst of requests from a single user. We space them out on top of the Spotify
+// client's own backoff so we don't trip rate limits in the first place.
+const queueRequestSpacing = 100 * time.Millisecond
+
+type Queue struct {
+	CurrentlyPlaying *SpotifyPlaybackTrack  `json:"currentlyPlaying"`
+	Queue            []SpotifyPlaybackTrack `json:"queue"`
+}
+
+func AddToQueue(ctx context.Context, sess *session.Session, deviceID, uri string) error {
+	return playerRequest(ctx, sess, "POST", "queue", deviceID, url.Values{"uri": {uri}}, nil)
+}
+
+// AddManyToQueue queues each URI in order, stopping at the first failure. It
+// returns how many were queued so the caller knows where it stopped.
+func AddManyToQueue(ctx context.Context, sess *session.Session, deviceID string, uris []string) (int, error) {
+	for i, uri := range uris {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return i, ctx.Err()
+			case <-time.After(queueRequestSpacing):
+			}
+		}
+		if err := AddToQueue(ctx, sess, deviceID, uri); err != nil {
+			return i, err
+		}
+	}
+	return len(uris), nil
+}
+
+func GetQueue(ctx context.Context, sess *session.Session) (Queue, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://api.spotify.com/v1/me/player/queue", nil)
+	if err != nil {
+		return Queue{}, fmt.Errorf("Could not build Spotify queue request: %s", err)
+	}
+	sess.SpotifyToken.SetAuthHeader(req)
+	res, err := common.SpotifyClient.Do(req)
+	if err != nil {
+		return Queue{}, fmt.Errorf("Could not make Spotify queue request: %s", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return Queue{}, parsePlayerError(res)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return Queue{}, fmt.Errorf("Could not read Spotify queue response: %s", err)
+	}
+	var parsedBody struct {
+		CurrentlyPlaying *SpotifyTrack  `json:"currently_playing"`
+		Queue            []SpotifyTrack `json:"queue"`
+	}
+	err = json.Unmarshal(body, &parsedBody)
+	if err != nil {
+		return Queue{}, fmt.Errorf("Could not parse Spotify queue response: %s", err)
+	}
+	queue := Queue{Queue: []SpotifyPlaybackTrack{}}
+	if parsedBody.CurrentlyPlaying != nil {
+		queue.CurrentlyPlaying = &SpotifyPlaybackTrack{
+			ID:      parsedBody.CurrentlyPlaying.ID,
+			Name:    parsedBody.CurrentlyPlaying.Name,
+			Artists: parsedBody.CurrentlyPlaying.Artists,
+		}
+	}
+	for _, track := range parsedBody.Queue {
+		queue.Queue = append(queue.Queue, SpotifyPlaybackTrack{
+			ID:      track.ID,
+			Name:    track.Name,
+			Artists: track.Artists,
+		})
+	}
+	return queue, nil
+}
@@ -0,0 +1,195 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/samuelhorwitz/phosphorescence/api/common"
+	"github.com/samuelhorwitz/phosphorescence/api/session"
+)
+
+const (
+	recentlyPlayedPageSize = 50
+	// Spotify doesn't keep much history so this is plenty to walk all of it.
+	maxRecentlyPlayedPages = 10
+)
+
+type RecentlyPlayed struct {
+	Track    *SpotifyTrackEnvelope `json:"track"`
+	PlayedAt time.Time             `json:"playedAt"`
+}
+
+// RecentPlay summarizes how recently and how often a track was heard.
+type RecentPlay struct {
+	LastPlayedAt time.Time `json:"lastPlayedAt"`
+	Count        int       `json:"count"`
+}
+
+// RecentFilterMode is how track endpoints treat tracks the user heard lately.
+type RecentFilterMode string
+
+const (
+	RecentFilterNone       RecentFilterMode = ""
+	RecentFilterExclude    RecentFilterMode = "exclude"
+	RecentFilterDownweight RecentFilterMode = "downweight"
+)
+
+// GetRecentlyPlayed returns the session user's plays since the given time, most
+// recent first, with each track's audio features.
+func GetRecentlyPlayed(ctx context.Context, sess *session.Session, since time.Time) ([]RecentlyPlayed, error) {
+	plays, err := getRecentlyPlayedFromSpotify(ctx, sess, since)
+	if err != nil {
+		return nil, err
+	}
+	var trackIDs []string
+	for _, play := range plays {
+		trackIDs = append(trackIDs, play.trackID)
+	}
+	trackIDs = dedupeTrackIDs(trackIDs)
+	if len(trackIDs) == 0 {
+		return []RecentlyPlayed{}, nil
+	}
+	tracks, err := getTracks(ctx, sess.SpotifyToken, sess.SpotifyCountry, trackIDs)
+	if err != nil {
+		return nil, fmt.Errorf("Could not get recently played tracks: %s", err)
+	}
+	tracksMap := make(map[string]*SpotifyTrackEnvelope)
+	for _, track := range tracks {
+		tracksMap[track.OriginalID()] = track
+	}
+	recentlyPlayed := []RecentlyPlayed{}
+	for _, play := range plays {
+		track, ok := tracksMap[play.trackID]
+		if !ok {
+			continue
+		}
+		recentlyPlayed = append(recentlyPlayed, RecentlyPlayed{Track: track, PlayedAt: play.playedAt})
+	}
+	return recentlyPlayed, nil
+}
+
+// GetRecentPlays returns what the session user heard since the given time keyed
+// by track ID, without fetching any track details.
+func GetRecentPlays(ctx context.Context, sess *session.Session, since time.Time) (map[string]RecentPlay, error) {
+	plays, err := getRecentlyPlayedFromSpotify(ctx, sess, since)
+	if err != nil {
+		return nil, err
+	}
+	recentPlays := make(map[string]RecentPlay)
+	for _, play := range plays {
+		recentPlay := recentPlays[play.trackID]
+		recentPlay.Count++
+		if play.playedAt.After(recentPlay.LastPlayedAt) {
+			recentPlay.LastPlayedAt = play.playedAt
+		}
+		recentPlays[play.trackID] = recentPlay
+	}
+	return recentPlays, nil
+}
+
+// RecentPlayWeight is how much a recently heard track should count when
+// picking tracks, from 0 for something played just now up to 1 for something
+// last played at the edge of the window. Tracks heard more than once recover
+// more slowly.
+func RecentPlayWeight(recentPlay RecentPlay, window time.Duration, now time.Time) float64 {
+	if window <= 0 {
+		return 1
+	}
+	elapsed := now.Sub(recentPlay.LastPlayedAt)
+	if elapsed >= window {
+		return 1
+	}
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	weight := float64(elapsed) / float64(window)
+	if recentPlay.Count > 1 {
+		weight /= float64(recentPlay.Count)
+	}
+	return weight
+}
+
+type recentPlay struct {
+	trackID  string
+	playedAt time.Time
+}
+
+func getRecentlyPlayedFromSpotify(ctx context.Context, sess *session.Session, since time.Time) ([]recentPlay, error) {
+	var plays []recentPlay
+	before := ""
+	for page := 0; page < maxRecentlyPlayedPages; page++ {
+		query := url.Values{"limit": {strconv.Itoa(recentlyPlayedPageSize)}}
+		if before != "" {
+			query.Set("before", before)
+		}
+		req, err := http.NewRequestWithContext(ctx, "GET", "https://api.spotify.com/v1/me/player/recently-played?"+query.Encode(), nil)
+		if err != nil {
+			return nil, fmt.Errorf("Could not build Spotify recently played request: %s", err)
+		}
+		sess.SpotifyToken.SetAuthHeader(req)
+		res, err := common.SpotifyClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("Could not make Spotify recently played request: %s", err)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("Could not read Spotify recently played response: %s", err)
+		}
+		if res.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("Spotify recently played request responded with %d", res.StatusCode)
+		}
+		var parsedBody struct {
+			Items []struct {
+				Track struct {
+					ID      string `json:"id"`
+					IsLocal bool   `json:"is_local"`
+				} `json:"track"`
+				PlayedAt time.Time `json:"played_at"`
+			} `json:"items"`
+			Next    string `json:"next"`
+			Cursors *struct {
+				Before string `json:"before"`
+			} `json:"cursors"`
+		}
+		err = json.Unmarshal(body, &parsedBody)
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse Spotify recently played response: %s", err)
+		}
+		for _, item := range parsedBody.Items {
+			if item.PlayedAt.Before(since) {
+				return plays, nil
+			}
+			if item.Track.IsLocal || item.Track.ID == "" {
+				continue
+			}
+			plays = append(plays, recentPlay{trackID: item.Track.ID, playedAt: item.PlayedAt})
+		}
+		if parsedBody.Next == "" || parsedBody.Cursors == nil || parsedBody.Cursors.Before == "" {
+			break
+		}
+		before = parsedBody.Cursors.Before
+	}
+	return plays, nil
+}
+
+// GetRecentPlayWeights returns RecentPlayWeight for every track the session
+// user heard within the window, tracks not in the map weren't heard recently.
+func GetRecentPlayWeights(ctx context.Context, sess *session.Session, window time.Duration) (map[string]float64, error) {
+	now := time.Now()
+	recentPlays, err := GetRecentPlays(ctx, sess, now.Add(-window))
+	if err != nil {
+		return nil, err
+	}
+	weights := make(map[string]float64)
+	for trackID, recentPlay := range recentPlays {
+		weights[trackID] = RecentPlayWeight(recentPlay, window, now)
+	}
+	return weights, nil
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_RecentPlayWeight(t *testing.T) {
+	now := time.Unix(1000000, 0)
+	window := 10 * 24 * time.Hour
+	if weight := RecentPlayWeight(RecentPlay{LastPlayedAt: now, Count: 1}, window, now); weight != 0 {
+		t.Fatalf("Expected track played just now to weigh 0, got %f", weight)
+	}
+	if weight := RecentPlayWeight(RecentPlay{LastPlayedAt: now.Add(-5 * 24 * time.Hour), Count: 1}, window, now); weight != 0.5 {
+		t.Fatalf("Expected track played halfway through window to weigh 0.5, got %f", weight)
+	}
+	if weight := RecentPlayWeight(RecentPlay{LastPlayedAt: now.Add(-5 * 24 * time.Hour), Count: 2}, window, now); weight != 0.25 {
+		t.Fatalf("Expected repeated track to weigh 0.25, got %f", weight)
+	}
+	if weight := RecentPlayWeight(RecentPlay{LastPlayedAt: now.Add(-20 * 24 * time.Hour), Count: 3}, window, now); weight != 1 {
+		t.Fatalf("Expected track outside window to weigh 1, got %f", weight)
+	}
+}
@@ -0,0 +1,146 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/satori/go.uuid"
+)
+
+// LatestScriptVersion stands in for a script's most recent publish wherever a
+// version is asked for.
+const LatestScriptVersion = "latest"
+
+const maxReleaseNameLength = 64
+
+// Semantic versions, with or without a leading v.
+var releaseName = regexp.MustCompile(`^v?(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)(-[0-9A-Za-z-]+(\.[0-9A-Za-z-]+)*)?(\+[0-9A-Za-z-]+(\.[0-9A-Za-z-]+)*)?$`)
+
+var (
+	ErrReleaseExists       = errors.New("Release name or version is already used")
+	ErrReleaseNotPublished = errors.New("Only published versions can be released")
+	ErrReleaseDoesNotExist = errors.New("Release does not exist")
+	ErrInvalidReleaseName  = errors.New("Release name must be a semantic version")
+)
+
+type ScriptRelease struct {
+	Name      string     `json:"name"`
+	Version   time.Time  `json:"version"`
+	Changelog nullString `json:"changelog"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// ScriptVersionRef is a script version as given in a URL: its timestamp, the
+// name of its release, or latest.
+type ScriptVersionRef struct {
+	CreatedAt time.Time
+	Release   string
+	Latest    bool
+}
+
+func ParseScriptVersionRef(ref string) ScriptVersionRef {
+	if ref == LatestScriptVersion {
+		return ScriptVersionRef{Latest: true}
+	}
+	if createdAt, err := time.Parse(time.RFC3339, ref); err == nil {
+		return ScriptVersionRef{CreatedAt: createdAt}
+	}
+	return ScriptVersionRef{Release: ref}
+}
+
+func ValidReleaseName(name string) bool {
+	return len(name) <= maxReleaseNameLength && releaseName.MatchString(name)
+}
+
+// apply narrows a query on script_versions to the version the ref points at.
+// The query must left join script_releases_view as script_releases. It is
+// not ok if the ref could never point at anything.
+func (ref ScriptVersionRef) apply(sel sq.SelectBuilder) (sq.SelectBuilder, bool) {
+	switch {
+	case ref.Latest:
+		return sel.Where(sq.Eq{"script_versions.type": ScriptSaveTypePublished}).
+			OrderBy("script_versions.created_at desc").
+			Limit(1), true
+	case !ref.CreatedAt.IsZero():
+		return sel.Where(sq.Eq{"script_versions.created_at": ref.CreatedAt}), true
+	case ValidReleaseName(ref.Release):
+		return sel.Where(sq.Eq{"script_releases.name": ref.Release}), true
+	}
+	return sel, false
+}
+
+// CreateScriptRelease names a published version of the script.
+func CreateScriptRelease(scriptID uuid.UUID, version ScriptVersion, name, changelog string) (ScriptRelease, error) {
+	if !ValidReleaseName(name) {
+		return ScriptRelease{}, ErrInvalidReleaseName
+	}
+	if version.Type != ScriptSaveTypePublished {
+		return ScriptRelease{}, ErrReleaseNotPublished
+	}
+	release := ScriptRelease{Name: name, Version: version.CreatedAt, Changelog: nullString{stringOrNull(changelog)}}
+	err := psql.Insert("script_releases").
+		Columns("script_id", "version_created_at", "name", "changelog").
+		Values(scriptID, version.CreatedAt, name, release.Changelog.NullString).
+		Suffix("on conflict do nothing returning created_at").
+		RunWith(postgresDB).QueryRow().Scan(&release.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ScriptRelease{}, ErrReleaseExists
+		}
+		return ScriptRelease{}, fmt.Errorf("Could not insert release: %s", err)
+	}
+	return release, nil
+}
+
+// GetScriptReleases gets a page of the script's releases, most recent first.
+func GetScriptReleases(scriptID uuid.UUID, count uint64, from time.Time) (releases []ScriptRelease, err error) {
+	where := sq.And{sq.Eq{"script_id": scriptID}}
+	if !from.IsZero() {
+		where = append(where, sq.Lt{"created_at": from})
+	}
+	rows, err := psql.Select("name", "version_created_at", "changelog", "created_at").
+		From("script_releases_view").
+		Where(where).
+		OrderBy("created_at desc").
+		Limit(count).
+		RunWith(postgresDB).Query()
+	if err != nil {
+		return nil, fmt.Errorf("Could not get releases from DB: %s", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var release ScriptRelease
+		if err := rows.Scan(&release.Name, &release.Version, &release.Changelog, &release.CreatedAt); err != nil {
+			return nil, fmt.Errorf("Could not scan row: %s", err)
+		}
+		releases = append(releases, release)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("Error after scanning rows: %s", err)
+	}
+	return releases, nil
+}
+
+// DeleteScriptRelease takes the name off a version so it can be used again.
+// The version itself stays.
+func DeleteScriptRelease(scriptID uuid.UUID, version time.Time) error {
+	res, err := psql.Delete("script_releases").
+		Where(sq.Eq{"script_id": scriptID, "version_created_at": version}).
+		RunWith(postgresDB).Exec()
+	if err != nil {
+		return fmt.Errorf("Could not delete release: %s", err)
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("Could not get deleted release count: %s", err)
+	}
+	if deleted == 0 {
+		return ErrReleaseDoesNotExist
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_ParseScriptVersionRef(t *testing.T) {
+	version := time.Date(2019, time.August, 31, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		ref      string
+		expected ScriptVersionRef
+	}{
+		{"latest", ScriptVersionRef{Latest: true}},
+		{version.Format(time.RFC3339), ScriptVersionRef{CreatedAt: version}},
+		{"v1.2.0", ScriptVersionRef{Release: "v1.2.0"}},
+	}
+	for _, test := range tests {
+		if actual := ParseScriptVersionRef(test.ref); actual != test.expected {
+			t.Errorf("Expected %s to parse to %+v, got %+v", test.ref, test.expected, actual)
+		}
+	}
+}
+
+func Test_ValidReleaseName(t *testing.T) {
+	for _, name := range []string{"1.0.0", "v0.1.0", "2.0.0-rc.1", "1.0.0+build.5", "v1.0.0-beta+exp.sha.5114f85"} {
+		if !ValidReleaseName(name) {
+			t.Errorf("Expected %s to be a valid release name", name)
+		}
+	}
+	for _, name := range []string{"", "latest", "1.0", "01.0.0", "1.0.0-", "v1.0.0 ", "2019-08-31T12:00:00Z"} {
+		if ValidReleaseName(name) {
+			t.Errorf("Expected %s to be an invalid release name", name)
+		}
+	}
+}
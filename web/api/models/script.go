@@ -1,23 +1,15 @@
 package models
 
 import (
-	"compress/gzip"
 	"database/sql"
 	"errors"
 	"fmt"
 	sq "github.com/Masterminds/squirrel"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/samuelhorwitz/phosphorescence/api/common"
 	"github.com/satori/go.uuid"
-	"io"
 	"time"
 )
 
-var scriptsNamespace = uuid.NewV5(common.PhosphorUUIDV5Namespace, "scripts")
-
 type Script struct {
 	ID                      uuid.UUID      `json:"id"`
 	Name                    nullString     `json:"name"`
@@ -28,14 +20,23 @@ type Script struct {
 	ForkedFromScriptID      nullUUID       `json:"forkedFromScriptId"`
 	ForkedFromScriptVersion nullTime       `json:"forkedFromScriptVersion"`
 	IsPrivate               bool           `json:"isPrivate,omitempty"`
+	IsHidden                bool           `json:"isHidden,omitempty"`
 	MostRecent              *ScriptVersion `json:"mostRecent,omitempty"`
 	CreatedAt               time.Time      `json:"createdAt"`
+	Likes                   uint64         `json:"likes"`
+	LikedByMe               bool           `json:"likedByMe"`
+	MyRole                  ScriptRole     `json:"myRole,omitempty"`
 }
 
 type ScriptVersion struct {
-	CreatedAt time.Time      `json:"createdAt"`
-	Type      ScriptSaveType `json:"type"`
-	FileID    uuid.UUID      `json:"fileId"`
+	CreatedAt        time.Time      `json:"createdAt"`
+	Type             ScriptSaveType `json:"type"`
+	FileID           uuid.UUID      `json:"fileId"`
+	FileURL          string         `json:"fileUrl,omitempty"`
+	Release          nullString     `json:"release"`
+	Changelog        nullString     `json:"changelog"`
+	SavedBySpotifyID nullString     `json:"savedBySpotifyId"`
+	SavedByName      nullString     `json:"savedByName"`
 }
 
 type CreateOrUpdateScriptResponse struct {
@@ -61,6 +62,8 @@ const (
 
 func GetScriptWithAuthorizationCheck(spotifyUserID string, scriptID uuid.UUID) (Script, bool, error) {
 	var script Script
+	var invitedRole ScriptRole
+	var accepted bool
 	err := psql.Select(
 		"scripts.id",
 		"scripts.author_id",
@@ -71,7 +74,12 @@ func GetScriptWithAuthorizationCheck(spotifyUserID string, scriptID uuid.UUID) (
 		"scripts.forked_from_script_id",
 		"scripts.forked_from_script_version_created_at",
 		"scripts.is_private",
-		"scripts.created_at").
+		"scripts.created_at",
+		"scripts.is_hidden",
+		scriptLikesColumn).
+		Column(scriptLikedByColumn, spotifyUserID).
+		Column(scriptCollaboratorRoleColumn, spotifyUserID).
+		Column(scriptCollaboratorCondition, spotifyUserID).
 		From("scripts_view as scripts").
 		LeftJoin("users_view users on users.id = scripts.author_id").
 		Where(sq.And{
@@ -79,6 +87,7 @@ func GetScriptWithAuthorizationCheck(spotifyUserID string, scriptID uuid.UUID) (
 			sq.Or{
 				sq.Eq{"users.spotify_id": spotifyUserID},
 				sq.Eq{"scripts.is_private": false},
+				sq.Expr(scriptCollaboratorCondition, spotifyUserID),
 			},
 		}).
 		RunWith(postgresDB).QueryRow().Scan(
@@ -91,33 +100,59 @@ func GetScriptWithAuthorizationCheck(spotifyUserID string, scriptID uuid.UUID) (
 		&script.ForkedFromScriptID,
 		&script.ForkedFromScriptVersion,
 		&script.IsPrivate,
-		&script.CreatedAt)
+		&script.CreatedAt,
+		&script.IsHidden,
+		&script.Likes,
+		&script.LikedByMe,
+		&invitedRole,
+		&accepted)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return Script{}, false, nil
 		}
 		return Script{}, false, fmt.Errorf("Could not query for script: %s", err)
 	}
+	script.MyRole = scriptRoleOf(script, spotifyUserID, invitedRole, accepted)
 	return script, true, nil
 }
 
-func GetScriptVersionWithAuthorizationCheck(spotifyUserID string, scriptID uuid.UUID, scriptVersionID time.Time) (ScriptVersion, bool, error) {
+func GetScriptVersionWithAuthorizationCheck(spotifyUserID string, scriptID uuid.UUID, ref ScriptVersionRef) (ScriptVersion, bool, error) {
 	var scriptVersion ScriptVersion
-	err := psql.Select("script_versions.created_at", "script_versions.type", "script_versions.file_id").
+	sel, ok := ref.apply(psql.Select(
+		"script_versions.created_at",
+		"script_versions.type",
+		"script_versions.file_id",
+		"script_releases.name",
+		"script_releases.changelog",
+		"saved_by_users.spotify_id",
+		"saved_by_users.name").
 		From("script_versions_view as script_versions").
 		Join("scripts_view scripts on scripts.id = script_versions.script_id").
 		LeftJoin("users_view users on users.id = scripts.author_id").
+		LeftJoin("users_view saved_by_users on saved_by_users.id = script_versions.saved_by").
+		LeftJoin("script_releases_view script_releases on script_releases.script_id = script_versions.script_id and script_releases.version_created_at = script_versions.created_at").
 		Where(sq.And{
 			sq.Eq{"scripts.id": scriptID},
 			sq.Or{
 				sq.Eq{"users.spotify_id": spotifyUserID},
+				sq.Expr(scriptCollaboratorCondition, spotifyUserID),
 				sq.And{
 					sq.Eq{"scripts.is_private": false},
 					sq.Eq{"script_versions.type": ScriptSaveTypePublished},
 				},
 			},
-		}).
-		RunWith(postgresDB).QueryRow().Scan(&scriptVersion.CreatedAt, &scriptVersion.Type, &scriptVersion.FileID)
+		}))
+	if !ok {
+		return ScriptVersion{}, false, nil
+	}
+	err := sel.RunWith(postgresDB).QueryRow().Scan(
+		&scriptVersion.CreatedAt,
+		&scriptVersion.Type,
+		&scriptVersion.FileID,
+		&scriptVersion.Release,
+		&scriptVersion.Changelog,
+		&scriptVersion.SavedBySpotifyID,
+		&scriptVersion.SavedByName)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return ScriptVersion{}, false, nil
@@ -145,7 +180,10 @@ func GetScriptsBySpotifyUserID(spotifyUserID string, count uint64, from time.Tim
 		"scripts.forked_from_script_id",
 		"scripts.forked_from_script_version_created_at",
 		"scripts.is_private",
-		"scripts.created_at").
+		"scripts.created_at",
+		"scripts.is_hidden",
+		scriptLikesColumn).
+		Column(scriptLikedByColumn, spotifyUserID).
 		From("scripts_view as scripts").
 		Join("users_view users on users.id = scripts.author_id").
 		Where(where).
@@ -168,7 +206,10 @@ func GetScriptsBySpotifyUserID(spotifyUserID string, count uint64, from time.Tim
 			&script.ForkedFromScriptID,
 			&script.ForkedFromScriptVersion,
 			&script.IsPrivate,
-			&script.CreatedAt)
+			&script.CreatedAt,
+			&script.IsHidden,
+			&script.Likes,
+			&script.LikedByMe)
 		if err != nil {
 			return nil, fmt.Errorf("Could not scan row: %s", err)
 		}
@@ -201,17 +242,26 @@ func GetMostRecentPublishedScriptVersion(scriptID uuid.UUID) (version ScriptVers
 }
 
 func GetScriptVersions(scriptID uuid.UUID, count uint64, from time.Time, limitToPublished bool) (versions []ScriptVersion, err error) {
-	where := sq.And{sq.Eq{"script_id": scriptID}}
+	where := sq.And{sq.Eq{"script_versions.script_id": scriptID}}
 	if !from.IsZero() {
-		where = append(where, sq.Lt{"created_at": from})
+		where = append(where, sq.Lt{"script_versions.created_at": from})
 	}
 	if limitToPublished {
-		where = append(where, sq.Eq{"type": ScriptSaveTypePublished})
-	}
-	sel := psql.Select("created_at", "type", "file_id").
+		where = append(where, sq.Eq{"script_versions.type": ScriptSaveTypePublished})
+	}
+	sel := psql.Select(
+		"script_versions.created_at",
+		"script_versions.type",
+		"script_versions.file_id",
+		"script_releases.name",
+		"script_releases.changelog",
+		"saved_by_users.spotify_id",
+		"saved_by_users.name").
 		From("script_versions_view as script_versions").
+		LeftJoin("users_view saved_by_users on saved_by_users.id = script_versions.saved_by").
+		LeftJoin("script_releases_view script_releases on script_releases.script_id = script_versions.script_id and script_releases.version_created_at = script_versions.created_at").
 		Where(where).
-		OrderBy("created_at desc").
+		OrderBy("script_versions.created_at desc").
 		Limit(count)
 	rows, err := sel.RunWith(postgresDB).Query()
 	if err != nil {
@@ -220,7 +270,14 @@ func GetScriptVersions(scriptID uuid.UUID, count uint64, from time.Time, limitTo
 	defer rows.Close()
 	for rows.Next() {
 		var version ScriptVersion
-		err := rows.Scan(&version.CreatedAt, &version.Type, &version.FileID)
+		err := rows.Scan(
+			&version.CreatedAt,
+			&version.Type,
+			&version.FileID,
+			&version.Release,
+			&version.Changelog,
+			&version.SavedBySpotifyID,
+			&version.SavedByName)
 		if err != nil {
 			return nil, fmt.Errorf("Could not scan row: %s", err)
 		}
@@ -233,7 +290,7 @@ func GetScriptVersions(scriptID uuid.UUID, count uint64, from time.Time, limitTo
 	return versions, nil
 }
 
-func GetNewScripts(count uint64, from time.Time) (scripts []Script, err error) {
+func GetNewScripts(viewerSpotifyID string, count uint64, from time.Time) (scripts []Script, err error) {
 	where := sq.And{sq.Eq{"is_private": false}}
 	if !from.IsZero() {
 		where = append(where, sq.Lt{"scripts.created_at": from})
@@ -248,7 +305,9 @@ func GetNewScripts(count uint64, from time.Time) (scripts []Script, err error) {
 		"scripts.forked_from_script_id",
 		"scripts.forked_from_script_version_created_at",
 		"scripts.is_private",
-		"scripts.created_at").
+		"scripts.created_at",
+		scriptLikesColumn).
+		Column(scriptLikedByColumn, viewerSpotifyID).
 		From("scripts_view as scripts").
 		LeftJoin("users_view users on users.id = scripts.author_id").
 		Where(where).
@@ -271,7 +330,9 @@ func GetNewScripts(count uint64, from time.Time) (scripts []Script, err error) {
 			&script.ForkedFromScriptID,
 			&script.ForkedFromScriptVersion,
 			&script.IsPrivate,
-			&script.CreatedAt)
+			&script.CreatedAt,
+			&script.Likes,
+			&script.LikedByMe)
 		if err != nil {
 			return nil, fmt.Errorf("Could not scan row: %s", err)
 		}
@@ -285,9 +346,9 @@ func GetNewScripts(count uint64, from time.Time) (scripts []Script, err error) {
 }
 
 func CreateScript(spotifyUserID, name, description, script string) (CreateOrUpdateScriptResponse, error) {
-	scriptFileID, err := pushScriptToObjectStorage(script)
+	scriptFileID, err := scriptStore.Put(script)
 	if err != nil {
-		return CreateOrUpdateScriptResponse{}, fmt.Errorf("Could not push script to Spaces: %s", err)
+		return CreateOrUpdateScriptResponse{}, fmt.Errorf("Could not store script: %s", err)
 	}
 	tx, err := postgresDB.Begin()
 	if err != nil {
@@ -304,8 +365,8 @@ func CreateScript(spotifyUserID, name, description, script string) (CreateOrUpda
 	if err != nil {
 		return CreateOrUpdateScriptResponse{}, common.TryToRollback(tx, fmt.Errorf("Could not insert new script: %s", err))
 	}
-	_, err = psql.Insert("script_versions").Columns("script_id", "type", "file_id").
-		Values(scriptID, ScriptSaveTypeDraft, scriptFileID).
+	_, err = psql.Insert("script_versions").Columns("script_id", "type", "file_id", "saved_by").
+		Values(scriptID, ScriptSaveTypeDraft, scriptFileID, userID).
 		RunWith(tx).Exec()
 	if err != nil {
 		return CreateOrUpdateScriptResponse{}, common.TryToRollback(tx, fmt.Errorf("Could not insert new script version: %s", err))
@@ -321,7 +382,7 @@ func CreateScript(spotifyUserID, name, description, script string) (CreateOrUpda
 	}, nil
 }
 
-func UpdateScript(scriptID uuid.UUID, name, description, script, permissions string, scriptSaveType ScriptSaveType) (CreateOrUpdateScriptResponse, error) {
+func UpdateScript(spotifyUserID string, scriptID uuid.UUID, name, description, script, permissions string, scriptSaveType ScriptSaveType) (CreateOrUpdateScriptResponse, error) {
 	if name == "" && permissions == permissionsPublic {
 		return CreateOrUpdateScriptResponse{}, errors.New("Public scripts must have a name")
 	}
@@ -331,9 +392,9 @@ func UpdateScript(scriptID uuid.UUID, name, description, script, permissions str
 		return CreateOrUpdateScriptResponse{}, fmt.Errorf("Could not start transaction: %s", err)
 	}
 	if script != "" {
-		scriptFileID, err := pushScriptToObjectStorage(script)
+		scriptFileID, err := scriptStore.Put(script)
 		if err != nil {
-			return CreateOrUpdateScriptResponse{}, fmt.Errorf("Could not push script to Spaces: %s", err)
+			return CreateOrUpdateScriptResponse{}, fmt.Errorf("Could not store script: %s", err)
 		}
 		var mostRecentFileID uuid.UUID
 		var mostRecentType ScriptSaveType
@@ -348,8 +409,12 @@ func UpdateScript(scriptID uuid.UUID, name, description, script, permissions str
 		}
 		// Don't create a new version row if the code is the exact same, unless we are publishing and the previous save was not a publish
 		if !uuid.Equal(mostRecentFileID, scriptFileID) || (mostRecentType != ScriptSaveTypePublished && scriptSaveType == ScriptSaveTypePublished) {
-			_, err = psql.Insert("script_versions").Columns("script_id", "type", "file_id").
-				Values(scriptID, scriptSaveType, scriptFileID).
+			userID, err := mapSpotifyIDToOurID(tx, spotifyUserID)
+			if err != nil {
+				return CreateOrUpdateScriptResponse{}, common.TryToRollback(tx, fmt.Errorf("Could not get user ID from Spotify ID: %s", err))
+			}
+			_, err = psql.Insert("script_versions").Columns("script_id", "type", "file_id", "saved_by").
+				Values(scriptID, scriptSaveType, scriptFileID, userID).
 				RunWith(tx).Exec()
 			if err != nil {
 				return CreateOrUpdateScriptResponse{}, common.TryToRollback(tx, fmt.Errorf("Could not insert new script version: %s", err))
@@ -408,15 +473,33 @@ func DeleteScript(scriptID uuid.UUID) error {
 }
 
 func DeleteScriptVersion(scriptID uuid.UUID, scriptVersionID time.Time) error {
-	_, err := psql.Update("script_versions").
+	tx, err := postgresDB.Begin()
+	if err != nil {
+		return fmt.Errorf("Could not start transaction: %s", err)
+	}
+	_, err = psql.Update("script_versions").
 		Set("deleted_at", sq.Expr("now()")).
 		Where(sq.Eq{
 			"script_id":  scriptID,
 			"created_at": scriptVersionID,
 		}).
-		RunWith(postgresDB).Exec()
+		RunWith(tx).Exec()
 	if err != nil {
-		return fmt.Errorf("Could not mark script version as deleted: %s", err)
+		return common.TryToRollback(tx, fmt.Errorf("Could not mark script version as deleted: %s", err))
+	}
+	// Deleted versions keep their rows, so free up the release name here. A
+	// release left behind would still hold its name without being seen.
+	_, err = psql.Delete("script_releases").
+		Where(sq.Eq{
+			"script_id":          scriptID,
+			"version_created_at": scriptVersionID,
+		}).
+		RunWith(tx).Exec()
+	if err != nil {
+		return common.TryToRollback(tx, fmt.Errorf("Could not delete script version release: %s", err))
+	}
+	if err = tx.Commit(); err != nil {
+		return common.TryToRollback(tx, fmt.Errorf("Could not commit: %s", err))
 	}
 	return nil
 }
@@ -457,8 +540,8 @@ func ForkScript(spotifyUserID string, toForkScriptID uuid.UUID, toForkScriptName
 	if err != nil {
 		return CreateOrUpdateScriptResponse{}, common.TryToRollback(tx, fmt.Errorf("Could not insert new script: %s", err))
 	}
-	_, err = psql.Insert("script_versions").Columns("script_id", "type", "file_id").
-		Values(scriptID, ScriptSaveTypeFork, toForkVersion.FileID).
+	_, err = psql.Insert("script_versions").Columns("script_id", "type", "file_id", "saved_by").
+		Values(scriptID, ScriptSaveTypeFork, toForkVersion.FileID, userID).
 		RunWith(tx).Exec()
 	if err != nil {
 		return CreateOrUpdateScriptResponse{}, common.TryToRollback(tx, fmt.Errorf("Could not insert new script version: %s", err))
@@ -474,67 +557,6 @@ func ForkScript(spotifyUserID string, toForkScriptID uuid.UUID, toForkScriptName
 	}, nil
 }
 
-func pushScriptToObjectStorage(script string) (scriptID uuid.UUID, err error) {
-	scriptID = uuid.NewV5(scriptsNamespace, script)
-	scriptInObjectStorage, err := isScriptIDInObjectStorage(scriptID)
-	if err != nil {
-		return scriptID, fmt.Errorf("Could access Spaces: %s", err)
-	}
-	if scriptInObjectStorage {
-		return scriptID, nil
-	}
-	reader, writer := io.Pipe()
-	go func() {
-		zw := gzip.NewWriter(writer)
-		if _, err = zw.Write([]byte(script)); err != nil {
-			err = fmt.Errorf("Could not compress script: %s", err)
-			return
-		}
-		if err := zw.Close(); err != nil {
-			err = fmt.Errorf("Could not close compression buffer: %s", err)
-			return
-		}
-		writer.Close()
-	}()
-	if err != nil {
-		return scriptID, fmt.Errorf("Could not zip script: %s", err)
-	}
-	_, err = s3Uploader.Upload(&s3manager.UploadInput{
-		Bucket:          aws.String("phosphorescence-scripts"),
-		ACL:             aws.String("public-read"),
-		ContentType:     aws.String("application/javascript"),
-		ContentEncoding: aws.String("gzip"),
-		CacheControl:    aws.String("public, max-age=31536000"),
-		Key:             aws.String(scriptID.String()),
-		Body:            reader,
-	})
-	if err != nil {
-		return scriptID, fmt.Errorf("Could not upload to Spaces: %s", err)
-	}
-	return scriptID, nil
-}
-
-func isScriptInObjectStorage(script string) (bool, error) {
-	return isScriptIDInObjectStorage(uuid.NewV5(scriptsNamespace, script))
-}
-
-func isScriptIDInObjectStorage(scriptID uuid.UUID) (bool, error) {
-	_, err := s3Service.HeadObject(&s3.HeadObjectInput{
-		Bucket: aws.String("phosphorescence-scripts"),
-		Key:    aws.String(scriptID.String()),
-	})
-	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			if aerr.Code() == common.S3NotFoundCode {
-				return false, nil
-			}
-			return false, fmt.Errorf("Could not reach Spaces: %s", aerr.Error())
-		}
-		return false, fmt.Errorf("Could not reach Spaces: %s", err)
-	}
-	return true, nil
-}
-
 func stringOrNull(str string) sql.NullString {
 	var nullString sql.NullString
 	if str == "" {
@@ -0,0 +1,477 @@
+package models
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"sync"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/satori/go.uuid"
+
+	"github.com/samuelhorwitz/phosphorescence/api/common"
+)
+
+const (
+	scriptBundleFormat       = 1
+	scriptBundleManifestPath = "script.json"
+	maxScriptBundleVersions  = 1000
+	// Matches the limit on saving a script, bundles can't sneak in bigger
+	// versions than the editor could.
+	maxScriptBundleFileSize = 256 * 1024
+	// Each distinct file is a round trip to the script store, a few can be in
+	// flight at once.
+	scriptBundleStoreConcurrency = 8
+)
+
+// Autosaves are never made through the API but older scripts have them, so
+// bundles may too.
+const scriptSaveTypeAutosave ScriptSaveType = "autosave"
+
+type ScriptNameConflict string
+
+const (
+	// ScriptNameConflictRename numbers the imported script's name until it is
+	// unique among the importer's scripts.
+	ScriptNameConflictRename ScriptNameConflict = "rename"
+	ScriptNameConflictFail   ScriptNameConflict = "fail"
+	ScriptNameConflictAllow  ScriptNameConflict = "allow"
+)
+
+// ScriptBundle is the manifest of an exported script. Each version's source is
+// stored next to it in the archive.
+type ScriptBundle struct {
+	Format      int                   `json:"format"`
+	ExportedAt  time.Time             `json:"exportedAt"`
+	ID          uuid.UUID             `json:"id"`
+	Name        string                `json:"name"`
+	Description string                `json:"description"`
+	Permissions string                `json:"permissions"`
+	ForkedFrom  *ScriptBundleFork     `json:"forkedFrom"`
+	Versions    []ScriptBundleVersion `json:"versions"`
+}
+
+type ScriptBundleFork struct {
+	ScriptID uuid.UUID `json:"scriptId"`
+	Version  time.Time `json:"version"`
+}
+
+type ScriptBundleVersion struct {
+	CreatedAt time.Time      `json:"createdAt"`
+	Type      ScriptSaveType `json:"type"`
+	FileID    uuid.UUID      `json:"fileId"`
+	Path      string         `json:"path"`
+	Script    string         `json:"-"`
+}
+
+type ScriptImportOptions struct {
+	NameConflict ScriptNameConflict
+	// Permissions overrides the bundle's permissions when set.
+	Permissions string
+}
+
+type ScriptImportResponse struct {
+	CreateOrUpdateScriptResponse
+	Versions    int      `json:"versions"`
+	StoredFiles int      `json:"storedFiles"`
+	ReusedFiles int      `json:"reusedFiles"`
+	Warnings    []string `json:"warnings,omitempty"`
+}
+
+// ScriptBundleError is a bundle which can't be imported as it is.
+type ScriptBundleError struct {
+	Message string
+}
+
+func (e ScriptBundleError) Error() string {
+	return e.Message
+}
+
+// ScriptNameConflictError is an import which would give the importer two
+// scripts with the same name.
+type ScriptNameConflictError struct {
+	Name string
+}
+
+func (e ScriptNameConflictError) Error() string {
+	return fmt.Sprintf("You already have a script named %s", e.Name)
+}
+
+// ExportScript writes a zip of the script's details and every version's source.
+func ExportScript(w io.Writer, script Script) error {
+	bundle := ScriptBundle{
+		Format:      scriptBundleFormat,
+		ExportedAt:  time.Now().UTC(),
+		ID:          script.ID,
+		Name:        script.Name.String,
+		Description: script.Description.String,
+		Permissions: permissionsPublic,
+	}
+	if script.IsPrivate {
+		bundle.Permissions = permissionsPrivate
+	}
+	if script.ForkedFromScriptID.Valid && script.ForkedFromScriptVersion.Valid {
+		bundle.ForkedFrom = &ScriptBundleFork{
+			ScriptID: script.ForkedFromScriptID.UUID,
+			Version:  script.ForkedFromScriptVersion.Time,
+		}
+	}
+	rows, err := psql.Select("created_at", "type", "file_id").
+		From("script_versions_view as script_versions").
+		Where(sq.Eq{"script_id": script.ID}).
+		OrderBy("created_at asc").
+		RunWith(postgresDB).Query()
+	if err != nil {
+		return fmt.Errorf("Could not get script versions: %s", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version ScriptBundleVersion
+		if err := rows.Scan(&version.CreatedAt, &version.Type, &version.FileID); err != nil {
+			return fmt.Errorf("Could not scan row: %s", err)
+		}
+		version.Path = path.Join("versions", version.FileID.String()+".js")
+		bundle.Versions = append(bundle.Versions, version)
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("Error after scanning rows: %s", err)
+	}
+	var sourcesMux sync.Mutex
+	sources := make(map[uuid.UUID]string)
+	err = eachScriptBundleFile(bundle.Versions, func(version ScriptBundleVersion) error {
+		source, err := scriptStore.Get(version.FileID)
+		if err != nil {
+			return fmt.Errorf("Could not get script version %s: %s", version.CreatedAt, err)
+		}
+		sourcesMux.Lock()
+		defer sourcesMux.Unlock()
+		sources[version.FileID] = source
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for i, version := range bundle.Versions {
+		bundle.Versions[i].Script = sources[version.FileID]
+	}
+	return writeScriptBundle(w, bundle)
+}
+
+func writeScriptBundle(w io.Writer, bundle ScriptBundle) error {
+	zw := zip.NewWriter(w)
+	manifest, err := zw.Create(scriptBundleManifestPath)
+	if err != nil {
+		return fmt.Errorf("Could not add manifest to bundle: %s", err)
+	}
+	encoder := json.NewEncoder(manifest)
+	encoder.SetIndent("", "  ")
+	if err = encoder.Encode(bundle); err != nil {
+		return fmt.Errorf("Could not write manifest: %s", err)
+	}
+	// Identical versions share a file so only write each one once.
+	written := make(map[string]bool)
+	for _, version := range bundle.Versions {
+		if written[version.Path] {
+			continue
+		}
+		file, err := zw.Create(version.Path)
+		if err != nil {
+			return fmt.Errorf("Could not add script version to bundle: %s", err)
+		}
+		if _, err = io.WriteString(file, version.Script); err != nil {
+			return fmt.Errorf("Could not write script version: %s", err)
+		}
+		written[version.Path] = true
+	}
+	if err = zw.Close(); err != nil {
+		return fmt.Errorf("Could not finish bundle: %s", err)
+	}
+	return nil
+}
+
+// ReadScriptBundle reads a bundle made by ExportScript, checking every version
+// is what the manifest says it is.
+func ReadScriptBundle(data []byte) (ScriptBundle, error) {
+	var bundle ScriptBundle
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return bundle, ScriptBundleError{Message: fmt.Sprintf("Bundle is not a zip: %s", err)}
+	}
+	files := make(map[string]*zip.File)
+	for _, file := range zr.File {
+		files[file.Name] = file
+	}
+	manifest, ok := files[scriptBundleManifestPath]
+	if !ok {
+		return bundle, ScriptBundleError{Message: "Bundle has no manifest"}
+	}
+	manifestJSON, err := readScriptBundleFile(manifest)
+	if err != nil {
+		return bundle, err
+	}
+	if err = json.Unmarshal(manifestJSON, &bundle); err != nil {
+		return bundle, ScriptBundleError{Message: fmt.Sprintf("Could not parse manifest: %s", err)}
+	}
+	if bundle.Format != scriptBundleFormat {
+		return bundle, ScriptBundleError{Message: fmt.Sprintf("Unsupported bundle format %d", bundle.Format)}
+	}
+	if len(bundle.Versions) == 0 {
+		return bundle, ScriptBundleError{Message: "Bundle has no versions"}
+	}
+	if len(bundle.Versions) > maxScriptBundleVersions {
+		return bundle, ScriptBundleError{Message: fmt.Sprintf("Bundle cannot have more than %d versions", maxScriptBundleVersions)}
+	}
+	if bundle.Permissions != permissionsPublic && bundle.Permissions != permissionsPrivate {
+		return bundle, ScriptBundleError{Message: fmt.Sprintf("Invalid permissions %s", bundle.Permissions)}
+	}
+	seen := make(map[int64]bool)
+	for i, version := range bundle.Versions {
+		switch version.Type {
+		case ScriptSaveTypeDraft, ScriptSaveTypePublished, ScriptSaveTypeFork, scriptSaveTypeAutosave:
+		default:
+			return bundle, ScriptBundleError{Message: fmt.Sprintf("Invalid version type %s", version.Type)}
+		}
+		if version.CreatedAt.IsZero() || seen[version.CreatedAt.UnixNano()] {
+			return bundle, ScriptBundleError{Message: "Every version needs its own timestamp"}
+		}
+		// Versions from the future would stay the latest over anything saved
+		// after importing.
+		if version.CreatedAt.After(time.Now()) {
+			return bundle, ScriptBundleError{Message: "Versions cannot be from the future"}
+		}
+		seen[version.CreatedAt.UnixNano()] = true
+		file, ok := files[version.Path]
+		if !ok {
+			return bundle, ScriptBundleError{Message: fmt.Sprintf("Bundle is missing %s", version.Path)}
+		}
+		source, err := readScriptBundleFile(file)
+		if err != nil {
+			return bundle, err
+		}
+		bundle.Versions[i].Script = string(source)
+		if !uuid.Equal(ScriptFileID(bundle.Versions[i].Script), version.FileID) {
+			return bundle, ScriptBundleError{Message: fmt.Sprintf("%s does not match its file ID", version.Path)}
+		}
+	}
+	return bundle, nil
+}
+
+func readScriptBundleFile(file *zip.File) ([]byte, error) {
+	if file.UncompressedSize64 > maxScriptBundleFileSize {
+		return nil, ScriptBundleError{Message: fmt.Sprintf("%s is too large", file.Name)}
+	}
+	rc, err := file.Open()
+	if err != nil {
+		return nil, ScriptBundleError{Message: fmt.Sprintf("Could not open %s: %s", file.Name, err)}
+	}
+	defer rc.Close()
+	// The header's size can't be trusted, so don't read past the limit either.
+	contents, err := ioutil.ReadAll(io.LimitReader(rc, maxScriptBundleFileSize+1))
+	if err != nil {
+		return nil, ScriptBundleError{Message: fmt.Sprintf("Could not read %s: %s", file.Name, err)}
+	}
+	if len(contents) > maxScriptBundleFileSize {
+		return nil, ScriptBundleError{Message: fmt.Sprintf("%s is too large", file.Name)}
+	}
+	return contents, nil
+}
+
+// ImportScript recreates a bundled script as a new script belonging to the
+// importer, keeping every version's timestamp and type. Files already in the
+// script store are reused rather than uploaded again.
+func ImportScript(spotifyUserID string, bundle ScriptBundle, opts ScriptImportOptions) (ScriptImportResponse, error) {
+	var res ScriptImportResponse
+	permissions := bundle.Permissions
+	if opts.Permissions != "" {
+		permissions = opts.Permissions
+	}
+	if permissions != permissionsPublic && permissions != permissionsPrivate {
+		return res, ScriptBundleError{Message: fmt.Sprintf("Invalid permissions %s", permissions)}
+	}
+	var countsMux sync.Mutex
+	err := eachScriptBundleFile(bundle.Versions, func(version ScriptBundleVersion) error {
+		exists, err := scriptStore.Head(version.FileID)
+		if err != nil {
+			return fmt.Errorf("Could not check for script file: %s", err)
+		}
+		if !exists {
+			if _, err = scriptStore.Put(version.Script); err != nil {
+				return fmt.Errorf("Could not store script: %s", err)
+			}
+		}
+		countsMux.Lock()
+		defer countsMux.Unlock()
+		if exists {
+			res.ReusedFiles++
+		} else {
+			res.StoredFiles++
+		}
+		return nil
+	})
+	if err != nil {
+		return res, err
+	}
+	tx, err := postgresDB.Begin()
+	if err != nil {
+		return res, fmt.Errorf("Could not start transaction: %s", err)
+	}
+	userID, err := mapSpotifyIDToOurID(tx, spotifyUserID)
+	if err != nil {
+		return res, common.TryToRollback(tx, fmt.Errorf("Could not get user ID from Spotify ID: %s", err))
+	}
+	name := bundle.Name
+	if name != "" && opts.NameConflict != ScriptNameConflictAllow {
+		name, err = resolveScriptNameConflict(tx, userID, name, opts.NameConflict)
+		if err != nil {
+			return res, common.TryToRollback(tx, err)
+		}
+		if name != bundle.Name {
+			res.Warnings = append(res.Warnings, fmt.Sprintf("Renamed to %s", name))
+		}
+	}
+	if permissions == permissionsPublic {
+		if name == "" {
+			permissions = permissionsPrivate
+			res.Warnings = append(res.Warnings, "Public scripts must have a name, imported as private")
+		} else if !hasPublishedScriptBundleVersion(bundle) {
+			permissions = permissionsPrivate
+			res.Warnings = append(res.Warnings, "Nothing has been published, imported as private")
+		}
+	}
+	forkedFromID, forkedFromVersion := sql.NullString{}, nullTime{}
+	if bundle.ForkedFrom != nil {
+		var exists bool
+		err = psql.Select("count(*) > 0").
+			From("script_versions_view").
+			Where(sq.Eq{
+				"script_id":  bundle.ForkedFrom.ScriptID,
+				"created_at": bundle.ForkedFrom.Version,
+			}).
+			RunWith(tx).QueryRow().Scan(&exists)
+		if err != nil {
+			return res, common.TryToRollback(tx, fmt.Errorf("Could not check for forked script: %s", err))
+		}
+		if exists {
+			forkedFromID = stringOrNull(bundle.ForkedFrom.ScriptID.String())
+			forkedFromVersion.Time = bundle.ForkedFrom.Version
+			forkedFromVersion.Valid = true
+		} else {
+			res.Warnings = append(res.Warnings, "The script this was forked from does not exist here, lineage was dropped")
+		}
+	}
+	scriptID := uuid.NewV4()
+	_, err = psql.Insert("scripts").
+		Columns("id", "author_id", "name", "description", "is_private", "forked_from_script_id", "forked_from_script_version_created_at").
+		Values(scriptID, userID, stringOrNull(name), stringOrNull(bundle.Description), permissions == permissionsPrivate, forkedFromID, forkedFromVersion).
+		RunWith(tx).Exec()
+	if err != nil {
+		return res, common.TryToRollback(tx, fmt.Errorf("Could not insert new script: %s", err))
+	}
+	insert := psql.Insert("script_versions").Columns("script_id", "created_at", "type", "file_id", "saved_by")
+	for _, version := range bundle.Versions {
+		insert = insert.Values(scriptID, version.CreatedAt, version.Type, version.FileID, userID)
+	}
+	if _, err = insert.RunWith(tx).Exec(); err != nil {
+		return res, common.TryToRollback(tx, fmt.Errorf("Could not insert script versions: %s", err))
+	}
+	if err = tx.Commit(); err != nil {
+		return res, common.TryToRollback(tx, fmt.Errorf("Could not commit: %s", err))
+	}
+	latest := bundle.Versions[0]
+	for _, version := range bundle.Versions {
+		if version.CreatedAt.After(latest.CreatedAt) {
+			latest = version
+		}
+	}
+	res.ID = scriptID
+	res.FileID = latest.FileID
+	res.Name = name
+	res.Description = bundle.Description
+	res.Permissions = permissions
+	res.Versions = len(bundle.Versions)
+	return res, nil
+}
+
+func resolveScriptNameConflict(tx *sql.Tx, userID uuid.UUID, name string, conflict ScriptNameConflict) (string, error) {
+	rows, err := psql.Select("name").
+		From("scripts_view").
+		Where(sq.And{
+			sq.Eq{"author_id": userID},
+			// Untitled scripts can't conflict with anything.
+			sq.NotEq{"name": nil},
+		}).
+		RunWith(tx).Query()
+	if err != nil {
+		return "", fmt.Errorf("Could not get script names: %s", err)
+	}
+	defer rows.Close()
+	taken := make(map[string]bool)
+	for rows.Next() {
+		var existing string
+		if err := rows.Scan(&existing); err != nil {
+			return "", fmt.Errorf("Could not scan row: %s", err)
+		}
+		taken[existing] = true
+	}
+	if err = rows.Err(); err != nil {
+		return "", fmt.Errorf("Error after scanning rows: %s", err)
+	}
+	return uniqueScriptName(name, taken, conflict)
+}
+
+func uniqueScriptName(name string, taken map[string]bool, conflict ScriptNameConflict) (string, error) {
+	if !taken[name] {
+		return name, nil
+	}
+	if conflict == ScriptNameConflictFail {
+		return "", ScriptNameConflictError{Name: name}
+	}
+	for i := 2; ; i++ {
+		candidate := fmt.Sprintf("%s (%d)", name, i)
+		if !taken[candidate] {
+			return candidate, nil
+		}
+	}
+}
+
+// eachScriptBundleFile calls fn once for each distinct file in the bundle,
+// several at a time, and returns the first error any call returned.
+func eachScriptBundleFile(versions []ScriptBundleVersion, fn func(ScriptBundleVersion) error) error {
+	seen := make(map[uuid.UUID]bool)
+	sem := make(chan struct{}, scriptBundleStoreConcurrency)
+	errs := make(chan error, len(versions))
+	var wg sync.WaitGroup
+	for _, version := range versions {
+		if seen[version.FileID] {
+			continue
+		}
+		seen[version.FileID] = true
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(version ScriptBundleVersion) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := fn(version); err != nil {
+				errs <- err
+			}
+		}(version)
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+func hasPublishedScriptBundleVersion(bundle ScriptBundle) bool {
+	for _, version := range bundle.Versions {
+		if version.Type == ScriptSaveTypePublished {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package models
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+func testScriptBundle() ScriptBundle {
+	first := "self.hooks.getFirstTrack = () => null;"
+	second := "self.hooks.getNextTrack = () => null;"
+	created := time.Date(2019, 8, 1, 12, 0, 0, 0, time.UTC)
+	bundle := ScriptBundle{
+		Format:      scriptBundleFormat,
+		ID:          uuid.NewV4(),
+		Name:        "Walk",
+		Permissions: permissionsPublic,
+	}
+	for i, script := range []string{first, second, first} {
+		fileID := ScriptFileID(script)
+		bundle.Versions = append(bundle.Versions, ScriptBundleVersion{
+			CreatedAt: created.Add(time.Duration(i) * time.Hour),
+			Type:      ScriptSaveTypeDraft,
+			FileID:    fileID,
+			Path:      "versions/" + fileID.String() + ".js",
+			Script:    script,
+		})
+	}
+	return bundle
+}
+
+func Test_ScriptBundleRoundTrip(t *testing.T) {
+	bundle := testScriptBundle()
+	var buf bytes.Buffer
+	if err := writeScriptBundle(&buf, bundle); err != nil {
+		t.Fatalf("Could not write bundle: %s", err)
+	}
+	read, err := ReadScriptBundle(buf.Bytes())
+	if err != nil {
+		t.Fatalf("Could not read bundle: %s", err)
+	}
+	if read.Name != bundle.Name || len(read.Versions) != len(bundle.Versions) {
+		t.Fatalf("Expected %+v, got %+v", bundle, read)
+	}
+	for i, version := range read.Versions {
+		if version.Script != bundle.Versions[i].Script || !version.CreatedAt.Equal(bundle.Versions[i].CreatedAt) {
+			t.Fatalf("Version %d did not survive the round trip: %+v", i, version)
+		}
+	}
+}
+
+func Test_ReadScriptBundleRejects(t *testing.T) {
+	tests := map[string]func(*ScriptBundle){
+		"tampered source":  func(b *ScriptBundle) { b.Versions[1].Script = "alert(1);" },
+		"bad type":         func(b *ScriptBundle) { b.Versions[0].Type = "release" },
+		"same timestamp":   func(b *ScriptBundle) { b.Versions[1].CreatedAt = b.Versions[0].CreatedAt },
+		"future timestamp": func(b *ScriptBundle) { b.Versions[2].CreatedAt = time.Now().Add(time.Hour) },
+		"too large": func(b *ScriptBundle) {
+			b.Versions[0].Script = strings.Repeat(" ", maxScriptBundleFileSize+1)
+			b.Versions[0].FileID = ScriptFileID(b.Versions[0].Script)
+			b.Versions[0].Path = "versions/large.js"
+		},
+		"no versions": func(b *ScriptBundle) { b.Versions = nil },
+		"format":      func(b *ScriptBundle) { b.Format = 2 },
+	}
+	for name, tamper := range tests {
+		bundle := testScriptBundle()
+		tamper(&bundle)
+		var buf bytes.Buffer
+		if err := writeScriptBundle(&buf, bundle); err != nil {
+			t.Fatalf("%s: could not write bundle: %s", name, err)
+		}
+		if _, err := ReadScriptBundle(buf.Bytes()); err == nil {
+			t.Errorf("%s: expected bundle to be rejected", name)
+		} else if _, ok := err.(ScriptBundleError); !ok {
+			t.Errorf("%s: expected bundle error, got %s", name, err)
+		}
+	}
+	if _, err := ReadScriptBundle([]byte("not a zip")); err == nil {
+		t.Error("Expected garbage to be rejected")
+	}
+}
+
+func Test_uniqueScriptName(t *testing.T) {
+	taken := map[string]bool{"Walk": true, "Walk (2)": true}
+	if name, err := uniqueScriptName("Run", taken, ScriptNameConflictFail); err != nil || name != "Run" {
+		t.Fatalf("Expected Run, got %s, %v", name, err)
+	}
+	if name, err := uniqueScriptName("Walk", taken, ScriptNameConflictRename); err != nil || name != "Walk (3)" {
+		t.Fatalf("Expected Walk (3), got %s, %v", name, err)
+	}
+	if _, err := uniqueScriptName("Walk", taken, ScriptNameConflictFail); err == nil {
+		t.Fatal("Expected a name conflict")
+	}
+}
+
+func Test_eachScriptBundleFile(t *testing.T) {
+	bundle := testScriptBundle()
+	var mux sync.Mutex
+	calls := make(map[uuid.UUID]int)
+	err := eachScriptBundleFile(bundle.Versions, func(version ScriptBundleVersion) error {
+		mux.Lock()
+		defer mux.Unlock()
+		calls[version.FileID]++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(calls) != 2 || calls[bundle.Versions[0].FileID] != 1 {
+		t.Fatalf("Expected each of the 2 distinct files once, got %v", calls)
+	}
+	failed := errors.New("failed")
+	err = eachScriptBundleFile(bundle.Versions, func(version ScriptBundleVersion) error {
+		if uuid.Equal(version.FileID, bundle.Versions[1].FileID) {
+			return failed
+		}
+		return nil
+	})
+	if err != failed {
+		t.Fatalf("Expected the failure to be returned, got %v", err)
+	}
+}
@@ -2,6 +2,7 @@ package models
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	sq "github.com/Masterminds/squirrel"
@@ -22,24 +23,57 @@ type ScriptChain struct {
 	IsPrivate                    bool                `json:"isPrivate,omitempty"`
 	MostRecent                   *ScriptChainVersion `json:"mostRecent,omitempty"`
 	CreatedAt                    time.Time           `json:"createdAt"`
+	Likes                        uint64              `json:"likes"`
+	LikedByMe                    bool                `json:"likedByMe"`
+}
+
+// ScriptChainScript is a script in a chain, pinned to one of its published
+// versions.
+type ScriptChainScript struct {
+	ScriptID uuid.UUID `json:"scriptId"`
+	Version  time.Time `json:"version"`
+	// Release pins to a named release when saving. Chains always store the
+	// version it names.
+	Release string `json:"-"`
 }
 
 type ScriptChainVersion struct {
-	CreatedAt time.Time      `json:"createdAt"`
-	Type      ScriptSaveType `json:"type"`
-	PrunerIDs []uuid.UUID    `json:"prunerIds"`
-	SeederID  uuid.UUID      `json:"seederId"`
-	BuilderID uuid.UUID      `json:"builderId"`
+	CreatedAt time.Time           `json:"createdAt"`
+	Type      ScriptSaveType      `json:"type"`
+	Seeder    *ScriptChainScript  `json:"seeder"`
+	Builder   ScriptChainScript   `json:"builder"`
+	Pruners   []ScriptChainScript `json:"pruners"`
 }
 
 type CreateOrUpdateScriptChainResponse struct {
-	ID          uuid.UUID   `json:"id"`
-	PrunerIDs   []uuid.UUID `json:"prunerIds"`
-	SeederID    uuid.UUID   `json:"seederId"`
-	BuilderID   uuid.UUID   `json:"builderId"`
-	Name        string      `json:"name"`
-	Description string      `json:"description"`
-	Permissions string      `json:"permissions"`
+	ID          uuid.UUID           `json:"id"`
+	Seeder      *ScriptChainScript  `json:"seeder,omitempty"`
+	Builder     *ScriptChainScript  `json:"builder,omitempty"`
+	Pruners     []ScriptChainScript `json:"pruners,omitempty"`
+	Name        string              `json:"name"`
+	Description string              `json:"description"`
+	Permissions string              `json:"permissions"`
+}
+
+// ScriptChainScriptError is returned when a chain refers to a script which has
+// no published version, or none the chain's author is allowed to use.
+type ScriptChainScriptError struct {
+	Role     string
+	ScriptID uuid.UUID
+}
+
+func (e ScriptChainScriptError) Error() string {
+	return fmt.Sprintf("No usable published version of %s script %s", e.Role, e.ScriptID)
+}
+
+var scriptChainVersionColumns = []string{
+	"script_chain_versions.created_at",
+	"script_chain_versions.type",
+	"script_chain_versions.seeder_id",
+	"script_chain_versions.seeder_version_created_at",
+	"script_chain_versions.builder_id",
+	"script_chain_versions.builder_version_created_at",
+	"script_chain_versions.pruners",
 }
 
 func GetScriptChainWithAuthorizationCheck(spotifyUserID string, scriptChainID uuid.UUID) (ScriptChain, bool, error) {
@@ -54,7 +88,9 @@ func GetScriptChainWithAuthorizationCheck(spotifyUserID string, scriptChainID uu
 		"script_chains.forked_from_script_chain_id",
 		"script_chains.forked_from_script_chain_version_created_at",
 		"script_chains.is_private",
-		"script_chains.created_at").
+		"script_chains.created_at",
+		scriptChainLikesColumn).
+		Column(scriptChainLikedByColumn, spotifyUserID).
 		From("script_chains_view as script_chains").
 		LeftJoin("users_view users on users.id = script_chains.author_id").
 		Where(sq.And{
@@ -74,7 +110,9 @@ func GetScriptChainWithAuthorizationCheck(spotifyUserID string, scriptChainID uu
 		&scriptChain.ForkedFromScriptChainID,
 		&scriptChain.ForkedFromScriptChainVersion,
 		&scriptChain.IsPrivate,
-		&scriptChain.CreatedAt)
+		&scriptChain.CreatedAt,
+		&scriptChain.Likes,
+		&scriptChain.LikedByMe)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return ScriptChain{}, false, nil
@@ -85,21 +123,24 @@ func GetScriptChainWithAuthorizationCheck(spotifyUserID string, scriptChainID uu
 }
 
 func GetScriptChainVersionWithAuthorizationCheck(spotifyUserID string, scriptChainID uuid.UUID, scriptChainVersionID time.Time) (ScriptChainVersion, bool, error) {
-	var scriptChainVersion ScriptChainVersion
-	err := psql.Select("script_chain_versions.created_at", "script_chain_versions.type", "script_chain_versions.seeder_id", "script_chain_versions.builder_id", "script_chain_versions.pruner_ids").
+	scriptChainVersion, err := scanScriptChainVersion(psql.Select(scriptChainVersionColumns...).
 		From("script_chain_versions_view as script_chain_versions").
-		Join("script_chains_view script_chains on script_chains.id = script_chain_versions.script_id").
+		Join("script_chains_view script_chains on script_chains.id = script_chain_versions.script_chain_id").
 		LeftJoin("users_view users on users.id = script_chains.author_id").
 		Where(sq.And{
-			sq.Eq{"script_chains.id": scriptChainID},
+			sq.Eq{
+				"script_chains.id":                 scriptChainID,
+				"script_chain_versions.created_at": scriptChainVersionID,
+			},
 			sq.Or{
 				sq.Eq{"users.spotify_id": spotifyUserID},
 				sq.And{
 					sq.Eq{"script_chains.is_private": false},
+					sq.Eq{"script_chain_versions.type": ScriptSaveTypePublished},
 				},
 			},
 		}).
-		RunWith(postgresDB).QueryRow().Scan(&scriptChainVersion.CreatedAt, &scriptChainVersion.Type, &scriptChainVersion.SeederID, &scriptChainVersion.BuilderID, &scriptChainVersion.PrunerIDs)
+		RunWith(postgresDB).QueryRow())
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return ScriptChainVersion{}, false, nil
@@ -124,10 +165,12 @@ func GetScriptChainsBySpotifyUserID(spotifyUserID string, count uint64, from tim
 		"script_chains.description",
 		"users.spotify_id",
 		"users.name",
-		"script_chains.forked_from_script_id",
-		"script_chains.forked_from_script_version_created_at",
+		"script_chains.forked_from_script_chain_id",
+		"script_chains.forked_from_script_chain_version_created_at",
 		"script_chains.is_private",
-		"script_chains.created_at").
+		"script_chains.created_at",
+		scriptChainLikesColumn).
+		Column(scriptChainLikedByColumn, spotifyUserID).
 		From("script_chains_view as script_chains").
 		Join("users_view users on users.id = script_chains.author_id").
 		Where(where).
@@ -150,7 +193,9 @@ func GetScriptChainsBySpotifyUserID(spotifyUserID string, count uint64, from tim
 			&scriptChain.ForkedFromScriptChainID,
 			&scriptChain.ForkedFromScriptChainVersion,
 			&scriptChain.IsPrivate,
-			&scriptChain.CreatedAt)
+			&scriptChain.CreatedAt,
+			&scriptChain.Likes,
+			&scriptChain.LikedByMe)
 		if err != nil {
 			return nil, fmt.Errorf("Could not scan row: %s", err)
 		}
@@ -163,15 +208,16 @@ func GetScriptChainsBySpotifyUserID(spotifyUserID string, count uint64, from tim
 	return scriptChains, nil
 }
 
-func GetMostRecentScriptChainVersion(scriptChainID uuid.UUID) (version ScriptChainVersion, ok bool, err error) {
-	err = psql.Select("created_at", "type", "seeder_id", "builder_id", "pruner_ids").
+func GetMostRecentPublishedScriptChainVersion(scriptChainID uuid.UUID) (version ScriptChainVersion, ok bool, err error) {
+	version, err = scanScriptChainVersion(psql.Select(scriptChainVersionColumns...).
 		From("script_chain_versions_view as script_chain_versions").
 		Where(sq.Eq{
 			"script_chain_id": scriptChainID,
+			"type":            ScriptSaveTypePublished,
 		}).
 		OrderBy("created_at desc").
 		Limit(1).
-		RunWith(postgresDB).QueryRow().Scan(&version.CreatedAt, &version.Type, &version.SeederID, &version.BuilderID, &version.PrunerIDs)
+		RunWith(postgresDB).QueryRow())
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return ScriptChainVersion{}, false, nil
@@ -181,12 +227,15 @@ func GetMostRecentScriptChainVersion(scriptChainID uuid.UUID) (version ScriptCha
 	return version, true, nil
 }
 
-func GetScriptChainVersions(scriptChainID uuid.UUID, count uint64, from time.Time) (versions []ScriptChainVersion, err error) {
+func GetScriptChainVersions(scriptChainID uuid.UUID, count uint64, from time.Time, limitToPublished bool) (versions []ScriptChainVersion, err error) {
 	where := sq.And{sq.Eq{"script_chain_id": scriptChainID}}
 	if !from.IsZero() {
 		where = append(where, sq.Lt{"created_at": from})
 	}
-	sel := psql.Select("created_at", "type", "seeder_id", "builder_id", "pruner_ids").
+	if limitToPublished {
+		where = append(where, sq.Eq{"type": ScriptSaveTypePublished})
+	}
+	sel := psql.Select(scriptChainVersionColumns...).
 		From("script_chain_versions_view as script_chain_versions").
 		Where(where).
 		OrderBy("created_at desc").
@@ -197,8 +246,7 @@ func GetScriptChainVersions(scriptChainID uuid.UUID, count uint64, from time.Tim
 	}
 	defer rows.Close()
 	for rows.Next() {
-		var version ScriptChainVersion
-		err := rows.Scan(&version.CreatedAt, &version.Type, &version.SeederID, &version.BuilderID, &version.PrunerIDs)
+		version, err := scanScriptChainVersion(rows)
 		if err != nil {
 			return nil, fmt.Errorf("Could not scan row: %s", err)
 		}
@@ -211,8 +259,8 @@ func GetScriptChainVersions(scriptChainID uuid.UUID, count uint64, from time.Tim
 	return versions, nil
 }
 
-func GetNewScriptChains(count uint64, from time.Time) (scriptChains []ScriptChain, err error) {
-	where := sq.And{sq.Eq{"is_private": false}}
+func GetNewScriptChains(viewerSpotifyID string, count uint64, from time.Time) (scriptChains []ScriptChain, err error) {
+	where := sq.And{sq.Eq{"script_chains.is_private": false}}
 	if !from.IsZero() {
 		where = append(where, sq.Lt{"script_chains.created_at": from})
 	}
@@ -223,10 +271,12 @@ func GetNewScriptChains(count uint64, from time.Time) (scriptChains []ScriptChai
 		"script_chains.description",
 		"users.spotify_id",
 		"users.name",
-		"script_chains.forked_from_script_id",
-		"script_chains.forked_from_script_version_created_at",
+		"script_chains.forked_from_script_chain_id",
+		"script_chains.forked_from_script_chain_version_created_at",
 		"script_chains.is_private",
-		"script_chains.created_at").
+		"script_chains.created_at",
+		scriptChainLikesColumn).
+		Column(scriptChainLikedByColumn, viewerSpotifyID).
 		From("script_chains_view as script_chains").
 		LeftJoin("users_view users on users.id = script_chains.author_id").
 		Where(where).
@@ -249,7 +299,9 @@ func GetNewScriptChains(count uint64, from time.Time) (scriptChains []ScriptChai
 			&scriptChain.ForkedFromScriptChainID,
 			&scriptChain.ForkedFromScriptChainVersion,
 			&scriptChain.IsPrivate,
-			&scriptChain.CreatedAt)
+			&scriptChain.CreatedAt,
+			&scriptChain.Likes,
+			&scriptChain.LikedByMe)
 		if err != nil {
 			return nil, fmt.Errorf("Could not scan row: %s", err)
 		}
@@ -262,7 +314,9 @@ func GetNewScriptChains(count uint64, from time.Time) (scriptChains []ScriptChai
 	return scriptChains, nil
 }
 
-func CreateScriptChain(spotifyUserID, name, description string, seederID, builderID uuid.UUID, prunerIDs []uuid.UUID) (CreateOrUpdateScriptChainResponse, error) {
+// CreateScriptChain creates a chain with a draft first version. Scripts given
+// without a version are pinned to their most recent published version.
+func CreateScriptChain(spotifyUserID, name, description string, seeder *ScriptChainScript, builder ScriptChainScript, pruners []ScriptChainScript) (CreateOrUpdateScriptChainResponse, error) {
 	tx, err := postgresDB.Begin()
 	if err != nil {
 		return CreateOrUpdateScriptChainResponse{}, fmt.Errorf("Could not start transaction: %s", err)
@@ -271,96 +325,80 @@ func CreateScriptChain(spotifyUserID, name, description string, seederID, builde
 	if err != nil {
 		return CreateOrUpdateScriptChainResponse{}, common.TryToRollback(tx, fmt.Errorf("Could not get user ID from Spotify ID: %s", err))
 	}
-	scriptID := uuid.NewV4()
+	seeder, builder, pruners, err = pinScriptChainScripts(tx, spotifyUserID, seeder, builder, pruners)
+	if err != nil {
+		return CreateOrUpdateScriptChainResponse{}, common.TryToRollback(tx, err)
+	}
+	scriptChainID := uuid.NewV4()
 	_, err = psql.Insert("script_chains").Columns("id", "author_id", "name", "description").
-		Values(scriptID, userID, stringOrNull(name), stringOrNull(description)).
+		Values(scriptChainID, userID, stringOrNull(name), stringOrNull(description)).
 		RunWith(tx).Exec()
 	if err != nil {
 		return CreateOrUpdateScriptChainResponse{}, common.TryToRollback(tx, fmt.Errorf("Could not insert new script chain: %s", err))
 	}
-	_, err = psql.Insert("script_chain_versions").Columns("script_id", "seeder_id", "builder_id").
-		Values(scriptID, seederID, builderID).
-		RunWith(tx).Exec()
+	err = insertScriptChainVersion(tx, scriptChainID, ScriptSaveTypeDraft, seeder, builder, pruners)
 	if err != nil {
-		return CreateOrUpdateScriptChainResponse{}, common.TryToRollback(tx, fmt.Errorf("Could not insert new script chain version: %s", err))
+		return CreateOrUpdateScriptChainResponse{}, common.TryToRollback(tx, err)
 	}
-	// TODO pruner
 	if err = tx.Commit(); err != nil {
 		return CreateOrUpdateScriptChainResponse{}, common.TryToRollback(tx, fmt.Errorf("Could not commit: %s", err))
 	}
 	return CreateOrUpdateScriptChainResponse{
-		ID:        scriptID,
-		SeederID:  seederID,
-		BuilderID: builderID,
-		// TODO pruner IDs
+		ID:          scriptChainID,
+		Seeder:      seeder,
+		Builder:     &builder,
+		Pruners:     pruners,
 		Name:        name,
+		Description: description,
 		Permissions: permissionsPrivate,
 	}, nil
 }
 
-func UpdateScriptChain(scriptChainID uuid.UUID, name, description string, seederID, builderID uuid.UUID, prunerIDs []uuid.UUID, permissions string) (CreateOrUpdateScriptChainResponse, error) {
+// UpdateScriptChain saves a new version of the chain if a builder is given,
+// along with any details which are set.
+func UpdateScriptChain(spotifyUserID string, scriptChainID uuid.UUID, name, description string, seeder *ScriptChainScript, builder ScriptChainScript, pruners []ScriptChainScript, permissions string, scriptSaveType ScriptSaveType) (CreateOrUpdateScriptChainResponse, error) {
 	if name == "" && permissions == permissionsPublic {
 		return CreateOrUpdateScriptChainResponse{}, errors.New("Public script chains must have a name")
 	}
-	res := CreateOrUpdateScriptChainResponse{}
+	res := CreateOrUpdateScriptChainResponse{ID: scriptChainID}
 	tx, err := postgresDB.Begin()
 	if err != nil {
 		return CreateOrUpdateScriptChainResponse{}, fmt.Errorf("Could not start transaction: %s", err)
 	}
-	if !uuid.Equal(seederID, uuid.Nil) || !uuid.Equal(builderID, uuid.Nil) || len(prunerIDs) > 0 {
-		var mostRecentSeederID uuid.UUID
-		var mostRecentBuilderID uuid.UUID
-		var mostRecentPrunerIDs []uuid.UUID
-		err = psql.Select("seeder_id", "builder_id", "pruner_ids").
+	if !uuid.Equal(builder.ScriptID, uuid.Nil) {
+		seeder, builder, pruners, err = pinScriptChainScripts(tx, spotifyUserID, seeder, builder, pruners)
+		if err != nil {
+			return CreateOrUpdateScriptChainResponse{}, common.TryToRollback(tx, err)
+		}
+		mostRecent, err := scanScriptChainVersion(psql.Select(scriptChainVersionColumns...).
 			From("script_chain_versions_view as script_chain_versions").
 			Where(sq.Eq{"script_chain_id": scriptChainID}).
 			OrderBy("created_at desc").
 			Limit(1).
-			RunWith(tx).QueryRow().Scan(&mostRecentSeederID, &mostRecentBuilderID, &mostRecentPrunerIDs)
+			RunWith(tx).QueryRow())
 		if err != nil {
 			return CreateOrUpdateScriptChainResponse{}, common.TryToRollback(tx, fmt.Errorf("Could not check most recent script chain version: %s", err))
 		}
-		// Don't create a new version row if the code is the exact same
-		isSameAsOld := uuid.Equal(mostRecentSeederID, seederID) && uuid.Equal(mostRecentBuilderID, builderID)
-		if isSameAsOld {
-			if len(prunerIDs) != len(mostRecentPrunerIDs) {
-				isSameAsOld = false
-			} else {
-				mostRecentPrunerIDsMap := make(map[string]bool)
-				for _, mostRecentPrunerID := range mostRecentPrunerIDs {
-					mostRecentPrunerIDsMap[mostRecentPrunerID.String()] = true
-				}
-				for _, prunerID := range prunerIDs {
-					if !mostRecentPrunerIDsMap[prunerID.String()] {
-						isSameAsOld = false
-						break
-					}
-				}
-			}
-		}
-		if !isSameAsOld {
-			_, err = psql.Insert("script_chain_versions").Columns("script_id", "seeder_id", "builder_id").
-				Values(scriptChainID, seederID, builderID).
-				RunWith(tx).Exec()
+		// Don't create a new version row if the chain is the exact same, unless we are publishing and the previous save was not a publish
+		if !mostRecent.hasScripts(seeder, builder, pruners) || (mostRecent.Type != ScriptSaveTypePublished && scriptSaveType == ScriptSaveTypePublished) {
+			err = insertScriptChainVersion(tx, scriptChainID, scriptSaveType, seeder, builder, pruners)
 			if err != nil {
-				return CreateOrUpdateScriptChainResponse{}, common.TryToRollback(tx, fmt.Errorf("Could not insert new script chain version: %s", err))
+				return CreateOrUpdateScriptChainResponse{}, common.TryToRollback(tx, err)
 			}
-			// TODO pruner
 		}
-		res.ID = scriptChainID
-		res.SeederID = seederID
-		res.BuilderID = builderID
-		// TODO pruner
+		res.Seeder = seeder
+		res.Builder = &builder
+		res.Pruners = pruners
 	}
 	updateBuilder := psql.Update("script_chains").Where(sq.Eq{"id": scriptChainID}).RunWith(tx)
 	shouldUpdate := false
 	if name != "" {
-		updateBuilder.Set("name", name)
+		updateBuilder = updateBuilder.Set("name", name)
 		res.Name = name
 		shouldUpdate = true
 	}
 	if description != "" {
-		updateBuilder.Set("description", description)
+		updateBuilder = updateBuilder.Set("description", description)
 		res.Description = description
 		shouldUpdate = true
 	}
@@ -374,7 +412,7 @@ func UpdateScriptChain(scriptChainID uuid.UUID, name, description string, seeder
 		default:
 			return CreateOrUpdateScriptChainResponse{}, common.TryToRollback(tx, fmt.Errorf("Could not update script chain permissions, invalid permissions %s", permissions))
 		}
-		updateBuilder.Set("isPrivate", isPrivate)
+		updateBuilder = updateBuilder.Set("is_private", isPrivate)
 		res.Permissions = permissions
 		shouldUpdate = true
 	}
@@ -415,7 +453,10 @@ func DeleteScriptChainVersion(scriptChainID uuid.UUID, scriptChainVersionID time
 	return nil
 }
 
-func ForkScriptChain(spotifyUserID string, toForkScriptChainID uuid.UUID, toForkScriptChainName string, toForkVersionID time.Time) (CreateOrUpdateScriptChainResponse, error) {
+// ForkScriptChain copies a chain version, keeping the scripts pinned to the same
+// versions as the original. The forker must be able to use each of them, a
+// script may have been made private since the chain was saved.
+func ForkScriptChain(spotifyUserID string, toForkScriptChainID uuid.UUID, toForkScriptChainName string, toForkVersionID time.Time, onlyPublished bool) (CreateOrUpdateScriptChainResponse, error) {
 	tx, err := postgresDB.Begin()
 	if err != nil {
 		return CreateOrUpdateScriptChainResponse{}, fmt.Errorf("Could not start transaction: %s", err)
@@ -427,7 +468,10 @@ func ForkScriptChain(spotifyUserID string, toForkScriptChainID uuid.UUID, toFork
 	where := sq.Eq{
 		"script_chain_id": toForkScriptChainID,
 	}
-	sel := psql.Select("created_at", "type", "seeder_id", "builder_id", "pruner_ids").
+	if onlyPublished {
+		where["type"] = ScriptSaveTypePublished
+	}
+	sel := psql.Select(scriptChainVersionColumns...).
 		From("script_chain_versions_view as script_chain_versions").
 		Limit(1)
 	if !toForkVersionID.IsZero() {
@@ -435,12 +479,14 @@ func ForkScriptChain(spotifyUserID string, toForkScriptChainID uuid.UUID, toFork
 	} else {
 		sel = sel.OrderBy("created_at desc")
 	}
-	var toForkVersion ScriptChainVersion
-	err = sel.Where(where).
-		RunWith(tx).QueryRow().Scan(&toForkVersion.CreatedAt, &toForkVersion.Type, &toForkVersion.SeederID, &toForkVersion.BuilderID, &toForkVersion.PrunerIDs)
+	toForkVersion, err := scanScriptChainVersion(sel.Where(where).RunWith(tx).QueryRow())
 	if err != nil {
 		return CreateOrUpdateScriptChainResponse{}, common.TryToRollback(tx, fmt.Errorf("Could not get script chain version: %s", err))
 	}
+	toForkVersion.Seeder, toForkVersion.Builder, toForkVersion.Pruners, err = pinScriptChainScripts(tx, spotifyUserID, toForkVersion.Seeder, toForkVersion.Builder, toForkVersion.Pruners)
+	if err != nil {
+		return CreateOrUpdateScriptChainResponse{}, common.TryToRollback(tx, err)
+	}
 	scriptChainID := uuid.NewV4()
 	_, err = psql.Insert("script_chains").Columns("id", "author_id", "name", "forked_from_script_chain_id", "forked_from_script_chain_version_created_at").
 		Values(scriptChainID, userID, stringOrNull(toForkScriptChainName), toForkScriptChainID, toForkVersion.CreatedAt).
@@ -448,22 +494,156 @@ func ForkScriptChain(spotifyUserID string, toForkScriptChainID uuid.UUID, toFork
 	if err != nil {
 		return CreateOrUpdateScriptChainResponse{}, common.TryToRollback(tx, fmt.Errorf("Could not insert new script chain: %s", err))
 	}
-	_, err = psql.Insert("script_chain_versions").Columns("script_id", "type", "seeder_id", "builder_id").
-		Values(scriptChainID, ScriptSaveTypeFork, toForkVersion.SeederID, toForkVersion.BuilderID).
-		RunWith(tx).Exec()
+	err = insertScriptChainVersion(tx, scriptChainID, ScriptSaveTypeFork, toForkVersion.Seeder, toForkVersion.Builder, toForkVersion.Pruners)
 	if err != nil {
-		return CreateOrUpdateScriptChainResponse{}, common.TryToRollback(tx, fmt.Errorf("Could not insert new script chain version: %s", err))
+		return CreateOrUpdateScriptChainResponse{}, common.TryToRollback(tx, err)
 	}
-	// TODO pruner
 	if err = tx.Commit(); err != nil {
 		return CreateOrUpdateScriptChainResponse{}, common.TryToRollback(tx, fmt.Errorf("Could not commit: %s", err))
 	}
 	return CreateOrUpdateScriptChainResponse{
-		ID:        scriptChainID,
-		SeederID:  toForkVersion.SeederID,
-		BuilderID: toForkVersion.BuilderID,
-		// TODO pruner
+		ID:          scriptChainID,
+		Seeder:      toForkVersion.Seeder,
+		Builder:     &toForkVersion.Builder,
+		Pruners:     toForkVersion.Pruners,
 		Name:        toForkScriptChainName,
 		Permissions: permissionsPrivate,
 	}, nil
 }
+
+func insertScriptChainVersion(tx *sql.Tx, scriptChainID uuid.UUID, scriptSaveType ScriptSaveType, seeder *ScriptChainScript, builder ScriptChainScript, pruners []ScriptChainScript) error {
+	var seederID nullUUID
+	var seederVersion nullTime
+	if seeder != nil {
+		seederID.UUID, seederID.Valid = seeder.ScriptID, true
+		seederVersion.Time, seederVersion.Valid = seeder.Version, true
+	}
+	var createdAt time.Time
+	err := psql.Insert("script_chain_versions").
+		Columns("script_chain_id", "type", "seeder_id", "seeder_version_created_at", "builder_id", "builder_version_created_at").
+		Values(scriptChainID, scriptSaveType, seederID, seederVersion, builder.ScriptID, builder.Version).
+		Suffix("returning created_at").
+		RunWith(tx).QueryRow().Scan(&createdAt)
+	if err != nil {
+		return fmt.Errorf("Could not insert new script chain version: %s", err)
+	}
+	if len(pruners) == 0 {
+		return nil
+	}
+	insertPruners := psql.Insert("script_chain_pruners").
+		Columns("script_chain_id", "created_at", "pruner_id", "pruner_version_created_at", "execution_order")
+	for i, pruner := range pruners {
+		insertPruners = insertPruners.Values(scriptChainID, createdAt, pruner.ScriptID, pruner.Version, i)
+	}
+	_, err = insertPruners.RunWith(tx).Exec()
+	if err != nil {
+		return fmt.Errorf("Could not insert script chain pruners: %s", err)
+	}
+	return nil
+}
+
+// pinScriptChainScripts checks that the user may use every script in a chain
+// and fills in the version for any script given without one. Private scripts
+// can be used by their author and accepted collaborators.
+func pinScriptChainScripts(tx *sql.Tx, spotifyUserID string, seeder *ScriptChainScript, builder ScriptChainScript, pruners []ScriptChainScript) (*ScriptChainScript, ScriptChainScript, []ScriptChainScript, error) {
+	var pinnedSeeder *ScriptChainScript
+	if seeder != nil {
+		pinned, err := pinScriptChainScript(tx, spotifyUserID, "seeder", *seeder)
+		if err != nil {
+			return nil, ScriptChainScript{}, nil, err
+		}
+		pinnedSeeder = &pinned
+	}
+	pinnedBuilder, err := pinScriptChainScript(tx, spotifyUserID, "builder", builder)
+	if err != nil {
+		return nil, ScriptChainScript{}, nil, err
+	}
+	var pinnedPruners []ScriptChainScript
+	for _, pruner := range pruners {
+		pinned, err := pinScriptChainScript(tx, spotifyUserID, "pruner", pruner)
+		if err != nil {
+			return nil, ScriptChainScript{}, nil, err
+		}
+		pinnedPruners = append(pinnedPruners, pinned)
+	}
+	return pinnedSeeder, pinnedBuilder, pinnedPruners, nil
+}
+
+func pinScriptChainScript(tx *sql.Tx, spotifyUserID string, role string, script ScriptChainScript) (ScriptChainScript, error) {
+	where := sq.And{
+		sq.Eq{
+			"script_versions.script_id": script.ScriptID,
+			"script_versions.type":      ScriptSaveTypePublished,
+		},
+		sq.Or{
+			sq.Eq{"users.spotify_id": spotifyUserID},
+			sq.Eq{"scripts.is_private": false},
+			sq.Expr(scriptCollaboratorCondition, spotifyUserID),
+		},
+	}
+	if !script.Version.IsZero() {
+		where = append(where, sq.Eq{"script_versions.created_at": script.Version})
+	}
+	if script.Release != "" {
+		where = append(where, sq.Eq{"script_releases.name": script.Release})
+	}
+	var version time.Time
+	err := psql.Select("script_versions.created_at").
+		From("script_versions_view as script_versions").
+		Join("scripts_view scripts on scripts.id = script_versions.script_id").
+		LeftJoin("users_view users on users.id = scripts.author_id").
+		LeftJoin("script_releases_view script_releases on script_releases.script_id = script_versions.script_id and script_releases.version_created_at = script_versions.created_at").
+		Where(where).
+		OrderBy("script_versions.created_at desc").
+		Limit(1).
+		RunWith(tx).QueryRow().Scan(&version)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ScriptChainScript{}, ScriptChainScriptError{Role: role, ScriptID: script.ScriptID}
+		}
+		return ScriptChainScript{}, fmt.Errorf("Could not get %s script version: %s", role, err)
+	}
+	return ScriptChainScript{ScriptID: script.ScriptID, Version: version}, nil
+}
+
+func scanScriptChainVersion(row sq.RowScanner) (ScriptChainVersion, error) {
+	var version ScriptChainVersion
+	var seederID nullUUID
+	var seederVersion nullTime
+	var pruners []byte
+	err := row.Scan(&version.CreatedAt, &version.Type, &seederID, &seederVersion, &version.Builder.ScriptID, &version.Builder.Version, &pruners)
+	if err != nil {
+		return ScriptChainVersion{}, err
+	}
+	if seederID.Valid {
+		version.Seeder = &ScriptChainScript{ScriptID: seederID.UUID, Version: seederVersion.Time}
+	}
+	if err = json.Unmarshal(pruners, &version.Pruners); err != nil {
+		return ScriptChainVersion{}, fmt.Errorf("Could not parse pruners: %s", err)
+	}
+	return version, nil
+}
+
+// hasScripts is whether the version runs exactly these scripts, pruners in the
+// same order.
+func (v ScriptChainVersion) hasScripts(seeder *ScriptChainScript, builder ScriptChainScript, pruners []ScriptChainScript) bool {
+	if (v.Seeder == nil) != (seeder == nil) {
+		return false
+	}
+	if seeder != nil && !v.Seeder.equal(*seeder) {
+		return false
+	}
+	if !v.Builder.equal(builder) || len(v.Pruners) != len(pruners) {
+		return false
+	}
+	for i, pruner := range pruners {
+		if !v.Pruners[i].equal(pruner) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s ScriptChainScript) equal(other ScriptChainScript) bool {
+	return uuid.Equal(s.ScriptID, other.ScriptID) && s.Version.Equal(other.Version)
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+func Test_hasScripts(t *testing.T) {
+	at := time.Unix(1566918933, 0)
+	a := ScriptChainScript{ScriptID: uuid.NewV4(), Version: at}
+	b := ScriptChainScript{ScriptID: uuid.NewV4(), Version: at}
+	c := ScriptChainScript{ScriptID: uuid.NewV4(), Version: at}
+	version := ScriptChainVersion{Seeder: &a, Builder: b, Pruners: []ScriptChainScript{b, c}}
+	seeder := a
+	if !version.hasScripts(&seeder, b, []ScriptChainScript{b, c}) {
+		t.Fatalf("Expected same scripts to match")
+	}
+	if version.hasScripts(nil, b, []ScriptChainScript{b, c}) {
+		t.Fatalf("Expected missing seeder not to match")
+	}
+	if version.hasScripts(&seeder, b, []ScriptChainScript{c, b}) {
+		t.Fatalf("Expected reordered pruners not to match")
+	}
+	newer := ScriptChainScript{ScriptID: b.ScriptID, Version: at.Add(time.Second)}
+	if version.hasScripts(&seeder, newer, []ScriptChainScript{b, c}) {
+		t.Fatalf("Expected builder pinned to another version not to match")
+	}
+	if version.hasScripts(&seeder, b, []ScriptChainScript{b}) {
+		t.Fatalf("Expected fewer pruners not to match")
+	}
+}
@@ -0,0 +1,308 @@
+package models
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/satori/go.uuid"
+
+	"github.com/samuelhorwitz/phosphorescence/api/common"
+)
+
+var scriptsNamespace = uuid.NewV5(common.PhosphorUUIDV5Namespace, "scripts")
+
+var ErrScriptFileNotFound = errors.New("Script file not found")
+
+// ScriptStore holds script sources. Files are keyed by a hash of their content
+// so identical scripts are only ever stored once.
+type ScriptStore interface {
+	// Put stores the script if it isn't already stored and returns its key.
+	Put(script string) (uuid.UUID, error)
+	Head(fileID uuid.UUID) (bool, error)
+	// Get returns ErrScriptFileNotFound if there is no such file.
+	Get(fileID uuid.UUID) (string, error)
+	Delete(fileID uuid.UUID) error
+	// URL is where browsers can fetch the file from directly.
+	URL(fileID uuid.UUID) string
+}
+
+// SetScriptStore replaces where script sources are kept, tests use it to swap
+// in a MemoryScriptStore.
+func SetScriptStore(store ScriptStore) {
+	scriptStore = store
+}
+
+// ScriptFileID is the key a script's source is stored under.
+func ScriptFileID(script string) uuid.UUID {
+	return uuid.NewV5(scriptsNamespace, script)
+}
+
+// ScriptFileURL is where browsers can fetch a script version's source from.
+func ScriptFileURL(fileID uuid.UUID) string {
+	return scriptStore.URL(fileID)
+}
+
+// GetScriptFile gets the source of a script version.
+func GetScriptFile(fileID uuid.UUID) (string, error) {
+	return scriptStore.Get(fileID)
+}
+
+type S3ScriptStoreConfig struct {
+	Service       *s3.S3
+	Uploader      *s3manager.Uploader
+	Bucket        string
+	ACL           string
+	PublicURLBase string
+}
+
+// S3ScriptStore stores gzipped scripts in an S3 compatible bucket, such as
+// Spaces or MinIO.
+type S3ScriptStore struct {
+	cfg S3ScriptStoreConfig
+}
+
+func NewS3ScriptStore(cfg S3ScriptStoreConfig) *S3ScriptStore {
+	return &S3ScriptStore{cfg: cfg}
+}
+
+func (s *S3ScriptStore) Put(script string) (uuid.UUID, error) {
+	fileID := ScriptFileID(script)
+	exists, err := s.Head(fileID)
+	if err != nil {
+		return fileID, err
+	}
+	if exists {
+		return fileID, nil
+	}
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	if _, err = zw.Write([]byte(script)); err != nil {
+		return fileID, fmt.Errorf("Could not compress script: %s", err)
+	}
+	if err = zw.Close(); err != nil {
+		return fileID, fmt.Errorf("Could not close compression buffer: %s", err)
+	}
+	input := &s3manager.UploadInput{
+		Bucket:          aws.String(s.cfg.Bucket),
+		ContentType:     aws.String("application/javascript"),
+		ContentEncoding: aws.String("gzip"),
+		CacheControl:    aws.String("public, max-age=31536000"),
+		Key:             aws.String(fileID.String()),
+		Body:            &compressed,
+	}
+	if s.cfg.ACL != "" {
+		input.ACL = aws.String(s.cfg.ACL)
+	}
+	if _, err = s.cfg.Uploader.Upload(input); err != nil {
+		return fileID, fmt.Errorf("Could not upload script: %s", err)
+	}
+	return fileID, nil
+}
+
+func (s *S3ScriptStore) Head(fileID uuid.UUID) (bool, error) {
+	_, err := s.cfg.Service.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(s.cfg.Bucket),
+		Key:    aws.String(fileID.String()),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			if aerr.Code() == common.S3NotFoundCode {
+				return false, nil
+			}
+			return false, fmt.Errorf("Could not reach script storage: %s", aerr.Error())
+		}
+		return false, fmt.Errorf("Could not reach script storage: %s", err)
+	}
+	return true, nil
+}
+
+func (s *S3ScriptStore) Get(fileID uuid.UUID) (string, error) {
+	res, err := s.cfg.Service.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.cfg.Bucket),
+		Key:    aws.String(fileID.String()),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return "", ErrScriptFileNotFound
+		}
+		return "", fmt.Errorf("Could not get script from storage: %s", err)
+	}
+	defer res.Body.Close()
+	// Scripts are stored gzipped but the HTTP client may already have
+	// decompressed it because of the content encoding.
+	body := bufio.NewReader(res.Body)
+	var reader io.Reader = body
+	if magic, err := body.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			return "", fmt.Errorf("Could not decompress script: %s", err)
+		}
+		defer zr.Close()
+		reader = zr
+	}
+	script, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return "", fmt.Errorf("Could not read script: %s", err)
+	}
+	return string(script), nil
+}
+
+func (s *S3ScriptStore) Delete(fileID uuid.UUID) error {
+	_, err := s.cfg.Service.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(s.cfg.Bucket),
+		Key:    aws.String(fileID.String()),
+	})
+	if err != nil {
+		return fmt.Errorf("Could not delete script from storage: %s", err)
+	}
+	return nil
+}
+
+func (s *S3ScriptStore) URL(fileID uuid.UUID) string {
+	return joinScriptURL(s.cfg.PublicURLBase, fileID)
+}
+
+// LocalScriptStore keeps plain, uncompressed scripts in a directory so anything
+// serving static files can stand in for the bucket in development.
+type LocalScriptStore struct {
+	dir           string
+	publicURLBase string
+}
+
+func NewLocalScriptStore(dir, publicURLBase string) (*LocalScriptStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("Could not create script directory: %s", err)
+	}
+	return &LocalScriptStore{dir: dir, publicURLBase: publicURLBase}, nil
+}
+
+func (s *LocalScriptStore) Put(script string) (uuid.UUID, error) {
+	fileID := ScriptFileID(script)
+	exists, err := s.Head(fileID)
+	if err != nil || exists {
+		return fileID, err
+	}
+	// Write somewhere else first so a reader never sees half a script.
+	tmp, err := ioutil.TempFile(s.dir, ".upload-")
+	if err != nil {
+		return fileID, fmt.Errorf("Could not create script file: %s", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.WriteString(script); err != nil {
+		tmp.Close()
+		return fileID, fmt.Errorf("Could not write script file: %s", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fileID, fmt.Errorf("Could not write script file: %s", err)
+	}
+	if err = os.Chmod(tmp.Name(), 0644); err != nil {
+		return fileID, fmt.Errorf("Could not set script file permissions: %s", err)
+	}
+	if err = os.Rename(tmp.Name(), s.path(fileID)); err != nil {
+		return fileID, fmt.Errorf("Could not move script file into place: %s", err)
+	}
+	return fileID, nil
+}
+
+func (s *LocalScriptStore) Head(fileID uuid.UUID) (bool, error) {
+	_, err := os.Stat(s.path(fileID))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("Could not check for script file: %s", err)
+	}
+	return true, nil
+}
+
+func (s *LocalScriptStore) Get(fileID uuid.UUID) (string, error) {
+	script, err := ioutil.ReadFile(s.path(fileID))
+	if os.IsNotExist(err) {
+		return "", ErrScriptFileNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("Could not read script file: %s", err)
+	}
+	return string(script), nil
+}
+
+func (s *LocalScriptStore) Delete(fileID uuid.UUID) error {
+	err := os.Remove(s.path(fileID))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Could not delete script file: %s", err)
+	}
+	return nil
+}
+
+func (s *LocalScriptStore) URL(fileID uuid.UUID) string {
+	return joinScriptURL(s.publicURLBase, fileID)
+}
+
+func (s *LocalScriptStore) path(fileID uuid.UUID) string {
+	return filepath.Join(s.dir, fileID.String())
+}
+
+// MemoryScriptStore keeps scripts in memory, it is meant for tests along with
+// SetScriptStore.
+type MemoryScriptStore struct {
+	mux           sync.RWMutex
+	files         map[uuid.UUID]string
+	publicURLBase string
+}
+
+func NewMemoryScriptStore(publicURLBase string) *MemoryScriptStore {
+	return &MemoryScriptStore{files: make(map[uuid.UUID]string), publicURLBase: publicURLBase}
+}
+
+func (s *MemoryScriptStore) Put(script string) (uuid.UUID, error) {
+	fileID := ScriptFileID(script)
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.files[fileID] = script
+	return fileID, nil
+}
+
+func (s *MemoryScriptStore) Head(fileID uuid.UUID) (bool, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	_, ok := s.files[fileID]
+	return ok, nil
+}
+
+func (s *MemoryScriptStore) Get(fileID uuid.UUID) (string, error) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	script, ok := s.files[fileID]
+	if !ok {
+		return "", ErrScriptFileNotFound
+	}
+	return script, nil
+}
+
+func (s *MemoryScriptStore) Delete(fileID uuid.UUID) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	delete(s.files, fileID)
+	return nil
+}
+
+func (s *MemoryScriptStore) URL(fileID uuid.UUID) string {
+	return joinScriptURL(s.publicURLBase, fileID)
+}
+
+func joinScriptURL(base string, fileID uuid.UUID) string {
+	return strings.TrimSuffix(base, "/") + "/" + fileID.String()
+}
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func Test_LocalScriptStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scripts")
+	if err != nil {
+		t.Fatalf("Could not create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := NewLocalScriptStore(dir, "https://scripts.example/")
+	if err != nil {
+		t.Fatalf("Could not create store: %s", err)
+	}
+	testScriptStore(t, store)
+}
+
+func Test_MemoryScriptStore(t *testing.T) {
+	testScriptStore(t, NewMemoryScriptStore("https://scripts.example/"))
+}
+
+func testScriptStore(t *testing.T, store ScriptStore) {
+	script := "builder.build = () => [];"
+	fileID, err := store.Put(script)
+	if err != nil {
+		t.Fatalf("Could not put script: %s", err)
+	}
+	if fileID != ScriptFileID(script) {
+		t.Fatalf("Expected script to be keyed by its content, got %s", fileID)
+	}
+	if again, err := store.Put(script); err != nil || again != fileID {
+		t.Fatalf("Expected putting the same script again to return the same key, got %s, %v", again, err)
+	}
+	if exists, err := store.Head(fileID); err != nil || !exists {
+		t.Fatalf("Expected script to exist, got %t, %v", exists, err)
+	}
+	if stored, err := store.Get(fileID); err != nil || stored != script {
+		t.Fatalf("Expected stored script back, got %q, %v", stored, err)
+	}
+	if url := store.URL(fileID); url != "https://scripts.example/"+fileID.String() {
+		t.Fatalf("Unexpected URL %s", url)
+	}
+	if err = store.Delete(fileID); err != nil {
+		t.Fatalf("Could not delete script: %s", err)
+	}
+	if exists, err := store.Head(fileID); err != nil || exists {
+		t.Fatalf("Expected script to be gone, got %t, %v", exists, err)
+	}
+	if _, err = store.Get(fileID); err != ErrScriptFileNotFound {
+		t.Fatalf("Expected not found after delete, got %v", err)
+	}
+}
@@ -53,7 +53,7 @@ func Query(q string) (searchResults []searchResult, _ error) {
 		} else {
 			searchResult.PartialDescription = false
 			searchResults = append(searchResults, searchResult)
-			return searchResults, nil
+			return withLiveLikeCounts(searchResults)
 		}
 	}
 	rows, err := postgresDB.Query("select rank, id, type, name, description, author_name, likes from search($1, $2, $3) limit 50", q, pq.Array(strictMatches), pq.Array(tags))
@@ -79,7 +79,7 @@ func Query(q string) (searchResults []searchResult, _ error) {
 		searchResult.PartialDescription = true
 		searchResults = append(searchResults, searchResult)
 	}
-	return searchResults, nil
+	return withLiveLikeCounts(searchResults)
 }
 
 func QueryTag(tag string) (searchResults []searchResult, _ error) {
@@ -100,6 +100,37 @@ func QueryTag(tag string) (searchResults []searchResult, _ error) {
 		searchResult.PartialDescription = false
 		searchResults = append(searchResults, searchResult)
 	}
+	return withLiveLikeCounts(searchResults)
+}
+
+// withLiveLikeCounts replaces the like counts from searchables, which lag behind
+// until the next refresh, with the current counts.
+func withLiveLikeCounts(searchResults []searchResult) ([]searchResult, error) {
+	var scriptIDs, scriptChainIDs []uuid.UUID
+	for _, searchResult := range searchResults {
+		switch searchResult.ResultType {
+		case scriptResultType:
+			scriptIDs = append(scriptIDs, searchResult.ID)
+		case scriptChainResultType:
+			scriptChainIDs = append(scriptChainIDs, searchResult.ID)
+		}
+	}
+	scriptLikes, err := getLikeCounts("script_likes", "script_id", scriptIDs)
+	if err != nil {
+		return nil, err
+	}
+	scriptChainLikes, err := getLikeCounts("script_chain_likes", "script_chain_id", scriptChainIDs)
+	if err != nil {
+		return nil, err
+	}
+	for i, searchResult := range searchResults {
+		switch searchResult.ResultType {
+		case scriptResultType:
+			searchResults[i].LikeCount = scriptLikes[searchResult.ID]
+		case scriptChainResultType:
+			searchResults[i].LikeCount = scriptChainLikes[searchResult.ID]
+		}
+	}
 	return searchResults, nil
 }
 
@@ -64,6 +64,17 @@ type SpotifyFeatures struct {
 const maxTracksPerSpotifyRequest = 50
 const maxTrackFeaturessPerSpotifyRequest = 100
 
+// Spotify only says whether a track is playable when asked about a market and
+// tracks are cached per market, so lookups without a region use this one.
+const defaultRegion = "US"
+
+func normalizeRegion(region string) string {
+	if region == "" {
+		return defaultRegion
+	}
+	return region
+}
+
 type TrackNotFoundInRegionError struct {
 	region string
 }
@@ -179,6 +190,7 @@ func GetTracks(ctx context.Context, region string, trackIDs []string) ([]*Spotif
 }
 
 func getTracks(ctx context.Context, token *oauth2.Token, region string, trackIDs []string) ([]*SpotifyTrackEnvelope, error) {
+	region = normalizeRegion(region)
 	var missingFromCache []string
 	missingFromCacheMap := make(map[string]int)
 	cachedTracks := getTracksFromCache(region, trackIDs)
@@ -210,12 +222,7 @@ func getTracks(ctx context.Context, token *oauth2.Token, region string, trackIDs
 			tracksMap[envelope.OriginalID()] = &envelope
 		}
 	}
-	var tracks []*SpotifyTrackEnvelope
-	for _, trackID := range trackIDs {
-		tracks = append(tracks, tracksMap[trackID])
-	}
-	var err error
-	tracks, err = populateAudioFeatures(ctx, token, region, tracks)
+	tracks, err := populateAudioFeatures(ctx, token, region, orderTracks(trackIDs, tracksMap))
 	if err != nil {
 		return nil, fmt.Errorf("Could not get missing audio features: %s", err)
 	}
@@ -253,13 +260,29 @@ func populateAudioFeatures(ctx context.Context, token *oauth2.Token, region stri
 			setTrackInCache(region, id, tracks[index])
 		}
 	}
+	return withFeatures(tracks), nil
+}
+
+// orderTracks puts the tracks we found in the order they were asked for,
+// leaving out any we couldn't find.
+func orderTracks(trackIDs []string, tracksMap map[string]*SpotifyTrackEnvelope) []*SpotifyTrackEnvelope {
+	var tracks []*SpotifyTrackEnvelope
+	for _, trackID := range trackIDs {
+		if track, ok := tracksMap[trackID]; ok {
+			tracks = append(tracks, track)
+		}
+	}
+	return tracks
+}
+
+func withFeatures(tracks []*SpotifyTrackEnvelope) []*SpotifyTrackEnvelope {
 	var filteredTracks []*SpotifyTrackEnvelope
 	for _, track := range tracks {
 		if track.Features != nil {
 			filteredTracks = append(filteredTracks, track)
 		}
 	}
-	return filteredTracks, nil
+	return filteredTracks
 }
 
 func getTrackFromSpotify(ctx context.Context, token *oauth2.Token, region, trackID string) (*SpotifyTrack, error) {
@@ -0,0 +1,11 @@
+package models
+
+import "testing"
+
+func Test_normalizeRegion(t *testing.T) {
+	for region, expected := range map[string]string{"": defaultRegion, "GB": "GB", defaultRegion: defaultRegion} {
+		if actual := normalizeRegion(region); actual != expected {
+			t.Errorf("Expected %q to normalize to %s, got %s", region, expected, actual)
+		}
+	}
+}
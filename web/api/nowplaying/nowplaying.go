@@ -0,0 +1,167 @@
+// Package nowplaying polls Spotify for what a user is playing on behalf of
+// every open stream that user has, so that many tabs cost one poller rather
+// than one poller each. Pollers live in this process only, so a user connected
+// to more than one API instance gets one poller per instance.
+package nowplaying
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/samuelhorwitz/phosphorescence/api/models"
+	"github.com/samuelhorwitz/phosphorescence/api/session"
+)
+
+type EventType string
+
+const (
+	EventTypeTrack       EventType = "track"
+	EventTypeProgress    EventType = "progress"
+	EventTypePause       EventType = "pause"
+	EventTypeResume      EventType = "resume"
+	EventTypeUnavailable EventType = "unavailable"
+)
+
+type Event struct {
+	Type     EventType        `json:"type"`
+	Playback *models.Playback `json:"playback,omitempty"`
+	Message  string           `json:"message,omitempty"`
+}
+
+const (
+	playingInterval = 5 * time.Second
+	pausedInterval  = 10 * time.Second
+	minimumInterval = 1 * time.Second
+	// Spotify takes a moment to report the next track once one ends.
+	trackEndSlack = 500 * time.Millisecond
+	pollTimeout   = 5 * time.Second
+	// Slow subscribers drop events rather than hold up everyone else, the next
+	// poll will catch them up anyway.
+	subscriberBuffer = 4
+)
+
+type poller struct {
+	mux         sync.Mutex
+	sess        *session.Session
+	subscribers map[chan Event]struct{}
+	last        *Event
+	stop        context.CancelFunc
+}
+
+var (
+	pollersMux sync.Mutex
+	pollers    = make(map[string]*poller)
+)
+
+// Subscribe returns a channel of now playing events for the session's user and
+// a function to call once the caller is done listening. If the user is already
+// being polled the most recent state is sent straight away.
+func Subscribe(sess *session.Session) (<-chan Event, func()) {
+	events := make(chan Event, subscriberBuffer)
+	pollersMux.Lock()
+	p, ok := pollers[sess.SpotifyID]
+	if !ok {
+		ctx, cancel := context.WithCancel(context.Background())
+		p = &poller{
+			sess:        sess,
+			subscribers: make(map[chan Event]struct{}),
+			stop:        cancel,
+		}
+		pollers[sess.SpotifyID] = p
+		go p.run(ctx)
+	}
+	p.mux.Lock()
+	// The newest session has the freshest token.
+	p.sess = sess
+	p.subscribers[events] = struct{}{}
+	if p.last != nil {
+		snapshot := *p.last
+		if snapshot.Type == EventTypeProgress || snapshot.Type == EventTypePause || snapshot.Type == EventTypeResume {
+			snapshot.Type = EventTypeTrack
+		}
+		events <- snapshot
+	}
+	p.mux.Unlock()
+	pollersMux.Unlock()
+	return events, func() {
+		pollersMux.Lock()
+		defer pollersMux.Unlock()
+		p.mux.Lock()
+		defer p.mux.Unlock()
+		delete(p.subscribers, events)
+		if len(p.subscribers) == 0 {
+			p.stop()
+			if pollers[sess.SpotifyID] == p {
+				delete(pollers, sess.SpotifyID)
+			}
+		}
+	}
+}
+
+func (p *poller) run(ctx context.Context) {
+	for {
+		p.mux.Lock()
+		sess := p.sess
+		p.mux.Unlock()
+		pollCtx, cancel := context.WithTimeout(ctx, pollTimeout)
+		playback, err := models.GetCurrentPlayback(pollCtx, sess)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			// The listening log is best effort, it shouldn't get in the way
+			// of telling subscribers what is playing.
+			models.ObservePlayback(sess, playback)
+		}
+		p.mux.Lock()
+		event := nextEvent(p.last, playback, err)
+		p.last = &event
+		for subscriber := range p.subscribers {
+			select {
+			case subscriber <- event:
+			default:
+			}
+		}
+		p.mux.Unlock()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(nextInterval(event)):
+		}
+	}
+}
+
+func nextEvent(last *Event, playback models.Playback, err error) Event {
+	if err != nil {
+		return Event{Type: EventTypeUnavailable, Message: err.Error()}
+	}
+	event := Event{Type: EventTypeProgress, Playback: &playback}
+	switch {
+	case last == nil || last.Playback == nil || last.Playback.Type != playback.Type || last.Playback.ItemURI() != playback.ItemURI():
+		event.Type = EventTypeTrack
+	case last.Playback.IsPlaying && !playback.IsPlaying:
+		event.Type = EventTypePause
+	case !last.Playback.IsPlaying && playback.IsPlaying:
+		event.Type = EventTypeResume
+	}
+	return event
+}
+
+// nextInterval polls slowly while nothing is happening and wakes up just after
+// the current track should end so track changes show up promptly.
+func nextInterval(event Event) time.Duration {
+	if event.Playback == nil || !event.Playback.IsPlaying {
+		return pausedInterval
+	}
+	duration := event.Playback.Duration()
+	remaining := time.Duration(duration-event.Playback.Progress)*time.Millisecond + trackEndSlack
+	if duration == 0 || remaining > playingInterval {
+		return playingInterval
+	}
+	if remaining < minimumInterval {
+		return minimumInterval
+	}
+	return remaining
+}
@@ -0,0 +1,54 @@
+package nowplaying
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/samuelhorwitz/phosphorescence/api/models"
+)
+
+func Test_nextEvent(t *testing.T) {
+	track := &models.SpotifyPlaybackTrack{ID: "a", URI: "spotify:track:a"}
+	playing := models.Playback{Type: models.PlaybackItemTrack, IsPlaying: true, Track: track}
+	paused := models.Playback{Type: models.PlaybackItemTrack, IsPlaying: false, Track: track}
+	other := models.Playback{Type: models.PlaybackItemTrack, IsPlaying: true, Track: &models.SpotifyPlaybackTrack{ID: "b", URI: "spotify:track:b"}}
+	nothing := models.Playback{Type: models.PlaybackItemNothing}
+	if event := nextEvent(nil, playing, nil); event.Type != EventTypeTrack {
+		t.Fatalf("Expected first event to be track, got %s", event.Type)
+	}
+	last := Event{Type: EventTypeTrack, Playback: &playing}
+	if event := nextEvent(&last, playing, nil); event.Type != EventTypeProgress {
+		t.Fatalf("Expected progress, got %s", event.Type)
+	}
+	if event := nextEvent(&last, paused, nil); event.Type != EventTypePause {
+		t.Fatalf("Expected pause, got %s", event.Type)
+	}
+	if event := nextEvent(&last, other, nil); event.Type != EventTypeTrack {
+		t.Fatalf("Expected track, got %s", event.Type)
+	}
+	if event := nextEvent(&last, nothing, nil); event.Type != EventTypeTrack {
+		t.Fatalf("Expected track when playback stops, got %s", event.Type)
+	}
+	if event := nextEvent(&last, models.Playback{}, errors.New("nope")); event.Type != EventTypeUnavailable {
+		t.Fatalf("Expected unavailable, got %s", event.Type)
+	}
+}
+
+func Test_nextInterval(t *testing.T) {
+	if interval := nextInterval(Event{Type: EventTypeUnavailable}); interval != pausedInterval {
+		t.Fatalf("Expected paused interval, got %s", interval)
+	}
+	playback := models.Playback{IsPlaying: true, Progress: 10000, Track: &models.SpotifyPlaybackTrack{Duration: 200000}}
+	if interval := nextInterval(Event{Playback: &playback}); interval != playingInterval {
+		t.Fatalf("Expected playing interval, got %s", interval)
+	}
+	playback.Progress = 198000
+	if interval := nextInterval(Event{Playback: &playback}); interval != 2*time.Second+trackEndSlack {
+		t.Fatalf("Expected interval to end of track, got %s", interval)
+	}
+	playback.Progress = 200000
+	if interval := nextInterval(Event{Playback: &playback}); interval != minimumInterval {
+		t.Fatalf("Expected minimum interval, got %s", interval)
+	}
+}
@@ -0,0 +1,89 @@
+package party
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/samuelhorwitz/phosphorescence/api/common"
+	"github.com/samuelhorwitz/phosphorescence/api/models"
+)
+
+type EventType string
+
+const (
+	EventTypeState           EventType = "state"
+	EventTypeJoined          EventType = "joined"
+	EventTypeLeft            EventType = "left"
+	EventTypeKicked          EventType = "kicked"
+	EventTypeEnded           EventType = "ended"
+	EventTypeHostUnavailable EventType = "host_unavailable"
+	EventTypeMemberError     EventType = "member_error"
+)
+
+type Event struct {
+	Type     EventType        `json:"type"`
+	Member   *Member          `json:"member,omitempty"`
+	Playback *models.Playback `json:"playback,omitempty"`
+	Message  string           `json:"message,omitempty"`
+}
+
+// Subscribers that fall behind drop events, the next state event catches them
+// up.
+const subscriberBuffer = 8
+
+// Subscribe listens for the party's events until ctx is done, at which point
+// the channel is closed. Events come over Redis pub/sub so members connected to
+// any instance hear from whichever instance is syncing.
+func Subscribe(ctx context.Context, partyID string) (<-chan Event, error) {
+	psc := redis.PubSubConn{Conn: common.RedisPool.Get()}
+	if err := psc.Subscribe(getEventsChannel(partyID)); err != nil {
+		psc.Close()
+		return nil, fmt.Errorf("Could not subscribe to party events: %s", err)
+	}
+	events := make(chan Event, subscriberBuffer)
+	go func() {
+		<-ctx.Done()
+		psc.Unsubscribe()
+	}()
+	go func() {
+		defer close(events)
+		defer psc.Close()
+		for {
+			switch message := psc.Receive().(type) {
+			case redis.Message:
+				var event Event
+				if err := json.Unmarshal(message.Data, &event); err != nil {
+					if !isProduction {
+						log.Printf("Could not parse party event: %s", err)
+					}
+					continue
+				}
+				select {
+				case events <- event:
+				default:
+				}
+			case redis.Subscription:
+				if message.Count == 0 {
+					return
+				}
+			case error:
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
+func publish(redisConn redis.Conn, partyID string, event Event) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Could not marshal party event: %s", err)
+		return
+	}
+	if _, err = redisConn.Do("PUBLISH", getEventsChannel(partyID), data); err != nil {
+		log.Printf("Could not publish party event: %s", err)
+	}
+}
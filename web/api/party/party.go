@@ -0,0 +1,428 @@
+// Package party lets a host share what they are playing with followers who
+// listen along on their own Spotify devices. All party state lives in Redis so
+// any API instance can serve any member, and exactly one instance at a time
+// keeps the followers in sync with the host.
+package party
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/samuelhorwitz/phosphorescence/api/common"
+	"github.com/samuelhorwitz/phosphorescence/api/session"
+)
+
+const (
+	partyPrefix     = "party"
+	partyUserPrefix = "party_user"
+	// Parties are refreshed whenever anything happens so this is really an
+	// idle timeout.
+	partyExpiration = 12 * time.Hour
+	maxMembers      = 20
+)
+
+var (
+	ErrPartyNotFound  = errors.New("Party not found")
+	ErrAlreadyInParty = errors.New("Already in a party")
+	ErrPartyFull      = fmt.Errorf("Party is full (max %d members)", maxMembers)
+	ErrKicked         = errors.New("Kicked from party")
+	ErrNotHost        = errors.New("Only the host can do that")
+	ErrNotMember      = errors.New("Not a member of this party")
+)
+
+// Joining checks the member cap and adds the member in one go so two people
+// can't both take the last spot. Members who are already in the party are
+// just updated.
+var joinScript = redis.NewScript(1, `
+if redis.call("HEXISTS", KEYS[1], ARGV[1]) == 0 and redis.call("HLEN", KEYS[1]) >= tonumber(ARGV[3]) then
+	return 0
+end
+redis.call("HSET", KEYS[1], ARGV[1], ARGV[2])
+return 1
+`)
+
+var (
+	isProduction bool
+	instanceID   string
+)
+
+type Config struct {
+	IsProduction bool
+}
+
+func Initialize(cfg *Config) {
+	isProduction = cfg.IsProduction
+	var err error
+	instanceID, err = randomID()
+	if err != nil {
+		log.Fatalf("Could not create party instance ID: %s", err)
+	}
+}
+
+type Party struct {
+	ID        string    `json:"id"`
+	Host      Member    `json:"host"`
+	Members   []Member  `json:"members"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type Member struct {
+	SpotifyID string `json:"spotifyId"`
+	Name      string `json:"name"`
+}
+
+// The session ID is what lets the syncer act on a member's behalf, it must
+// never leave Redis.
+type storedMember struct {
+	Member
+	SessionID string `json:"sessionId"`
+}
+
+type storedParty struct {
+	HostSpotifyID string `redis:"host_spotify_id"`
+	HostName      string `redis:"host_name"`
+	HostSessionID string `redis:"host_session_id"`
+	CreatedAt     int64  `redis:"created_at"`
+}
+
+func Create(sess *session.Session) (Party, error) {
+	redisConn := common.RedisPool.Get()
+	defer redisConn.Close()
+	if _, ok, err := currentParty(redisConn, sess.SpotifyID); err != nil {
+		return Party{}, err
+	} else if ok {
+		return Party{}, ErrAlreadyInParty
+	}
+	partyID, err := randomID()
+	if err != nil {
+		return Party{}, fmt.Errorf("Could not create party ID: %s", err)
+	}
+	createdAt := time.Now()
+	redisConn.Send("MULTI")
+	redisConn.Send("HMSET", getPartyKey(partyID),
+		"host_spotify_id", sess.SpotifyID,
+		"host_name", sess.SpotifyName,
+		"host_session_id", sess.ID,
+		"created_at", createdAt.Unix(),
+	)
+	redisConn.Send("EXPIRE", getPartyKey(partyID), int(partyExpiration.Seconds()))
+	redisConn.Send("SET", getPartyUserKey(sess.SpotifyID), partyID, "EX", int(partyExpiration.Seconds()))
+	if _, err = redisConn.Do("EXEC"); err != nil {
+		return Party{}, fmt.Errorf("Could not save party: %s", err)
+	}
+	EnsureSyncing(partyID)
+	return Party{
+		ID:        partyID,
+		Host:      Member{SpotifyID: sess.SpotifyID, Name: sess.SpotifyName},
+		Members:   []Member{},
+		CreatedAt: createdAt,
+	}, nil
+}
+
+func Get(partyID string) (Party, error) {
+	redisConn := common.RedisPool.Get()
+	defer redisConn.Close()
+	stored, err := getStoredParty(redisConn, partyID)
+	if err != nil {
+		return Party{}, err
+	}
+	members, err := getStoredMembers(redisConn, partyID)
+	if err != nil {
+		return Party{}, err
+	}
+	party := Party{
+		ID:        partyID,
+		Host:      Member{SpotifyID: stored.HostSpotifyID, Name: stored.HostName},
+		Members:   []Member{},
+		CreatedAt: time.Unix(stored.CreatedAt, 0),
+	}
+	for _, member := range members {
+		party.Members = append(party.Members, member.Member)
+	}
+	return party, nil
+}
+
+// IsInParty is true for the host as well as members. It fails with
+// ErrPartyNotFound if the party has ended.
+func IsInParty(partyID, spotifyID string) (bool, error) {
+	redisConn := common.RedisPool.Get()
+	defer redisConn.Close()
+	if _, err := getStoredParty(redisConn, partyID); err != nil {
+		return false, err
+	}
+	currentPartyID, ok, err := currentParty(redisConn, spotifyID)
+	if err != nil {
+		return false, err
+	}
+	return ok && currentPartyID == partyID, nil
+}
+
+func Join(partyID string, sess *session.Session) (Party, error) {
+	redisConn := common.RedisPool.Get()
+	defer redisConn.Close()
+	stored, err := getStoredParty(redisConn, partyID)
+	if err != nil {
+		return Party{}, err
+	}
+	if stored.HostSpotifyID == sess.SpotifyID {
+		return Party{}, ErrAlreadyInParty
+	}
+	if currentPartyID, ok, err := currentParty(redisConn, sess.SpotifyID); err != nil {
+		return Party{}, err
+	} else if ok && currentPartyID != partyID {
+		return Party{}, ErrAlreadyInParty
+	}
+	kicked, err := redis.Bool(redisConn.Do("SISMEMBER", getKickedKey(partyID), sess.SpotifyID))
+	if err != nil {
+		return Party{}, fmt.Errorf("Could not check if kicked: %s", err)
+	}
+	if kicked {
+		return Party{}, ErrKicked
+	}
+	member := storedMember{
+		Member:    Member{SpotifyID: sess.SpotifyID, Name: sess.SpotifyName},
+		SessionID: sess.ID,
+	}
+	memberJSON, err := json.Marshal(member)
+	if err != nil {
+		return Party{}, fmt.Errorf("Could not marshal member: %s", err)
+	}
+	joined, err := redis.Bool(joinScript.Do(redisConn, getMembersKey(partyID), sess.SpotifyID, memberJSON, maxMembers))
+	if err != nil {
+		return Party{}, fmt.Errorf("Could not join party: %s", err)
+	}
+	if !joined {
+		return Party{}, ErrPartyFull
+	}
+	if _, err = redisConn.Do("SET", getPartyUserKey(sess.SpotifyID), partyID, "EX", int(partyExpiration.Seconds())); err != nil {
+		return Party{}, fmt.Errorf("Could not join party: %s", err)
+	}
+	if err = touch(redisConn, partyID, stored); err != nil {
+		return Party{}, err
+	}
+	publish(redisConn, partyID, Event{Type: EventTypeJoined, Member: &member.Member})
+	EnsureSyncing(partyID)
+	return Get(partyID)
+}
+
+// Leave removes a member from the party. If the host leaves the party ends.
+func Leave(partyID string, sess *session.Session) error {
+	redisConn := common.RedisPool.Get()
+	defer redisConn.Close()
+	stored, err := getStoredParty(redisConn, partyID)
+	if err != nil {
+		return err
+	}
+	if stored.HostSpotifyID == sess.SpotifyID {
+		return end(redisConn, partyID, stored)
+	}
+	removed, err := removeMember(redisConn, partyID, sess.SpotifyID)
+	if err != nil {
+		return err
+	}
+	if !removed {
+		return ErrNotMember
+	}
+	if err = touch(redisConn, partyID, stored); err != nil {
+		return err
+	}
+	publish(redisConn, partyID, Event{Type: EventTypeLeft, Member: &Member{SpotifyID: sess.SpotifyID, Name: sess.SpotifyName}})
+	return nil
+}
+
+// Kick removes a member and stops them from joining again.
+func Kick(partyID string, sess *session.Session, spotifyID string) error {
+	redisConn := common.RedisPool.Get()
+	defer redisConn.Close()
+	stored, err := getStoredParty(redisConn, partyID)
+	if err != nil {
+		return err
+	}
+	if stored.HostSpotifyID != sess.SpotifyID {
+		return ErrNotHost
+	}
+	isMember, err := redis.Bool(redisConn.Do("HEXISTS", getMembersKey(partyID), spotifyID))
+	if err != nil {
+		return fmt.Errorf("Could not check party membership: %s", err)
+	}
+	if !isMember {
+		return ErrNotMember
+	}
+	if _, err = redisConn.Do("SADD", getKickedKey(partyID), spotifyID); err != nil {
+		return fmt.Errorf("Could not mark member as kicked: %s", err)
+	}
+	if _, err = redisConn.Do("EXPIRE", getKickedKey(partyID), int(partyExpiration.Seconds())); err != nil {
+		return fmt.Errorf("Could not set kicked expiration: %s", err)
+	}
+	removed, err := removeMember(redisConn, partyID, spotifyID)
+	if err != nil {
+		return err
+	}
+	if !removed {
+		return ErrNotMember
+	}
+	if err = touch(redisConn, partyID, stored); err != nil {
+		return err
+	}
+	publish(redisConn, partyID, Event{Type: EventTypeKicked, Member: &Member{SpotifyID: spotifyID}})
+	return nil
+}
+
+func End(partyID string, sess *session.Session) error {
+	redisConn := common.RedisPool.Get()
+	defer redisConn.Close()
+	stored, err := getStoredParty(redisConn, partyID)
+	if err != nil {
+		return err
+	}
+	if stored.HostSpotifyID != sess.SpotifyID {
+		return ErrNotHost
+	}
+	return end(redisConn, partyID, stored)
+}
+
+func end(redisConn redis.Conn, partyID string, stored storedParty) error {
+	members, err := getStoredMembers(redisConn, partyID)
+	if err != nil {
+		return err
+	}
+	redisConn.Send("MULTI")
+	for _, member := range members {
+		redisConn.Send("DEL", getPartyUserKey(member.SpotifyID))
+	}
+	redisConn.Send("DEL", getPartyUserKey(stored.HostSpotifyID))
+	redisConn.Send("DEL", getPartyKey(partyID), getMembersKey(partyID), getKickedKey(partyID))
+	if _, err = redisConn.Do("EXEC"); err != nil {
+		return fmt.Errorf("Could not end party: %s", err)
+	}
+	publish(redisConn, partyID, Event{Type: EventTypeEnded})
+	return nil
+}
+
+func removeMember(redisConn redis.Conn, partyID, spotifyID string) (bool, error) {
+	removed, err := redis.Int(redisConn.Do("HDEL", getMembersKey(partyID), spotifyID))
+	if err != nil {
+		return false, fmt.Errorf("Could not remove member: %s", err)
+	}
+	currentPartyID, ok, err := currentParty(redisConn, spotifyID)
+	if err != nil {
+		return false, err
+	}
+	if ok && currentPartyID == partyID {
+		if _, err = redisConn.Do("DEL", getPartyUserKey(spotifyID)); err != nil {
+			return false, fmt.Errorf("Could not remove member party pointer: %s", err)
+		}
+	}
+	return removed > 0, nil
+}
+
+func getStoredParty(redisConn redis.Conn, partyID string) (storedParty, error) {
+	values, err := redis.Values(redisConn.Do("HGETALL", getPartyKey(partyID)))
+	if err != nil {
+		return storedParty{}, fmt.Errorf("Could not get party: %s", err)
+	}
+	if len(values) == 0 {
+		return storedParty{}, ErrPartyNotFound
+	}
+	var stored storedParty
+	if err = redis.ScanStruct(values, &stored); err != nil {
+		return storedParty{}, fmt.Errorf("Could not parse party: %s", err)
+	}
+	return stored, nil
+}
+
+func getStoredMembers(redisConn redis.Conn, partyID string) ([]storedMember, error) {
+	membersJSON, err := redis.StringMap(redisConn.Do("HGETALL", getMembersKey(partyID)))
+	if err != nil {
+		return nil, fmt.Errorf("Could not get party members: %s", err)
+	}
+	var members []storedMember
+	for _, memberJSON := range membersJSON {
+		var member storedMember
+		if err = json.Unmarshal([]byte(memberJSON), &member); err != nil {
+			return nil, fmt.Errorf("Could not parse party member: %s", err)
+		}
+		members = append(members, member)
+	}
+	return members, nil
+}
+
+func currentParty(redisConn redis.Conn, spotifyID string) (string, bool, error) {
+	partyID, err := redis.String(redisConn.Do("GET", getPartyUserKey(spotifyID)))
+	if err == redis.ErrNil {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("Could not get current party: %s", err)
+	}
+	exists, err := redis.Bool(redisConn.Do("EXISTS", getPartyKey(partyID)))
+	if err != nil {
+		return "", false, fmt.Errorf("Could not check if party exists: %s", err)
+	}
+	return partyID, exists, nil
+}
+
+// touch pushes back the party's expiration, for the host and every member, now
+// that something has happened.
+func touch(redisConn redis.Conn, partyID string, stored storedParty) error {
+	members, err := getStoredMembers(redisConn, partyID)
+	if err != nil {
+		return err
+	}
+	redisConn.Send("MULTI")
+	refreshExpiration(redisConn, partyID, stored, members)
+	if _, err = redisConn.Do("EXEC"); err != nil {
+		return fmt.Errorf("Could not refresh party expiration: %s", err)
+	}
+	return nil
+}
+
+// refreshExpiration must be called inside a MULTI.
+func refreshExpiration(redisConn redis.Conn, partyID string, stored storedParty, members []storedMember) {
+	seconds := int(partyExpiration.Seconds())
+	redisConn.Send("EXPIRE", getPartyKey(partyID), seconds)
+	redisConn.Send("EXPIRE", getMembersKey(partyID), seconds)
+	redisConn.Send("EXPIRE", getKickedKey(partyID), seconds)
+	redisConn.Send("EXPIRE", getPartyUserKey(stored.HostSpotifyID), seconds)
+	for _, member := range members {
+		redisConn.Send("EXPIRE", getPartyUserKey(member.SpotifyID), seconds)
+	}
+}
+
+func randomID() (string, error) {
+	idBytes := make([]byte, 12)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(idBytes), nil
+}
+
+func getPartyKey(partyID string) string {
+	return fmt.Sprintf("%s:%s", partyPrefix, partyID)
+}
+
+func getMembersKey(partyID string) string {
+	return fmt.Sprintf("%s:%s:members", partyPrefix, partyID)
+}
+
+func getKickedKey(partyID string) string {
+	return fmt.Sprintf("%s:%s:kicked", partyPrefix, partyID)
+}
+
+func getSyncerKey(partyID string) string {
+	return fmt.Sprintf("%s:%s:syncer", partyPrefix, partyID)
+}
+
+func getEventsChannel(partyID string) string {
+	return fmt.Sprintf("%s:%s:events", partyPrefix, partyID)
+}
+
+func getPartyUserKey(spotifyID string) string {
+	return fmt.Sprintf("%s:%s", partyUserPrefix, spotifyID)
+}
@@ -0,0 +1,236 @@
+package party
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/samuelhorwitz/phosphorescence/api/common"
+	"github.com/samuelhorwitz/phosphorescence/api/middleware"
+	"github.com/samuelhorwitz/phosphorescence/api/models"
+	"github.com/samuelhorwitz/phosphorescence/api/session"
+)
+
+const (
+	syncInterval = 3 * time.Second
+	// Spotify's reported progress is only accurate to a second or so and every
+	// seek causes an audible skip, so only correct drift people would notice.
+	driftTolerance = 2 * time.Second
+	// Near the end of a track the host is about to move on anyway, correcting
+	// now would just cause two skips.
+	trackEndGrace  = 3 * time.Second
+	syncerLockTTL  = 3 * syncInterval
+	syncRequestTTL = 5 * time.Second
+)
+
+// Only renew the lock if we still hold it, otherwise a slow instance could steal
+// it back from whichever instance took over.
+var renewLockScript = redis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+var (
+	syncersMux sync.Mutex
+	syncers    = make(map[string]struct{})
+)
+
+type correctionAction int
+
+const (
+	correctionNone correctionAction = iota
+	correctionPlay
+	correctionSeek
+	correctionPause
+)
+
+type correction struct {
+	action   correctionAction
+	position int
+}
+
+// EnsureSyncing starts keeping the party's members in sync with the host unless
+// some instance, this one or another, already is.
+func EnsureSyncing(partyID string) {
+	syncersMux.Lock()
+	defer syncersMux.Unlock()
+	if _, ok := syncers[partyID]; ok {
+		return
+	}
+	redisConn := common.RedisPool.Get()
+	defer redisConn.Close()
+	_, err := redis.String(redisConn.Do("SET", getSyncerKey(partyID), instanceID, "NX", "PX", int(syncerLockTTL/time.Millisecond)))
+	if err == redis.ErrNil {
+		return
+	}
+	if err != nil {
+		log.Printf("Could not acquire party syncer lock: %s", err)
+		return
+	}
+	syncers[partyID] = struct{}{}
+	go runSyncer(partyID)
+}
+
+func runSyncer(partyID string) {
+	defer func() {
+		syncersMux.Lock()
+		delete(syncers, partyID)
+		syncersMux.Unlock()
+	}()
+	ticker := time.NewTicker(syncInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !syncOnce(partyID) {
+			return
+		}
+	}
+}
+
+// syncOnce returns false once this instance should stop syncing the party,
+// either because the party is gone or because another instance took over.
+func syncOnce(partyID string) bool {
+	redisConn := common.RedisPool.Get()
+	defer redisConn.Close()
+	renewed, err := redis.Int(renewLockScript.Do(redisConn, getSyncerKey(partyID), instanceID, int(syncerLockTTL/time.Millisecond)))
+	if err != nil || renewed == 0 {
+		return false
+	}
+	stored, err := getStoredParty(redisConn, partyID)
+	if err != nil {
+		if err != ErrPartyNotFound {
+			log.Printf("Could not get party to sync: %s", err)
+			return true
+		}
+		redisConn.Do("DEL", getSyncerKey(partyID))
+		return false
+	}
+	hostSess, err := session.Get(stored.HostSessionID)
+	if err != nil {
+		publish(redisConn, partyID, Event{Type: EventTypeHostUnavailable, Message: "Host session has expired"})
+		return true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), syncRequestTTL)
+	defer cancel()
+	hostPlayback, err := models.GetCurrentPlayback(ctx, hostSess)
+	if err != nil {
+		publish(redisConn, partyID, Event{Type: EventTypeHostUnavailable, Message: err.Error()})
+		return true
+	}
+	publish(redisConn, partyID, Event{Type: EventTypeState, Playback: &hostPlayback})
+	members, err := getStoredMembers(redisConn, partyID)
+	if err != nil {
+		log.Printf("Could not get party members to sync: %s", err)
+		return true
+	}
+	// A party that is playing is in use even if nobody joins or leaves.
+	if hostPlayback.IsPlaying {
+		redisConn.Send("MULTI")
+		refreshExpiration(redisConn, partyID, stored, members)
+		if _, err = redisConn.Do("EXEC"); err != nil {
+			log.Printf("Could not refresh party expiration: %s", err)
+		}
+	}
+	var wg sync.WaitGroup
+	for _, member := range members {
+		wg.Add(1)
+		go func(member storedMember) {
+			defer wg.Done()
+			if err := syncMember(ctx, hostPlayback, member); err != nil {
+				memberConn := common.RedisPool.Get()
+				defer memberConn.Close()
+				publish(memberConn, partyID, Event{Type: EventTypeMemberError, Member: &member.Member, Message: err.Error()})
+			}
+		}(member)
+	}
+	wg.Wait()
+	return true
+}
+
+func syncMember(ctx context.Context, hostPlayback models.Playback, member storedMember) error {
+	// The member's own requests count against the same limit, whatever is left
+	// over waits for the next sync.
+	if middleware.SpotifyLimitReached(member.SpotifyID) {
+		return nil
+	}
+	memberSess, err := session.Get(member.SessionID)
+	if err != nil {
+		return fmt.Errorf("Session has expired")
+	}
+	var memberPlayback *models.Playback
+	if playback, err := models.GetCurrentPlayback(ctx, memberSess); err == nil {
+		memberPlayback = &playback
+	}
+	c := decideCorrection(hostPlayback, memberPlayback, time.Now())
+	switch c.action {
+	case correctionPlay:
+		err = models.Play(ctx, memberSess, "", models.PlayOptions{
+			URIs:                 []string{"spotify:track:" + hostPlayback.TrackID()},
+			PositionMilliseconds: c.position,
+		})
+	case correctionSeek:
+		err = models.Seek(ctx, memberSess, "", c.position)
+	case correctionPause:
+		err = models.PausePlayback(ctx, memberSess, "")
+		if playerErr, ok := err.(models.SpotifyPlayerError); ok && playerErr.Reason == models.PlayerErrorAlreadyPaused {
+			err = nil
+		}
+	}
+	if err != nil {
+		if !isProduction {
+			log.Printf("Could not sync party member %s: %s", member.SpotifyID, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// decideCorrection works out what, if anything, a member's player needs to do
+// to match the host. A nil member playback means we couldn't find out what the
+// member is playing.
+func decideCorrection(host models.Playback, member *models.Playback, now time.Time) correction {
+	// Only Spotify tracks can be shared, anything else the host listens to is
+	// treated as a pause.
+	if !host.IsPlaying || host.TrackID() == "" {
+		if member != nil && member.IsPlaying {
+			return correction{action: correctionPause}
+		}
+		return correction{action: correctionNone}
+	}
+	hostPosition := expectedPosition(host, now)
+	if host.Duration() > 0 && time.Duration(host.Duration()-hostPosition)*time.Millisecond < trackEndGrace {
+		return correction{action: correctionNone}
+	}
+	if member == nil || member.TrackID() != host.TrackID() {
+		return correction{action: correctionPlay, position: hostPosition}
+	}
+	if !member.IsPlaying {
+		return correction{action: correctionPlay, position: hostPosition}
+	}
+	drift := time.Duration(expectedPosition(*member, now)-hostPosition) * time.Millisecond
+	if drift > driftTolerance || drift < -driftTolerance {
+		return correction{action: correctionSeek, position: hostPosition}
+	}
+	return correction{action: correctionNone}
+}
+
+// expectedPosition is where playback should be by now given where it was when
+// we last asked Spotify.
+func expectedPosition(playback models.Playback, now time.Time) int {
+	if !playback.IsPlaying {
+		return playback.Progress
+	}
+	elapsed := int(now.UnixNano()/int64(time.Millisecond) - playback.FetchedAt)
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	position := playback.Progress + elapsed
+	if duration := playback.Duration(); duration > 0 && position > duration {
+		return duration
+	}
+	return position
+}
@@ -0,0 +1,49 @@
+package party
+
+import (
+	"testing"
+	"time"
+
+	"github.com/samuelhorwitz/phosphorescence/api/models"
+)
+
+func Test_decideCorrection(t *testing.T) {
+	now := time.Unix(1000, 0)
+	fetchedAt := now.Add(-1*time.Second).UnixNano() / int64(time.Millisecond)
+	track := &models.SpotifyPlaybackTrack{ID: "a", Duration: 200000}
+	host := models.Playback{Type: models.PlaybackItemTrack, IsPlaying: true, Progress: 60000, FetchedAt: fetchedAt, Track: track}
+	if c := decideCorrection(host, nil, now); c.action != correctionPlay || c.position != 61000 {
+		t.Fatalf("Expected play at 61000 for idle member, got %+v", c)
+	}
+	other := models.Playback{Type: models.PlaybackItemTrack, IsPlaying: true, Progress: 60000, FetchedAt: fetchedAt, Track: &models.SpotifyPlaybackTrack{ID: "b"}}
+	if c := decideCorrection(host, &other, now); c.action != correctionPlay {
+		t.Fatalf("Expected play for member on another track, got %+v", c)
+	}
+	paused := models.Playback{Type: models.PlaybackItemTrack, IsPlaying: false, Progress: 61000, Track: track}
+	if c := decideCorrection(host, &paused, now); c.action != correctionPlay {
+		t.Fatalf("Expected play for paused member, got %+v", c)
+	}
+	close := models.Playback{Type: models.PlaybackItemTrack, IsPlaying: true, Progress: 61500, FetchedAt: fetchedAt, Track: track}
+	if c := decideCorrection(host, &close, now); c.action != correctionNone {
+		t.Fatalf("Expected no correction within tolerance, got %+v", c)
+	}
+	behind := models.Playback{Type: models.PlaybackItemTrack, IsPlaying: true, Progress: 55000, FetchedAt: fetchedAt, Track: track}
+	if c := decideCorrection(host, &behind, now); c.action != correctionSeek || c.position != 61000 {
+		t.Fatalf("Expected seek to 61000, got %+v", c)
+	}
+	ending := models.Playback{Type: models.PlaybackItemTrack, IsPlaying: true, Progress: 198000, FetchedAt: fetchedAt, Track: track}
+	if c := decideCorrection(ending, &behind, now); c.action != correctionNone {
+		t.Fatalf("Expected no correction at end of track, got %+v", c)
+	}
+	hostPaused := models.Playback{Type: models.PlaybackItemTrack, IsPlaying: false, Progress: 60000, Track: track}
+	if c := decideCorrection(hostPaused, &close, now); c.action != correctionPause {
+		t.Fatalf("Expected pause when host paused, got %+v", c)
+	}
+	if c := decideCorrection(hostPaused, &paused, now); c.action != correctionNone {
+		t.Fatalf("Expected no correction when both paused, got %+v", c)
+	}
+	episode := models.Playback{Type: models.PlaybackItemEpisode, IsPlaying: true, Episode: &models.SpotifyPlaybackEpisode{ID: "e"}}
+	if c := decideCorrection(episode, &close, now); c.action != correctionPause {
+		t.Fatalf("Expected pause when host plays an episode, got %+v", c)
+	}
+}
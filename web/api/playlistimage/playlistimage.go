@@ -0,0 +1,126 @@
+package playlistimage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	stddraw "image/draw"
+	"image/jpeg"
+	_ "image/png"
+	"strings"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// Spotify rejects playlist images whose base64 payload is over 256KB.
+const maxPayloadBytes = 256 * 1024
+
+// Spotify displays playlist covers at 640x640 at most, anything bigger is
+// wasted payload.
+const coverDimension = 640
+
+// Uploads are base64 in a JSON body, so this is a cap on the encoded size.
+const maxUploadBytes = 8 * 1024 * 1024
+
+// We check the header before decoding so a tiny file claiming to be enormous
+// can't make us allocate gigabytes of pixels.
+const maxSourcePixels = 40 * 1000 * 1000
+
+// This mirrors the quality stepping the web client uses when it renders its
+// own constellation image.
+const (
+	startingQuality = 90
+	minimumQuality  = 50
+	qualityStep     = 5
+)
+
+var (
+	ErrNotImage          = errors.New("Data is not a supported image")
+	ErrImageTooLarge     = errors.New("Image is too large")
+	ErrCannotMeetPayload = fmt.Errorf("Could not encode image under %d bytes", maxPayloadBytes)
+)
+
+// Process takes a base64 encoded PNG, WebP or JPEG (optionally as a data URL)
+// and returns a base64 encoded JPEG suitable for Spotify's playlist image
+// endpoint. The image is center cropped to a square and resized. Because the
+// image is fully decoded and re-encoded, EXIF and any other metadata is
+// dropped along the way.
+func Process(base64Image string) (string, error) {
+	if len(base64Image) > maxUploadBytes {
+		return "", ErrImageTooLarge
+	}
+	if i := strings.Index(base64Image, ","); strings.HasPrefix(base64Image, "data:") && i != -1 {
+		base64Image = base64Image[i+1:]
+	}
+	raw, err := base64.StdEncoding.DecodeString(base64Image)
+	if err != nil {
+		return "", fmt.Errorf("Could not decode base64 image: %s", err)
+	}
+	img, err := Decode(raw)
+	if err != nil {
+		return "", err
+	}
+	return Encode(Square(img, coverDimension))
+}
+
+// Decode sniffs and decodes a PNG, WebP or JPEG, refusing anything which is not
+// one of those or which claims unreasonable dimensions.
+func Decode(raw []byte) (image.Image, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(raw))
+	if err != nil {
+		return nil, ErrNotImage
+	}
+	switch format {
+	case "png", "webp", "jpeg":
+	default:
+		return nil, ErrNotImage
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, ErrNotImage
+	}
+	if cfg.Width*cfg.Height > maxSourcePixels {
+		return nil, ErrImageTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return nil, ErrNotImage
+	}
+	return img, nil
+}
+
+// Square center crops the image to its shortest side and scales the result to
+// a size by size image. Transparent areas are flattened onto black since JPEG
+// has no alpha channel.
+func Square(img image.Image, size int) *image.RGBA {
+	bounds := img.Bounds()
+	side := bounds.Dx()
+	if bounds.Dy() < side {
+		side = bounds.Dy()
+	}
+	offset := image.Pt((bounds.Dx()-side)/2, (bounds.Dy()-side)/2)
+	crop := image.Rect(0, 0, side, side).Add(bounds.Min).Add(offset)
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	stddraw.Draw(dst, dst.Bounds(), image.NewUniform(color.Black), image.ZP, stddraw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, crop, draw.Over, nil)
+	return dst
+}
+
+// Encode writes the image as a JPEG, stepping down quality until the base64
+// payload fits under Spotify's limit.
+func Encode(img image.Image) (string, error) {
+	var buf bytes.Buffer
+	for quality := startingQuality; quality >= minimumQuality; quality -= qualityStep {
+		buf.Reset()
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+			return "", fmt.Errorf("Could not encode JPEG: %s", err)
+		}
+		if base64.StdEncoding.EncodedLen(buf.Len()) <= maxPayloadBytes {
+			return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+		}
+	}
+	return "", ErrCannotMeetPayload
+}
@@ -0,0 +1,66 @@
+package playlistimage_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/samuelhorwitz/phosphorescence/api/playlistimage"
+)
+
+func TestProcessPNG(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 1200, 800))
+	for x := 0; x < 1200; x++ {
+		for y := 0; y < 800; y++ {
+			src.Set(x, y, color.NRGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, src); err != nil {
+		t.Fatalf("Could not encode PNG: %s", err)
+	}
+	processed, err := playlistimage.Process("data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Could not process image: %s", err)
+	}
+	if len(processed) > 256*1024 {
+		t.Fatalf("Processed image too large: %d", len(processed))
+	}
+	raw, err := base64.StdEncoding.DecodeString(processed)
+	if err != nil {
+		t.Fatalf("Processed image is not base64: %s", err)
+	}
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("Processed image is not a JPEG: %s", err)
+	}
+	if cfg.Width != cfg.Height {
+		t.Fatalf("Processed image is not square: %dx%d", cfg.Width, cfg.Height)
+	}
+}
+
+func TestProcessNotImage(t *testing.T) {
+	_, err := playlistimage.Process(base64.StdEncoding.EncodeToString([]byte("<svg onload=alert(1)></svg>")))
+	if err != playlistimage.ErrNotImage {
+		t.Fatalf("Expected ErrNotImage, got %v", err)
+	}
+	_, err = playlistimage.Process("not base64!")
+	if err == nil {
+		t.Fatalf("Expected error for invalid base64")
+	}
+}
+
+func TestProcessTooManyPixels(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 10000, 5000))); err != nil {
+		t.Fatalf("Could not encode PNG: %s", err)
+	}
+	_, err := playlistimage.Process(base64.StdEncoding.EncodeToString(buf.Bytes()))
+	if err != playlistimage.ErrImageTooLarge {
+		t.Fatalf("Expected ErrImageTooLarge, got %v", err)
+	}
+}
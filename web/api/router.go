@@ -28,9 +28,52 @@ func initializeRoutes(cfg *config) http.Handler {
 	})
 	r.Use(cors.Handler)
 	r.Use(middleware.CSP(cfg.phosphorOrigin))
-	r.Use(chimiddleware.Timeout(cfg.handlerTimeout))
 	r.Use(chimiddleware.NoCache)
 	r.Use(chimiddleware.RealIP)
+	// Event streams are long-lived so they can't have the handler timeout, they
+	// end themselves before the server's write timeout instead.
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.Session)
+		r.Use(middleware.SpotifyLimiter)
+		r.Get("/user/me/currently-playing/stream", phosphor.StreamCurrentlyPlaying)
+		r.Get("/users/me/currently-playing/stream", phosphor.StreamCurrentlyPlaying)
+		r.Get("/party/{partyID}/stream", phosphor.StreamParty)
+	})
+	// Running scripts can take longer than the handler timeout, Eos stops them
+	// itself well before the server's write timeout.
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.Disable) // TODO remove this when ready
+		r.Use(middleware.Session)
+		r.Use(middleware.AuthenticatedSession)
+		r.Use(middleware.AuthorizeReadScript)
+		r.Use(middleware.AuthorizeReadScriptVersion)
+		r.Post("/script/{scriptID}/version/{scriptVersionID}/run", phosphor.RunScriptVersion)
+		r.Post("/scripts/{scriptID}/versions/{scriptVersionID}/run", phosphor.RunScriptVersion)
+	})
+	// Bundles hold a script's whole history, which can be too many trips to
+	// the script store to fit in the handler timeout.
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.Disable) // TODO remove this when ready
+		r.Use(chimiddleware.Timeout(cfg.scriptBundleTimeout))
+		r.Use(middleware.Session)
+		r.Use(middleware.AuthenticatedSession)
+		r.Post("/script/import", phosphor.ImportScript)
+		r.Post("/scripts/import", phosphor.ImportScript)
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.AuthorizeReadScript)
+			r.Use(middleware.AuthorizeScriptViewer)
+			r.Get("/script/{scriptID}/export", phosphor.ExportScript)
+			r.Get("/scripts/{scriptID}/export", phosphor.ExportScript)
+		})
+	})
+	r.Group(func(r chi.Router) {
+		r.Use(chimiddleware.Timeout(cfg.handlerTimeout))
+		initializeTimeoutRoutes(r, cfg)
+	})
+	return r
+}
+
+func initializeTimeoutRoutes(r chi.Router, cfg *config) {
 	r.Route("/spotify", func(r chi.Router) {
 		r.Route("/authorize", func(r chi.Router) {
 			r.Get("/", spotify.Authorize)
@@ -59,7 +102,7 @@ func initializeRoutes(cfg *config) http.Handler {
 			r.Use(middleware.Paginate)
 			r.Get("/", phosphor.GetScriptVersions)
 			r.Group(func(r chi.Router) {
-				r.Use(middleware.AuthorizePrivateScriptActions)
+				r.Use(middleware.AuthorizeScriptViewer)
 				r.Get("/draft", phosphor.GetPrivateScriptVersions)
 				r.Get("/drafts", phosphor.GetPrivateScriptVersions)
 			})
@@ -68,11 +111,28 @@ func initializeRoutes(cfg *config) http.Handler {
 			r.Use(middleware.AuthorizeReadScriptVersion)
 			r.Get("/", phosphor.GetScriptVersion)
 			r.Post("/fork", phosphor.ForkScriptVersion)
+			r.Get("/diff/upstream", phosphor.DiffScriptVersionWithUpstream)
+			r.Get("/diff/{otherScriptVersionID}", phosphor.DiffScriptVersions)
+			r.With(middleware.AuthorizeScriptViewer).Post("/duplicate", phosphor.DuplicateScriptVersion)
 			r.Group(func(r chi.Router) {
-				r.Use(middleware.AuthorizePrivateScriptActions)
-				r.Post("/duplicate", phosphor.DuplicateScriptVersion)
-				r.Delete("/", phosphor.DeleteScriptVersion)
+				r.Use(middleware.AuthorizeScriptEditor)
+				r.Put("/release", phosphor.ReleaseScriptVersion)
+				r.Delete("/release", phosphor.DeleteScriptVersionRelease)
 			})
+			r.With(middleware.AuthorizeScriptOwner).Delete("/", phosphor.DeleteScriptVersion)
+		})
+	}
+	commentRouter := func(r chi.Router) {
+		r.With(middleware.Paginate).Get("/", phosphor.ListComments)
+		r.With(middleware.CommentLimiter).Post("/", phosphor.CreateComment)
+		r.Route("/{commentID}", func(r chi.Router) {
+			r.Use(middleware.AuthorizeReadComment)
+			r.Get("/", phosphor.GetComment)
+			r.With(middleware.Paginate).Get("/replies", phosphor.ListCommentReplies)
+			r.Get("/history", phosphor.GetCommentHistory)
+			r.With(middleware.CommentLimiter).Put("/", phosphor.EditComment)
+			r.Delete("/", phosphor.DeleteComment)
+			r.Post("/report", phosphor.ReportComment)
 		})
 	}
 	scriptRouter := func(r chi.Router) {
@@ -89,13 +149,30 @@ func initializeRoutes(cfg *config) http.Handler {
 			r.Use(middleware.AuthorizeReadScript)
 			r.Get("/", phosphor.GetScript)
 			r.Post("/fork", phosphor.ForkScript)
+			r.Put("/like", phosphor.LikeScript)
+			r.Delete("/like", phosphor.UnlikeScript)
+			r.With(middleware.Paginate).Get("/lineage", phosphor.GetScriptLineage)
+			r.With(middleware.Paginate).Get("/releases", phosphor.ListScriptReleases)
+			r.Post("/report", phosphor.ReportScript)
 			r.Route("/version", scriptVersionRouter)
 			r.Route("/versions", scriptVersionRouter)
+			r.With(middleware.ScriptCommentTarget).Route("/comment", commentRouter)
+			r.With(middleware.ScriptCommentTarget).Route("/comments", commentRouter)
 			r.Group(func(r chi.Router) {
-				r.Use(middleware.AuthorizePrivateScriptActions)
+				r.Use(middleware.AuthorizeScriptViewer)
 				r.Post("/duplicate", phosphor.DuplicateScript)
+				r.Get("/stats", phosphor.GetScriptListeningStats)
+				r.Get("/collaborators", phosphor.ListScriptCollaborators)
+			})
+			r.Group(func(r chi.Router) {
+				r.Use(middleware.AuthorizeScriptEditor)
 				r.Put("/", phosphor.UpdateScript)
 				r.Put("/publish", phosphor.PublishScript)
+			})
+			r.Group(func(r chi.Router) {
+				r.Use(middleware.AuthorizeScriptOwner)
+				r.Put("/collaborators/{spotifyID}", phosphor.InviteScriptCollaborator)
+				r.Delete("/collaborators/{spotifyID}", phosphor.RevokeScriptCollaborator)
 				r.Delete("/", phosphor.DeleteScript)
 			})
 		})
@@ -105,21 +182,21 @@ func initializeRoutes(cfg *config) http.Handler {
 	scriptChainVersionRouter := func(r chi.Router) {
 		r.Group(func(r chi.Router) {
 			r.Use(middleware.Paginate)
-			// r.Get("/", phosphor.GetScriptChainVersions)
+			r.Get("/", phosphor.GetScriptChainVersions)
 			r.Group(func(r chi.Router) {
-				r.Use(middleware.AuthorizePrivateScriptActions)
-				// r.Get("/draft", phosphor.GetPrivateScriptChainVersions)
-				// r.Get("/drafts", phosphor.GetPrivateScriptChainVersions)
+				r.Use(middleware.AuthorizePrivateScriptChainActions)
+				r.Get("/draft", phosphor.GetPrivateScriptChainVersions)
+				r.Get("/drafts", phosphor.GetPrivateScriptChainVersions)
 			})
 		})
 		r.Route("/{scriptChainVersionID}", func(r chi.Router) {
-			r.Use(middleware.AuthorizeReadScriptVersion)
-			// r.Get("/", phosphor.GetScriptChainVersion)
-			// r.Post("/fork", phosphor.ForkScriptChainVersion)
+			r.Use(middleware.AuthorizeReadScriptChainVersion)
+			r.Get("/", phosphor.GetScriptChainVersion)
+			r.Post("/fork", phosphor.ForkScriptChainVersion)
 			r.Group(func(r chi.Router) {
-				r.Use(middleware.AuthorizePrivateScriptActions)
-				// r.Post("/duplicate", phosphor.DuplicateScriptChainVersion)
-				// r.Delete("/", phosphor.DeleteScriptChainVersion)
+				r.Use(middleware.AuthorizePrivateScriptChainActions)
+				r.Post("/duplicate", phosphor.DuplicateScriptChainVersion)
+				r.Delete("/", phosphor.DeleteScriptChainVersion)
 			})
 		})
 	}
@@ -127,23 +204,28 @@ func initializeRoutes(cfg *config) http.Handler {
 		r.Use(middleware.Disable) // TODO remove this when ready
 		r.Use(middleware.Session)
 		r.Use(middleware.AuthenticatedSession)
-		// r.Post("/", phosphor.CreateScriptChain)
+		r.Post("/", phosphor.CreateScriptChain)
 		r.Group(func(r chi.Router) {
 			r.Use(middleware.Paginate)
-			// r.Get("/", phosphor.ListPublicScriptChains)
-			// r.Get("/my", phosphor.ListCurrentUserScriptChains)
+			r.Get("/", phosphor.ListPublicScriptChains)
+			r.Get("/my", phosphor.ListCurrentUserScriptChains)
 		})
 		r.Route("/{scriptChainID}", func(r chi.Router) {
-			r.Use(middleware.AuthorizeReadScript)
-			// r.Get("/", phosphor.GetScriptChain)
-			// r.Post("/fork", phosphor.ForkScriptChain)
+			r.Use(middleware.AuthorizeReadScriptChain)
+			r.Get("/", phosphor.GetScriptChain)
+			r.Post("/fork", phosphor.ForkScriptChain)
+			r.Put("/like", phosphor.LikeScriptChain)
+			r.Delete("/like", phosphor.UnlikeScriptChain)
 			r.Route("/version", scriptChainVersionRouter)
 			r.Route("/versions", scriptChainVersionRouter)
+			r.With(middleware.ScriptChainCommentTarget).Route("/comment", commentRouter)
+			r.With(middleware.ScriptChainCommentTarget).Route("/comments", commentRouter)
 			r.Group(func(r chi.Router) {
-				r.Use(middleware.AuthorizePrivateScriptActions)
-				// r.Post("/duplicate", phosphor.DuplicateScriptChain)
-				// r.Put("/", phosphor.UpdateScriptChain)
-				// r.Delete("/", phosphor.DeleteScriptChain)
+				r.Use(middleware.AuthorizePrivateScriptChainActions)
+				r.Post("/duplicate", phosphor.DuplicateScriptChain)
+				r.Put("/", phosphor.UpdateScriptChain)
+				r.Put("/publish", phosphor.PublishScriptChain)
+				r.Delete("/", phosphor.DeleteScriptChain)
 			})
 		})
 	}
@@ -154,6 +236,15 @@ func initializeRoutes(cfg *config) http.Handler {
 		r.Route("/me", func(r chi.Router) {
 			r.Get("/", phosphor.GetCurrentUser)
 			r.Get("/currently-playing", phosphor.GetCurrentlyPlaying)
+			r.With(middleware.SpotifyLimiter).Get("/recently-played", phosphor.GetRecentlyPlayed)
+			r.Get("/listening-log", phosphor.GetListeningLog)
+			r.Put("/listening-log", phosphor.SetListeningLog)
+			r.With(middleware.AuthenticatedSession, middleware.Paginate).Get("/likes", phosphor.ListCurrentUserLikes)
+			r.With(middleware.AuthenticatedSession, middleware.Paginate).Get("/notifications", phosphor.ListNotifications)
+			r.With(middleware.AuthenticatedSession).Put("/notifications/read", phosphor.MarkNotificationsRead)
+			r.With(middleware.AuthenticatedSession).Get("/invites", phosphor.ListScriptInvites)
+			r.With(middleware.AuthenticatedSession).Put("/invites/{scriptID}", phosphor.AcceptScriptInvite)
+			r.With(middleware.AuthenticatedSession).Delete("/invites/{scriptID}", phosphor.LeaveScript)
 			r.Post("/playlist", phosphor.CreateAndFollowPlaylist)
 		})
 	}
@@ -182,6 +273,18 @@ func initializeRoutes(cfg *config) http.Handler {
 	}
 	r.Route("/device", deviceRouter)
 	r.Route("/devices", deviceRouter)
+	r.Route("/party", func(r chi.Router) {
+		r.Use(middleware.Session)
+		r.Use(middleware.SpotifyLimiter)
+		r.Post("/", phosphor.CreateParty)
+		r.Route("/{partyID}", func(r chi.Router) {
+			r.Get("/", phosphor.GetParty)
+			r.With(middleware.AuthorizePremiumSpotifyUser).Post("/join", phosphor.JoinParty)
+			r.Post("/leave", phosphor.LeaveParty)
+			r.Delete("/member/{spotifyID}", phosphor.KickPartyMember)
+			r.Delete("/", phosphor.EndParty)
+		})
+	})
 	r.Route("/search", func(r chi.Router) {
 		r.Use(middleware.Session)
 		r.Get("/{query}", phosphor.Search)
@@ -192,7 +295,17 @@ func initializeRoutes(cfg *config) http.Handler {
 		r.Use(middleware.Session)
 		r.Use(middleware.AuthenticatedSession)
 		r.Use(middleware.AuthorizeAdminAccount)
-		r.Get("/playlist/{playlistID}", phosphor.MakePlaylistOfficial)
+		r.Post("/playlist/{playlistID}", phosphor.PromoteOfficialPlaylist)
+		r.Delete("/playlist/{playlistID}", phosphor.RetireOfficialPlaylist)
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.Paginate)
+			r.Get("/reports", phosphor.ListReports)
+			r.Get("/moderation-log", phosphor.GetModerationLog)
+		})
+		r.Put("/report/{reportID}/dismiss", phosphor.DismissReport)
+		r.Put("/script/{scriptID}/hide", phosphor.HideScript)
+		r.Put("/script/{scriptID}/restore", phosphor.RestoreScript)
+		r.Delete("/comment/{commentID}", phosphor.RemoveComment)
 	})
 	r.Route("/playlist", func(r chi.Router) {
 		r.Route("/unauthenticated", func(r chi.Router) {
@@ -203,9 +316,14 @@ func initializeRoutes(cfg *config) http.Handler {
 			r.Use(middleware.Session)
 			r.Use(middleware.SpotifyLimiter)
 			r.Get("/{playlistID}", phosphor.GetPlaylist)
+			r.Get("/{playlistID}/analysis", phosphor.GetPlaylistAnalysis)
 			r.Post("/", phosphor.CreatePrivatePlaylist)
+			r.Post("/analyze", phosphor.AnalyzeTracks)
 		})
 	})
+	r.Route("/playlists", func(r chi.Router) {
+		r.With(middleware.Paginate).Get("/official", phosphor.ListOfficialPlaylists)
+	})
 	r.Route("/album", func(r chi.Router) {
 		r.Route("/unauthenticated", func(r chi.Router) {
 			r.With(middleware.Captcha("api/album", botCutoff)).Get("/{region}/{albumID}", phosphor.GetAlbumUnauthenticated)
@@ -218,9 +336,24 @@ func initializeRoutes(cfg *config) http.Handler {
 	})
 	r.Route("/player", func(r chi.Router) {
 		r.With(middleware.Captcha("api/player/playlist", botCutoff)).Get("/playlist/{playlistID}", phosphor.GetPlayerPlaylist)
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.Session)
+			r.Use(middleware.AuthorizePremiumSpotifyUser)
+			r.Use(middleware.SpotifyLimiter)
+			r.Put("/play", phosphor.Play)
+			r.Put("/resume", phosphor.Resume)
+			r.Put("/pause", phosphor.PausePlayback)
+			r.Post("/next", phosphor.SkipToNext)
+			r.Post("/previous", phosphor.SkipToPrevious)
+			r.Put("/seek", phosphor.Seek)
+			r.Put("/volume", phosphor.SetVolume)
+			r.Put("/shuffle", phosphor.SetShuffle)
+			r.Put("/repeat", phosphor.SetRepeat)
+			r.Get("/queue", phosphor.GetQueue)
+			r.Post("/queue", phosphor.AddToQueue)
+		})
 	})
 	r.Get("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprint(w, "User-agent: *\nDisallow: /\n")
 	})
-	return r
 }
@@ -0,0 +1,218 @@
+package scriptcheck
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tdewolff/parse/v2"
+	"github.com/tdewolff/parse/v2/js"
+)
+
+// MaxScriptSize is the largest script, in bytes, we will store. The builders
+// we ship are all well under a tenth of this.
+const MaxScriptSize = 256 * 1024
+
+var ErrTooLarge = fmt.Errorf("Script cannot be larger than %d KB", MaxScriptSize/1024)
+
+// Role is what a script is used as when Eos runs it.
+type Role string
+
+const (
+	RoleSeeder  Role = "seeder"
+	RoleBuilder Role = "builder"
+	RolePruner  Role = "pruner"
+)
+
+// requiredHooks are the hooks a script must define to fill a role. Any other
+// hooks fall back to the defaults the Eos runner provides.
+var requiredHooks = map[Role][]string{
+	RoleSeeder:  {"getFirstTrack"},
+	RoleBuilder: {"getNextTrack"},
+	RolePruner:  {"prune"},
+}
+
+// The hooks the Eos runner knows to call, anything else is most likely a typo.
+var knownHooks = map[string]bool{
+	"prune":         true,
+	"buildTree":     true,
+	"getFirstTrack": true,
+	"getNextTrack":  true,
+}
+
+// SyntaxError is a script that does not parse. Line and column are 1-indexed.
+type SyntaxError struct {
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Message string `json:"message"`
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("Syntax error at line %d, column %d: %s", e.Line, e.Column, e.Message)
+}
+
+// ContractError is a script that parses but does not define the hooks it
+// needs to.
+type ContractError struct {
+	Message string `json:"message"`
+}
+
+func (e *ContractError) Error() string {
+	return e.Message
+}
+
+// Report describes a script which passed checking.
+type Report struct {
+	Hooks []string `json:"hooks"`
+	Roles []Role   `json:"roles"`
+}
+
+// ValidRole reports whether the role is one we know of.
+func ValidRole(role Role) bool {
+	_, ok := requiredHooks[role]
+	return ok
+}
+
+// Check parses the script and finds the hooks it assigns to self.hooks. If any
+// roles are given the script must define the hooks for all of them, otherwise
+// it must be usable as at least one.
+func Check(script string, roles ...Role) (Report, error) {
+	var report Report
+	if strings.TrimSpace(script) == "" {
+		return report, errors.New("Script cannot be empty")
+	}
+	if len(script) > MaxScriptSize {
+		return report, ErrTooLarge
+	}
+	ast, err := js.Parse(parse.NewInputString(script), js.Options{})
+	if err != nil {
+		if perr, ok := err.(*parse.Error); ok {
+			return report, &SyntaxError{Line: perr.Line, Column: perr.Column, Message: perr.Message}
+		}
+		return report, &SyntaxError{Message: err.Error()}
+	}
+	v := &hookVisitor{hooks: make(map[string]bool)}
+	js.Walk(v, ast)
+	for hook := range v.hooks {
+		if !knownHooks[hook] {
+			return report, &ContractError{Message: fmt.Sprintf("Unknown hook self.hooks.%s", hook)}
+		}
+		report.Hooks = append(report.Hooks, hook)
+	}
+	sort.Strings(report.Hooks)
+	for _, role := range []Role{RoleSeeder, RoleBuilder, RolePruner} {
+		if hasHooks(v.hooks, requiredHooks[role]) {
+			report.Roles = append(report.Roles, role)
+		}
+	}
+	for _, role := range roles {
+		required, ok := requiredHooks[role]
+		if !ok {
+			return report, fmt.Errorf("Unknown script type %s", role)
+		}
+		for _, hook := range required {
+			if !v.hooks[hook] {
+				return report, &ContractError{Message: fmt.Sprintf("A %s must define self.hooks.%s", role, hook)}
+			}
+		}
+	}
+	if len(report.Roles) == 0 {
+		return report, &ContractError{Message: "Script must define at least one of self.hooks.getFirstTrack, self.hooks.getNextTrack or self.hooks.prune"}
+	}
+	return report, nil
+}
+
+func hasHooks(hooks map[string]bool, required []string) bool {
+	for _, hook := range required {
+		if !hooks[hook] {
+			return false
+		}
+	}
+	return true
+}
+
+// hookVisitor collects hooks from assignments anywhere in the script, either
+// one at a time as in
+//
+//	self.hooks.getFirstTrack = function() {...}
+//	self.hooks['getFirstTrack'] = function() {...}
+//
+// or all together as in
+//
+//	self.hooks = {getFirstTrack() {...}}
+type hookVisitor struct {
+	hooks map[string]bool
+}
+
+func (v *hookVisitor) Enter(n js.INode) js.IVisitor {
+	assign, ok := n.(*js.BinaryExpr)
+	if !ok || assign.Op != js.EqToken {
+		return v
+	}
+	if isSelfHooks(assign.X) {
+		if obj, ok := assign.Y.(*js.ObjectExpr); ok {
+			for _, prop := range obj.List {
+				if name, ok := propertyName(prop); ok {
+					v.hooks[name] = true
+				}
+			}
+		}
+		return v
+	}
+	switch target := assign.X.(type) {
+	case *js.DotExpr:
+		if isSelfHooks(target.X) {
+			v.hooks[string(target.Y.Data)] = true
+		}
+	case *js.IndexExpr:
+		if isSelfHooks(target.X) {
+			if lit, ok := target.Y.(*js.LiteralExpr); ok && lit.TokenType == js.StringToken {
+				v.hooks[unquote(lit.Data)] = true
+			}
+		}
+	}
+	return v
+}
+
+func (v *hookVisitor) Exit(n js.INode) {}
+
+func isSelfHooks(expr js.IExpr) bool {
+	dot, ok := expr.(*js.DotExpr)
+	if !ok || string(dot.Y.Data) != "hooks" {
+		return false
+	}
+	self, ok := dot.X.(*js.Var)
+	return ok && string(self.Data) == "self"
+}
+
+func propertyName(prop js.Property) (string, bool) {
+	if method, ok := prop.Value.(*js.MethodDecl); ok && prop.Name == nil {
+		return literalName(method.Name)
+	}
+	if prop.Name == nil {
+		// Shorthand properties, as in {prune}, only have a value.
+		if ref, ok := prop.Value.(*js.Var); ok {
+			return string(ref.Data), true
+		}
+		return "", false
+	}
+	return literalName(*prop.Name)
+}
+
+func literalName(name js.PropertyName) (string, bool) {
+	if name.IsComputed() {
+		return "", false
+	}
+	if name.Literal.TokenType == js.StringToken {
+		return unquote(name.Literal.Data), true
+	}
+	return string(name.Literal.Data), true
+}
+
+func unquote(data []byte) string {
+	if len(data) >= 2 {
+		return string(data[1 : len(data)-1])
+	}
+	return string(data)
+}
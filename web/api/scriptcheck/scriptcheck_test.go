@@ -0,0 +1,88 @@
+package scriptcheck_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/samuelhorwitz/phosphorescence/api/scriptcheck"
+)
+
+func TestCheckShippedBuilders(t *testing.T) {
+	files, err := filepath.Glob("../../builders/*.js")
+	if err != nil {
+		t.Fatalf("Could not list builders: %s", err)
+	}
+	for _, file := range files {
+		if filepath.Base(file) == "index.js" {
+			continue
+		}
+		script, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatalf("Could not read %s: %s", file, err)
+		}
+		if _, err := scriptcheck.Check(string(script)); err != nil {
+			t.Errorf("Expected %s to pass, got %s", file, err)
+		}
+	}
+}
+
+func TestCheckRoles(t *testing.T) {
+	script := `self.hooks.buildTree = function (kdTree, {points}) {};
+self.hooks.getFirstTrack = function() {};
+self.hooks['getNextTrack'] = async function({tags, previousTrack}) {};`
+	report, err := scriptcheck.Check(script, scriptcheck.RoleBuilder)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if expected := []string{"buildTree", "getFirstTrack", "getNextTrack"}; !reflect.DeepEqual(report.Hooks, expected) {
+		t.Fatalf("Expected hooks %v, got %v", expected, report.Hooks)
+	}
+	if expected := []scriptcheck.Role{scriptcheck.RoleSeeder, scriptcheck.RoleBuilder}; !reflect.DeepEqual(report.Roles, expected) {
+		t.Fatalf("Expected roles %v, got %v", expected, report.Roles)
+	}
+	_, err = scriptcheck.Check(script, scriptcheck.RolePruner)
+	if _, ok := err.(*scriptcheck.ContractError); !ok {
+		t.Fatalf("Expected contract error, got %v", err)
+	}
+}
+
+func TestCheckObjectLiteral(t *testing.T) {
+	report, err := scriptcheck.Check(`function prune() {}
+self.hooks = {prune, getFirstTrack({points}) {return points[0]}};`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if expected := []string{"getFirstTrack", "prune"}; !reflect.DeepEqual(report.Hooks, expected) {
+		t.Fatalf("Expected hooks %v, got %v", expected, report.Hooks)
+	}
+}
+
+func TestCheckSyntaxError(t *testing.T) {
+	_, err := scriptcheck.Check("self.hooks.getFirstTrack = function() {};\nfunction (\n")
+	syntaxErr, ok := err.(*scriptcheck.SyntaxError)
+	if !ok {
+		t.Fatalf("Expected syntax error, got %v", err)
+	}
+	if syntaxErr.Line != 2 || syntaxErr.Column != 10 {
+		t.Fatalf("Expected error at 2:10, got %d:%d", syntaxErr.Line, syntaxErr.Column)
+	}
+}
+
+func TestCheckContract(t *testing.T) {
+	if _, err := scriptcheck.Check("let x = 1;"); err == nil {
+		t.Fatal("Expected a script without hooks to fail")
+	}
+	if _, err := scriptcheck.Check("self.hooks.getFristTrack = function() {};"); err == nil {
+		t.Fatal("Expected a misspelled hook to fail")
+	}
+}
+
+func TestCheckSize(t *testing.T) {
+	script := "self.hooks.prune = function() {};\n" + strings.Repeat("//", scriptcheck.MaxScriptSize)
+	if _, err := scriptcheck.Check(script); err != scriptcheck.ErrTooLarge {
+		t.Fatalf("Expected too large error, got %v", err)
+	}
+}
@@ -0,0 +1,248 @@
+package scriptdiff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// How many unchanged lines to show around each change, the same as diff -u.
+const contextLines = 3
+
+type Op string
+
+const (
+	Equal  Op = "equal"
+	Insert Op = "insert"
+	Delete Op = "delete"
+)
+
+type Line struct {
+	Op   Op     `json:"op"`
+	Text string `json:"text"`
+}
+
+// Hunk is a run of changes along with the unchanged lines around them. Starts
+// are 1-indexed line numbers as in a unified diff header.
+type Hunk struct {
+	OldStart int    `json:"oldStart"`
+	OldLines int    `json:"oldLines"`
+	NewStart int    `json:"newStart"`
+	NewLines int    `json:"newLines"`
+	Lines    []Line `json:"lines"`
+}
+
+type Diff struct {
+	Unified string `json:"unified"`
+	Hunks   []Hunk `json:"hunks"`
+}
+
+// Compare diffs two scripts line by line. The names are used for the unified
+// diff's file headers.
+func Compare(oldName, oldScript, newName, newScript string) Diff {
+	hunks := makeHunks(diffLines(splitLines(oldScript), splitLines(newScript)))
+	return Diff{
+		Unified: unified(oldName, newName, hunks),
+		Hunks:   hunks,
+	}
+}
+
+func splitLines(script string) []string {
+	if script == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(script, "\n"), "\n")
+}
+
+// Finding the shortest edit script costs time proportional to the size of the
+// scripts times how different they are, so give up on the parts which are
+// still unresolved after this much work and call them a replacement instead.
+const maxDiffWork = 5000000
+
+// diffLines finds the shortest edit script between the two using the linear
+// space version of Myers' algorithm, which splits the problem around the
+// middle of an optimal path and solves each half.
+func diffLines(a, b []string) []Line {
+	d := differ{budget: maxDiffWork}
+	d.diff(a, b)
+	return d.lines
+}
+
+type differ struct {
+	lines  []Line
+	budget int
+}
+
+func (d *differ) diff(a, b []string) {
+	// Most versions of a script only change a little, so common leading and
+	// trailing lines are set aside first.
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		d.lines = append(d.lines, Line{Op: Equal, Text: a[0]})
+		a, b = a[1:], b[1:]
+	}
+	var suffix []string
+	for len(a) > 0 && len(b) > 0 && a[len(a)-1] == b[len(b)-1] {
+		suffix = append(suffix, a[len(a)-1])
+		a, b = a[:len(a)-1], b[:len(b)-1]
+	}
+	switch x, y, u, v, ok := d.middleSnake(a, b); {
+	case len(a) == 0 || len(b) == 0 || !ok:
+		d.replace(a, b)
+	default:
+		d.diff(a[:x], b[:y])
+		for _, line := range a[x:u] {
+			d.lines = append(d.lines, Line{Op: Equal, Text: line})
+		}
+		d.diff(a[u:], b[v:])
+	}
+	for i := len(suffix) - 1; i >= 0; i-- {
+		d.lines = append(d.lines, Line{Op: Equal, Text: suffix[i]})
+	}
+}
+
+func (d *differ) replace(a, b []string) {
+	for _, line := range a {
+		d.lines = append(d.lines, Line{Op: Delete, Text: line})
+	}
+	for _, line := range b {
+		d.lines = append(d.lines, Line{Op: Insert, Text: line})
+	}
+}
+
+// middleSnake searches from both ends at once until the paths meet and
+// returns the snake, a run of equal lines from (x, y) to (u, v), where they
+// do. It is not ok if the work budget ran out first.
+func (d *differ) middleSnake(a, b []string) (x, y, u, v int, ok bool) {
+	n, m := len(a), len(b)
+	if n == 0 || m == 0 {
+		return 0, 0, 0, 0, false
+	}
+	delta := n - m
+	max := (n + m + 1) / 2
+	offset := max + 1
+	// forward[k] is how far along a the furthest path from the start on
+	// diagonal k reaches, backward[k] the same from the end with both
+	// scripts reversed.
+	forward := make([]int, 2*max+3)
+	backward := make([]int, 2*max+3)
+	for e := 0; e <= max; e++ {
+		d.budget -= 2 * (e + 1)
+		if d.budget < 0 {
+			return 0, 0, 0, 0, false
+		}
+		for k := -e; k <= e; k += 2 {
+			var fx int
+			if k == -e || (k != e && forward[offset+k-1] < forward[offset+k+1]) {
+				fx = forward[offset+k+1]
+			} else {
+				fx = forward[offset+k-1] + 1
+			}
+			fy := fx - k
+			startX, startY := fx, fy
+			for fx < n && fy < m && a[fx] == b[fy] {
+				fx++
+				fy++
+			}
+			d.budget -= fx - startX
+			forward[offset+k] = fx
+			if c := delta - k; delta%2 != 0 && c >= -(e-1) && c <= e-1 && fx+backward[offset+c] >= n {
+				return startX, startY, fx, fy, true
+			}
+		}
+		for k := -e; k <= e; k += 2 {
+			var bx int
+			if k == -e || (k != e && backward[offset+k-1] < backward[offset+k+1]) {
+				bx = backward[offset+k+1]
+			} else {
+				bx = backward[offset+k-1] + 1
+			}
+			by := bx - k
+			startX, startY := bx, by
+			for bx < n && by < m && a[n-1-bx] == b[m-1-by] {
+				bx++
+				by++
+			}
+			d.budget -= bx - startX
+			backward[offset+k] = bx
+			if c := delta - k; delta%2 == 0 && c >= -e && c <= e && bx+forward[offset+c] >= n {
+				return n - bx, m - by, n - startX, m - startY, true
+			}
+		}
+	}
+	return 0, 0, 0, 0, false
+}
+
+// makeHunks groups changes into hunks, merging any which are close enough that
+// their context would overlap.
+func makeHunks(lines []Line) []Hunk {
+	oldBefore := make([]int, len(lines)+1)
+	newBefore := make([]int, len(lines)+1)
+	var changes []int
+	for i, line := range lines {
+		oldBefore[i+1], newBefore[i+1] = oldBefore[i], newBefore[i]
+		if line.Op != Insert {
+			oldBefore[i+1]++
+		}
+		if line.Op != Delete {
+			newBefore[i+1]++
+		}
+		if line.Op != Equal {
+			changes = append(changes, i)
+		}
+	}
+	var hunks []Hunk
+	for i := 0; i < len(changes); i++ {
+		start := changes[i] - contextLines
+		if start < 0 {
+			start = 0
+		}
+		end := changes[i] + 1
+		for i+1 < len(changes) && changes[i+1]-end <= 2*contextLines {
+			i++
+			end = changes[i] + 1
+		}
+		end += contextLines
+		if end > len(lines) {
+			end = len(lines)
+		}
+		hunk := Hunk{
+			OldStart: oldBefore[start] + 1,
+			OldLines: oldBefore[end] - oldBefore[start],
+			NewStart: newBefore[start] + 1,
+			NewLines: newBefore[end] - newBefore[start],
+			Lines:    lines[start:end],
+		}
+		// An empty side is numbered by the line before it, as diff -u does.
+		if hunk.OldLines == 0 {
+			hunk.OldStart--
+		}
+		if hunk.NewLines == 0 {
+			hunk.NewStart--
+		}
+		hunks = append(hunks, hunk)
+	}
+	return hunks
+}
+
+func unified(oldName, newName string, hunks []Hunk) string {
+	if len(hunks) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", oldName, newName)
+	for _, hunk := range hunks {
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", hunk.OldStart, hunk.OldLines, hunk.NewStart, hunk.NewLines)
+		for _, line := range hunk.Lines {
+			switch line.Op {
+			case Equal:
+				sb.WriteString(" ")
+			case Insert:
+				sb.WriteString("+")
+			case Delete:
+				sb.WriteString("-")
+			}
+			sb.WriteString(line.Text)
+			sb.WriteString("\n")
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,162 @@
+package scriptdiff_test
+
+import (
+	"fmt"
+	"math/rand"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/samuelhorwitz/phosphorescence/api/scriptdiff"
+)
+
+func TestCompareUnified(t *testing.T) {
+	oldScript := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\n"
+	newScript := "a\nb\nC\nd\ne\nf\ng\nh\ni\nj\nk\n"
+	diff := scriptdiff.Compare("old", oldScript, "new", newScript)
+	expected := strings.Join([]string{
+		"--- old",
+		"+++ new",
+		"@@ -1,6 +1,6 @@",
+		" a",
+		" b",
+		"-c",
+		"+C",
+		" d",
+		" e",
+		" f",
+		"@@ -8,3 +8,4 @@",
+		" h",
+		" i",
+		" j",
+		"+k",
+		"",
+	}, "\n")
+	if diff.Unified != expected {
+		t.Fatalf("Expected\n%s\ngot\n%s", expected, diff.Unified)
+	}
+	if len(diff.Hunks) != 2 {
+		t.Fatalf("Expected 2 hunks, got %d", len(diff.Hunks))
+	}
+}
+
+func TestCompareMergesCloseChanges(t *testing.T) {
+	diff := scriptdiff.Compare("old", "a\nb\nc\nd\ne\nf\ng\n", "new", "A\nb\nc\nd\ne\nf\nG\n")
+	if len(diff.Hunks) != 1 {
+		t.Fatalf("Expected changes 6 lines apart to share a hunk, got %d hunks", len(diff.Hunks))
+	}
+	hunk := diff.Hunks[0]
+	if hunk.OldStart != 1 || hunk.OldLines != 7 || hunk.NewStart != 1 || hunk.NewLines != 7 {
+		t.Fatalf("Unexpected hunk range %+v", hunk)
+	}
+}
+
+func TestCompareEmpty(t *testing.T) {
+	diff := scriptdiff.Compare("old", "", "new", "a\nb\n")
+	if len(diff.Hunks) != 1 {
+		t.Fatalf("Expected 1 hunk, got %d", len(diff.Hunks))
+	}
+	if hunk := diff.Hunks[0]; hunk.OldStart != 0 || hunk.OldLines != 0 || hunk.NewStart != 1 || hunk.NewLines != 2 {
+		t.Fatalf("Unexpected hunk range %+v", hunk)
+	}
+	if same := scriptdiff.Compare("old", "a\n", "new", "a\n"); same.Unified != "" || len(same.Hunks) != 0 {
+		t.Fatalf("Expected no diff for identical scripts, got %+v", same)
+	}
+}
+
+func TestCompareMinimal(t *testing.T) {
+	diff := scriptdiff.Compare("old", "a\nb\nc\na\nb\nb\na\n", "new", "c\nb\na\nb\na\nc\n")
+	var edits int
+	for _, hunk := range diff.Hunks {
+		for _, line := range hunk.Lines {
+			if line.Op != scriptdiff.Equal {
+				edits++
+			}
+		}
+	}
+	if edits != 5 {
+		t.Fatalf("Expected the shortest edit script of 5 edits, got %d", edits)
+	}
+}
+
+func countEdits(diff scriptdiff.Diff) (deletes, inserts int) {
+	for _, hunk := range diff.Hunks {
+		for _, line := range hunk.Lines {
+			switch line.Op {
+			case scriptdiff.Delete:
+				deletes++
+			case scriptdiff.Insert:
+				inserts++
+			}
+		}
+	}
+	return deletes, inserts
+}
+
+func longestCommonSubsequence(a, b []string) int {
+	lengths := make([][]int, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else if lengths[i+1][j] > lengths[i][j+1] {
+				lengths[i][j] = lengths[i+1][j]
+			} else {
+				lengths[i][j] = lengths[i][j+1]
+			}
+		}
+	}
+	return lengths[0][0]
+}
+
+func TestCompareMinimalRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	randomLines := func() []string {
+		lines := make([]string, rng.Intn(30))
+		for i := range lines {
+			lines[i] = string('a' + rune(rng.Intn(4)))
+		}
+		return lines
+	}
+	for i := 0; i < 500; i++ {
+		a, b := randomLines(), randomLines()
+		diff := scriptdiff.Compare("old", strings.Join(a, "\n"), "new", strings.Join(b, "\n"))
+		deletes, inserts := countEdits(diff)
+		lcs := longestCommonSubsequence(a, b)
+		if deletes != len(a)-lcs || inserts != len(b)-lcs {
+			t.Fatalf("Expected %d deletes and %d inserts diffing %q and %q, got %d and %d", len(a)-lcs, len(b)-lcs, a, b, deletes, inserts)
+		}
+	}
+}
+
+func TestCompareLarge(t *testing.T) {
+	const size = 20000
+	var oldLines, newLines, unrelated []string
+	for i := 0; i < size; i++ {
+		oldLines = append(oldLines, fmt.Sprintf("line %d", i))
+		if i%3 == 0 {
+			newLines = append(newLines, fmt.Sprintf("changed %d", i))
+		} else {
+			newLines = append(newLines, fmt.Sprintf("line %d", i))
+		}
+		unrelated = append(unrelated, fmt.Sprintf("other %d", i))
+	}
+	oldScript := strings.Join(oldLines, "\n")
+	for _, newScript := range []string{strings.Join(newLines, "\n"), strings.Join(unrelated, "\n")} {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		diff := scriptdiff.Compare("old", oldScript, "new", newScript)
+		runtime.ReadMemStats(&after)
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 64<<20 {
+			t.Fatalf("Expected diffing to allocate in proportion to the scripts, allocated %d bytes", allocated)
+		}
+		// Whether or not the diff is minimal, it has to account for every line.
+		deletes, inserts := countEdits(diff)
+		if deletes == 0 || deletes > size || deletes != inserts {
+			t.Fatalf("Unexpected diff with %d deletes and %d inserts", deletes, inserts)
+		}
+	}
+}
@@ -23,6 +23,13 @@ func Find(w http.ResponseWriter, r *http.Request, sessionID string, refreshID st
 	return nil, errors.New("No session or refresh IDs")
 }
 
+// Get looks up a live session by ID outside of a request, refreshing its
+// Spotify token if needed. It never revives expired sessions since there is no
+// client to hand new cookies to.
+func Get(sessionID string) (*Session, error) {
+	return liveSession(sessionID)
+}
+
 func liveSession(sessionID string) (*Session, error) {
 	redisConn := common.RedisPool.Get()
 	defer redisConn.Close()
@@ -0,0 +1,255 @@
+package setanalysis
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Spotify's mode, see https://developer.spotify.com/documentation/web-api/reference/tracks/get-audio-features/
+const (
+	Minor = 0
+	Major = 1
+)
+
+// Spotify uses -1 when it couldn't detect a key.
+const unknownKey = -1
+
+// How close together two tracks by the same artist have to be before we call
+// it out. Adjacent tracks are distance 1.
+const artistProximity = 3
+
+// How many of the lowest scoring transitions to return.
+const worstTransitionCount = 3
+
+// How much of the transition cost comes from each measure. These add up to 1.
+const (
+	tempoWeight    = 0.35
+	harmonicWeight = 0.3
+	energyWeight   = 0.2
+	valenceWeight  = 0.15
+)
+
+// Each artist repeat takes this much off the flow score, scaled by how close
+// together the repeat is.
+const artistRepeatPenalty = 5
+
+// These are the same circles the builder API uses for harmonic mixing, the
+// index into each is the Camelot number minus one.
+var (
+	minorsCircle = [12]int{8, 3, 10, 5, 0, 7, 2, 9, 4, 11, 6, 1}
+	majorsCircle = [12]int{11, 6, 1, 8, 3, 10, 5, 0, 7, 2, 9, 4}
+)
+
+type KeyCompatibility string
+
+const (
+	KeyCompatibilitySame     KeyCompatibility = "same"
+	KeyCompatibilityAdjacent KeyCompatibility = "adjacent"
+	KeyCompatibilityRelative KeyCompatibility = "relative"
+	KeyCompatibilityClash    KeyCompatibility = "clash"
+	KeyCompatibilityUnknown  KeyCompatibility = "unknown"
+)
+
+type Artist struct {
+	ID   string
+	Name string
+}
+
+type Track struct {
+	ID      string
+	Artists []Artist
+	Tempo   float64
+	Key     int
+	Mode    int
+	Energy  float64
+	Valence float64
+}
+
+type Transition struct {
+	From             int              `json:"from"`
+	To               int              `json:"to"`
+	FromID           string           `json:"fromId"`
+	ToID             string           `json:"toId"`
+	BPMDelta         float64          `json:"bpmDelta"`
+	FromCamelot      string           `json:"fromCamelot"`
+	ToCamelot        string           `json:"toCamelot"`
+	KeyCompatibility KeyCompatibility `json:"keyCompatibility"`
+	EnergyJump       float64          `json:"energyJump"`
+	ValenceJump      float64          `json:"valenceJump"`
+	Score            float64          `json:"score"`
+}
+
+type ArtistRepeat struct {
+	ArtistID   string `json:"artistId"`
+	ArtistName string `json:"artistName"`
+	First      int    `json:"first"`
+	Second     int    `json:"second"`
+}
+
+type Analysis struct {
+	Transitions      []Transition   `json:"transitions"`
+	ArtistRepeats    []ArtistRepeat `json:"artistRepeats"`
+	FlowScore        float64        `json:"flowScore"`
+	WorstTransitions []Transition   `json:"worstTransitions"`
+}
+
+// Analyze looks at each join between consecutive tracks and at artists that
+// repeat too close together. Transition scores are between 0 (jarring) and 1
+// (seamless), the flow score is between 0 and 100.
+func Analyze(tracks []Track) Analysis {
+	analysis := Analysis{
+		Transitions:      []Transition{},
+		ArtistRepeats:    []ArtistRepeat{},
+		WorstTransitions: []Transition{},
+	}
+	if len(tracks) < 2 {
+		analysis.FlowScore = 100
+		return analysis
+	}
+	var totalScore float64
+	for i := 1; i < len(tracks); i++ {
+		transition := analyzeTransition(i-1, tracks[i-1], i, tracks[i])
+		totalScore += transition.Score
+		analysis.Transitions = append(analysis.Transitions, transition)
+	}
+	analysis.ArtistRepeats = findArtistRepeats(tracks)
+	flowScore := 100 * totalScore / float64(len(analysis.Transitions))
+	for _, repeat := range analysis.ArtistRepeats {
+		flowScore -= artistRepeatPenalty * float64(artistProximity-(repeat.Second-repeat.First)+1) / artistProximity
+	}
+	analysis.FlowScore = round(math.Max(0, flowScore))
+	worst := make([]Transition, len(analysis.Transitions))
+	copy(worst, analysis.Transitions)
+	sort.SliceStable(worst, func(i, j int) bool {
+		return worst[i].Score < worst[j].Score
+	})
+	if len(worst) > worstTransitionCount {
+		worst = worst[:worstTransitionCount]
+	}
+	analysis.WorstTransitions = worst
+	return analysis
+}
+
+func analyzeTransition(fromIndex int, from Track, toIndex int, to Track) Transition {
+	compatibility, harmonicCost := keyCompatibility(from, to)
+	energyJump := to.Energy - from.Energy
+	valenceJump := to.Valence - from.Valence
+	cost := tempoWeight*tempoCost(from.Tempo, to.Tempo) +
+		harmonicWeight*harmonicCost +
+		energyWeight*math.Min(1, math.Abs(energyJump)) +
+		valenceWeight*math.Min(1, math.Abs(valenceJump))
+	return Transition{
+		From:             fromIndex,
+		To:               toIndex,
+		FromID:           from.ID,
+		ToID:             to.ID,
+		BPMDelta:         round(to.Tempo - from.Tempo),
+		FromCamelot:      Camelot(from.Key, from.Mode),
+		ToCamelot:        Camelot(to.Key, to.Mode),
+		KeyCompatibility: compatibility,
+		EnergyJump:       round(energyJump),
+		ValenceJump:      round(valenceJump),
+		Score:            round(1 - cost),
+	}
+}
+
+// Camelot returns the Camelot wheel notation (e.g. "8A" for A minor) for a
+// Spotify key and mode, or an empty string if the key is unknown.
+func Camelot(key, mode int) string {
+	number := camelotNumber(key, mode)
+	if number == 0 {
+		return ""
+	}
+	if mode == Minor {
+		return fmt.Sprintf("%dA", number)
+	}
+	return fmt.Sprintf("%dB", number)
+}
+
+func camelotNumber(key, mode int) int {
+	if key == unknownKey || key < 0 || key > 11 {
+		return 0
+	}
+	circle := majorsCircle
+	if mode == Minor {
+		circle = minorsCircle
+	}
+	for i, pitch := range circle {
+		if pitch == key {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// keyCompatibility follows the usual harmonic mixing rules: the same key, one
+// step around the wheel or the relative major/minor all mix cleanly. The cost
+// uses the same curve as the builder API's nonJarringHarmonicDifference.
+func keyCompatibility(a, b Track) (KeyCompatibility, float64) {
+	aNumber, bNumber := camelotNumber(a.Key, a.Mode), camelotNumber(b.Key, b.Mode)
+	if aNumber == 0 || bNumber == 0 {
+		return KeyCompatibilityUnknown, 1
+	}
+	steps := aNumber - bNumber
+	if steps < 0 {
+		steps = -steps
+	}
+	if steps > 12/2 {
+		steps = 12 - steps
+	}
+	var compatibility KeyCompatibility
+	switch {
+	case a.Mode == b.Mode && steps == 0:
+		compatibility = KeyCompatibilitySame
+	case a.Mode == b.Mode && steps == 1:
+		compatibility = KeyCompatibilityAdjacent
+	case a.Mode != b.Mode && steps == 0:
+		compatibility = KeyCompatibilityRelative
+	default:
+		compatibility = KeyCompatibilityClash
+	}
+	if a.Mode != b.Mode {
+		steps++
+	}
+	if steps == 0 {
+		return compatibility, 0
+	}
+	return compatibility, 1 - (1 / (1 + (0.5 * math.Pow(float64(steps), 3))))
+}
+
+// tempoCost is the builder API's nonJarringTempoDifference.
+func tempoCost(a, b float64) float64 {
+	if a == 0 || b == 0 {
+		return 1
+	}
+	return math.Min(1, math.Max(0, 0.57*math.Log(math.Abs(b-a))))
+}
+
+func findArtistRepeats(tracks []Track) []ArtistRepeat {
+	repeats := []ArtistRepeat{}
+	lastSeen := make(map[string]int)
+	for i, track := range tracks {
+		seenOnTrack := make(map[string]bool)
+		for _, artist := range track.Artists {
+			if artist.ID == "" || seenOnTrack[artist.ID] {
+				continue
+			}
+			seenOnTrack[artist.ID] = true
+			if previous, ok := lastSeen[artist.ID]; ok && i-previous <= artistProximity {
+				repeats = append(repeats, ArtistRepeat{
+					ArtistID:   artist.ID,
+					ArtistName: artist.Name,
+					First:      previous,
+					Second:     i,
+				})
+			}
+			lastSeen[artist.ID] = i
+		}
+	}
+	return repeats
+}
+
+func round(f float64) float64 {
+	return math.Round(f*1000) / 1000
+}
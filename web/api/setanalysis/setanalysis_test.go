@@ -0,0 +1,59 @@
+package setanalysis_test
+
+import (
+	"testing"
+
+	"github.com/samuelhorwitz/phosphorescence/api/setanalysis"
+)
+
+func TestCamelot(t *testing.T) {
+	cases := []struct {
+		key, mode int
+		expected  string
+	}{
+		{9, setanalysis.Minor, "8A"},
+		{0, setanalysis.Major, "8B"},
+		{8, setanalysis.Minor, "1A"},
+		{4, setanalysis.Major, "12B"},
+		{-1, setanalysis.Major, ""},
+	}
+	for _, c := range cases {
+		if camelot := setanalysis.Camelot(c.key, c.mode); camelot != c.expected {
+			t.Fatalf("Expected key %d mode %d to be %q, got %q", c.key, c.mode, c.expected, camelot)
+		}
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	artist := setanalysis.Artist{ID: "artist", Name: "Artist"}
+	tracks := []setanalysis.Track{
+		{ID: "a", Tempo: 120, Key: 9, Mode: setanalysis.Minor, Energy: 0.5, Valence: 0.5, Artists: []setanalysis.Artist{artist}},
+		{ID: "b", Tempo: 121, Key: 0, Mode: setanalysis.Major, Energy: 0.55, Valence: 0.5},
+		{ID: "c", Tempo: 175, Key: 6, Mode: setanalysis.Major, Energy: 0.95, Valence: 0.1, Artists: []setanalysis.Artist{artist}},
+	}
+	analysis := setanalysis.Analyze(tracks)
+	if len(analysis.Transitions) != 2 {
+		t.Fatalf("Expected 2 transitions, got %d", len(analysis.Transitions))
+	}
+	if analysis.Transitions[0].KeyCompatibility != setanalysis.KeyCompatibilityRelative {
+		t.Fatalf("Expected relative key change, got %s", analysis.Transitions[0].KeyCompatibility)
+	}
+	if analysis.Transitions[1].KeyCompatibility != setanalysis.KeyCompatibilityClash {
+		t.Fatalf("Expected key clash, got %s", analysis.Transitions[1].KeyCompatibility)
+	}
+	if analysis.Transitions[1].BPMDelta != 54 {
+		t.Fatalf("Expected BPM delta of 54, got %f", analysis.Transitions[1].BPMDelta)
+	}
+	if analysis.Transitions[0].Score <= analysis.Transitions[1].Score {
+		t.Fatalf("Expected smooth transition to score higher than rough one")
+	}
+	if analysis.WorstTransitions[0].From != 1 {
+		t.Fatalf("Expected worst transition to start at 1, got %d", analysis.WorstTransitions[0].From)
+	}
+	if len(analysis.ArtistRepeats) != 1 || analysis.ArtistRepeats[0].First != 0 || analysis.ArtistRepeats[0].Second != 2 {
+		t.Fatalf("Expected one artist repeat between 0 and 2, got %v", analysis.ArtistRepeats)
+	}
+	if analysis.FlowScore <= 0 || analysis.FlowScore >= 100 {
+		t.Fatalf("Expected flow score between 0 and 100, got %f", analysis.FlowScore)
+	}
+}
@@ -0,0 +1,181 @@
+package cleanup
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+type cleaner struct {
+	cfg                *Config
+	db                 *sql.DB
+	spotifyToken       string
+	spotifyTokenExpiry time.Time
+	limiter            *time.Ticker
+}
+
+type createdPlaylist struct {
+	id         string
+	createdAt  time.Time
+	followedAt sql.NullTime
+	trackCount int
+}
+
+// Report is what happened (or, for a dry run, what would have happened) to
+// every playlist the cleanup looked at.
+type Report struct {
+	Checked  int
+	Followed int
+	Deleted  int
+	Gone     int
+	Failed   int
+}
+
+func (r Report) String() string {
+	return fmt.Sprintf("checked %d, still followed %d, deleted %d, already gone %d, failed %d", r.Checked, r.Followed, r.Deleted, r.Gone, r.Failed)
+}
+
+// CleanPlaylists finds playlists created by the app user which are older than
+// the configured age and are not official, and deletes the ones nobody else
+// follows: their tracks are cleared and, if creating the playlist didn't manage
+// to already, the app user unfollows them. Playlists are read from the DB in
+// batches and Spotify requests are rate limited. With DryRun set nothing is
+// changed, Spotify is only asked who follows each playlist.
+func CleanPlaylists(cfg *Config) (Report, error) {
+	var report Report
+	if cfg.BatchSize <= 0 || cfg.RequestsPerSecond <= 0 {
+		return report, fmt.Errorf("Batch size and requests per second must be positive")
+	}
+	db, err := sql.Open("postgres", cfg.PostgresConnectionString)
+	if err != nil {
+		return report, fmt.Errorf("Could not open Postgres connection: %s", err)
+	}
+	defer db.Close()
+	c := &cleaner{
+		cfg:     cfg,
+		db:      db,
+		limiter: time.NewTicker(time.Second / time.Duration(cfg.RequestsPerSecond)),
+	}
+	defer c.limiter.Stop()
+	if err = c.refreshTokenIfExpiring(); err != nil {
+		return report, fmt.Errorf("Could not get Spotify application user token: %s", err)
+	}
+	cutoff := time.Now().Add(-cfg.OlderThan)
+	var after createdPlaylist
+	for batchNumber := 1; ; batchNumber++ {
+		batch, err := c.getCandidates(cutoff, after, cfg.BatchSize)
+		if err != nil {
+			return report, fmt.Errorf("Could not get batch %d: %s", batchNumber, err)
+		}
+		if len(batch) == 0 {
+			break
+		}
+		log.Printf("Handling batch %d (%d playlists)...", batchNumber, len(batch))
+		for _, playlist := range batch {
+			c.handle(playlist, &report)
+		}
+		after = batch[len(batch)-1]
+	}
+	return report, nil
+}
+
+func (c *cleaner) handle(playlist createdPlaylist, report *Report) {
+	report.Checked++
+	followers, err := c.getFollowerCount(playlist.id)
+	var appUserFollows bool
+	if err == nil {
+		appUserFollows, err = c.appUserFollows(playlist.id)
+	}
+	if err == errPlaylistGone {
+		c.gone(playlist, report)
+		return
+	}
+	if err != nil {
+		report.Failed++
+		log.Printf("Could not get followers for %s: %s", playlist.id, err)
+		return
+	}
+	if appUserFollows {
+		followers--
+	}
+	if followers > 0 {
+		report.Followed++
+		return
+	}
+	if c.cfg.DryRun {
+		report.Deleted++
+		log.Printf("Would delete %s (created %s, %d tracks, followed by creator: %t, followed by app user: %t)", playlist.id, playlist.createdAt.Format(time.RFC3339), playlist.trackCount, playlist.followedAt.Valid, appUserFollows)
+		return
+	}
+	err = c.clearPlaylist(playlist.id)
+	if err == nil && appUserFollows {
+		err = c.unfollowPlaylist(playlist.id)
+	}
+	if err == errPlaylistGone {
+		c.gone(playlist, report)
+		return
+	}
+	if err != nil {
+		report.Failed++
+		log.Printf("Could not delete %s: %s", playlist.id, err)
+		return
+	}
+	report.Deleted++
+	if err = c.markDeleted(playlist.id); err != nil {
+		report.Failed++
+		log.Printf("Could not mark %s as deleted: %s", playlist.id, err)
+	}
+}
+
+func (c *cleaner) gone(playlist createdPlaylist, report *Report) {
+	report.Gone++
+	log.Printf("%s no longer exists", playlist.id)
+	if c.cfg.DryRun {
+		return
+	}
+	if err := c.markDeleted(playlist.id); err != nil {
+		report.Failed++
+		log.Printf("Could not mark %s as deleted: %s", playlist.id, err)
+	}
+}
+
+// Candidates are paged by (created_at, playlist_id) rather than by offset so a
+// real run, which removes rows from the view as it goes, doesn't skip any.
+func (c *cleaner) getCandidates(cutoff time.Time, after createdPlaylist, count int) ([]createdPlaylist, error) {
+	rows, err := c.db.Query(`select created.playlist_id, created.created_at, created.followed_at, created.track_count
+		from created_playlists_view created
+		where created.created_at < $1
+		and (created.created_at, created.playlist_id) > ($2, $3)
+		and not exists (select 1 from official_playlists_view official where official.playlist_id = created.playlist_id)
+		order by created.created_at, created.playlist_id
+		limit $4`, cutoff, after.createdAt, after.id, count)
+	if err != nil {
+		return nil, fmt.Errorf("Could not query created playlists: %s", err)
+	}
+	defer rows.Close()
+	var playlists []createdPlaylist
+	for rows.Next() {
+		var playlist createdPlaylist
+		err = rows.Scan(&playlist.id, &playlist.createdAt, &playlist.followedAt, &playlist.trackCount)
+		if err != nil {
+			return nil, fmt.Errorf("Could not scan row: %s", err)
+		}
+		playlists = append(playlists, playlist)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("Error after scanning rows: %s", err)
+	}
+	return playlists, nil
+}
+
+func (c *cleaner) markDeleted(playlistID string) error {
+	_, err := c.db.Exec("update created_playlists set deleted_at = now() where playlist_id = $1", playlistID)
+	if err != nil {
+		return fmt.Errorf("Could not mark created playlist as deleted: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+package cleanup
+
+import "time"
+
+type Config struct {
+	SpotifyClientID             string
+	SpotifySecret               string
+	PhosphorescenceSpotifyID    string
+	PhosphorescenceRefreshToken string
+	PostgresConnectionString    string
+	OlderThan                   time.Duration
+	BatchSize                   int
+	RequestsPerSecond           int
+	DryRun                      bool
+}
@@ -0,0 +1,204 @@
+package cleanup
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var spotifyClient = &http.Client{
+	Timeout: 60 * time.Second,
+}
+
+var errPlaylistGone = fmt.Errorf("Playlist no longer exists")
+
+const tokenExpiryMargin = 5 * time.Minute
+
+// The playlists are owned by the Phosphorescence account so we need its user
+// token, not a client credentials token like the spider uses.
+func getAppUserToken(spotifyClientID, spotifySecret, refreshToken string) (string, time.Time, error) {
+	body := url.Values{}
+	body.Set("client_id", spotifyClientID)
+	body.Set("client_secret", spotifySecret)
+	body.Set("grant_type", "refresh_token")
+	body.Set("refresh_token", refreshToken)
+	req, err := http.NewRequest("POST", "https://accounts.spotify.com/api/token", strings.NewReader(body.Encode()))
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("Could not build Spotify token request: %s", err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	res, err := spotifyClient.Do(req)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("Could not make Spotify token request: %s", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", time.Time{}, fmt.Errorf("Spotify token request responded with %d", res.StatusCode)
+	}
+	resBody, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("Could not read Spotify token response: %s", err)
+	}
+	var parsedBody struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	err = json.Unmarshal(resBody, &parsedBody)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("Could not parse Spotify token response: %s", err)
+	}
+	return parsedBody.AccessToken, time.Now().Add(time.Duration(parsedBody.ExpiresIn) * time.Second), nil
+}
+
+// A run over a large backlog easily outlasts the hour a token is good for, so
+// we refresh it a little before it expires.
+func (c *cleaner) refreshTokenIfExpiring() error {
+	if c.spotifyToken != "" && time.Now().Before(c.spotifyTokenExpiry.Add(-tokenExpiryMargin)) {
+		return nil
+	}
+	spotifyToken, expiry, err := getAppUserToken(c.cfg.SpotifyClientID, c.cfg.SpotifySecret, c.cfg.PhosphorescenceRefreshToken)
+	if err != nil {
+		return err
+	}
+	c.spotifyToken, c.spotifyTokenExpiry = spotifyToken, expiry
+	return nil
+}
+
+func (c *cleaner) getFollowerCount(playlistID string) (int, error) {
+	res, err := c.do("GET", fmt.Sprintf("https://api.spotify.com/v1/playlists/%s?fields=followers.total", playlistID), nil)
+	if err != nil {
+		return 0, fmt.Errorf("Could not make Spotify playlist request: %s", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return 0, errPlaylistGone
+	}
+	if res.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("Spotify playlist request responded with %d", res.StatusCode)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return 0, fmt.Errorf("Could not read Spotify playlist response: %s", err)
+	}
+	var parsedBody struct {
+		Followers struct {
+			Total int `json:"total"`
+		} `json:"followers"`
+	}
+	err = json.Unmarshal(body, &parsedBody)
+	if err != nil {
+		return 0, fmt.Errorf("Could not parse Spotify playlist response: %s", err)
+	}
+	return parsedBody.Followers.Total, nil
+}
+
+// appUserFollows is whether the Phosphorescence account still follows the
+// playlist. Creating a playlist normally unfollows it straight away, this
+// catches the ones where that failed.
+func (c *cleaner) appUserFollows(playlistID string) (bool, error) {
+	res, err := c.do("GET", fmt.Sprintf("https://api.spotify.com/v1/playlists/%s/followers/contains?ids=%s", playlistID, url.QueryEscape(c.cfg.PhosphorescenceSpotifyID)), nil)
+	if err != nil {
+		return false, fmt.Errorf("Could not make Spotify follow check request: %s", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return false, errPlaylistGone
+	}
+	if res.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("Spotify follow check request responded with %d", res.StatusCode)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return false, fmt.Errorf("Could not read Spotify follow check response: %s", err)
+	}
+	var follows []bool
+	err = json.Unmarshal(body, &follows)
+	if err != nil {
+		return false, fmt.Errorf("Could not parse Spotify follow check response: %s", err)
+	}
+	return len(follows) > 0 && follows[0], nil
+}
+
+// Spotify has no way to delete a playlist, the owner unfollowing it is as close
+// as it gets.
+func (c *cleaner) unfollowPlaylist(playlistID string) error {
+	res, err := c.do("DELETE", fmt.Sprintf("https://api.spotify.com/v1/playlists/%s/followers", playlistID), nil)
+	if err != nil {
+		return fmt.Errorf("Could not make Spotify unfollow playlist request: %s", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return errPlaylistGone
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("Spotify unfollow playlist request responded with %d", res.StatusCode)
+	}
+	return nil
+}
+
+// clearPlaylist removes every track, an unfollowed playlist still exists and
+// anyone with the link can open it.
+func (c *cleaner) clearPlaylist(playlistID string) error {
+	res, err := c.do("PUT", fmt.Sprintf("https://api.spotify.com/v1/playlists/%s/tracks", playlistID), []byte(`{"uris":[]}`))
+	if err != nil {
+		return fmt.Errorf("Could not make Spotify clear playlist request: %s", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return errPlaylistGone
+	}
+	if !(res.StatusCode == http.StatusOK || res.StatusCode == http.StatusCreated) {
+		return fmt.Errorf("Spotify clear playlist request responded with %d", res.StatusCode)
+	}
+	return nil
+}
+
+// do waits for the rate limiter and retries for as long as Spotify asks us to
+// back off. The token is refreshed before it expires and once more if Spotify
+// rejects it anyway.
+func (c *cleaner) do(method, url string, body []byte) (*http.Response, error) {
+	refreshed := false
+	for {
+		<-c.limiter.C
+		if err := c.refreshTokenIfExpiring(); err != nil {
+			return nil, fmt.Errorf("Could not refresh Spotify application user token: %s", err)
+		}
+		req, err := http.NewRequest(method, url, bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("Could not build Spotify request: %s", err)
+		}
+		req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", c.spotifyToken))
+		if body != nil {
+			req.Header.Add("Content-Type", "application/json")
+		}
+		res, err := spotifyClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if res.StatusCode == http.StatusUnauthorized && !refreshed {
+			res.Body.Close()
+			log.Println("Spotify rejected our token, refreshing")
+			c.spotifyToken = ""
+			refreshed = true
+			continue
+		}
+		if res.StatusCode != http.StatusTooManyRequests {
+			return res, nil
+		}
+		res.Body.Close()
+		log.Println("Spotify asked us to back off")
+		retryAfterSeconds, err := strconv.Atoi(res.Header.Get("Retry-After"))
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse retry after header: %s", err)
+		}
+		log.Printf("Waiting for %d seconds...", retryAfterSeconds)
+		time.Sleep(time.Duration(retryAfterSeconds) * time.Second)
+	}
+}
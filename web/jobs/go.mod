@@ -6,4 +6,5 @@ require (
 	github.com/aws/aws-sdk-go v1.21.1
 	github.com/google/brotli v1.0.7
 	github.com/joho/godotenv v1.3.0
+	github.com/lib/pq v1.1.1
 )
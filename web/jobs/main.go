@@ -8,8 +8,10 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/samuelhorwitz/phosphorescence/jobs/cleanup"
 	"github.com/samuelhorwitz/phosphorescence/jobs/push"
 	"github.com/samuelhorwitz/phosphorescence/jobs/spider"
 )
@@ -19,10 +21,20 @@ func main() {
 	var testPush bool
 	var fakeData bool
 	var bucketUpdatesOnly bool
+	var cleanPlaylists bool
+	var dryRun bool
+	var olderThanDays int
+	var batchSize int
+	var requestsPerSecond int
 	flag.StringVar(&outFile, "out", "", "output file (opt)")
 	flag.BoolVar(&testPush, "test", false, "test push (opt)")
 	flag.BoolVar(&fakeData, "fake", false, "fake data (opt)")
 	flag.BoolVar(&bucketUpdatesOnly, "bucket", false, "only make updates to bucket (opt)")
+	flag.BoolVar(&cleanPlaylists, "cleanup", false, "clean up unfollowed playlists instead of spidering (opt)")
+	flag.BoolVar(&dryRun, "dry-run", false, "only report what cleanup would do (opt)")
+	flag.IntVar(&olderThanDays, "older-than", 30, "minimum age in days of playlists to clean up (opt)")
+	flag.IntVar(&batchSize, "batch", 50, "number of playlists to clean up per batch (opt)")
+	flag.IntVar(&requestsPerSecond, "rate", 5, "maximum Spotify requests per second during cleanup (opt)")
 	flag.Parse()
 	isProduction := os.Getenv("ENV") == "production"
 	if !isProduction {
@@ -31,6 +43,20 @@ func main() {
 			return
 		}
 	}
+	if cleanPlaylists {
+		runCleanup(&cleanup.Config{
+			SpotifyClientID:             os.Getenv("SPOTIFY_CLIENT_ID"),
+			SpotifySecret:               os.Getenv("SPOTIFY_SECRET"),
+			PhosphorescenceSpotifyID:    os.Getenv("PHOSPHORESCENCE_SPOTIFY_ID"),
+			PhosphorescenceRefreshToken: os.Getenv("PHOSPHORESCENCE_REFRESH_TOKEN"),
+			PostgresConnectionString:    os.Getenv("PG_CONNECTION_STRING"),
+			OlderThan:                   time.Duration(olderThanDays) * 24 * time.Hour,
+			BatchSize:                   batchSize,
+			RequestsPerSecond:           requestsPerSecond,
+			DryRun:                      dryRun,
+		})
+		return
+	}
 	cfg := &config{
 		spotifyClientID:   os.Getenv("SPOTIFY_CLIENT_ID"),
 		spotifySecret:     os.Getenv("SPOTIFY_SECRET"),
@@ -94,6 +120,18 @@ func run(cfg *config) {
 	log.Println("Success")
 }
 
+func runCleanup(cfg *cleanup.Config) {
+	report, err := cleanup.CleanPlaylists(cfg)
+	if err != nil {
+		log.Fatalf("Could not clean up playlists: %s", err)
+	}
+	if cfg.DryRun {
+		log.Printf("Dry run: %s", report)
+		return
+	}
+	log.Printf("Success: %s", report)
+}
+
 func dumpTracksJSON(tracks interface{}, filename string) error {
 	trackJSON, err := json.Marshal(tracks)
 	if err != nil {